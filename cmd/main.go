@@ -45,11 +45,22 @@ func main() {
 		log.Fatalf("Failed to initialize MySQL: %v", err)
 	}
 
+	// 初始化Redis会话连接（在线状态注册表）
+	if err := db.InitRedisSession(); err != nil {
+		log.Fatalf("Failed to initialize Redis session: %v", err)
+	}
+
+	// 初始化Redis消息连接（离线消息队列）
+	if err := db.InitRedisMessage(); err != nil {
+		log.Fatalf("Failed to initialize Redis message: %v", err)
+	}
+
 	// 初始化应用依赖
-	app, err := internal.InitApp(db.GetDB())
+	app, err := internal.InitApp(db.GetDB(), cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize app: %v", err)
 	}
+	log.Printf("Instance ID: %s (source: %s)", app.Identity.ID(), app.Identity.Source())
 
 	// 创建 Gin 引擎
 	if cfg.Env == "production" {
@@ -58,7 +69,7 @@ func main() {
 	r := gin.New()
 
 	// 设置路由
-	router.SetupRouter(r, app.AuthHandler, app.UserHandler, app.MessageHandler, app.RoomHandler, app.HubHandler)
+	router.SetupRouter(r, app.AuthHandler, app.UserHandler, app.MessageHandler, app.RoomHandler, app.HubHandler, app.AttachmentHandler, app.ConversationHandler, app.AdminHandler, app.Identity.ID())
 
 	// 启动 HTTP 服务，使用配置中的端口（若未设置则回退到 :8080）
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
@@ -0,0 +1,6 @@
+package config
+
+// AdminConfig 管理员配置
+type AdminConfig struct {
+	UserIDs []uint `mapstructure:"user_ids" json:"user_ids"` // 启动时授予管理员角色的用户ID，从列表中移除不会撤销已授予的角色
+}
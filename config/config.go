@@ -10,22 +10,26 @@ type Config struct {
 	AppName string `mapstructure:"app_name" json:"app_name"`
 	Env     string `mapstructure:"env" json:"env"` // development | production
 
-	Server ServerConfig `mapstructure:"server" json:"server"`
-	MySQL  MySQLConfig  `mapstructure:"mysql" json:"mysql"`
+	Instance InstanceConfig `mapstructure:"instance" json:"instance"`
+	Server   ServerConfig   `mapstructure:"server" json:"server"`
+	MySQL    MySQLConfig    `mapstructure:"mysql" json:"mysql"`
 
 	Redis struct {
 		Session RedisConfig `mapstructure:"session"`
 		Message RedisConfig `mapstructure:"message"`
 	} `mapstructure:"redis"`
 
-	Kafka struct {
-		Brokers        []string `mapstructure:"brokers" json:"brokers"`
-		Topics         []string `mapstructure:"topics" json:"topics"`
-		ConsumerGroup  string   `mapstructure:"consumer_group" json:"consumer_group"`
-		RetentionHours int      `mapstructure:"retention_hours" json:"retention_hours"`
-	} `mapstructure:"kafka"`
-
-	JWT JWTConfig `mapstructure:"jwt" json:"jwt"`
+	Kafka KafkaConfig `mapstructure:"kafka" json:"kafka"`
+
+	JWT       JWTConfig       `mapstructure:"jwt" json:"jwt"`
+	Hub       HubConfig       `mapstructure:"hub" json:"hub"`
+	Offline   OfflineConfig   `mapstructure:"offline" json:"offline"`
+	Presence  PresenceConfig  `mapstructure:"presence" json:"presence"`
+	Message   MessageConfig   `mapstructure:"message" json:"message"`
+	Storage   StorageConfig   `mapstructure:"storage" json:"storage"`
+	Search    SearchConfig    `mapstructure:"search" json:"search"`
+	Scheduler SchedulerConfig `mapstructure:"scheduler" json:"scheduler"`
+	Admin     AdminConfig     `mapstructure:"admin" json:"admin"`
 }
 
 var globalConfig *Config
@@ -47,6 +51,9 @@ func LoadConfig(path string) *Config {
 	if err := v.Unmarshal(config); err != nil {
 		panic(fmt.Sprintf("failed to unmarshal config: %v", err))
 	}
+	if err := config.Kafka.applyLegacyTopics(); err != nil {
+		panic(fmt.Sprintf("invalid kafka config: %v", err))
+	}
 
 	globalConfig = config
 	return config
@@ -65,6 +72,11 @@ func GetMySQLConfig() MySQLConfig {
 	return GetConfig().MySQL
 }
 
+// GetInstanceConfig 获取实例身份配置
+func GetInstanceConfig() InstanceConfig {
+	return GetConfig().Instance
+}
+
 // GetServerConfig 获取服务器配置
 func GetServerConfig() ServerConfig {
 	return GetConfig().Server
@@ -75,6 +87,46 @@ func GetJWTConfig() JWTConfig {
 	return GetConfig().JWT
 }
 
+// GetOfflineConfig 获取离线消息队列配置
+func GetOfflineConfig() OfflineConfig {
+	return GetConfig().Offline
+}
+
+// GetPresenceConfig 获取在线状态注册表配置
+func GetPresenceConfig() PresenceConfig {
+	return GetConfig().Presence
+}
+
+// GetMessageConfig 获取消息配置
+func GetMessageConfig() MessageConfig {
+	return GetConfig().Message
+}
+
+// GetStorageConfig 获取附件存储配置
+func GetStorageConfig() StorageConfig {
+	return GetConfig().Storage
+}
+
+// GetSearchConfig 获取消息搜索配置
+func GetSearchConfig() SearchConfig {
+	return GetConfig().Search
+}
+
+// GetSchedulerConfig 获取定时消息配置
+func GetSchedulerConfig() SchedulerConfig {
+	return GetConfig().Scheduler
+}
+
+// GetAdminConfig 获取管理员配置
+func GetAdminConfig() AdminConfig {
+	return GetConfig().Admin
+}
+
+// GetHubConfig 获取Hub配置
+func GetHubConfig() HubConfig {
+	return GetConfig().Hub
+}
+
 // GetRedisSessionConfig 获取Redis Session配置
 func GetRedisSessionConfig() RedisConfig {
 	return GetConfig().Redis.Session
@@ -89,9 +141,11 @@ func GetRedisMessageConfig() RedisConfig {
 func GetKafkaConfig() map[string]interface{} {
 	kafkaConfig := GetConfig().Kafka
 	return map[string]interface{}{
-		"Brokers":        kafkaConfig.Brokers,
-		"Topics":         kafkaConfig.Topics,
-		"ConsumerGroup":  kafkaConfig.ConsumerGroup,
-		"RetentionHours": kafkaConfig.RetentionHours,
+		"Brokers":         kafkaConfig.Brokers,
+		"Topics":          kafkaConfig.Topics(),
+		"BroadcastTopics": kafkaConfig.BroadcastTopics,
+		"QueueTopics":     kafkaConfig.QueueTopics,
+		"ConsumerGroup":   kafkaConfig.ConsumerGroup,
+		"RetentionHours":  kafkaConfig.RetentionHours,
 	}
 }
@@ -0,0 +1,16 @@
+package config
+
+// HubConfig Hub连接管理配置
+type HubConfig struct {
+	SendQueueSize       int    `mapstructure:"send_queue_size" json:"send_queue_size"`             // 每个客户端发送队列长度
+	SlowConsumerPolicy  string `mapstructure:"slow_consumer_policy" json:"slow_consumer_policy"`   // drop_oldest | drop_newest | disconnect
+	SSEKeepAliveSeconds int    `mapstructure:"sse_keepalive_seconds" json:"sse_keepalive_seconds"` // SSE连接发送注释保活的间隔
+	PollTimeoutSeconds  int    `mapstructure:"poll_timeout_seconds" json:"poll_timeout_seconds"`   // 长轮询请求没有数据时的最长等待时间
+	PollGraceSeconds    int    `mapstructure:"poll_grace_seconds" json:"poll_grace_seconds"`       // 长轮询会话在两次请求之间的保留时间，超时后用户视为离线
+	PollBufferSize      int    `mapstructure:"poll_buffer_size" json:"poll_buffer_size"`           // 长轮询会话缓冲区长度，超出时丢弃最旧的数据
+	PollMaxBatch        int    `mapstructure:"poll_max_batch" json:"poll_max_batch"`               // 每次长轮询响应最多返回的数据条数
+
+	SignalTTLSeconds     int `mapstructure:"signal_ttl_seconds" json:"signal_ttl_seconds"`         // 正在输入等临时信号的有效期，超时未刷新时服务端推送结束
+	SignalRefreshSeconds int `mapstructure:"signal_refresh_seconds" json:"signal_refresh_seconds"` // 同一信号在此间隔内重复发送时只延长有效期，不再推送
+	SignalRatePerSecond  int `mapstructure:"signal_rate_per_second" json:"signal_rate_per_second"` // 每个用户每秒可发送的临时信号数，突发上限为两倍
+}
@@ -0,0 +1,8 @@
+package config
+
+// InstanceConfig 实例身份配置
+type InstanceConfig struct {
+	Name        string `mapstructure:"name" json:"name"`                 // 显式指定的实例名，优先级最高
+	UseHostname bool   `mapstructure:"use_hostname" json:"use_hostname"` // 从POD_NAME环境变量或主机名派生实例名
+	IDFile      string `mapstructure:"id_file" json:"id_file"`           // 自动生成的实例ID的持久化文件，保证重启后不变
+}
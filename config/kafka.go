@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"log"
+)
+
+// KafkaConfig Kafka配置
+type KafkaConfig struct {
+	Brokers         []string `mapstructure:"brokers" json:"brokers"`
+	ConsumerGroup   string   `mapstructure:"consumer_group" json:"consumer_group"`
+	BroadcastTopics []string `mapstructure:"broadcast_topics" json:"broadcast_topics"` // 每个实例都要消费的主题（在线状态、房间扇出）
+	QueueTopics     []string `mapstructure:"queue_topics" json:"queue_topics"`         // 集群内只需一个实例处理的主题（持久化、Webhook）
+	LegacyTopics    []string `mapstructure:"topics" json:"-"`                          // 旧版配置项，等同于broadcast_topics
+	RetentionHours  int      `mapstructure:"retention_hours" json:"retention_hours"`
+
+	// 实例收件箱：发往某个实例上用户的私聊消息直接投递到该实例的收件箱主题
+	InboxTopicPrefix       string `mapstructure:"inbox_topic_prefix" json:"inbox_topic_prefix"`
+	InboxPartitions        int32  `mapstructure:"inbox_partitions" json:"inbox_partitions"`
+	InboxReplicationFactor int16  `mapstructure:"inbox_replication_factor" json:"inbox_replication_factor"`
+
+	// 临时信号主题：正在输入等不持久化的信号，每个实例都会消费，不放在broadcast_topics中
+	SignalTopic string `mapstructure:"signal_topic" json:"signal_topic"`
+}
+
+// Topics 获取所有主题
+func (c KafkaConfig) Topics() []string {
+	topics := make([]string, 0, len(c.BroadcastTopics)+len(c.QueueTopics))
+	topics = append(topics, c.BroadcastTopics...)
+	topics = append(topics, c.QueueTopics...)
+	return topics
+}
+
+// applyLegacyTopics 将旧版的topics配置作为broadcast_topics使用，两者同时设置时返回错误
+func (c *KafkaConfig) applyLegacyTopics() error {
+	if len(c.LegacyTopics) == 0 {
+		return nil
+	}
+	if len(c.BroadcastTopics) > 0 {
+		return errors.New("kafka.topics and kafka.broadcast_topics are both set, move topics into broadcast_topics")
+	}
+	log.Printf("kafka.topics is deprecated, use kafka.broadcast_topics instead")
+	c.BroadcastTopics = c.LegacyTopics
+	c.LegacyTopics = nil
+	return nil
+}
+
+// InboxTopic 获取指定实例的收件箱主题
+func (c KafkaConfig) InboxTopic(instanceID string) string {
+	prefix := c.InboxTopicPrefix
+	if prefix == "" {
+		prefix = "user_inbox."
+	}
+	return prefix + instanceID
+}
+
+// SignalTopicName 获取临时信号主题
+func (c KafkaConfig) SignalTopicName() string {
+	if c.SignalTopic == "" {
+		return "ephemeral_signals"
+	}
+	return c.SignalTopic
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadConfigLegacyTopics(t *testing.T) {
+	tests := []struct {
+		name      string
+		kafka     string
+		want      []string
+		wantPanic bool
+	}{
+		{"broadcast topics", `broadcast_topics = ["a", "b"]`, []string{"a", "b"}, false},
+		{"legacy topics", `topics = ["a", "b"]`, []string{"a", "b"}, false},
+		{"both set", "topics = [\"a\"]\nbroadcast_topics = [\"b\"]", nil, true},
+		{"neither set", `brokers = ["127.0.0.1:9092"]`, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte("[kafka]\n"+tt.kafka+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("panic = %v, want panic %v", r, tt.wantPanic)
+				}
+			}()
+
+			cfg := LoadConfig(path)
+			if !slices.Equal(cfg.Kafka.BroadcastTopics, tt.want) {
+				t.Errorf("BroadcastTopics = %q, want %q", cfg.Kafka.BroadcastTopics, tt.want)
+			}
+			if cfg.Kafka.LegacyTopics != nil {
+				t.Errorf("LegacyTopics = %q, want nil", cfg.Kafka.LegacyTopics)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package config
+
+// MessageConfig 消息配置
+type MessageConfig struct {
+	EditWindowSeconds int `mapstructure:"edit_window_seconds" json:"edit_window_seconds"` // 发送者可编辑、撤回消息的时间窗口，房间管理员不受限制
+
+	ExpirySweepIntervalSeconds int `mapstructure:"expiry_sweep_interval_seconds" json:"expiry_sweep_interval_seconds"` // 清理过期消息的间隔
+	ExpiryBatchSize            int `mapstructure:"expiry_batch_size" json:"expiry_batch_size"`                         // 每次最多清理的过期消息数
+	MaxTTLSeconds              int `mapstructure:"max_ttl_seconds" json:"max_ttl_seconds"`                             // 消息和房间可设置的最大有效期
+}
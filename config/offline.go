@@ -0,0 +1,7 @@
+package config
+
+// OfflineConfig 离线消息队列配置
+type OfflineConfig struct {
+	MaxLength int `mapstructure:"max_length" json:"max_length"` // 每个用户离线队列的最大长度，超出时丢弃最旧的消息
+	TTLHours  int `mapstructure:"ttl_hours" json:"ttl_hours"`   // 离线队列过期时间（小时），每次写入时刷新
+}
@@ -0,0 +1,9 @@
+package config
+
+// PresenceConfig 在线状态注册表配置
+type PresenceConfig struct {
+	ConnectionTTLSeconds     int `mapstructure:"connection_ttl_seconds" json:"connection_ttl_seconds"`         // 连接键过期时间，由心跳刷新，需大于客户端心跳间隔
+	HeartbeatIntervalSeconds int `mapstructure:"heartbeat_interval_seconds" json:"heartbeat_interval_seconds"` // 实例心跳间隔
+	InstanceTTLSeconds       int `mapstructure:"instance_ttl_seconds" json:"instance_ttl_seconds"`             // 实例心跳键过期时间，超时视为实例已宕机
+	ReapIntervalSeconds      int `mapstructure:"reap_interval_seconds" json:"reap_interval_seconds"`           // 清理宕机实例的间隔
+}
@@ -1,9 +1,20 @@
 package config
 
+import "fmt"
+
 type RedisConfig struct {
+	Addr     string `mapstructure:"addr" json:"addr"` // host:port，设置后优先于host和port
 	Host     string `mapstructure:"host" json:"host"`
 	Port     int    `mapstructure:"port" json:"port"`
 	Password string `mapstructure:"password" json:"password"`
 	DB       int    `mapstructure:"db" json:"db"`
 	PoolSize int    `mapstructure:"pool_size" json:"pool_size"`
 }
+
+// Address 获取Redis连接地址
+func (c RedisConfig) Address() string {
+	if c.Addr != "" {
+		return c.Addr
+	}
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
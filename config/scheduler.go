@@ -0,0 +1,9 @@
+package config
+
+// SchedulerConfig 定时消息配置
+type SchedulerConfig struct {
+	PollIntervalSeconds int `mapstructure:"poll_interval_seconds" json:"poll_interval_seconds"` // 扫描到期定时消息的间隔
+	LeaseSeconds        int `mapstructure:"lease_seconds" json:"lease_seconds"`                 // 实例领取定时消息后的租约时长，超时未发送完成的消息标记为失败
+	BatchSize           int `mapstructure:"batch_size" json:"batch_size"`                       // 每次最多领取的定时消息数
+	MaxDelayDays        int `mapstructure:"max_delay_days" json:"max_delay_days"`               // 定时发送时间距当前时间的最大天数
+}
@@ -0,0 +1,6 @@
+package config
+
+// SearchConfig 消息搜索配置
+type SearchConfig struct {
+	Driver string `mapstructure:"driver" json:"driver"` // mysql | memory（仅用于测试，数据不持久化，也不在实例间共享）
+}
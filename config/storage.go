@@ -0,0 +1,22 @@
+package config
+
+// StorageConfig 附件存储配置
+type StorageConfig struct {
+	Driver        string   `mapstructure:"driver" json:"driver"`                 // local | s3
+	LocalDir      string   `mapstructure:"local_dir" json:"local_dir"`           // 本地存储根目录
+	MaxSizeMB     int      `mapstructure:"max_size_mb" json:"max_size_mb"`       // 单个附件的最大大小
+	AllowedTypes  []string `mapstructure:"allowed_types" json:"allowed_types"`   // 允许的MIME类型，以/结尾表示前缀匹配（如 image/）
+	ThumbnailSize int      `mapstructure:"thumbnail_size" json:"thumbnail_size"` // 图片缩略图的最大边长（像素）
+
+	S3 S3Config `mapstructure:"s3" json:"s3"`
+}
+
+// S3Config S3兼容对象存储配置
+type S3Config struct {
+	Endpoint  string `mapstructure:"endpoint" json:"endpoint"` // 如 https://s3.us-east-1.amazonaws.com 或 http://127.0.0.1:9000
+	Region    string `mapstructure:"region" json:"region"`
+	Bucket    string `mapstructure:"bucket" json:"bucket"`
+	AccessKey string `mapstructure:"access_key" json:"access_key"`
+	SecretKey string `mapstructure:"secret_key" json:"-"`
+	PathStyle bool   `mapstructure:"path_style" json:"path_style"` // 使用 endpoint/bucket/key 形式的地址（MinIO等需要开启）
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/service"
+	"github.com/Gopher0727/RTMP/internal/utils"
+)
+
+// AdminHandler 管理员处理器
+type AdminHandler struct {
+	broadcastService service.IBroadcastService
+}
+
+// NewAdminHandler 创建管理员处理器
+func NewAdminHandler(broadcastService service.IBroadcastService) *AdminHandler {
+	return &AdminHandler{
+		broadcastService: broadcastService,
+	}
+}
+
+// BroadcastRequest 系统广播请求
+type BroadcastRequest struct {
+	Target      string `json:"target" binding:"required,oneof=all room"`                     // all：全体用户，room：房间全体成员
+	RoomID      uint   `json:"room_id" binding:"required_if=Target room"`                    // 目标房间ID
+	Content     string `json:"content" binding:"required,max=2000"`                          // 广播内容
+	MessageType string `json:"message_type" binding:"omitempty,oneof=system notify warning"` // 消息类型，默认为system
+}
+
+// Broadcast godoc
+// @Summary 发送系统广播
+// @Description 管理员以系统身份向全体用户或房间全体成员发送广播，广播只保存一次，推送给所有实例上的在线连接，离线用户重连时补发
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body BroadcastRequest true "系统广播请求"
+// @Success 200 {object} utils.Response{data=MessageResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/admin/broadcast [post]
+func (h *AdminHandler) Broadcast(c *gin.Context) {
+	var req BroadcastRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	message, err := h.broadcastService.Broadcast(ctx, userID.(uint), service.BroadcastParams{
+		Target:  model.MessageTarget(req.Target),
+		RoomID:  req.RoomID,
+		Type:    model.MessageType(req.MessageType),
+		Content: req.Content,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPermissionDenied), errors.Is(err, service.ErrUserNotFound):
+			utils.ResponseForbidden(c, "需要管理员权限")
+		case errors.Is(err, service.ErrRoomNotFound):
+			utils.ResponseNotFound(c, "房间不存在")
+		case errors.Is(err, service.ErrInvalidOperation):
+			utils.ResponseBadRequest(c, "参数错误")
+		default:
+			utils.ResponseInternalError(c, "发送广播失败")
+		}
+		return
+	}
+
+	utils.ResponseSuccess(c, newMessageResponse(message))
+}
+
+// AdminHandlerSet 管理员处理器依赖注入
+var AdminHandlerSet = wire.NewSet(NewAdminHandler)
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/service"
+	"github.com/Gopher0727/RTMP/internal/utils"
+)
+
+// multipartOverhead 上传请求中表单边界等额外数据的余量
+const multipartOverhead = 1 << 20
+
+// AttachmentHandler 附件处理器
+type AttachmentHandler struct {
+	attachmentService service.IAttachmentService
+	maxBodySize       int64
+}
+
+// NewAttachmentHandler 创建附件处理器
+func NewAttachmentHandler(attachmentService service.IAttachmentService) *AttachmentHandler {
+	maxBodySize := int64(config.GetStorageConfig().MaxSizeMB) << 20
+	if maxBodySize <= 0 {
+		maxBodySize = 20 << 20
+	}
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+		maxBodySize:       maxBodySize + multipartOverhead,
+	}
+}
+
+// UploadAttachment godoc
+// @Summary 上传附件
+// @Description 上传附件（multipart表单字段file），返回的附件ID在发送消息时通过attachment_ids关联；MIME类型根据内容检测，图片会生成缩略图
+// @Tags attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "附件"
+// @Success 200 {object} utils.Response{data=model.Attachment}
+// @Failure 400 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Failure 415 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/attachments [post]
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	// 限制请求体大小，超出时读取表单失败
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxBodySize)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.ResponseError(c, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, "附件过大")
+			return
+		}
+		utils.ResponseBadRequest(c, "缺少附件")
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		utils.ResponseInternalError(c, "读取附件失败")
+		return
+	}
+	defer file.Close()
+
+	ctx := context.Background()
+	attachment, err := h.attachmentService.Upload(ctx, userID.(uint), header.Filename, file)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrAttachmentTooLarge):
+			utils.ResponseError(c, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, "附件过大")
+		case errors.Is(err, service.ErrAttachmentTypeNotAllowed):
+			utils.ResponseError(c, http.StatusUnsupportedMediaType, http.StatusUnsupportedMediaType, "不支持的附件类型")
+		case errors.Is(err, service.ErrInvalidOperation):
+			utils.ResponseBadRequest(c, "附件为空")
+		default:
+			utils.ResponseInternalError(c, "上传附件失败")
+		}
+		return
+	}
+
+	utils.ResponseSuccess(c, attachment)
+}
+
+// DownloadAttachment godoc
+// @Summary 下载附件
+// @Description 下载附件原文件，仅上传者和所属消息的会话参与者可访问
+// @Tags attachments
+// @Produce octet-stream
+// @Param id path int true "附件ID"
+// @Success 200 {file} file
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/attachments/{id} [get]
+func (h *AttachmentHandler) DownloadAttachment(c *gin.Context) {
+	h.serveAttachment(c, false)
+}
+
+// GetThumbnail godoc
+// @Summary 获取附件缩略图
+// @Description 获取图片附件的JPEG缩略图，非图片附件返回404
+// @Tags attachments
+// @Produce jpeg
+// @Param id path int true "附件ID"
+// @Success 200 {file} file
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/attachments/{id}/thumbnail [get]
+func (h *AttachmentHandler) GetThumbnail(c *gin.Context) {
+	h.serveAttachment(c, true)
+}
+
+// serveAttachment 输出附件或缩略图
+func (h *AttachmentHandler) serveAttachment(c *gin.Context, thumbnail bool) {
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的附件ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := c.Request.Context()
+	attachment, reader, err := h.attachmentService.Open(ctx, userID.(uint), uint(attachmentID), thumbnail)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrAttachmentNotFound):
+			utils.ResponseNotFound(c, "附件不存在")
+		case errors.Is(err, service.ErrNotRoomMember):
+			utils.ResponseForbidden(c, "不是房间成员")
+		default:
+			utils.ResponseInternalError(c, "读取附件失败")
+		}
+		return
+	}
+	defer reader.Close()
+
+	if thumbnail {
+		c.DataFromReader(http.StatusOK, -1, "image/jpeg", reader, map[string]string{
+			"Cache-Control": "private, max-age=86400",
+		})
+		return
+	}
+
+	// 图片、音视频在浏览器中直接展示，其他类型作为下载
+	disposition := "attachment"
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(attachment.ContentType, prefix) {
+			disposition = "inline"
+		}
+	}
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, reader, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}),
+		"Cache-Control":          "private, max-age=86400",
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// AttachmentHandlerSet 附件处理器依赖注入
+var AttachmentHandlerSet = wire.NewSet(NewAttachmentHandler)
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/internal/service"
+	"github.com/Gopher0727/RTMP/internal/utils"
+)
+
+// ConversationHandler 会话处理器
+type ConversationHandler struct {
+	conversationService service.IConversationService
+}
+
+// NewConversationHandler 创建会话处理器
+func NewConversationHandler(conversationService service.IConversationService) *ConversationHandler {
+	return &ConversationHandler{
+		conversationService: conversationService,
+	}
+}
+
+// ListConversationsRequest 获取会话列表请求
+type ListConversationsRequest struct {
+	Cursor string `form:"cursor"`                                   // 上一页返回的next_cursor，为空时从最新的会话开始
+	Limit  int    `form:"limit,default=20" binding:"min=1,max=100"` // 每页数量
+}
+
+// ListConversationsResponse 获取会话列表响应
+type ListConversationsResponse struct {
+	Conversations []*service.ConversationSummary `json:"conversations"`
+	NextCursor    string                         `json:"next_cursor,omitempty"` // 下一页的游标，没有更多时为空
+}
+
+// ListConversations godoc
+// @Summary 获取会话列表
+// @Description 按最后活跃时间倒序获取当前用户的私聊和所在房间的会话，附带最新消息和未读数，使用游标分页
+// @Tags conversations
+// @Produce json
+// @Param cursor query string false "上一页返回的next_cursor"
+// @Param limit query int false "每页数量" default(20)
+// @Success 200 {object} utils.Response{data=ListConversationsResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/conversations [get]
+func (h *ConversationHandler) ListConversations(c *gin.Context) {
+	var req ListConversationsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	conversations, next, err := h.conversationService.ListConversations(ctx, userID.(uint), req.Cursor, req.Limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			utils.ResponseBadRequest(c, "无效的游标")
+			return
+		}
+		utils.ResponseInternalError(c, "获取会话列表失败")
+		return
+	}
+
+	utils.ResponseSuccess(c, &ListConversationsResponse{
+		Conversations: conversations,
+		NextCursor:    next,
+	})
+}
+
+// ConversationHandlerSet 会话处理器依赖注入
+var ConversationHandlerSet = wire.NewSet(NewConversationHandler)
@@ -1,7 +1,10 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -11,16 +14,31 @@ import (
 	"github.com/google/wire"
 	"github.com/gorilla/websocket"
 
+	"github.com/Gopher0727/RTMP/config"
 	"github.com/Gopher0727/RTMP/internal/model"
 	"github.com/Gopher0727/RTMP/internal/service"
+	"github.com/Gopher0727/RTMP/pkg/protocol"
+)
+
+const (
+	writeWait  = 10 * time.Second // 单次写超时
+	pongWait   = 60 * time.Second // 等待Pong的超时时间
+	pingPeriod = 54 * time.Second // Ping发送间隔，必须小于pongWait
+
+	defaultSSEKeepAlive = 15 * time.Second // SSE注释保活的默认间隔
+	sseRetry            = 3000             // 建议客户端断线重连的等待时间（毫秒）
 )
 
 // HubHandler Hub API处理器
 type HubHandler struct {
-	hubService     service.IHubService
-	userService    service.IUserService
-	messageService service.IMessageService
-	roomService    service.IRoomService
+	hubService        service.IHubService
+	userService       service.IUserService
+	messageService    service.IMessageService
+	roomService       service.IRoomService
+	pollService       service.IPollService
+	attachmentService service.IAttachmentService
+	clientOptions     service.ClientOptions
+	sseKeepAlive      time.Duration
 }
 
 // NewHubHandler 创建Hub API处理器
@@ -29,27 +47,38 @@ func NewHubHandler(
 	userService service.IUserService,
 	messageService service.IMessageService,
 	roomService service.IRoomService,
+	pollService service.IPollService,
+	attachmentService service.IAttachmentService,
 ) *HubHandler {
+	hubConfig := config.GetHubConfig()
+	sseKeepAlive := time.Duration(hubConfig.SSEKeepAliveSeconds) * time.Second
+	if sseKeepAlive <= 0 {
+		sseKeepAlive = defaultSSEKeepAlive
+	}
 	return &HubHandler{
-		hubService:     hubService,
-		userService:    userService,
-		messageService: messageService,
-		roomService:    roomService,
+		hubService:        hubService,
+		userService:       userService,
+		messageService:    messageService,
+		roomService:       roomService,
+		pollService:       pollService,
+		attachmentService: attachmentService,
+		clientOptions:     service.NewClientOptions(hubConfig),
+		sseKeepAlive:      sseKeepAlive,
 	}
 }
 
 // WebSocketHandler WebSocket连接处理
 func (h *HubHandler) WebSocketHandler(c *gin.Context) {
 	// 从上下文中获取用户ID
-	userIDStr := c.GetString("userID")
-	userID, err := strconv.ParseUint(userIDStr, 10, 64)
-	if err != nil {
+	userID, ok := currentUserID(c)
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
 		return
 	}
 
 	// 升级HTTP连接为WebSocket
 	upgrader := websocket.Upgrader{
+		Subprotocols: []string{protocol.Subprotocol}, // 客户端请求时协商rtmp.v1协议
 		CheckOrigin: func(r *http.Request) bool {
 			return true // 允许所有跨域请求，实际应用中应该限制
 		},
@@ -60,8 +89,9 @@ func (h *HubHandler) WebSocketHandler(c *gin.Context) {
 		return
 	}
 
-	// 创建WebSocket客户端
-	client := service.NewWSClient(uint(userID), conn)
+	// 创建WebSocket客户端，同一用户的多个设备通过device_id区分
+	client := service.NewWSClient(userID, c.Query("device_id"), conn, h.clientOptions)
+	client.Protocol = conn.Subprotocol()
 
 	// 注册客户端
 	if err := h.hubService.Register(c, client); err != nil {
@@ -75,39 +105,155 @@ func (h *HubHandler) WebSocketHandler(c *gin.Context) {
 }
 
 // LongPollingHandler HTTP长轮询处理
+// @Summary HTTP长轮询
+// @Description 会话在两次请求之间保持，期间推送的数据缓存在服务端；携带上次响应的cursor确认已收到的数据，返回之后的一批数据
+// @Tags hub
+// @Produce json
+// @Param session query string false "会话令牌，首次请求不传"
+// @Param cursor query int false "上次响应的游标"
+// @Param device_id query string false "设备ID，仅新建会话时使用"
+// @Success 200 {object} service.PollResult
+// @Security BearerAuth
+// @Router /api/v1/poll [get]
 func (h *HubHandler) LongPollingHandler(c *gin.Context) {
 	// 从上下文中获取用户ID
-	userIDStr := c.GetString("userID")
-	userID, err := strconv.ParseUint(userIDStr, 10, 64)
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+
+	cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的游标"})
+		return
+	}
+
+	// 会话不存在或已过期时新建会话并注册客户端
+	token := c.Query("session")
+	session, found := h.pollService.Session(userID, token)
+	if !found {
+		session, err = h.pollService.Open(c, userID, c.Query("device_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "注册客户端失败"})
+			return
+		}
+		cursor = 0
+	}
+
+	// 等待数据或超时
+	result := h.pollService.Poll(c.Request.Context(), session, cursor)
+	result.Reset = token != "" && !found
+	c.JSON(http.StatusOK, result)
+}
+
+// ClosePollHandler 关闭长轮询会话
+// @Summary 关闭长轮询会话
+// @Description 立即注销长轮询客户端，不再等待宽限期
+// @Tags hub
+// @Produce json
+// @Param session query string true "会话令牌"
+// @Success 200 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /api/v1/poll [delete]
+func (h *HubHandler) ClosePollHandler(c *gin.Context) {
+	// 从上下文中获取用户ID
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+
+	if err := h.pollService.Close(c, userID, c.Query("session")); err != nil {
+		if errors.Is(err, service.ErrPollSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已过期"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "关闭会话失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "会话已关闭"})
+}
+
+// EventsHandler Server-Sent Events推送
+// @Summary SSE推送
+// @Description 以SSE事件流推送消息（event: message，id为消息ID）和事件（event: event），支持Last-Event-ID断线续传
+// @Tags hub
+// @Produce text/event-stream
+// @Param device_id query string false "设备ID"
+// @Param token query string false "JWT令牌，EventSource无法设置请求头时使用"
+// @Param Last-Event-ID header string false "最后收到的消息ID"
+// @Success 200 {string} string "事件流"
+// @Security BearerAuth
+// @Router /api/v1/events [get]
+func (h *HubHandler) EventsHandler(c *gin.Context) {
+	// 从上下文中获取用户ID
+	userID, ok := currentUserID(c)
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
 		return
 	}
 
-	// 创建HTTP长轮询客户端
-	client := service.NewHTTPClient(uint(userID))
+	// 创建SSE客户端，断线重连时补发Last-Event-ID之后的消息
+	client := service.NewSSEClient(userID, c.Query("device_id"), h.clientOptions)
+	client.ResumeAfter = lastEventID(c)
 
 	// 注册客户端
 	if err := h.hubService.Register(c, client); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "注册客户端失败"})
 		return
 	}
+	defer h.hubService.Unregister(context.Background(), client)
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // 关闭nginx缓冲
+	c.Status(http.StatusOK)
+
+	if _, err := fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetry); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(h.sseKeepAlive)
+	defer ticker.Stop()
 
-	// 设置超时时间
-	timeout := time.After(30 * time.Second)
-
-	// 等待消息或超时
-	select {
-	case msg := <-client.SendQueue:
-		c.Data(http.StatusOK, "application/json", msg)
-	case <-timeout:
-		c.JSON(http.StatusOK, gin.H{"status": "timeout"})
-	case <-client.Ctx.Done():
-		c.JSON(http.StatusOK, gin.H{"status": "canceled"})
+	for {
+		select {
+		case msg := <-client.SendQueue:
+			if _, err := c.Writer.Write(msg); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-ticker.C:
+			// 注释行保活，避免代理断开空闲连接
+			if _, err := io.WriteString(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			h.hubService.Heartbeat(context.Background(), client)
+		case <-c.Request.Context().Done():
+			return
+		case <-client.Ctx.Done():
+			return
+		}
 	}
+}
 
-	// 注销客户端
-	h.hubService.Unregister(c, uint(userID))
+// lastEventID 获取SSE断线续传的最后事件ID，EventSource重连时通过Last-Event-ID请求头携带
+func lastEventID(c *gin.Context) uint {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
 }
 
 // GetOnlineUsers 获取在线用户列表
@@ -121,6 +267,22 @@ func (h *HubHandler) GetOnlineUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"users": users})
 }
 
+// GetClientStats 获取本实例客户端发送队列统计，统计包含所有用户和设备，仅管理员可用
+func (h *HubHandler) GetClientStats(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+	user, err := h.userService.GetUserByID(c, userID.(uint))
+	if err != nil || user.Role != model.UserRoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"clients": h.hubService.GetClientStats(c)})
+}
+
 // SendMessage 发送消息
 func (h *HubHandler) SendMessage(c *gin.Context) {
 	var req struct {
@@ -146,12 +308,12 @@ func (h *HubHandler) SendMessage(c *gin.Context) {
 	message := &model.Message{
 		Content:  req.Content,
 		SenderID: uint(senderID),
+		Type:     string(model.MessageTypeText),
 		IsRead:   false,
 	}
 
 	// 根据消息类型设置目标
 	if req.MessageType == "user" {
-		message.Type = "user"
 		message.ReceiverID = req.TargetID
 		// 发送私聊消息
 		if err := h.hubService.SendMessage(c, message); err != nil {
@@ -160,7 +322,6 @@ func (h *HubHandler) SendMessage(c *gin.Context) {
 		}
 	} else {
 		// 房间消息
-		message.Type = "room"
 		message.RoomID = req.TargetID
 		// 广播房间消息
 		if err := h.hubService.BroadcastToRoom(c, req.TargetID, message); err != nil {
@@ -175,14 +336,17 @@ func (h *HubHandler) SendMessage(c *gin.Context) {
 // readPump 处理WebSocket读取
 func (h *HubHandler) readPump(client *service.Client) {
 	defer func() {
-		h.hubService.Unregister(client.Ctx, client.UserID)
+		// 客户端上下文在注销时会被取消，这里使用独立的上下文更新状态
+		h.hubService.Unregister(context.Background(), client)
 		client.Conn.Close()
 	}()
 
 	client.Conn.SetReadLimit(1024 * 1024) // 1MB
-	client.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	client.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	client.Conn.SetPongHandler(func(string) error {
-		client.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		// 刷新在线状态注册表中的连接TTL
+		h.hubService.Heartbeat(context.Background(), client)
 		return nil
 	})
 
@@ -195,62 +359,18 @@ func (h *HubHandler) readPump(client *service.Client) {
 			break
 		}
 
-		// 解析消息
-		var msg struct {
-			Type        string `json:"type"`         // 消息类型: message, ping等
-			TargetID    uint   `json:"target_id"`    // 目标ID: 房间ID或用户ID
-			Content     string `json:"content"`      // 消息内容
-			SenderID    uint   `json:"sender_id"`    // 发送者ID
-			MessageType string `json:"message_type"` // 内部消息类型: user, room
-		}
-
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("解析WebSocket消息失败: %v", err)
-			continue
-		}
-
-		// 处理不同类型的消息
-		switch msg.Type {
-		case "ping":
-			// 处理心跳消息
-			client.LastActive = time.Now()
-			if err := client.Conn.WriteMessage(websocket.PongMessage, nil); err != nil {
-				log.Printf("发送Pong消息失败: %v", err)
-			}
-		case "message":
-			// 创建消息对象
-			messageObj := &model.Message{
-				SenderID:  msg.SenderID,
-				Content:   msg.Content,
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-				IsRead:    false,
-			}
-
-			// 根据消息类型设置接收者
-			if msg.MessageType == "user" {
-				// 私聊消息
-				messageObj.Type = "user"
-				messageObj.ReceiverID = msg.TargetID
-			} else {
-				// 房间消息
-				messageObj.Type = "room"
-				messageObj.RoomID = msg.TargetID
-			}
-
-			// 通过hubService发送消息，这会通过Kafka广播到其他实例
-			if err := h.hubService.SendMessage(client.Ctx, messageObj); err != nil {
-				log.Printf("发送消息失败: %v", err)
-			}
-		default:
-			log.Printf("未知的消息类型: %s", msg.Type)
+		// 协商了rtmp.v1协议的连接使用帧格式，否则按旧的JSON格式处理
+		if client.Protocol == protocol.Subprotocol {
+			h.handleFrame(client, message)
+		} else {
+			h.handleLegacy(client, message)
 		}
 	}
 }
 
-// writePump 处理WebSocket写入
+// writePump 处理WebSocket写入，是连接上唯一的数据帧写入者
 func (h *HubHandler) writePump(client *service.Client) {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 		client.Conn.Close()
@@ -258,15 +378,35 @@ func (h *HubHandler) writePump(client *service.Client) {
 
 	for {
 		select {
+		case msg := <-client.SendQueue:
+			client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("WebSocket写入错误: %v", err)
+				return
+			}
 		case <-ticker.C:
+			client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		case <-client.Ctx.Done():
+			// 客户端被注销或因慢消费被断开
+			client.Conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
 			return
 		}
 	}
 }
 
+// currentUserID 从JWT中间件设置的上下文中获取当前用户ID
+func currentUserID(c *gin.Context) (uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return 0, false
+	}
+	id, ok := userID.(uint)
+	return id, ok && id != 0
+}
+
 // HubHandlerSet Hub处理器依赖注入
 var HubHandlerSet = wire.NewSet(NewHubHandler)
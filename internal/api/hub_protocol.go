@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/service"
+	"github.com/Gopher0727/RTMP/pkg/protocol"
+)
+
+// handleFrame 处理rtmp.v1协议的客户端帧，带ID的请求回复ok或error
+func (h *HubHandler) handleFrame(client *service.Client, raw []byte) {
+	frame, err := protocol.Decode(raw)
+	if err != nil {
+		id := ""
+		code := protocol.CodeBadRequest
+		if errors.Is(err, protocol.ErrVersion) {
+			id = frame.ID
+			code = protocol.CodeUnsupportedVersion
+		}
+		h.replyError(client, id, code, err.Error())
+		return
+	}
+
+	ctx := context.Background()
+	switch frame.Type {
+	case protocol.TypePing:
+		h.hubService.Heartbeat(ctx, client)
+		h.reply(client, protocol.TypePong, frame.ID, nil)
+
+	case protocol.TypeSend:
+		var data protocol.SendData
+		if err := frame.DecodeData(&data); err != nil {
+			h.replyError(client, frame.ID, protocol.CodeBadRequest, err.Error())
+			return
+		}
+		message, err := h.sendFromClient(ctx, client, data)
+		if err != nil {
+			h.replyServiceError(client, frame.ID, err)
+			return
+		}
+		h.replyOK(client, frame.ID, protocol.SentData{
+			MessageID:      message.ID,
+			ConversationID: message.ConversationID,
+			Seq:            message.Seq,
+			ThreadRootID:   message.ThreadRootID,
+		})
+
+	case protocol.TypeAck:
+		var data protocol.AckData
+		if err := frame.DecodeData(&data); err != nil {
+			h.replyError(client, frame.ID, protocol.CodeBadRequest, err.Error())
+			return
+		}
+		if err := h.hubService.Ack(ctx, client, data.ConversationID, data.Seq); err != nil {
+			h.replyServiceError(client, frame.ID, err)
+			return
+		}
+		h.replyOK(client, frame.ID, nil)
+
+	case protocol.TypeTyping:
+		var data protocol.TypingData
+		if err := frame.DecodeData(&data); err != nil {
+			h.replyError(client, frame.ID, protocol.CodeBadRequest, err.Error())
+			return
+		}
+		if err := h.hubService.SendSignal(ctx, client, data.ConversationID, service.SignalTyping, data.Typing, nil); err != nil {
+			h.replyServiceError(client, frame.ID, err)
+			return
+		}
+		h.replyOK(client, frame.ID, nil)
+
+	case protocol.TypeSignal:
+		var data protocol.SignalData
+		if err := frame.DecodeData(&data); err != nil {
+			h.replyError(client, frame.ID, protocol.CodeBadRequest, err.Error())
+			return
+		}
+		if err := h.hubService.SendSignal(ctx, client, data.ConversationID, data.Signal, data.Active, data.Data); err != nil {
+			h.replyServiceError(client, frame.ID, err)
+			return
+		}
+		h.replyOK(client, frame.ID, nil)
+
+	case protocol.TypeSubscribe, protocol.TypeUnsubscribe:
+		var data protocol.SubscribeData
+		if err := frame.DecodeData(&data); err != nil {
+			h.replyError(client, frame.ID, protocol.CodeBadRequest, err.Error())
+			return
+		}
+		if frame.Type == protocol.TypeUnsubscribe {
+			client.Unsubscribe(data.ConversationIDs)
+		} else if err := h.hubService.Subscribe(ctx, client, data.ConversationIDs); err != nil {
+			h.replyServiceError(client, frame.ID, err)
+			return
+		}
+		h.replyOK(client, frame.ID, data)
+
+	default:
+		h.replyError(client, frame.ID, protocol.CodeUnknownType, "unknown frame type: "+frame.Type)
+	}
+}
+
+// handleLegacy 处理未协商协议的旧JSON格式消息
+func (h *HubHandler) handleLegacy(client *service.Client, raw []byte) {
+	var msg struct {
+		Type           string `json:"type"`            // 消息类型: message, ping, ack
+		TargetID       uint   `json:"target_id"`       // 目标ID: 房间ID或用户ID
+		Content        string `json:"content"`         // 消息内容
+		MessageType    string `json:"message_type"`    // 内部消息类型: user, room
+		ConversationID string `json:"conversation_id"` // ack: 会话ID
+		Seq            uint64 `json:"seq"`             // ack: 设备在该会话中已收到的最大序号
+		AttachmentIDs  []uint `json:"attachment_ids"`  // 附件ID
+		ReplyToID      uint   `json:"reply_to_id"`     // 回复的消息ID
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		log.Printf("解析WebSocket消息失败: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	switch msg.Type {
+	case "ping":
+		// 处理心跳消息，控制帧可与writePump并发写出
+		h.hubService.Heartbeat(ctx, client)
+		if err := client.Conn.WriteControl(websocket.PongMessage, nil, time.Now().Add(writeWait)); err != nil {
+			log.Printf("发送Pong消息失败: %v", err)
+		}
+	case "message":
+		// 发送者始终是当前连接的用户
+		data := protocol.SendData{
+			TargetType:    msg.MessageType,
+			TargetID:      msg.TargetID,
+			Content:       msg.Content,
+			AttachmentIDs: msg.AttachmentIDs,
+			ReplyToID:     msg.ReplyToID,
+		}
+		if _, err := h.sendFromClient(ctx, client, data); err != nil {
+			log.Printf("发送消息失败: %v", err)
+		}
+	case "ack":
+		// 客户端确认已收到的消息，重连时只补发未确认的消息
+		if err := h.hubService.Ack(ctx, client, msg.ConversationID, msg.Seq); err != nil {
+			log.Printf("处理消息确认失败: %v", err)
+		}
+	default:
+		log.Printf("未知的消息类型: %s", msg.Type)
+	}
+}
+
+// sendFromClient 以连接所属用户的身份发送私聊或房间消息
+func (h *HubHandler) sendFromClient(ctx context.Context, client *service.Client, data protocol.SendData) (*model.Message, error) {
+	targetID := data.TargetID
+	if targetID == 0 || (data.Content == "" && len(data.AttachmentIDs) == 0) || len(data.ClientMsgID) > model.MaxClientMsgIDLength {
+		return nil, service.ErrInvalidOperation
+	}
+
+	// 重发时由消息仓库识别为重复消息，回复首次发送的消息
+	var clientMsgID *string
+	if data.ClientMsgID != "" {
+		clientMsgID = &data.ClientMsgID
+	}
+
+	attachments, err := h.attachmentService.Resolve(ctx, client.UserID, clientMsgID, data.AttachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &model.Message{
+		SenderID:    client.UserID,
+		Content:     data.Content,
+		Type:        string(model.MessageTypeText),
+		Attachments: attachments,
+		ReplyToID:   data.ReplyToID,
+		ClientMsgID: clientMsgID,
+	}
+	if err := h.messageService.ExpireAfter(message, data.TTLSeconds); err != nil {
+		return nil, err
+	}
+
+	switch data.TargetType {
+	case "user":
+		message.ReceiverID = targetID
+		if err := h.hubService.SendMessage(ctx, message); err != nil {
+			return nil, err
+		}
+	case "room":
+		isMember, err := h.roomService.IsMember(ctx, targetID, client.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, service.ErrNotRoomMember
+		}
+		message.RoomID = targetID
+		if err := h.hubService.BroadcastToRoom(ctx, targetID, message); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, service.ErrInvalidOperation
+	}
+	return message, nil
+}
+
+// reply 回复客户端帧
+func (h *HubHandler) reply(client *service.Client, frameType, id string, data any) {
+	msg, err := protocol.Encode(frameType, id, data)
+	if err != nil {
+		log.Printf("编码回复帧失败: %v", err)
+		return
+	}
+	if !client.Reply(msg) {
+		log.Printf("回复帧入队失败: UserID=%d, DeviceID=%s", client.UserID, client.DeviceID)
+	}
+}
+
+// replyOK 回复请求成功，没有请求ID时不回复
+func (h *HubHandler) replyOK(client *service.Client, id string, data any) {
+	if id == "" {
+		return
+	}
+	h.reply(client, protocol.TypeOK, id, data)
+}
+
+// replyError 回复错误
+func (h *HubHandler) replyError(client *service.Client, id, code, message string) {
+	h.reply(client, protocol.TypeError, id, protocol.ErrorData{Code: code, Message: message})
+}
+
+// replyServiceError 将服务层错误转换为协议错误码后回复
+func (h *HubHandler) replyServiceError(client *service.Client, id string, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidConversation), errors.Is(err, service.ErrInvalidOperation),
+		errors.Is(err, service.ErrAttachmentUnavailable), errors.Is(err, service.ErrInvalidReply),
+		errors.Is(err, service.ErrInvalidSignal), errors.Is(err, service.ErrInvalidTTL):
+		h.replyError(client, id, protocol.CodeBadRequest, err.Error())
+	case errors.Is(err, service.ErrNotRoomMember):
+		h.replyError(client, id, protocol.CodeForbidden, err.Error())
+	case errors.Is(err, service.ErrRoomNotFound), errors.Is(err, service.ErrUserNotFound):
+		h.replyError(client, id, protocol.CodeNotFound, err.Error())
+	case errors.Is(err, service.ErrRateLimited):
+		h.replyError(client, id, protocol.CodeRateLimited, err.Error())
+	default:
+		log.Printf("处理WebSocket请求失败: %v", err)
+		h.replyError(client, id, protocol.CodeInternal, "internal error")
+	}
+}
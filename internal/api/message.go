@@ -2,7 +2,11 @@ package api
 
 import (
 	"context"
+	"errors"
+	"log"
+	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/wire"
@@ -14,21 +18,37 @@ import (
 
 // MessageHandler 消息处理器
 type MessageHandler struct {
-	messageService service.IMessageService
+	messageService    service.IMessageService
+	hubService        service.IHubService
+	attachmentService service.IAttachmentService
+	scheduledService  service.IScheduledMessageService
+	roomService       service.IRoomService
 }
 
 // NewMessageHandler 创建消息处理器
-func NewMessageHandler(messageService service.IMessageService) *MessageHandler {
+func NewMessageHandler(messageService service.IMessageService, hubService service.IHubService, attachmentService service.IAttachmentService,
+	scheduledService service.IScheduledMessageService, roomService service.IRoomService) *MessageHandler {
 	return &MessageHandler{
-		messageService: messageService,
+		messageService:    messageService,
+		hubService:        hubService,
+		attachmentService: attachmentService,
+		scheduledService:  scheduledService,
+		roomService:       roomService,
 	}
 }
 
 // SendMessageRequest 发送消息请求
 type SendMessageRequest struct {
-	Content     string `json:"content" binding:"required"`
-	MessageType string `json:"message_type" binding:"required,oneof=user room"`
-	TargetID    uint   `json:"target_id" binding:"required"`
+	Content       string `json:"content"` // 内容，带附件时可为空
+	MessageType   string `json:"message_type" binding:"required,oneof=user room"`
+	TargetID      uint   `json:"target_id" binding:"required"`
+	AttachmentIDs []uint `json:"attachment_ids"` // 已上传的附件ID
+	ReplyToID     uint   `json:"reply_to_id"`    // 回复的消息ID，须属于同一会话
+	TTLSeconds    int    `json:"ttl_seconds"`    // 有效期（秒），过期后消息被删除，0表示不过期
+	// 客户端生成的消息ID，重试时使用相同的ID，服务端返回首次发送的消息而不会重复创建
+	ClientMsgID *string `json:"client_msg_id" binding:"omitempty,min=1,max=64"`
+	// 定时发送时间（RFC3339），设置时保存为定时消息，到时间后再发送
+	SendAt *time.Time `json:"send_at"`
 }
 
 // MessageResponse 消息响应
@@ -42,6 +62,58 @@ type MessageResponse struct {
 	SenderName string              `json:"sender_name"`
 	IsRead     bool                `json:"is_read"`
 	CreatedAt  string              `json:"created_at"`
+	EditedAt   string              `json:"edited_at,omitempty"`
+	ExpiresAt  string              `json:"expires_at,omitempty"`
+
+	ConversationID string `json:"conversation_id"`
+	Seq            uint64 `json:"seq"` // 会话内的序号，确认送达（ack）时使用
+
+	Attachments []model.Attachment `json:"attachments,omitempty"`
+
+	ReplyToID    uint                `json:"reply_to_id,omitempty"`
+	ThreadRootID uint                `json:"thread_root_id,omitempty"`
+	ReplyTo      *model.MessageQuote `json:"reply_to,omitempty"`
+	ReplyCount   int64               `json:"reply_count,omitempty"`
+
+	Reactions []model.ReactionCount `json:"reactions,omitempty"`
+
+	ClientMsgID *string `json:"client_msg_id,omitempty"`
+}
+
+// newMessageResponse 转换消息响应
+func newMessageResponse(msg *model.Message) *MessageResponse {
+	resp := &MessageResponse{
+		ID:         msg.ID,
+		Content:    msg.Content,
+		Type:       model.MessageType(msg.Type),
+		TargetType: model.MessageTarget(msg.TargetType),
+		TargetID:   msg.TargetID,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		IsRead:     msg.IsRead,
+		CreatedAt:  msg.CreatedAt.Format("2006-01-02 15:04:05"),
+
+		ConversationID: msg.ConversationID,
+		Seq:            msg.Seq,
+
+		Attachments: msg.Attachments,
+
+		ReplyToID:    msg.ReplyToID,
+		ThreadRootID: msg.ThreadRootID,
+		ReplyTo:      msg.ReplyTo,
+		ReplyCount:   msg.ReplyCount,
+
+		Reactions: msg.Reactions,
+
+		ClientMsgID: msg.ClientMsgID,
+	}
+	if msg.EditedAt != nil {
+		resp.EditedAt = msg.EditedAt.Format("2006-01-02 15:04:05")
+	}
+	if msg.ExpiresAt != nil {
+		resp.ExpiresAt = msg.ExpiresAt.Format("2006-01-02 15:04:05")
+	}
+	return resp
 }
 
 // ListMessagesRequest 获取消息列表请求
@@ -50,6 +122,53 @@ type ListMessagesRequest struct {
 	Size int `form:"size,default=10" binding:"min=1,max=100"`
 }
 
+// MessageHistoryRequest 获取消息历史请求，带before_id、after_id、around_id之一时使用键集分页，size为每页数量
+type MessageHistoryRequest struct {
+	ListMessagesRequest
+	BeforeID  *uint `form:"before_id"`  // 获取ID小于before_id的消息，为0时获取最新的消息
+	AfterID   *uint `form:"after_id"`   // 获取ID大于after_id的消息
+	AroundID  *uint `form:"around_id"`  // 获取around_id及其前后的消息
+	WithTotal bool  `form:"with_total"` // 键集分页时是否统计消息总数
+}
+
+// keyset 是否使用键集分页
+func (r *MessageHistoryRequest) keyset() bool {
+	return r.BeforeID != nil || r.AfterID != nil || r.AroundID != nil
+}
+
+// valid 键集分页参数至多设置一个
+func (r *MessageHistoryRequest) valid() bool {
+	n := 0
+	for _, id := range []*uint{r.BeforeID, r.AfterID, r.AroundID} {
+		if id != nil {
+			n++
+		}
+	}
+	return n <= 1
+}
+
+// cursor 转换为键集分页参数
+func (r *MessageHistoryRequest) cursor() service.MessageCursor {
+	cursor := service.MessageCursor{Limit: r.Size}
+	switch {
+	case r.BeforeID != nil:
+		cursor.BeforeID = *r.BeforeID
+	case r.AfterID != nil:
+		cursor.AfterID = *r.AfterID
+	case r.AroundID != nil:
+		cursor.AroundID = *r.AroundID
+	}
+	return cursor
+}
+
+// CursorMessagesResponse 键集分页获取消息列表响应
+type CursorMessagesResponse struct {
+	Messages  []*MessageResponse `json:"messages"`        // 按ID降序
+	HasBefore bool               `json:"has_before"`      // 是否还有更早的消息，继续获取时传入before_id=最后一条消息的ID
+	HasAfter  bool               `json:"has_after"`       // 是否还有更新的消息，继续获取时传入after_id=第一条消息的ID
+	Total     *int64             `json:"total,omitempty"` // 消息总数，仅with_total=true时返回
+}
+
 // ListMessagesResponse 获取消息列表响应
 type ListMessagesResponse struct {
 	Messages []*MessageResponse `json:"messages"`
@@ -58,14 +177,23 @@ type ListMessagesResponse struct {
 	Size     int                `json:"size"`
 }
 
+// ThreadResponse 获取话题响应
+type ThreadResponse struct {
+	Root    *MessageResponse   `json:"root"`
+	Replies []*MessageResponse `json:"replies"`
+	Total   int64              `json:"total"`
+	Page    int                `json:"page"`
+	Size    int                `json:"size"`
+}
+
 // SendMessage godoc
 // @Summary 发送消息
-// @Description 发送消息到用户或房间
+// @Description 发送消息到用户或房间并返回消息，带send_at时保存为定时消息并返回，到发送时间后再发送；带client_msg_id时同一发送者重试只创建一条消息，返回首次发送的消息
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param request body SendMessageRequest true "发送消息请求"
-// @Success 200 {object} utils.Response
+// @Success 200 {object} utils.Response{data=MessageResponse}
 // @Failure 400 {object} utils.Response
 // @Failure 403 {object} utils.Response
 // @Failure 500 {object} utils.Response
@@ -73,7 +201,7 @@ type ListMessagesResponse struct {
 // @Router /api/v1/messages [post]
 func (h *MessageHandler) SendMessage(c *gin.Context) {
 	var req SendMessageRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Content == "" && len(req.AttachmentIDs) == 0) {
 		utils.ResponseBadRequest(c, "参数错误")
 		return
 	}
@@ -91,12 +219,20 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
+	if req.SendAt != nil {
+		h.scheduleMessage(c, &req, userID.(uint), username.(string))
+		return
+	}
+
+	ctx := context.Background()
 	// 创建消息对象
 	message := &model.Message{
-		Content:    req.Content,
-		SenderID:   userID.(uint),
-		SenderName: username.(string),
-		Type:       string(model.MessageTypeText), // 默认设置为文本消息类型
+		Content:     req.Content,
+		SenderID:    userID.(uint),
+		SenderName:  username.(string),
+		Type:        string(model.MessageTypeText), // 默认设置为文本消息类型
+		ReplyToID:   req.ReplyToID,
+		ClientMsgID: req.ClientMsgID,
 	}
 
 	// 根据消息类型设置接收者
@@ -112,31 +248,72 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		message.RoomID = req.TargetID
 	}
 
-	ctx := context.Background()
-	err := h.messageService.SendMessage(ctx, message)
+	if err := h.messageService.ExpireAfter(message, req.TTLSeconds); err != nil {
+		utils.ResponseBadRequest(c, "有效期超出允许的范围")
+		return
+	}
+
+	attachments, err := h.attachmentService.Resolve(ctx, message.SenderID, message.ClientMsgID, req.AttachmentIDs)
 	if err != nil {
-		if err == service.ErrNotRoomMember {
-			utils.ResponseForbidden(c, "不是房间成员")
+		if errors.Is(err, service.ErrAttachmentUnavailable) {
+			utils.ResponseBadRequest(c, "附件不存在或已发送")
 			return
 		}
 		utils.ResponseInternalError(c, "发送消息失败")
 		return
 	}
+	message.Attachments = attachments
 
-	utils.ResponseSuccess(c, nil)
+	if err := h.deliver(ctx, message); err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotRoomMember):
+			utils.ResponseForbidden(c, "不是房间成员")
+		case errors.Is(err, service.ErrInvalidReply):
+			utils.ResponseBadRequest(c, "回复的消息不存在")
+		case errors.Is(err, service.ErrAttachmentUnavailable):
+			// 解析后、写入前附件被并发发送的消息关联
+			utils.ResponseBadRequest(c, "附件不存在或已发送")
+		default:
+			utils.ResponseInternalError(c, "发送消息失败")
+		}
+		return
+	}
+
+	utils.ResponseSuccess(c, newMessageResponse(message))
+}
+
+// deliver 与WebSocket发送相同，通过Hub持久化并推送消息；重试发送时message被替换为首次发送的消息
+func (h *MessageHandler) deliver(ctx context.Context, message *model.Message) error {
+	if message.TargetType != model.MessageTargetRoom {
+		return h.hubService.SendMessage(ctx, message)
+	}
+
+	isMember, err := h.roomService.IsMember(ctx, message.RoomID, message.SenderID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return service.ErrNotRoomMember
+	}
+	return h.hubService.BroadcastToRoom(ctx, message.RoomID, message)
 }
 
 // GetUserMessages godoc
 // @Summary 获取用户消息
-// @Description 获取指定用户的消息列表
+// @Description 获取指定用户的消息列表；带before_id、after_id、around_id之一时使用键集分页，返回CursorMessagesResponse
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param user_id path int true "用户ID"
 // @Param page query int false "页码" default(1)
 // @Param size query int false "每页数量" default(10)
+// @Param before_id query int false "获取ID小于before_id的消息，为0时获取最新的消息"
+// @Param after_id query int false "获取ID大于after_id的消息"
+// @Param around_id query int false "获取around_id及其前后的消息"
+// @Param with_total query bool false "键集分页时是否统计消息总数"
 // @Success 200 {object} utils.Response{data=ListMessagesResponse}
 // @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
 // @Failure 500 {object} utils.Response
 // @Security BearerAuth
 // @Router /api/v1/messages/user/{user_id} [get]
@@ -148,13 +325,18 @@ func (h *MessageHandler) GetUserMessages(c *gin.Context) {
 		return
 	}
 
-	var req ListMessagesRequest
-	if err = c.ShouldBindQuery(&req); err != nil {
+	var req MessageHistoryRequest
+	if err = c.ShouldBindQuery(&req); err != nil || !req.valid() {
 		utils.ResponseBadRequest(c, "参数错误")
 		return
 	}
 
 	ctx := context.Background()
+	if req.keyset() {
+		h.listMessagesByCursor(c, model.MessageTargetUser, uint(userID), &req, "获取用户消息失败")
+		return
+	}
+
 	messages, total, err := h.messageService.GetUserMessages(ctx, uint(userID), req.Page, req.Size)
 	if err != nil {
 		utils.ResponseInternalError(c, "获取用户消息失败")
@@ -163,17 +345,7 @@ func (h *MessageHandler) GetUserMessages(c *gin.Context) {
 
 	messageResponses := make([]*MessageResponse, len(messages))
 	for i, msg := range messages {
-		messageResponses[i] = &MessageResponse{
-			ID:         msg.ID,
-			Content:    msg.Content,
-			Type:       model.MessageType(msg.Type),
-			TargetType: model.MessageTarget(msg.TargetType),
-			TargetID:   msg.TargetID,
-			SenderID:   msg.SenderID,
-			SenderName: msg.SenderName,
-			IsRead:     msg.IsRead,
-			CreatedAt:  msg.CreatedAt.Format("2006-01-02 15:04:05"),
-		}
+		messageResponses[i] = newMessageResponse(msg)
 	}
 
 	resp := &ListMessagesResponse{
@@ -188,15 +360,20 @@ func (h *MessageHandler) GetUserMessages(c *gin.Context) {
 
 // GetRoomMessages godoc
 // @Summary 获取房间消息
-// @Description 获取指定房间的消息列表
+// @Description 获取指定房间的消息列表，附带话题回复数；带before_id、after_id、around_id之一时使用键集分页，返回CursorMessagesResponse
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param room_id path int true "房间ID"
 // @Param page query int false "页码" default(1)
 // @Param size query int false "每页数量" default(10)
+// @Param before_id query int false "获取ID小于before_id的消息，为0时获取最新的消息"
+// @Param after_id query int false "获取ID大于after_id的消息"
+// @Param around_id query int false "获取around_id及其前后的消息"
+// @Param with_total query bool false "键集分页时是否统计消息总数"
 // @Success 200 {object} utils.Response{data=ListMessagesResponse}
 // @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
 // @Failure 500 {object} utils.Response
 // @Security BearerAuth
 // @Router /api/v1/messages/room/{room_id} [get]
@@ -208,13 +385,18 @@ func (h *MessageHandler) GetRoomMessages(c *gin.Context) {
 		return
 	}
 
-	var req ListMessagesRequest
-	if err = c.ShouldBindQuery(&req); err != nil {
+	var req MessageHistoryRequest
+	if err = c.ShouldBindQuery(&req); err != nil || !req.valid() {
 		utils.ResponseBadRequest(c, "参数错误")
 		return
 	}
 
 	ctx := context.Background()
+	if req.keyset() {
+		h.listMessagesByCursor(c, model.MessageTargetRoom, uint(roomID), &req, "获取房间消息失败")
+		return
+	}
+
 	messages, total, err := h.messageService.GetRoomMessages(ctx, uint(roomID), req.Page, req.Size)
 	if err != nil {
 		utils.ResponseInternalError(c, "获取房间消息失败")
@@ -223,17 +405,7 @@ func (h *MessageHandler) GetRoomMessages(c *gin.Context) {
 
 	messageResponses := make([]*MessageResponse, len(messages))
 	for i, msg := range messages {
-		messageResponses[i] = &MessageResponse{
-			ID:         msg.ID,
-			Content:    msg.Content,
-			Type:       model.MessageType(msg.Type),
-			TargetType: model.MessageTarget(msg.TargetType),
-			TargetID:   msg.TargetID,
-			SenderID:   msg.SenderID,
-			SenderName: msg.SenderName,
-			IsRead:     msg.IsRead,
-			CreatedAt:  msg.CreatedAt.Format("2006-01-02 15:04:05"),
-		}
+		messageResponses[i] = newMessageResponse(msg)
 	}
 
 	resp := &ListMessagesResponse{
@@ -246,6 +418,43 @@ func (h *MessageHandler) GetRoomMessages(c *gin.Context) {
 	utils.ResponseSuccess(c, resp)
 }
 
+// listMessagesByCursor 按键集分页返回发给用户或房间的消息，仅房间成员或接收者本人可查看
+func (h *MessageHandler) listMessagesByCursor(c *gin.Context, target model.MessageTarget, targetID uint, req *MessageHistoryRequest, failMsg string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	page, total, err := h.messageService.GetMessagesByCursor(ctx, userID.(uint), target, targetID, req.cursor(), req.WithTotal)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotRoomMember):
+			utils.ResponseForbidden(c, "不是房间成员")
+		case errors.Is(err, service.ErrPermissionDenied):
+			utils.ResponseForbidden(c, "只能查看发给自己的消息")
+		default:
+			utils.ResponseInternalError(c, failMsg)
+		}
+		return
+	}
+
+	resp := &CursorMessagesResponse{
+		Messages:  make([]*MessageResponse, len(page.Messages)),
+		HasBefore: page.HasBefore,
+		HasAfter:  page.HasAfter,
+	}
+	for i, msg := range page.Messages {
+		resp.Messages[i] = newMessageResponse(msg)
+	}
+	if req.WithTotal {
+		resp.Total = &total
+	}
+
+	utils.ResponseSuccess(c, resp)
+}
+
 // MarkAsReadRequest 标记已读请求
 type MarkAsReadRequest struct {
 	MessageIDs []uint `json:"message_ids" binding:"required"`
@@ -253,13 +462,15 @@ type MarkAsReadRequest struct {
 
 // MarkAsRead godoc
 // @Summary 标记消息已读
-// @Description 标记指定消息为已读状态
+// @Description 将当前用户在消息所在会话中的已读位置推进到这些消息，只能标记自己参与的会话；向会话参与者推送read_receipt事件，返回推进后的已读位置
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param request body MarkAsReadRequest true "标记已读请求"
-// @Success 200 {object} utils.Response
+// @Success 200 {object} utils.Response{data=[]model.ReadCursor}
 // @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
 // @Failure 500 {object} utils.Response
 // @Security BearerAuth
 // @Router /api/v1/messages/read [put]
@@ -270,15 +481,677 @@ func (h *MessageHandler) MarkAsRead(c *gin.Context) {
 		return
 	}
 
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
 	ctx := context.Background()
-	err := h.messageService.MarkAsRead(ctx, req.MessageIDs)
+	cursors, err := h.messageService.MarkAsRead(ctx, userID.(uint), req.MessageIDs)
 	if err != nil {
-		utils.ResponseInternalError(c, "标记消息已读失败")
+		h.responseModifyError(c, err, "标记消息已读失败")
 		return
 	}
 
+	h.publishReadReceipts(ctx, cursors...)
+	utils.ResponseSuccess(c, cursors)
+}
+
+// MarkConversationReadRequest 标记会话已读请求
+type MarkConversationReadRequest struct {
+	MessageID uint `json:"message_id"` // 已读到的消息ID，为空时标记会话中的全部消息为已读
+}
+
+// MarkConversationRead godoc
+// @Summary 标记会话已读
+// @Description 将当前用户在会话中的已读位置推进到指定消息，已读位置只增不减；向会话参与者推送read_receipt事件
+// @Tags conversations
+// @Accept json
+// @Produce json
+// @Param conversation_id path string true "会话ID，如room:1、dm:1:2"
+// @Param request body MarkConversationReadRequest false "标记会话已读请求"
+// @Success 200 {object} utils.Response{data=model.ReadCursor}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/conversations/{conversation_id}/read [put]
+func (h *MessageHandler) MarkConversationRead(c *gin.Context) {
+	var req MarkConversationReadRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.ResponseBadRequest(c, "参数错误")
+			return
+		}
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	cursor, err := h.messageService.MarkConversationRead(ctx, userID.(uint), c.Param("conversation_id"), req.MessageID)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidConversation) {
+			utils.ResponseBadRequest(c, "无效的会话ID")
+			return
+		}
+		h.responseModifyError(c, err, "标记会话已读失败")
+		return
+	}
+
+	h.publishReadReceipts(ctx, cursor)
+	utils.ResponseSuccess(c, cursor)
+}
+
+// UnreadCountsResponse 未读消息统计响应
+type UnreadCountsResponse struct {
+	Conversations []*model.UnreadCount `json:"conversations"`
+	Total         int64                `json:"total"` // 全部会话的未读消息数
+}
+
+// GetUnreadCounts godoc
+// @Summary 获取未读消息数
+// @Description 获取当前用户在所在房间和私聊会话中的未读消息数（他人发送的、在已读位置之后的消息）
+// @Tags conversations
+// @Produce json
+// @Success 200 {object} utils.Response{data=UnreadCountsResponse}
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/conversations/unread [get]
+func (h *MessageHandler) GetUnreadCounts(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	counts, err := h.messageService.GetUnreadCounts(ctx, userID.(uint))
+	if err != nil {
+		utils.ResponseInternalError(c, "获取未读消息数失败")
+		return
+	}
+
+	resp := &UnreadCountsResponse{Conversations: counts}
+	for _, count := range counts {
+		resp.Total += count.Unread
+	}
+	utils.ResponseSuccess(c, resp)
+}
+
+// publishReadReceipts 推送已读回执
+func (h *MessageHandler) publishReadReceipts(ctx context.Context, cursors ...*model.ReadCursor) {
+	for _, cursor := range cursors {
+		if err := h.hubService.PublishReadReceipt(ctx, cursor); err != nil {
+			log.Printf("推送已读回执失败: %v", err)
+		}
+	}
+}
+
+// EditMessageRequest 编辑消息请求
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// EditMessage godoc
+// @Summary 编辑消息
+// @Description 发送者在时间窗口内编辑消息，房间管理员不受限制；编辑前的内容保存到编辑历史，并向会话参与者推送message_edited事件
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Param id path int true "消息ID"
+// @Param request body EditMessageRequest true "编辑消息请求"
+// @Success 200 {object} utils.Response{data=MessageResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/{id} [patch]
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的消息ID")
+		return
+	}
+
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	message, err := h.messageService.EditMessage(ctx, userID.(uint), uint(messageID), req.Content)
+	if err != nil {
+		h.responseModifyError(c, err, "编辑消息失败")
+		return
+	}
+
+	// 推送编辑事件
+	if err := h.hubService.PublishMessageEvent(ctx, service.EventMessageEdited, message, userID.(uint)); err != nil {
+		log.Printf("推送消息编辑事件失败: %v", err)
+	}
+
+	utils.ResponseSuccess(c, newMessageResponse(message))
+}
+
+// RecallMessage godoc
+// @Summary 撤回消息
+// @Description 发送者在时间窗口内撤回消息，房间管理员不受限制；撤回后消息不再出现在历史记录中，并向会话参与者推送message_recalled事件
+// @Tags messages
+// @Produce json
+// @Param id path int true "消息ID"
+// @Success 200 {object} utils.Response
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/{id} [delete]
+func (h *MessageHandler) RecallMessage(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的消息ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	message, err := h.messageService.RecallMessage(ctx, userID.(uint), uint(messageID))
+	if err != nil {
+		h.responseModifyError(c, err, "撤回消息失败")
+		return
+	}
+
+	// 推送撤回事件
+	if err := h.hubService.PublishMessageEvent(ctx, service.EventMessageRecalled, message, userID.(uint)); err != nil {
+		log.Printf("推送消息撤回事件失败: %v", err)
+	}
+
 	utils.ResponseSuccess(c, nil)
 }
 
+// GetMessageEdits godoc
+// @Summary 获取消息编辑历史
+// @Description 获取消息每次编辑前的内容，仅会话参与者可查看
+// @Tags messages
+// @Produce json
+// @Param id path int true "消息ID"
+// @Success 200 {object} utils.Response{data=[]model.MessageEdit}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/{id}/edits [get]
+func (h *MessageHandler) GetMessageEdits(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的消息ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	edits, err := h.messageService.GetMessageEdits(ctx, userID.(uint), uint(messageID))
+	if err != nil {
+		h.responseModifyError(c, err, "获取编辑历史失败")
+		return
+	}
+
+	utils.ResponseSuccess(c, edits)
+}
+
+// GetThread godoc
+// @Summary 获取话题
+// @Description 获取话题的根消息和按时间升序分页的回复，id为话题中的回复时返回其所在的话题；仅会话参与者可查看
+// @Tags messages
+// @Produce json
+// @Param id path int true "消息ID"
+// @Param page query int false "页码" default(1)
+// @Param size query int false "每页数量" default(10)
+// @Success 200 {object} utils.Response{data=ThreadResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/{id}/thread [get]
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的消息ID")
+		return
+	}
+
+	var req ListMessagesRequest
+	if err = c.ShouldBindQuery(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	root, replies, total, err := h.messageService.GetThread(ctx, userID.(uint), uint(messageID), req.Page, req.Size)
+	if err != nil {
+		h.responseModifyError(c, err, "获取话题失败")
+		return
+	}
+
+	replyResponses := make([]*MessageResponse, len(replies))
+	for i, msg := range replies {
+		replyResponses[i] = newMessageResponse(msg)
+	}
+
+	resp := &ThreadResponse{
+		Root:    newMessageResponse(root),
+		Replies: replyResponses,
+		Total:   total,
+		Page:    req.Page,
+		Size:    req.Size,
+	}
+
+	utils.ResponseSuccess(c, resp)
+}
+
+// SearchMessagesRequest 搜索消息请求
+type SearchMessagesRequest struct {
+	ListMessagesRequest
+	Query    string `form:"q" binding:"required,max=200"` // 搜索文本，按空白拆分为关键词
+	SenderID uint   `form:"sender_id"`                    // 发送者ID
+	RoomID   uint   `form:"room_id"`                      // 房间ID，指定时只搜索该房间
+	Since    string `form:"since"`                        // 发送时间下限，RFC3339或2006-01-02
+	Until    string `form:"until"`                        // 发送时间上限，RFC3339或2006-01-02（含当天）
+}
+
+// params 转换为搜索参数
+func (r *SearchMessagesRequest) params() (service.SearchParams, error) {
+	params := service.SearchParams{
+		Text:     r.Query,
+		SenderID: r.SenderID,
+		RoomID:   r.RoomID,
+		Page:     r.Page,
+		Size:     r.Size,
+	}
+	var err error
+	if params.Since, err = parseSearchTime(r.Since, false); err != nil {
+		return params, err
+	}
+	if params.Until, err = parseSearchTime(r.Until, true); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// parseSearchTime 解析RFC3339时间或日期，作为上限的日期包含当天
+func parseSearchTime(value string, until bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if until {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// SearchResultResponse 消息搜索结果
+type SearchResultResponse struct {
+	Message *MessageResponse `json:"message"`
+	Snippet string           `json:"snippet"` // 高亮片段，已做HTML转义，关键词用<mark>标出
+}
+
+// SearchMessagesResponse 搜索消息响应
+type SearchMessagesResponse struct {
+	Results []*SearchResultResponse `json:"results"`
+	Total   int64                   `json:"total"`
+	Page    int                     `json:"page"`
+	Size    int                     `json:"size"`
+}
+
+// SearchMessages godoc
+// @Summary 搜索消息
+// @Description 在当前用户所在的房间和参与的私聊中全文搜索消息，消息须包含全部关键词；按发送时间倒序返回，附带关键词高亮片段
+// @Tags messages
+// @Produce json
+// @Param q query string true "搜索文本，按空白拆分为关键词"
+// @Param sender_id query int false "发送者ID"
+// @Param room_id query int false "房间ID，指定时只搜索该房间"
+// @Param since query string false "发送时间下限，RFC3339或2006-01-02"
+// @Param until query string false "发送时间上限，RFC3339或2006-01-02（含当天）"
+// @Param page query int false "页码" default(1)
+// @Param size query int false "每页数量" default(10)
+// @Success 200 {object} utils.Response{data=SearchMessagesResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/search [get]
+func (h *MessageHandler) SearchMessages(c *gin.Context) {
+	var req SearchMessagesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+	params, err := req.params()
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的时间范围")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	results, total, err := h.messageService.SearchMessages(ctx, userID.(uint), params)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidOperation):
+			utils.ResponseBadRequest(c, "搜索内容不能为空")
+		case errors.Is(err, service.ErrNotRoomMember):
+			utils.ResponseForbidden(c, "不是房间成员")
+		default:
+			utils.ResponseInternalError(c, "搜索消息失败")
+		}
+		return
+	}
+
+	resp := &SearchMessagesResponse{
+		Results: make([]*SearchResultResponse, len(results)),
+		Total:   total,
+		Page:    req.Page,
+		Size:    req.Size,
+	}
+	for i, result := range results {
+		resp.Results[i] = &SearchResultResponse{
+			Message: newMessageResponse(result.Message),
+			Snippet: result.Snippet,
+		}
+	}
+
+	utils.ResponseSuccess(c, resp)
+}
+
+// ReactionRequest 表情回应请求
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// AddReaction godoc
+// @Summary 添加表情回应
+// @Description 会话参与者对消息添加表情回应，重复添加不产生变化；新增时向会话参与者推送reaction_added事件，返回消息最新的回应统计
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Param id path int true "消息ID"
+// @Param request body ReactionRequest true "表情回应请求"
+// @Success 200 {object} utils.Response{data=[]model.ReactionCount}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/{id}/reactions [post]
+func (h *MessageHandler) AddReaction(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的消息ID")
+		return
+	}
+
+	var req ReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	message, added, err := h.messageService.AddReaction(ctx, userID.(uint), uint(messageID), req.Emoji)
+	if err != nil {
+		h.responseModifyError(c, err, "添加表情回应失败")
+		return
+	}
+
+	// 推送表情回应事件
+	if added {
+		if err := h.hubService.PublishReactionEvent(ctx, service.EventReactionAdded, message, userID.(uint), req.Emoji); err != nil {
+			log.Printf("推送表情回应事件失败: %v", err)
+		}
+	}
+
+	utils.ResponseSuccess(c, message.Reactions)
+}
+
+// RemoveReaction godoc
+// @Summary 移除表情回应
+// @Description 移除自己对消息的表情回应，表情需URL编码；移除时向会话参与者推送reaction_removed事件，返回消息最新的回应统计
+// @Tags messages
+// @Produce json
+// @Param id path int true "消息ID"
+// @Param emoji path string true "表情"
+// @Success 200 {object} utils.Response{data=[]model.ReactionCount}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/{id}/reactions/{emoji} [delete]
+func (h *MessageHandler) RemoveReaction(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的消息ID")
+		return
+	}
+	emoji := c.Param("emoji")
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	message, removed, err := h.messageService.RemoveReaction(ctx, userID.(uint), uint(messageID), emoji)
+	if err != nil {
+		h.responseModifyError(c, err, "移除表情回应失败")
+		return
+	}
+
+	// 推送表情回应事件
+	if removed {
+		if err := h.hubService.PublishReactionEvent(ctx, service.EventReactionRemoved, message, userID.(uint), emoji); err != nil {
+			log.Printf("推送表情回应事件失败: %v", err)
+		}
+	}
+
+	utils.ResponseSuccess(c, message.Reactions)
+}
+
+// scheduleMessage 保存定时消息
+func (h *MessageHandler) scheduleMessage(c *gin.Context, req *SendMessageRequest, userID uint, username string) {
+	scheduled := &model.ScheduledMessage{
+		SenderID:      userID,
+		SenderName:    username,
+		TargetType:    model.MessageTarget(req.MessageType),
+		TargetID:      req.TargetID,
+		Content:       req.Content,
+		AttachmentIDs: req.AttachmentIDs,
+		ReplyToID:     req.ReplyToID,
+		TTLSeconds:    req.TTLSeconds,
+		SendAt:        *req.SendAt,
+	}
+
+	ctx := context.Background()
+	if err := h.scheduledService.Schedule(ctx, scheduled); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidSendTime):
+			utils.ResponseBadRequest(c, "发送时间须晚于当前时间且在允许的范围内")
+		case errors.Is(err, service.ErrInvalidTTL):
+			utils.ResponseBadRequest(c, "有效期超出允许的范围")
+		case errors.Is(err, service.ErrNotRoomMember):
+			utils.ResponseForbidden(c, "不是房间成员")
+		case errors.Is(err, service.ErrAttachmentUnavailable):
+			utils.ResponseBadRequest(c, "附件不存在或已发送")
+		case errors.Is(err, service.ErrInvalidOperation):
+			utils.ResponseBadRequest(c, "参数错误")
+		default:
+			utils.ResponseInternalError(c, "创建定时消息失败")
+		}
+		return
+	}
+
+	utils.ResponseSuccess(c, scheduled)
+}
+
+// ListScheduledMessagesResponse 获取定时消息列表响应
+type ListScheduledMessagesResponse struct {
+	Messages []*model.ScheduledMessage `json:"messages"`
+	Total    int64                     `json:"total"`
+	Page     int                       `json:"page"`
+	Size     int                       `json:"size"`
+}
+
+// ListScheduledMessages godoc
+// @Summary 获取定时消息列表
+// @Description 获取当前用户等待发送的定时消息，按发送时间升序
+// @Tags messages
+// @Produce json
+// @Param page query int false "页码" default(1)
+// @Param size query int false "每页数量" default(10)
+// @Success 200 {object} utils.Response{data=ListScheduledMessagesResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/scheduled [get]
+func (h *MessageHandler) ListScheduledMessages(c *gin.Context) {
+	var req ListMessagesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	scheduled, total, err := h.scheduledService.ListPending(ctx, userID.(uint), req.Page, req.Size)
+	if err != nil {
+		utils.ResponseInternalError(c, "获取定时消息失败")
+		return
+	}
+
+	utils.ResponseSuccess(c, &ListScheduledMessagesResponse{
+		Messages: scheduled,
+		Total:    total,
+		Page:     req.Page,
+		Size:     req.Size,
+	})
+}
+
+// CancelScheduledMessage godoc
+// @Summary 取消定时消息
+// @Description 取消当前用户等待发送的定时消息，已开始发送的消息不能取消
+// @Tags messages
+// @Produce json
+// @Param id path int true "定时消息ID"
+// @Success 200 {object} utils.Response
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/messages/scheduled/{id} [delete]
+func (h *MessageHandler) CancelScheduledMessage(c *gin.Context) {
+	scheduledID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的定时消息ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	if err := h.scheduledService.Cancel(ctx, userID.(uint), uint(scheduledID)); err != nil {
+		switch {
+		case errors.Is(err, service.ErrScheduledNotFound):
+			utils.ResponseNotFound(c, "定时消息不存在")
+		case errors.Is(err, service.ErrScheduledNotPending):
+			utils.ResponseError(c, http.StatusConflict, 409, "定时消息已发送或已取消")
+		default:
+			utils.ResponseInternalError(c, "取消定时消息失败")
+		}
+		return
+	}
+
+	utils.ResponseSuccess(c, nil)
+}
+
+// responseModifyError 返回编辑、撤回消息的错误响应
+func (h *MessageHandler) responseModifyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrMessageNotFound):
+		utils.ResponseNotFound(c, "消息不存在")
+	case errors.Is(err, service.ErrNotMessageSender):
+		utils.ResponseForbidden(c, "只能操作自己发送的消息")
+	case errors.Is(err, service.ErrEditWindowExpired):
+		utils.ResponseForbidden(c, "已超过可编辑、撤回的时间")
+	case errors.Is(err, service.ErrNotRoomMember):
+		utils.ResponseForbidden(c, "不是房间成员")
+	case errors.Is(err, service.ErrInvalidReaction):
+		utils.ResponseBadRequest(c, "无效的表情")
+	default:
+		utils.ResponseInternalError(c, message)
+	}
+}
+
 // MessageHandlerSet 消息处理器依赖注入
 var MessageHandlerSet = wire.NewSet(NewMessageHandler)
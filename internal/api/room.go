@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +30,8 @@ type CreateRoomRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
 	IsPrivate   bool   `json:"is_private"`
+	// 消息有效期（秒），过期后消息被删除，0表示不过期
+	MessageTTLSeconds int `json:"message_ttl_seconds"`
 }
 
 // RoomResponse 房间响应
@@ -39,6 +42,8 @@ type RoomResponse struct {
 	CreatorID   uint   `json:"creator_id"`
 	IsPrivate   bool   `json:"is_private"`
 	CreatedAt   string `json:"created_at"`
+
+	MessageTTLSeconds int `json:"message_ttl_seconds"`
 }
 
 // ListRoomsRequest 获取房间列表请求
@@ -95,11 +100,17 @@ func (h *RoomHandler) CreateRoom(c *gin.Context) {
 		Description: req.Description,
 		CreatorID:   userID.(uint),
 		IsPrivate:   req.IsPrivate,
+
+		MessageTTLSeconds: req.MessageTTLSeconds,
 	}
 
 	ctx := context.Background()
 	err := h.roomService.CreateRoom(ctx, room)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidTTL) {
+			utils.ResponseBadRequest(c, "有效期超出允许的范围")
+			return
+		}
 		utils.ResponseInternalError(c, "创建房间失败")
 		return
 	}
@@ -111,6 +122,8 @@ func (h *RoomHandler) CreateRoom(c *gin.Context) {
 		CreatorID:   room.CreatorID,
 		IsPrivate:   room.IsPrivate,
 		CreatedAt:   room.CreatedAt.Format("2006-01-02 15:04:05"),
+
+		MessageTTLSeconds: room.MessageTTLSeconds,
 	}
 
 	utils.ResponseSuccess(c, resp)
@@ -155,6 +168,8 @@ func (h *RoomHandler) GetRoom(c *gin.Context) {
 		CreatorID:   room.CreatorID,
 		IsPrivate:   room.IsPrivate,
 		CreatedAt:   room.CreatedAt.Format("2006-01-02 15:04:05"),
+
+		MessageTTLSeconds: room.MessageTTLSeconds,
 	}
 
 	utils.ResponseSuccess(c, resp)
@@ -196,6 +211,8 @@ func (h *RoomHandler) ListRooms(c *gin.Context) {
 			CreatorID:   room.CreatorID,
 			IsPrivate:   room.IsPrivate,
 			CreatedAt:   room.CreatedAt.Format("2006-01-02 15:04:05"),
+
+			MessageTTLSeconds: room.MessageTTLSeconds,
 		}
 	}
 
@@ -341,5 +358,75 @@ func (h *RoomHandler) GetMembers(c *gin.Context) {
 	utils.ResponseSuccess(c, memberResponses)
 }
 
+// UpdateRoomSettingsRequest 更新房间设置请求
+type UpdateRoomSettingsRequest struct {
+	MessageTTLSeconds int `json:"message_ttl_seconds" binding:"min=0"` // 消息有效期（秒），0表示不过期
+}
+
+// UpdateSettings godoc
+// @Summary 更新房间设置
+// @Description 房间管理员或创建者更新房间设置，消息有效期只对之后发送的消息生效
+// @Tags rooms
+// @Accept json
+// @Produce json
+// @Param id path int true "房间ID"
+// @Param request body UpdateRoomSettingsRequest true "更新房间设置请求"
+// @Success 200 {object} utils.Response{data=RoomResponse}
+// @Failure 400 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 500 {object} utils.Response
+// @Security BearerAuth
+// @Router /api/v1/rooms/{id}/settings [put]
+func (h *RoomHandler) UpdateSettings(c *gin.Context) {
+	idStr := c.Param("id")
+	roomID, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		utils.ResponseBadRequest(c, "无效的房间ID")
+		return
+	}
+
+	var req UpdateRoomSettingsRequest
+	if err = c.ShouldBindJSON(&req); err != nil {
+		utils.ResponseBadRequest(c, "参数错误")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ResponseUnauthorized(c, "未授权")
+		return
+	}
+
+	ctx := context.Background()
+	room, err := h.roomService.UpdateSettings(ctx, userID.(uint), uint(roomID), req.MessageTTLSeconds)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTTL):
+			utils.ResponseBadRequest(c, "有效期超出允许的范围")
+		case errors.Is(err, service.ErrPermissionDenied):
+			utils.ResponseForbidden(c, "需要房间管理员权限")
+		case errors.Is(err, service.ErrRoomNotFound):
+			utils.ResponseNotFound(c, "房间不存在")
+		default:
+			utils.ResponseInternalError(c, "更新房间设置失败")
+		}
+		return
+	}
+
+	resp := &RoomResponse{
+		ID:          room.ID,
+		Name:        room.Name,
+		Description: room.Description,
+		CreatorID:   room.CreatorID,
+		IsPrivate:   room.IsPrivate,
+		CreatedAt:   room.CreatedAt.Format("2006-01-02 15:04:05"),
+
+		MessageTTLSeconds: room.MessageTTLSeconds,
+	}
+
+	utils.ResponseSuccess(c, resp)
+}
+
 // RoomHandlerSet 房间处理器依赖注入
 var RoomHandlerSet = wire.NewSet(NewRoomHandler)
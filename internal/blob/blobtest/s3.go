@@ -0,0 +1,216 @@
+// Package blobtest 提供对象存储测试用的S3兼容服务
+package blobtest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Gopher0727/RTMP/config"
+)
+
+// 测试服务使用的凭证、区域和存储桶
+const (
+	AccessKey = "test-access-key"
+	SecretKey = "test-secret-key"
+	Region    = "us-east-1"
+	Bucket    = "rtmp-test"
+)
+
+// S3Server 内存中的S3兼容服务，支持路径风格的PutObject、GetObject、DeleteObject和DeleteObjects，
+// 每个请求都按AWS Signature V4校验签名
+type S3Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// NewS3Server 启动S3兼容服务，使用完毕后调用Close
+func NewS3Server() *S3Server {
+	s := &S3Server{objects: make(map[string][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Config 获取连接到该服务的存储配置
+func (s *S3Server) Config() config.S3Config {
+	return config.S3Config{
+		Endpoint:  s.URL,
+		Region:    Region,
+		Bucket:    Bucket,
+		AccessKey: AccessKey,
+		SecretKey: SecretKey,
+		PathStyle: true,
+	}
+}
+
+// Keys 获取已保存的对象键，按字典序排列
+func (s *S3Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serve 处理S3请求
+func (s *S3Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if err := verifySignature(r, body); err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		s.deleteObjects(w, r, body)
+	case key != "" && r.Method == http.MethodPut:
+		if r.ContentLength != int64(len(body)) {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", "content length mismatch")
+			return
+		}
+		s.objects[key] = body
+	case key != "" && r.Method == http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		w.Write(data)
+	case key != "" && r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// deleteObjects 处理DeleteObjects请求，请求体须带正确的Content-MD5
+func (s *S3Server) deleteObjects(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := md5.Sum(body)
+	if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "InvalidDigest", "content md5 mismatch")
+		return
+	}
+
+	var req struct {
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	for _, object := range req.Objects {
+		delete(s.objects, object.Key)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><DeleteResult></DeleteResult>`)
+}
+
+// verifySignature 按Authorization头中列出的签名头重新计算签名并比较
+func verifySignature(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	fields := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != AccessKey {
+		return fmt.Errorf("invalid credential %q", fields["Credential"])
+	}
+
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if payloadHash != "UNSIGNED-PAYLOAD" && payloadHash != sha256Hex(body) {
+		return fmt.Errorf("payload hash mismatch")
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.Query()),
+		headers.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+
+	date, scope := credential[1], strings.Join(credential[1:], "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", r.Header.Get("x-amz-date"), scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+SecretKey), date)
+	for _, part := range credential[2:] {
+		key = hmacSHA256(key, part)
+	}
+	if signature := hex.EncodeToString(hmacSHA256(key, stringToSign)); signature != fields["Signature"] {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// canonicalQuery 按键排序编码查询参数，没有值的参数编码为key=
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// writeError 返回S3格式的错误
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>`, code)
+	xml.EscapeText(&buf, []byte(message))
+	buf.WriteString(`</Message></Error>`)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// sha256Hex 计算SHA256十六进制摘要
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 计算HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,39 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/config"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore 附件对象存储接口
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	DeleteMany(ctx context.Context, keys []string) error
+}
+
+// NewBlobStore 根据配置创建对象存储
+func NewBlobStore(cfg *config.Config) (BlobStore, error) {
+	sc := cfg.Storage
+	switch sc.Driver {
+	case "", "local":
+		return NewLocalStore(sc.LocalDir)
+	case "s3":
+		return NewS3Store(sc.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", sc.Driver)
+	}
+}
+
+// BlobStoreSet 对象存储依赖注入
+var BlobStoreSet = wire.NewSet(NewBlobStore)
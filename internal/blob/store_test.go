@@ -0,0 +1,193 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/blob/blobtest"
+)
+
+// testStores 返回参与测试的对象存储：本地目录、S3兼容的测试服务，
+// 设置RTMP_TEST_S3_ENDPOINT时还包括该地址上的MinIO（见scripts/docker-compose.yml）
+func testStores(t *testing.T) map[string]BlobStore {
+	t.Helper()
+
+	local, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+
+	server := blobtest.NewS3Server()
+	t.Cleanup(server.Close)
+	s3, err := NewS3Store(server.Config())
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+
+	stores := map[string]BlobStore{"local": local, "s3": s3}
+	if endpoint := os.Getenv("RTMP_TEST_S3_ENDPOINT"); endpoint != "" {
+		minio, err := NewS3Store(config.S3Config{
+			Endpoint:  endpoint,
+			Bucket:    envOr("RTMP_TEST_S3_BUCKET", "rtmp-attachments"),
+			AccessKey: envOr("RTMP_TEST_S3_ACCESS_KEY", "minioadmin"),
+			SecretKey: envOr("RTMP_TEST_S3_SECRET_KEY", "minioadmin"),
+			PathStyle: true,
+		})
+		if err != nil {
+			t.Fatalf("NewS3Store(minio): %v", err)
+		}
+		stores["minio"] = minio
+	}
+	return stores
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func put(t *testing.T, store BlobStore, key, content string) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func get(t *testing.T, store BlobStore, key string) (string, error) {
+	t.Helper()
+	r, err := store.Get(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %q: %v", key, err)
+	}
+	return string(data), nil
+}
+
+func TestStorePutGetDelete(t *testing.T) {
+	keys := []string{
+		"attachments/2026/10/17/plain",
+		"attachments/2026/10/17/plain.thumb.jpg",
+		"attachments/名字 with space+plus",
+	}
+
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, key := range keys {
+				put(t, store, key, "content of "+key)
+			}
+			for _, key := range keys {
+				got, err := get(t, store, key)
+				if err != nil || got != "content of "+key {
+					t.Fatalf("Get(%q) = %q, %v", key, got, err)
+				}
+			}
+
+			// 覆盖写入
+			put(t, store, keys[0], "replaced")
+			if got, err := get(t, store, keys[0]); err != nil || got != "replaced" {
+				t.Fatalf("Get after overwrite = %q, %v", got, err)
+			}
+
+			if err := store.Delete(ctx, keys[0]); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := get(t, store, keys[0]); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get deleted object: err = %v, want ErrNotFound", err)
+			}
+			// 删除不存在的对象不报错
+			if err := store.Delete(ctx, keys[0]); err != nil {
+				t.Fatalf("Delete missing object: %v", err)
+			}
+
+			if err := store.DeleteMany(ctx, append(keys[1:], "attachments/missing")); err != nil {
+				t.Fatalf("DeleteMany: %v", err)
+			}
+			for _, key := range keys[1:] {
+				if _, err := get(t, store, key); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("Get(%q) after DeleteMany: err = %v, want ErrNotFound", key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestS3StoreDeleteManyBatches(t *testing.T) {
+	server := blobtest.NewS3Server()
+	defer server.Close()
+	store, err := NewS3Store(server.Config())
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+
+	// 超过单次DeleteObjects请求的上限，分多次请求删除
+	keys := make([]string, s3MaxDeleteKeys+5)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k/%04d", i)
+		put(t, store, keys[i], "v")
+	}
+	keep := "k/keep"
+	put(t, store, keep, "v")
+
+	if err := store.DeleteMany(context.Background(), keys); err != nil {
+		t.Fatalf("DeleteMany: %v", err)
+	}
+	if got := server.Keys(); !slices.Equal(got, []string{keep}) {
+		t.Fatalf("remaining keys = %v, want [%s]", got, keep)
+	}
+}
+
+func TestLocalStoreRejectsEscapingKeys(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"attachments/a", true},
+		{"a/../b", true},
+		{"", false},
+		{"..", false},
+		{"../a", false},
+		{"a/../../b", false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		_, err := store.path(tt.key)
+		if (err == nil) != tt.valid {
+			t.Errorf("path(%q) err = %v, want valid = %v", tt.key, err, tt.valid)
+		}
+	}
+}
+
+func TestS3StoreSignatureMismatch(t *testing.T) {
+	server := blobtest.NewS3Server()
+	defer server.Close()
+	cfg := server.Config()
+	cfg.SecretKey = "wrong-secret"
+	store, err := NewS3Store(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+
+	err = store.Put(context.Background(), "a", strings.NewReader("v"), 1, "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with wrong secret: err = %v, want SignatureDoesNotMatch", err)
+	}
+}
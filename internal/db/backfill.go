@@ -0,0 +1,126 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+const (
+	backfillBatchSize  = 1000 // 每批读取的早期消息数
+	backfillUpdateSize = 500  // 每条UPDATE语句更新的消息数
+)
+
+// errBackfillRaced 会话的早期消息已由其他实例回填
+var errBackfillRaced = errors.New("conversation already backfilled")
+
+// legacyMessage 早期版本写入、没有会话ID的消息
+type legacyMessage struct {
+	ID        uint
+	CreatedAt time.Time
+}
+
+// backfillConversations 为早期版本写入的消息补充会话ID和会话内序号，并写入会话序号计数器和会话列表
+// 早期消息按ID顺序占用会话开头的序号；会话中已有新消息时，新消息的序号和设备的送达位置整体后移。
+// 每个会话在一个事务中回填，多个实例同时启动时只有一个实例回填成功。
+func backfillConversations(db *gorm.DB) error {
+	conversations, order, err := loadLegacyMessages(db)
+	if err != nil {
+		return err
+	}
+	for _, conversationID := range order {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return backfillConversation(tx, conversationID, conversations[conversationID])
+		})
+		if err != nil && !errors.Is(err, errBackfillRaced) {
+			return fmt.Errorf("backfill conversation %s: %w", conversationID, err)
+		}
+	}
+	return nil
+}
+
+// loadLegacyMessages 按ID顺序读取没有会话ID的消息（包括已撤回的消息），按会话分组
+func loadLegacyMessages(db *gorm.DB) (map[string][]legacyMessage, []string, error) {
+	conversations := make(map[string][]legacyMessage)
+	var order []string
+
+	var batch []*model.Message
+	err := db.Unscoped().Model(&model.Message{}).
+		Select("id", "target_type", "target_id", "sender_id", "receiver_id", "room_id", "created_at").
+		Where("conversation_id IS NULL OR conversation_id = ''").
+		FindInBatches(&batch, backfillBatchSize, func(tx *gorm.DB, _ int) error {
+			for _, message := range batch {
+				message.Normalize()
+				id := message.ConversationID
+				if _, ok := conversations[id]; !ok {
+					order = append(order, id)
+				}
+				conversations[id] = append(conversations[id], legacyMessage{ID: message.ID, CreatedAt: message.CreatedAt})
+			}
+			return nil
+		}).Error
+	return conversations, order, err
+}
+
+// backfillConversation 在事务中回填一个会话的早期消息，messages按ID升序
+func backfillConversation(tx *gorm.DB, conversationID string, messages []legacyMessage) error {
+	n := uint64(len(messages))
+
+	// 递增计数器并锁定计数器行，与新消息的写入及其他实例的回填串行
+	counter := model.ConversationSeq{ConversationID: conversationID, Seq: n}
+	if err := tx.Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]any{"seq": gorm.Expr("seq + ?", n)}),
+	}).Create(&counter).Error; err != nil {
+		return err
+	}
+
+	// 早期消息的ID都小于新消息，新消息的序号和已确认的送达位置后移，为早期消息腾出开头的序号
+	if err := tx.Unscoped().Model(&model.Message{}).
+		Where("conversation_id = ?", conversationID).
+		UpdateColumn("seq", gorm.Expr("seq + ?", n)).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&model.DeliveryCursor{}).
+		Where("conversation_id = ?", conversationID).
+		UpdateColumn("seq", gorm.Expr("seq + ?", n)).Error; err != nil {
+		return err
+	}
+
+	for start := 0; start < len(messages); start += backfillUpdateSize {
+		chunk := messages[start:min(start+backfillUpdateSize, len(messages))]
+		ids := make([]uint, len(chunk))
+		seqs := clause.Expr{SQL: "CASE id"}
+		for i, message := range chunk {
+			ids[i] = message.ID
+			seqs.SQL += " WHEN ? THEN ?"
+			seqs.Vars = append(seqs.Vars, message.ID, uint64(start+i+1))
+		}
+		seqs.SQL += " END"
+
+		result := tx.Unscoped().Model(&model.Message{}).
+			Where("id IN ? AND (conversation_id IS NULL OR conversation_id = '')", ids).
+			UpdateColumns(map[string]any{"conversation_id": conversationID, "seq": seqs})
+		if result.Error != nil {
+			return result.Error
+		}
+		// 其他实例已回填这些消息，回滚本事务中的计数器递增和序号后移
+		if result.RowsAffected != int64(len(chunk)) {
+			return errBackfillRaced
+		}
+	}
+
+	// 会话列表：会话已存在时其中已有更新的消息，不做修改
+	conversation, err := model.NewConversation(conversationID)
+	if err != nil || conversation == nil {
+		return err
+	}
+	last := messages[len(messages)-1]
+	conversation.LastMessageID = last.ID
+	conversation.LastActivityAt = last.CreatedAt
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(conversation).Error
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.Message{}, &model.ConversationSeq{}, &model.DeliveryCursor{}, &model.Conversation{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// seedLegacy 写入早期版本的消息：房间1的三条（一条已撤回）、用户1与2的两条私聊和一条全员消息，
+// 以及升级后已按新方式写入的一条私聊和它的送达位置
+func seedLegacy(t *testing.T, db *gorm.DB) time.Time {
+	t.Helper()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	messages := []model.Message{
+		{ID: 1, SenderID: 1, RoomID: 1, TargetType: model.MessageTargetRoom, TargetID: 1, Content: "r1", CreatedAt: at(1)},
+		{ID: 2, SenderID: 2, RoomID: 1, TargetType: model.MessageTargetRoom, TargetID: 1, Content: "r2", CreatedAt: at(2)},
+		{ID: 3, SenderID: 1, ReceiverID: 2, TargetType: model.MessageTargetUser, TargetID: 2, Content: "d1", CreatedAt: at(3)},
+		{ID: 4, SenderID: 2, ReceiverID: 1, TargetType: model.MessageTargetUser, TargetID: 1, Content: "d2", CreatedAt: at(4)},
+		{ID: 5, TargetType: model.MessageTargetAll, Content: "all", CreatedAt: at(5)},
+		{ID: 6, SenderID: 1, RoomID: 1, TargetType: model.MessageTargetRoom, TargetID: 1, Content: "r3", CreatedAt: at(6)},
+		{ID: 7, SenderID: 1, ReceiverID: 2, TargetType: model.MessageTargetUser, TargetID: 2, Content: "new",
+			ConversationID: model.DirectConversationID(1, 2), Seq: 1, CreatedAt: at(7)},
+	}
+	if err := db.Create(&messages).Error; err != nil {
+		t.Fatalf("create messages: %v", err)
+	}
+	if err := db.Delete(&model.Message{}, 6).Error; err != nil {
+		t.Fatalf("recall message: %v", err)
+	}
+	if err := db.Create(&model.ConversationSeq{ConversationID: model.DirectConversationID(1, 2), Seq: 1}).Error; err != nil {
+		t.Fatalf("create counter: %v", err)
+	}
+	if err := db.Create(&model.DeliveryCursor{UserID: 2, DeviceID: "phone", ConversationID: model.DirectConversationID(1, 2), Seq: 1}).Error; err != nil {
+		t.Fatalf("create delivery cursor: %v", err)
+	}
+	if err := db.Create(&model.Conversation{ID: model.DirectConversationID(1, 2), Type: model.MessageTargetUser,
+		UserA: 1, UserB: 2, LastMessageID: 7, LastActivityAt: at(7)}).Error; err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	return base
+}
+
+func TestBackfillConversations(t *testing.T) {
+	db := newTestDB(t)
+	base := seedLegacy(t, db)
+
+	// 重复执行时没有需要回填的消息，结果不变
+	for range 2 {
+		if err := backfillConversations(db); err != nil {
+			t.Fatalf("backfillConversations: %v", err)
+		}
+	}
+
+	var messages []model.Message
+	if err := db.Unscoped().Order("id").Find(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint]struct {
+		conversationID string
+		seq            uint64
+	}{
+		1: {"room:1", 1},
+		2: {"room:1", 2},
+		3: {model.DirectConversationID(1, 2), 1},
+		4: {model.DirectConversationID(1, 2), 2},
+		5: {model.BroadcastConversationID, 1},
+		6: {"room:1", 3},
+		7: {model.DirectConversationID(1, 2), 3},
+	}
+	for _, message := range messages {
+		w := want[message.ID]
+		if message.ConversationID != w.conversationID || message.Seq != w.seq {
+			t.Errorf("message %d = (%q, %d), want (%q, %d)", message.ID, message.ConversationID, message.Seq, w.conversationID, w.seq)
+		}
+	}
+
+	counters := map[string]uint64{"room:1": 3, model.DirectConversationID(1, 2): 3, model.BroadcastConversationID: 1}
+	for id, seq := range counters {
+		var counter model.ConversationSeq
+		if err := db.Take(&counter, "conversation_id = ?", id).Error; err != nil {
+			t.Fatalf("counter %s: %v", id, err)
+		}
+		if counter.Seq != seq {
+			t.Errorf("counter %s = %d, want %d", id, counter.Seq, seq)
+		}
+	}
+
+	var cursor model.DeliveryCursor
+	if err := db.Take(&cursor, "user_id = ? AND device_id = ?", 2, "phone").Error; err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Seq != 3 {
+		t.Errorf("delivery cursor = %d, want 3", cursor.Seq)
+	}
+
+	var conversations []model.Conversation
+	if err := db.Order("id").Find(&conversations).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 2 {
+		t.Fatalf("got %d conversations, want 2", len(conversations))
+	}
+	for _, conversation := range conversations {
+		switch conversation.ID {
+		case "room:1":
+			if conversation.RoomID != 1 || conversation.LastMessageID != 6 || !conversation.LastActivityAt.Equal(base.Add(6*time.Minute)) {
+				t.Errorf("room conversation = %+v", conversation)
+			}
+		case model.DirectConversationID(1, 2):
+			if conversation.LastMessageID != 7 {
+				t.Errorf("direct conversation last message = %d, want 7", conversation.LastMessageID)
+			}
+		default:
+			t.Errorf("unexpected conversation %s", conversation.ID)
+		}
+	}
+}
+
+func TestBackfillConversationRaced(t *testing.T) {
+	db := newTestDB(t)
+	seedLegacy(t, db)
+
+	conversations, _, err := loadLegacyMessages(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backfillConversations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// 另一个实例读取到同样的早期消息后才开始回填
+	id := model.DirectConversationID(1, 2)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return backfillConversation(tx, id, conversations[id])
+	})
+	if !errors.Is(err, errBackfillRaced) {
+		t.Fatalf("err = %v, want errBackfillRaced", err)
+	}
+
+	var counter model.ConversationSeq
+	if err := db.Take(&counter, "conversation_id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	var latest model.Message
+	if err := db.Take(&latest, 7).Error; err != nil {
+		t.Fatal(err)
+	}
+	if counter.Seq != 3 || latest.Seq != 3 {
+		t.Errorf("counter = %d, latest seq = %d, want both 3 after rollback", counter.Seq, latest.Seq)
+	}
+}
@@ -17,7 +17,7 @@ func InitRedisMessage() error {
 	messageConfig := cfg.Redis.Message
 
 	RedisMessage = redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", messageConfig.Host, messageConfig.Port),
+		Addr:     messageConfig.Address(),
 		Password: messageConfig.Password,
 		DB:       messageConfig.DB,
 		PoolSize: messageConfig.PoolSize,
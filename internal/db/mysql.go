@@ -29,12 +29,32 @@ func InitMySQL() error {
 
 // AutoMigrate 自动迁移数据库表
 func AutoMigrate() error {
-	return MySQL.AutoMigrate(
+	if err := MySQL.AutoMigrate(
 		&model.User{},
 		&model.Message{},
 		&model.Room{},
 		&model.RoomMember{},
-	)
+		&model.ConversationSeq{},
+		&model.DeliveryCursor{},
+		&model.ReadCursor{},
+		&model.Conversation{},
+		&model.MessageEdit{},
+		&model.Attachment{},
+		&model.Reaction{},
+		&model.ScheduledMessage{},
+	); err != nil {
+		return err
+	}
+
+	// 早期版本用type字段记录发送目标（user/room），目标现在由receiver_id/room_id表示，type统一为内容类型
+	if err := MySQL.Unscoped().Model(&model.Message{}).
+		Where("type IN ?", []string{"user", "room"}).
+		Update("type", model.MessageTypeText).Error; err != nil {
+		return err
+	}
+
+	// 早期版本写入的消息没有会话ID和序号
+	return backfillConversations(MySQL)
 }
 
 // GetDB 获取数据库连接
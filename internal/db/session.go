@@ -17,7 +17,7 @@ func InitRedisSession() error {
 	sessionConfig := cfg.Redis.Session
 
 	RedisSession = redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", sessionConfig.Host, sessionConfig.Port),
+		Addr:     sessionConfig.Address(),
 		Password: sessionConfig.Password,
 		DB:       sessionConfig.DB,
 		PoolSize: sessionConfig.PoolSize,
@@ -0,0 +1,171 @@
+package instance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/config"
+)
+
+// maxIDLength 实例ID最大长度，与 model.User/model.Room 的 InstanceID 字段长度一致
+const maxIDLength = 50
+
+// maxIDSlots 同一id_file最多可供多少个进程各自持有实例ID，第n个槽位使用 <id_file>.<n>
+const maxIDSlots = 64
+
+// Identity 实例身份，生产者、消费者、Hub及日志共用同一个实例ID
+type Identity struct {
+	id     string
+	source string   // 实例ID来源：config | hostname | file | generated
+	lock   *os.File // 持有的实例ID文件锁，进程退出时自动释放
+}
+
+// NewIdentity 解析实例身份
+// 优先级：配置的实例名 > POD_NAME/主机名（启用use_hostname时）> 持久化文件 > 新生成并写入持久化文件
+// 持久化文件在进程运行期间加锁，被占用时改用下一个槽位的文件
+func NewIdentity(cfg *config.Config) (*Identity, error) {
+	ic := cfg.Instance
+
+	if id := sanitize(ic.Name); id != "" {
+		return &Identity{id: id, source: "config"}, nil
+	}
+
+	if ic.UseHostname {
+		if id := sanitize(hostname()); id != "" {
+			return &Identity{id: id, source: "hostname"}, nil
+		}
+	}
+
+	if ic.IDFile != "" {
+		return claimIDFile(ic.IDFile)
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{id: id, source: "generated"}, nil
+}
+
+// claimIDFile 锁定一个未被其他进程持有的实例ID文件并读取其中的ID，文件为空时生成新ID写入
+// 同一工作目录启动的多个实例各自持有不同的文件，不会使用相同的实例ID
+func claimIDFile(path string) (*Identity, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create instance id dir %s: %w", dir, err)
+		}
+	}
+
+	for slot := 0; slot < maxIDSlots; slot++ {
+		slotPath := path
+		if slot > 0 {
+			slotPath = fmt.Sprintf("%s.%d", path, slot)
+		}
+
+		lock, err := tryLock(slotPath + ".lock")
+		if err != nil {
+			return nil, err
+		}
+		if lock == nil {
+			// 已被其他进程持有
+			continue
+		}
+
+		data, err := os.ReadFile(slotPath)
+		if err == nil {
+			if id := sanitize(string(data)); id != "" {
+				return &Identity{id: id, source: "file", lock: lock}, nil
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			lock.Close()
+			return nil, fmt.Errorf("failed to read instance id file %s: %w", slotPath, err)
+		}
+
+		id, err := generateID()
+		if err == nil {
+			err = persist(slotPath, id)
+		}
+		if err != nil {
+			lock.Close()
+			return nil, err
+		}
+		return &Identity{id: id, source: "generated", lock: lock}, nil
+	}
+	return nil, fmt.Errorf("all %d instance id files of %s are held by other processes", maxIDSlots, path)
+}
+
+// ID 获取实例ID
+func (i *Identity) ID() string {
+	return i.id
+}
+
+// Source 获取实例ID的来源
+func (i *Identity) Source() string {
+	return i.source
+}
+
+// String 实现fmt.Stringer
+func (i *Identity) String() string {
+	return i.id
+}
+
+// hostname 获取主机名，容器环境下优先使用POD_NAME
+func hostname() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	if name := os.Getenv("HOSTNAME"); name != "" {
+		return name
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// generateID 生成随机实例ID
+func generateID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate instance id: %w", err)
+	}
+	return "instance-" + hex.EncodeToString(b), nil
+}
+
+// persist 将实例ID写入持久化文件
+func persist(path, id string) error {
+	if err := os.WriteFile(path, []byte(id+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write instance id file %s: %w", path, err)
+	}
+	return nil
+}
+
+// sanitize 规范化实例ID，实例ID会用于Kafka主题名和消费者组名，只保留字母、数字、'.'、'_'和'-'
+func sanitize(s string) string {
+	s = strings.TrimSpace(s)
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	id := strings.Trim(b.String(), "-")
+	if len(id) > maxIDLength {
+		id = id[:maxIDLength]
+	}
+	return id
+}
+
+// IdentitySet 实例身份依赖注入
+var IdentitySet = wire.NewSet(NewIdentity)
@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/IBM/sarama"
+)
+
+// ensureTopic 确保主题存在，不存在时按给定参数创建
+func ensureTopic(brokers []string, topic string, partitions int32, replicationFactor int16, retentionHours int) error {
+	admin, err := sarama.NewClusterAdmin(brokers, sarama.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	if partitions <= 0 {
+		partitions = 1
+	}
+	if replicationFactor <= 0 {
+		replicationFactor = 1
+	}
+	detail := &sarama.TopicDetail{
+		NumPartitions:     partitions,
+		ReplicationFactor: replicationFactor,
+	}
+	if retentionHours > 0 {
+		retention := strconv.FormatInt(int64(retentionHours)*3600*1000, 10)
+		detail.ConfigEntries = map[string]*string{"retention.ms": &retention}
+	}
+
+	err = admin.CreateTopic(topic, detail, false)
+	var topicErr *sarama.TopicError
+	if errors.As(err, &topicErr) && topicErr.Err == sarama.ErrTopicAlreadyExists {
+		return nil
+	}
+	return err
+}
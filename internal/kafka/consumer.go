@@ -3,128 +3,248 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 
 	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/instance"
 )
 
+const (
+	handleMaxRetries = 3                      // 处理失败时的最大重试次数
+	handleRetryDelay = 200 * time.Millisecond // 重试间隔基数
+	consumeRetryWait = 2 * time.Second        // 消费者组异常退出或消息处理失败后的重连间隔
+
+	signalRetentionHours = 1 // 临时信号主题的保留时间，信号在几秒内就会过期
+)
+
+// Handler 同步消息处理器，返回nil表示处理成功，之后才会提交offset
+type Handler func(ctx context.Context, msg *SyncMessage) error
+
+// RebalanceHook 分区重平衡回调，claims为主题到分区列表的映射
+type RebalanceHook func(groupID string, claims map[string][]int32)
+
+// groupConsumer 单个消费者组
+type groupConsumer struct {
+	groupID string
+	topics  []string
+	group   sarama.ConsumerGroup
+	skipOwn bool // 跳过本实例发布的消息，本实例的客户端已在发布时直接推送
+}
+
 // MessageConsumer Kafka消息消费者
+// 广播主题、收件箱和临时信号主题使用实例独立的消费者组，每个实例都会收到全部消息；
+// 队列主题使用共享的消费者组，每条消息只由集群内一个实例处理。
 type MessageConsumer struct {
-	consumer   sarama.Consumer
+	groups     []*groupConsumer
 	instanceID string
-	topics     map[string]bool
 	mu         sync.Mutex
 	ctx        context.Context
 	cancel     context.CancelFunc
 	wg         sync.WaitGroup
-	handlers   map[string]func(*SyncMessage)
+	handlers   map[string]Handler
+	onAssigned []RebalanceHook
+	onRevoked  []RebalanceHook
 }
 
 // NewMessageConsumer 创建新的消息消费者
-func NewMessageConsumer(cfg *config.Config) (*MessageConsumer, error) {
-	config := sarama.NewConfig()
-	config.Consumer.Return.Errors = true
+// 实例ID在重启后保持不变，实例独立的消费者组因此可以从上次提交的offset继续消费
+func NewMessageConsumer(cfg *config.Config, identity *instance.Identity) (*MessageConsumer, error) {
+	instanceID := identity.ID()
 
-	consumer, err := sarama.NewConsumer(cfg.Kafka.Brokers, config)
+	var groups []*groupConsumer
+
+	// 本实例的收件箱主题，其他实例会把发给本实例用户的私聊消息直接投递到这里
+	inboxTopic := cfg.Kafka.InboxTopic(instanceID)
+	if err := ensureTopic(cfg.Kafka.Brokers, inboxTopic, cfg.Kafka.InboxPartitions,
+		cfg.Kafka.InboxReplicationFactor, cfg.Kafka.RetentionHours); err != nil {
+		return nil, fmt.Errorf("failed to create inbox topic %s: %w", inboxTopic, err)
+	}
+
+	// 临时信号主题，所有实例都要消费
+	signalTopic := cfg.Kafka.SignalTopicName()
+	if err := ensureTopic(cfg.Kafka.Brokers, signalTopic, cfg.Kafka.InboxPartitions,
+		cfg.Kafka.InboxReplicationFactor, signalRetentionHours); err != nil {
+		return nil, fmt.Errorf("failed to create signal topic %s: %w", signalTopic, err)
+	}
+
+	// 广播主题、收件箱和临时信号主题：每个实例一个消费者组，首次启动从最新位置开始
+	instanceTopics := append([]string{inboxTopic, signalTopic}, cfg.Kafka.BroadcastTopics...)
+	groupID := fmt.Sprintf("%s-%s", cfg.Kafka.ConsumerGroup, instanceID)
+	group, err := newConsumerGroup(cfg.Kafka.Brokers, groupID, sarama.OffsetNewest)
 	if err != nil {
 		return nil, err
 	}
+	groups = append(groups, &groupConsumer{groupID: groupID, topics: instanceTopics, group: group, skipOwn: true})
 
-	// 初始化主题映射
-	topics := make(map[string]bool)
-	for _, topic := range cfg.Kafka.Topics {
-		topics[topic] = true
+	// 队列主题：所有实例共享消费者组，首次启动从最早位置开始；
+	// 消息可能由发布它的实例处理，不跳过本实例发布的消息
+	if len(cfg.Kafka.QueueTopics) > 0 {
+		groupID := cfg.Kafka.ConsumerGroup
+		group, err := newConsumerGroup(cfg.Kafka.Brokers, groupID, sarama.OffsetOldest)
+		if err != nil {
+			closeGroups(groups)
+			return nil, err
+		}
+		groups = append(groups, &groupConsumer{groupID: groupID, topics: cfg.Kafka.QueueTopics, group: group})
 	}
 
-	// 生成实例ID
-	instanceID := generateInstanceID()
-
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &MessageConsumer{
-		consumer:   consumer,
+		groups:     groups,
 		instanceID: instanceID,
-		topics:     topics,
 		ctx:        ctx,
 		cancel:     cancel,
-		handlers:   make(map[string]func(*SyncMessage)),
+		handlers:   make(map[string]Handler),
 	}, nil
 }
 
+// newConsumerGroup 创建消费者组，initialOffset为没有已提交offset时的起始位置
+func newConsumerGroup(brokers []string, groupID string, initialOffset int64) (sarama.ConsumerGroup, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = initialOffset
+	config.Consumer.Offsets.AutoCommit.Enable = true // 定期提交已标记的offset
+
+	return sarama.NewConsumerGroup(brokers, groupID, config)
+}
+
+// closeGroups 关闭消费者组
+func closeGroups(groups []*groupConsumer) {
+	for _, g := range groups {
+		if err := g.group.Close(); err != nil {
+			log.Printf("Failed to close consumer group %s: %v", g.groupID, err)
+		}
+	}
+}
+
 // RegisterHandler 注册消息处理器
-func (c *MessageConsumer) RegisterHandler(msgType string, handler func(*SyncMessage)) {
+func (c *MessageConsumer) RegisterHandler(msgType string, handler Handler) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.handlers[msgType] = handler
 }
 
+// OnPartitionsAssigned 注册分区分配回调，在每次重平衡完成、开始消费前调用
+func (c *MessageConsumer) OnPartitionsAssigned(hook RebalanceHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onAssigned = append(c.onAssigned, hook)
+}
+
+// OnPartitionsRevoked 注册分区回收回调，在重平衡开始、放弃当前分区时调用
+func (c *MessageConsumer) OnPartitionsRevoked(hook RebalanceHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRevoked = append(c.onRevoked, hook)
+}
+
 // Start 启动消息消费
 func (c *MessageConsumer) Start() error {
-	// 订阅所有主题
-	for topic := range c.topics {
-		c.wg.Add(1)
-		go c.consumeTopic(topic)
+	for _, g := range c.groups {
+		c.wg.Add(2)
+		go c.consumeGroup(g)
+		go c.logErrors(g)
 	}
 	return nil
 }
 
-// consumeTopic 消费指定主题的消息
-func (c *MessageConsumer) consumeTopic(topic string) {
+// consumeGroup 持续消费消费者组，重平衡后Consume返回，需要循环重新加入
+func (c *MessageConsumer) consumeGroup(g *groupConsumer) {
 	defer c.wg.Done()
 
-	// 获取所有分区
-	partitions, err := c.consumer.Partitions(topic)
-	if err != nil {
-		log.Printf("Failed to get partitions for topic %s: %v", topic, err)
-		return
+	handler := &groupHandler{consumer: c, groupID: g.groupID, skipOwn: g.skipOwn}
+	for {
+		if err := g.group.Consume(c.ctx, g.topics, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			log.Printf("Consumer group %s error: %v", g.groupID, err)
+			select {
+			case <-time.After(consumeRetryWait):
+			case <-c.ctx.Done():
+			}
+		} else if handler.failed.Swap(false) {
+			// 处理失败的消息未标记，等待后重新加入，避免持续失败时频繁重平衡
+			select {
+			case <-time.After(consumeRetryWait):
+			case <-c.ctx.Done():
+			}
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
 	}
+}
+
+// logErrors 记录消费者组的异步错误
+func (c *MessageConsumer) logErrors(g *groupConsumer) {
+	defer c.wg.Done()
 
-	// 为每个分区创建消费者
-	for _, partition := range partitions {
-		// 跳过当前实例产生的消息
-		go func(p int32) {
-			pc, err := c.consumer.ConsumePartition(topic, p, sarama.OffsetNewest)
-			if err != nil {
-				log.Printf("Failed to start consumer for partition %d: %v", p, err)
+	for {
+		select {
+		case err, ok := <-g.group.Errors():
+			if !ok {
 				return
 			}
-			defer pc.Close()
-
-			for {
-				select {
-				case msg := <-pc.Messages():
-					// 解析消息
-					var syncMsg SyncMessage
-					if err := json.Unmarshal(msg.Value, &syncMsg); err != nil {
-						log.Printf("Failed to unmarshal message: %v", err)
-						continue
-					}
-
-					// 跳过自己产生的消息
-					if syncMsg.SourceID == c.instanceID {
-						continue
-					}
-
-					// 调用相应的处理器
-					c.mu.Lock()
-					handler, exists := c.handlers[syncMsg.Type]
-					c.mu.Unlock()
-
-					if exists {
-						go handler(&syncMsg)
-					}
-
-				case err := <-pc.Errors():
-					log.Printf("Error consuming message: %v", err)
-
-				case <-c.ctx.Done():
-					return
-				}
-			}
-		}(partition)
+			log.Printf("Error consuming group %s: %v", g.groupID, err)
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// handle 处理单条Kafka消息，失败时重试；返回nil后才标记offset，
+// 重试耗尽或上下文取消时返回错误，消息不标记，之后重新投递
+func (c *MessageConsumer) handle(ctx context.Context, msg *sarama.ConsumerMessage, skipOwn bool) error {
+	// 解析消息，无法解析的消息重试也不会成功，跳过
+	var syncMsg SyncMessage
+	if err := json.Unmarshal(msg.Value, &syncMsg); err != nil {
+		log.Printf("Failed to unmarshal message at %s/%d/%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		return nil
+	}
+
+	// 跳过自己产生的消息
+	if skipOwn && syncMsg.SourceID == c.instanceID {
+		return nil
+	}
+
+	// 调用相应的处理器
+	c.mu.Lock()
+	handler, exists := c.handlers[syncMsg.Type]
+	c.mu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := handler(ctx, &syncMsg)
+		if err == nil {
+			return nil
+		}
+		if attempt > handleMaxRetries || ctx.Err() != nil {
+			return fmt.Errorf("handle %s message at %s/%d/%d after %d attempts: %w",
+				syncMsg.Type, msg.Topic, msg.Partition, msg.Offset, attempt, err)
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * handleRetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runHooks 调用重平衡回调
+func (c *MessageConsumer) runHooks(hooks []RebalanceHook, groupID string, claims map[string][]int32) {
+	for _, hook := range hooks {
+		hook(groupID, claims)
 	}
 }
 
@@ -132,13 +252,73 @@ func (c *MessageConsumer) consumeTopic(topic string) {
 func (c *MessageConsumer) Stop() error {
 	// 取消上下文
 	c.cancel()
+	// 关闭消费者组，已标记的offset会在关闭时提交
+	var firstErr error
+	for _, g := range c.groups {
+		if err := g.group.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 	// 等待所有goroutine结束
 	c.wg.Wait()
-	// 关闭消费者
-	return c.consumer.Close()
+	return firstErr
 }
 
 // GetInstanceID 获取实例ID
 func (c *MessageConsumer) GetInstanceID() string {
 	return c.instanceID
 }
+
+// groupHandler 实现sarama.ConsumerGroupHandler
+type groupHandler struct {
+	consumer *MessageConsumer
+	groupID  string
+	skipOwn  bool
+	failed   atomic.Bool // 有消息处理失败，重新加入消费者组前等待
+}
+
+// Setup 重平衡完成、开始消费新分配的分区前调用
+func (h *groupHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	log.Printf("Consumer group %s assigned partitions: %v", h.groupID, sess.Claims())
+
+	h.consumer.mu.Lock()
+	hooks := h.consumer.onAssigned
+	h.consumer.mu.Unlock()
+	h.consumer.runHooks(hooks, h.groupID, sess.Claims())
+	return nil
+}
+
+// Cleanup 所有分区的ConsumeClaim退出后调用
+func (h *groupHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	log.Printf("Consumer group %s revoked partitions: %v", h.groupID, sess.Claims())
+
+	h.consumer.mu.Lock()
+	hooks := h.consumer.onRevoked
+	h.consumer.mu.Unlock()
+	h.consumer.runHooks(hooks, h.groupID, sess.Claims())
+	return nil
+}
+
+// ConsumeClaim 顺序处理分区内的消息，处理成功后标记offset
+// 处理失败时不标记并结束会话，重新加入消费者组后从该消息重新消费
+func (h *groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := h.consumer.handle(sess.Context(), msg, h.skipOwn); err != nil {
+				// 重平衡或停止时未处理完的消息由分区的下一个持有者重新消费
+				if sess.Context().Err() != nil {
+					return nil
+				}
+				h.failed.Store(true)
+				return err
+			}
+			sess.MarkMessage(msg, "")
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/service"
+)
+
+// Dispatcher 将其他实例发布的同步消息分发给本实例的Hub
+// 只推送给本地连接的客户端，不会再次持久化或重新发布
+type Dispatcher struct {
+	hub        service.IHubService
+	instanceID string // 本实例ID，用于跳过自己发布的消息
+}
+
+// NewDispatcher 创建消息分发器
+func NewDispatcher(hub service.IHubService, instanceID string) *Dispatcher {
+	return &Dispatcher{
+		hub:        hub,
+		instanceID: instanceID,
+	}
+}
+
+// Register 在消费者上注册各类同步消息的处理器
+func (d *Dispatcher) Register(consumer *MessageConsumer) {
+	consumer.RegisterHandler(TypeUserMessage, d.handleUserMessage)
+	consumer.RegisterHandler(TypeRoomMessage, d.handleRoomMessage)
+	consumer.RegisterHandler(TypeSystemMessage, d.handleSystemMessage)
+	consumer.RegisterHandler(TypeStatusUpdate, d.handleStatusUpdate)
+	consumer.RegisterHandler(TypeMessageEvent, d.handleMessageEvent)
+	consumer.RegisterHandler(TypeExpiredEvent, d.handleExpiredEvent)
+	consumer.RegisterHandler(TypeReactionEvent, d.handleReactionEvent)
+	consumer.RegisterHandler(TypeReadReceipt, d.handleReadReceipt)
+	consumer.RegisterHandler(TypeSignal, d.handleSignal)
+}
+
+// handleUserMessage 处理私聊消息
+func (d *Dispatcher) handleUserMessage(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var message model.Message
+	if err := msg.DecodeContent(&message); err != nil {
+		// 无法解析的消息重试也无济于事，直接跳过
+		log.Printf("Failed to decode user message: %v", err)
+		return nil
+	}
+
+	receiverID := message.ReceiverID
+	if receiverID == 0 {
+		receiverID = message.TargetID
+	}
+	if err := d.hub.DeliverToUser(ctx, receiverID, &message); err != nil {
+		return fmt.Errorf("deliver user message %d to user %d: %w", message.ID, receiverID, err)
+	}
+	return nil
+}
+
+// handleRoomMessage 处理房间消息
+func (d *Dispatcher) handleRoomMessage(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var message model.Message
+	if err := msg.DecodeContent(&message); err != nil {
+		log.Printf("Failed to decode room message: %v", err)
+		return nil
+	}
+
+	roomID := message.RoomID
+	if roomID == 0 {
+		roomID = message.TargetID
+	}
+	if err := d.hub.DeliverToRoom(ctx, roomID, &message); err != nil {
+		return fmt.Errorf("deliver room message %d to room %d: %w", message.ID, roomID, err)
+	}
+	return nil
+}
+
+// handleSystemMessage 处理全员系统广播
+func (d *Dispatcher) handleSystemMessage(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var message model.Message
+	if err := msg.DecodeContent(&message); err != nil {
+		log.Printf("Failed to decode system message: %v", err)
+		return nil
+	}
+
+	if err := d.hub.DeliverToAll(ctx, &message); err != nil {
+		return fmt.Errorf("deliver system message %d: %w", message.ID, err)
+	}
+	return nil
+}
+
+// handleStatusUpdate 处理用户在线状态变化
+func (d *Dispatcher) handleStatusUpdate(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var payload StatusPayload
+	if err := msg.DecodeContent(&payload); err != nil {
+		log.Printf("Failed to decode status update: %v", err)
+		return nil
+	}
+
+	status, err := strconv.Atoi(payload.Status)
+	if err != nil {
+		log.Printf("Invalid status %q for user %d", payload.Status, payload.UserID)
+		return nil
+	}
+	if err := d.hub.NotifyStatus(ctx, payload.UserID, status, payload.InstanceID); err != nil {
+		return fmt.Errorf("notify status of user %d: %w", payload.UserID, err)
+	}
+	return nil
+}
+
+// handleMessageEvent 处理消息编辑、撤回事件
+func (d *Dispatcher) handleMessageEvent(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var payload MessageEventPayload
+	if err := msg.DecodeContent(&payload); err != nil || payload.Message == nil {
+		log.Printf("Failed to decode message event: %v", err)
+		return nil
+	}
+
+	if err := d.hub.DeliverMessageEvent(ctx, payload.Event, payload.Message, payload.OperatorID); err != nil {
+		return fmt.Errorf("deliver %s event of message %d: %w", payload.Event, payload.Message.ID, err)
+	}
+	return nil
+}
+
+// handleExpiredEvent 处理消息过期事件
+func (d *Dispatcher) handleExpiredEvent(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var payload ExpiredEventPayload
+	if err := msg.DecodeContent(&payload); err != nil || len(payload.MessageIDs) == 0 {
+		log.Printf("Failed to decode expired event: %v", err)
+		return nil
+	}
+
+	if err := d.hub.DeliverExpiredEvent(ctx, payload.ConversationID, payload.MessageIDs); err != nil {
+		return fmt.Errorf("deliver expired event of conversation %s: %w", payload.ConversationID, err)
+	}
+	return nil
+}
+
+// handleReactionEvent 处理表情回应事件
+func (d *Dispatcher) handleReactionEvent(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var payload ReactionEventPayload
+	if err := msg.DecodeContent(&payload); err != nil || payload.Message == nil {
+		log.Printf("Failed to decode reaction event: %v", err)
+		return nil
+	}
+
+	if err := d.hub.DeliverReactionEvent(ctx, payload.Event, payload.Message, payload.UserID, payload.Emoji); err != nil {
+		return fmt.Errorf("deliver %s event of message %d: %w", payload.Event, payload.Message.ID, err)
+	}
+	return nil
+}
+
+// handleReadReceipt 处理已读回执
+func (d *Dispatcher) handleReadReceipt(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var payload ReadReceiptPayload
+	if err := msg.DecodeContent(&payload); err != nil || payload.Cursor == nil {
+		log.Printf("Failed to decode read receipt: %v", err)
+		return nil
+	}
+
+	if err := d.hub.DeliverReadReceipt(ctx, payload.Cursor); err != nil {
+		return fmt.Errorf("deliver read receipt of %s: %w", payload.Cursor.ConversationID, err)
+	}
+	return nil
+}
+
+// handleSignal 处理临时信号，已过期的信号由Hub丢弃
+func (d *Dispatcher) handleSignal(ctx context.Context, msg *SyncMessage) error {
+	if d.isSelf(msg) {
+		return nil
+	}
+
+	var payload SignalPayload
+	if err := msg.DecodeContent(&payload); err != nil || payload.Signal == nil {
+		log.Printf("Failed to decode signal: %v", err)
+		return nil
+	}
+
+	if err := d.hub.DeliverSignal(ctx, payload.Signal); err != nil {
+		return fmt.Errorf("deliver %s signal of %s: %w", payload.Signal.Name, payload.Signal.ConversationID, err)
+	}
+	return nil
+}
+
+// isSelf 判断消息是否由本实例发布，本实例的客户端已在发送时直接推送
+func (d *Dispatcher) isSelf(msg *SyncMessage) bool {
+	return msg.SourceID == d.instanceID
+}
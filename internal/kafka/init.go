@@ -1,12 +1,10 @@
 package kafka
 
 import (
-	"encoding/hex"
 	"log"
-	"math/rand"
-	"time"
 
 	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/instance"
 	"github.com/Gopher0727/RTMP/internal/service"
 )
 
@@ -16,11 +14,11 @@ var (
 )
 
 // InitKafka 初始化Kafka生产者和消费者
-func InitKafka(cfg *config.Config) error {
+func InitKafka(cfg *config.Config, identity *instance.Identity) error {
 	var err error
 
 	// 初始化生产者
-	producer, err = NewMessageProducer(cfg)
+	producer, err = NewMessageProducer(cfg, identity)
 	if err != nil {
 		return err
 	}
@@ -30,15 +28,18 @@ func InitKafka(cfg *config.Config) error {
 }
 
 // InitConsumer 初始化消费者（需要在服务初始化后调用）
-func InitConsumer(cfg *config.Config, messageService service.IMessageService, hubService service.IHubService) error {
+func InitConsumer(cfg *config.Config, identity *instance.Identity, messageService service.IMessageService, hubService service.IHubService) error {
 	var err error
 
-	// 初始化消费者
-	consumer, err = NewMessageConsumer(cfg)
+	// 初始化消费者，与生产者、Hub使用同一实例身份
+	consumer, err = NewMessageConsumer(cfg, identity)
 	if err != nil {
 		return err
 	}
 
+	// 注册分发器，将其他实例的消息推送给本实例的客户端
+	NewDispatcher(hubService, consumer.GetInstanceID()).Register(consumer)
+
 	// 启动消费者
 	consumer.Start()
 
@@ -70,14 +71,3 @@ func CloseKafka() {
 
 	log.Println("Kafka connections closed")
 }
-
-// generateInstanceID 生成唯一的实例ID
-func generateInstanceID() string {
-	b := make([]byte, 8)
-	_, err := rand.Read(b)
-	if err != nil {
-		// 如果随机数生成失败，使用时间戳和进程ID作为备用
-		return time.Now().Format("20060102150405")
-	}
-	return "instance-" + hex.EncodeToString(b)
-}
@@ -1,6 +1,24 @@
 package kafka
 
-import "github.com/Gopher0727/RTMP/internal/model"
+import (
+	"encoding/json"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/service"
+)
+
+// 同步消息类型
+const (
+	TypeUserMessage   = "user_message"   // 私聊消息
+	TypeRoomMessage   = "room_message"   // 房间消息
+	TypeSystemMessage = "system_message" // 系统消息
+	TypeStatusUpdate  = "status_update"  // 用户在线状态变化
+	TypeMessageEvent  = "message_event"  // 消息编辑、撤回事件
+	TypeExpiredEvent  = "expired_event"  // 消息过期事件
+	TypeReactionEvent = "reaction_event" // 表情回应事件
+	TypeReadReceipt   = "read_receipt"   // 已读回执
+	TypeSignal        = "signal"         // 临时信号（正在输入等）
+)
 
 // SyncMessage 同步消息结构
 type SyncMessage struct {
@@ -10,6 +28,15 @@ type SyncMessage struct {
 	Content   interface{} `json:"content"`
 }
 
+// DecodeContent 将消息内容解析为指定的负载类型
+func (m *SyncMessage) DecodeContent(v any) error {
+	raw, err := json.Marshal(m.Content)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
 // MessagePayload 消息负载结构
 type MessagePayload struct {
 	Message *model.Message `json:"message"`
@@ -21,3 +48,34 @@ type StatusPayload struct {
 	Status     string `json:"status"`
 	InstanceID string `json:"instance_id"`
 }
+
+// MessageEventPayload 消息编辑、撤回事件负载结构
+type MessageEventPayload struct {
+	Event      string         `json:"event"` // message_edited | message_recalled
+	Message    *model.Message `json:"message"`
+	OperatorID uint           `json:"operator_id"`
+}
+
+// ExpiredEventPayload 消息过期事件负载结构，同一会话的一批消息合并发送
+type ExpiredEventPayload struct {
+	ConversationID string `json:"conversation_id"`
+	MessageIDs     []uint `json:"message_ids"`
+}
+
+// ReactionEventPayload 表情回应事件负载结构，消息中附带最新的回应统计
+type ReactionEventPayload struct {
+	Event   string         `json:"event"` // reaction_added | reaction_removed
+	Message *model.Message `json:"message"`
+	UserID  uint           `json:"user_id"`
+	Emoji   string         `json:"emoji"`
+}
+
+// ReadReceiptPayload 已读回执负载结构
+type ReadReceiptPayload struct {
+	Cursor *model.ReadCursor `json:"cursor"`
+}
+
+// SignalPayload 临时信号负载结构
+type SignalPayload struct {
+	Signal *service.Signal `json:"signal"`
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/IBM/sarama"
 
 	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/instance"
 	"github.com/Gopher0727/RTMP/internal/model"
 	"github.com/Gopher0727/RTMP/internal/service"
 )
@@ -19,11 +20,12 @@ type MessageProducer struct {
 	producer   sarama.SyncProducer
 	instanceID string
 	topics     map[string]string
+	kafkaCfg   config.KafkaConfig
 	mu         sync.Mutex
 }
 
 // NewMessageProducer 创建新的消息生产者
-func NewMessageProducer(cfg *config.Config) (*MessageProducer, error) {
+func NewMessageProducer(cfg *config.Config, identity *instance.Identity) (*MessageProducer, error) {
 	config := sarama.NewConfig()
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Retry.Max = 5
@@ -36,17 +38,15 @@ func NewMessageProducer(cfg *config.Config) (*MessageProducer, error) {
 
 	// 初始化主题映射
 	topics := make(map[string]string)
-	for _, topic := range cfg.Kafka.Topics {
+	for _, topic := range cfg.Kafka.Topics() {
 		topics[topic] = topic
 	}
 
-	// 生成实例ID
-	instanceID := generateInstanceID()
-
 	return &MessageProducer{
 		producer:   producer,
-		instanceID: instanceID,
+		instanceID: identity.ID(),
 		topics:     topics,
+		kafkaCfg:   cfg.Kafka,
 	}, nil
 }
 
@@ -76,7 +76,7 @@ func (p *MessageProducer) SendMessage(topic string, key string, value []byte) er
 func (p *MessageProducer) SendUserMessage(userID uint, message *model.Message) error {
 	// 创建符合SyncMessage格式的消息
 	syncMsg := SyncMessage{
-		Type:      "user_message",
+		Type:      TypeUserMessage,
 		SourceID:  p.instanceID,
 		Timestamp: time.Now().Unix(),
 		Content:   message,
@@ -90,11 +90,29 @@ func (p *MessageProducer) SendUserMessage(userID uint, message *model.Message) e
 	return p.SendMessage(p.topics["user_messages"], strconv.FormatUint(uint64(userID), 10), jsonPayload)
 }
 
+// SendUserMessageToInstance 将私聊消息直接投递到接收者所在实例的收件箱
+func (p *MessageProducer) SendUserMessageToInstance(instanceID string, userID uint, message *model.Message) error {
+	// 创建符合SyncMessage格式的消息
+	syncMsg := SyncMessage{
+		Type:      TypeUserMessage,
+		SourceID:  p.instanceID,
+		Timestamp: time.Now().Unix(),
+		Content:   message,
+	}
+
+	// 序列化消息
+	jsonPayload, err := json.Marshal(syncMsg)
+	if err != nil {
+		return err
+	}
+	return p.SendMessage(p.kafkaCfg.InboxTopic(instanceID), strconv.FormatUint(uint64(userID), 10), jsonPayload)
+}
+
 // SendRoomMessage 发送房间消息
 func (p *MessageProducer) SendRoomMessage(roomID uint, message *model.Message) error {
 	// 创建符合SyncMessage格式的消息
 	syncMsg := SyncMessage{
-		Type:      "room_message",
+		Type:      TypeRoomMessage,
 		SourceID:  p.instanceID,
 		Timestamp: time.Now().Unix(),
 		Content:   message,
@@ -108,14 +126,14 @@ func (p *MessageProducer) SendRoomMessage(roomID uint, message *model.Message) e
 	return p.SendMessage(p.topics["room_messages"], strconv.FormatUint(uint64(roomID), 10), jsonPayload)
 }
 
-// SendSystemMessage 发送系统消息
-func (p *MessageProducer) SendSystemMessage(payload any) error {
+// SendSystemMessage 发送全员系统广播
+func (p *MessageProducer) SendSystemMessage(message *model.Message) error {
 	// 创建符合SyncMessage格式的消息
 	syncMsg := SyncMessage{
-		Type:      "system_message",
+		Type:      TypeSystemMessage,
 		SourceID:  p.instanceID,
 		Timestamp: time.Now().Unix(),
-		Content:   payload,
+		Content:   message,
 	}
 
 	// 序列化消息
@@ -155,7 +173,7 @@ func (p *MessageProducer) SendStatusUpdate(userID uint, status int) error {
 
 	// 创建符合SyncMessage格式的消息
 	syncMsg := SyncMessage{
-		Type:      "status_update",
+		Type:      TypeStatusUpdate,
 		SourceID:  p.instanceID,
 		Timestamp: time.Now().Unix(),
 		Content:   statusPayload,
@@ -170,6 +188,132 @@ func (p *MessageProducer) SendStatusUpdate(userID uint, status int) error {
 	return p.SendMessage(p.topics["online_status"], "status", jsonPayload)
 }
 
+// SendMessageEvent 发送消息编辑、撤回事件，与原消息使用同一主题和分区键，保证在原消息之后被处理
+func (p *MessageProducer) SendMessageEvent(eventType string, message *model.Message, operatorID uint) error {
+	// 创建符合SyncMessage格式的消息
+	syncMsg := SyncMessage{
+		Type:      TypeMessageEvent,
+		SourceID:  p.instanceID,
+		Timestamp: time.Now().Unix(),
+		Content: MessageEventPayload{
+			Event:      eventType,
+			Message:    message,
+			OperatorID: operatorID,
+		},
+	}
+
+	// 序列化消息
+	jsonPayload, err := json.Marshal(syncMsg)
+	if err != nil {
+		return err
+	}
+
+	if message.TargetType == model.MessageTargetRoom {
+		return p.SendMessage(p.topics["room_messages"], strconv.FormatUint(uint64(message.RoomID), 10), jsonPayload)
+	}
+	return p.SendMessage(p.topics["user_messages"], strconv.FormatUint(uint64(message.ReceiverID), 10), jsonPayload)
+}
+
+// SendExpiredEvent 发送会话中一批消息的过期事件，房间会话使用房间消息主题，私聊会话使用私聊消息主题
+func (p *MessageProducer) SendExpiredEvent(conversationID string, messageIDs []uint) error {
+	// 创建符合SyncMessage格式的消息
+	syncMsg := SyncMessage{
+		Type:      TypeExpiredEvent,
+		SourceID:  p.instanceID,
+		Timestamp: time.Now().Unix(),
+		Content: ExpiredEventPayload{
+			ConversationID: conversationID,
+			MessageIDs:     messageIDs,
+		},
+	}
+
+	// 序列化消息
+	jsonPayload, err := json.Marshal(syncMsg)
+	if err != nil {
+		return err
+	}
+
+	kind, ids, err := model.ParseConversationID(conversationID)
+	if err != nil {
+		return err
+	}
+	if kind == model.MessageTargetRoom {
+		return p.SendMessage(p.topics["room_messages"], strconv.FormatUint(uint64(ids[0]), 10), jsonPayload)
+	}
+	return p.SendMessage(p.topics["user_messages"], conversationID, jsonPayload)
+}
+
+// SendReactionEvent 发送表情回应事件，与原消息使用同一主题和分区键
+func (p *MessageProducer) SendReactionEvent(eventType string, message *model.Message, userID uint, emoji string) error {
+	// 创建符合SyncMessage格式的消息
+	syncMsg := SyncMessage{
+		Type:      TypeReactionEvent,
+		SourceID:  p.instanceID,
+		Timestamp: time.Now().Unix(),
+		Content: ReactionEventPayload{
+			Event:   eventType,
+			Message: message,
+			UserID:  userID,
+			Emoji:   emoji,
+		},
+	}
+
+	// 序列化消息
+	jsonPayload, err := json.Marshal(syncMsg)
+	if err != nil {
+		return err
+	}
+
+	if message.TargetType == model.MessageTargetRoom {
+		return p.SendMessage(p.topics["room_messages"], strconv.FormatUint(uint64(message.RoomID), 10), jsonPayload)
+	}
+	return p.SendMessage(p.topics["user_messages"], strconv.FormatUint(uint64(message.ReceiverID), 10), jsonPayload)
+}
+
+// SendReadReceipt 发送已读回执，房间会话使用房间消息主题，私聊会话使用私聊消息主题
+func (p *MessageProducer) SendReadReceipt(cursor *model.ReadCursor) error {
+	// 创建符合SyncMessage格式的消息
+	syncMsg := SyncMessage{
+		Type:      TypeReadReceipt,
+		SourceID:  p.instanceID,
+		Timestamp: time.Now().Unix(),
+		Content:   ReadReceiptPayload{Cursor: cursor},
+	}
+
+	// 序列化消息
+	jsonPayload, err := json.Marshal(syncMsg)
+	if err != nil {
+		return err
+	}
+
+	kind, ids, err := model.ParseConversationID(cursor.ConversationID)
+	if err != nil {
+		return err
+	}
+	if kind == model.MessageTargetRoom {
+		return p.SendMessage(p.topics["room_messages"], strconv.FormatUint(uint64(ids[0]), 10), jsonPayload)
+	}
+	return p.SendMessage(p.topics["user_messages"], cursor.ConversationID, jsonPayload)
+}
+
+// SendSignal 发送临时信号到专用主题，按会话ID分区保证同一会话的信号有序
+func (p *MessageProducer) SendSignal(signal *service.Signal) error {
+	// 创建符合SyncMessage格式的消息
+	syncMsg := SyncMessage{
+		Type:      TypeSignal,
+		SourceID:  p.instanceID,
+		Timestamp: time.Now().Unix(),
+		Content:   SignalPayload{Signal: signal},
+	}
+
+	// 序列化消息
+	jsonPayload, err := json.Marshal(syncMsg)
+	if err != nil {
+		return err
+	}
+	return p.SendMessage(p.kafkaCfg.SignalTopicName(), signal.ConversationID, jsonPayload)
+}
+
 // Close 关闭生产者
 func (p *MessageProducer) Close() error {
 	return p.producer.Close()
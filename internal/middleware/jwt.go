@@ -37,6 +37,10 @@ func JWTAuth() gin.HandlerFunc {
 	cfg := config.GetJWTConfig()
 	return func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
+		// EventSource 和浏览器 WebSocket 无法设置请求头，GET 请求允许通过 token 查询参数传递
+		if auth == "" && c.Request.Method == http.MethodGet && c.Query("token") != "" {
+			auth = "Bearer " + c.Query("token")
+		}
 		if auth == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
 			return
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +13,7 @@ import (
 // @Summary 请求日志中间件
 // @Description 记录HTTP请求的详细信息
 // @Tags middleware
-func Logger() gin.HandlerFunc {
+func Logger(instanceID string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 开始时间
 		startTime := time.Now()
@@ -30,7 +31,7 @@ func Logger() gin.HandlerFunc {
 		reqMethod := c.Request.Method
 
 		// 请求路由
-		reqUri := c.Request.RequestURI
+		reqUri := redactToken(c.Request.URL)
 
 		// 状态码
 		statusCode := c.Writer.Status()
@@ -40,6 +41,7 @@ func Logger() gin.HandlerFunc {
 
 		// 日志格式
 		logrus.WithFields(logrus.Fields{
+			"instance_id":  instanceID,
 			"status_code":  statusCode,
 			"latency_time": latencyTime,
 			"client_ip":    clientIP,
@@ -65,3 +67,15 @@ func Logger() gin.HandlerFunc {
 		)
 	}
 }
+
+// redactToken 隐去查询参数中的JWT令牌，避免写入日志
+func redactToken(u *url.URL) string {
+	query := u.Query()
+	if !query.Has("token") {
+		return u.RequestURI()
+	}
+	query.Set("token", "***")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
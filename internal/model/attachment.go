@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Attachment 消息附件，上传后尚未发送时MessageID为0
+type Attachment struct {
+	ID           uint           `gorm:"primarykey" json:"id"`
+	MessageID    uint           `gorm:"index" json:"message_id"`               // 所属消息ID，0表示尚未随消息发送
+	UploaderID   uint           `gorm:"not null;index" json:"uploader_id"`     // 上传者ID
+	FileName     string         `gorm:"size:255;not null" json:"file_name"`    // 原始文件名
+	ContentType  string         `gorm:"size:100;not null" json:"content_type"` // MIME类型，由服务端根据内容检测
+	Size         int64          `gorm:"not null" json:"size"`                  // 文件大小（字节）
+	StorageKey   string         `gorm:"size:255;not null" json:"-"`            // 对象存储中的键
+	ThumbnailKey string         `gorm:"size:255" json:"-"`                     // 缩略图在对象存储中的键，非图片为空
+	HasThumbnail bool           `gorm:"default:false" json:"has_thumbnail"`    // 是否有缩略图
+	Width        int            `json:"width,omitempty"`                       // 图片宽度
+	Height       int            `json:"height,omitempty"`                      // 图片高度
+	CreatedAt    time.Time      `json:"created_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// TableName 指定表名
+func (Attachment) TableName() string {
+	return "attachments"
+}
@@ -0,0 +1,51 @@
+package model
+
+import "time"
+
+// Conversation 私聊或房间会话，发送消息时更新最新消息和活跃时间
+type Conversation struct {
+	ID             string        `gorm:"primarykey;size:64" json:"id"` // 会话ID：dm:<小ID>:<大ID> 或 room:<房间ID>
+	Type           MessageTarget `gorm:"size:20;not null" json:"type"`
+	UserA          uint          `gorm:"index" json:"-"`                                          // 私聊双方中较小的用户ID
+	UserB          uint          `gorm:"index" json:"-"`                                          // 私聊双方中较大的用户ID
+	RoomID         uint          `gorm:"index" json:"room_id,omitempty"`                          // 房间ID，房间会话时使用
+	LastMessageID  uint          `json:"last_message_id"`                                         // 最新消息ID，没有消息时为0
+	LastActivityAt time.Time     `gorm:"index:idx_conversation_activity" json:"last_activity_at"` // 最后活跃时间，没有消息时为创建时间
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (Conversation) TableName() string {
+	return "conversations"
+}
+
+// NewConversation 根据会话ID创建会话，全员会话不记录，返回nil
+func NewConversation(id string) (*Conversation, error) {
+	kind, ids, err := ParseConversationID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	conversation := &Conversation{ID: id, Type: kind}
+	switch kind {
+	case MessageTargetUser:
+		conversation.UserA, conversation.UserB = ids[0], ids[1]
+	case MessageTargetRoom:
+		conversation.RoomID = ids[0]
+	default:
+		return nil, nil
+	}
+	return conversation, nil
+}
+
+// PeerID 私聊会话中对方的用户ID，房间会话返回0
+func (c *Conversation) PeerID(userID uint) uint {
+	if c.Type != MessageTargetUser {
+		return 0
+	}
+	if c.UserA == userID {
+		return c.UserB
+	}
+	return c.UserA
+}
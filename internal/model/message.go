@@ -1,7 +1,11 @@
 package model
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gorm.io/gorm"
 )
@@ -25,25 +29,223 @@ const (
 	MessageTargetAll  MessageTarget = "all"  // 发送给所有人
 )
 
+// MaxClientMsgIDLength 客户端消息ID的最大长度，与client_msg_id列的长度一致
+const MaxClientMsgIDLength = 64
+
 // Message 消息模型
 type Message struct {
-	ID         uint           `gorm:"primarykey" json:"id"`
-	Content    string         `gorm:"type:text;not null" json:"content"`
-	Type       string         `gorm:"size:20;not null" json:"type"` // 使用string类型以支持"user"和"room"
-	TargetType MessageTarget  `gorm:"size:20;not null" json:"target_type"`
-	TargetID   uint           `gorm:"not null" json:"target_id"`    // 目标ID（用户ID或房间ID）
-	SenderID   uint           `json:"sender_id"`                    // 发送者ID，0表示系统
-	SenderName string         `gorm:"size:50" json:"sender_name"`   // 发送者名称
-	ReceiverID uint           `json:"receiver_id"`                  // 接收者ID，私聊时使用
-	RoomID     uint           `json:"room_id"`                      // 房间ID，房间消息时使用
-	InstanceID string         `gorm:"size:50" json:"instance_id"`   // 消息所属实例ID
-	IsRead     bool           `gorm:"default:false" json:"is_read"` // 是否已读
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	ID             uint            `gorm:"primarykey;index:idx_target_id,priority:3" json:"id"`
+	Content        string          `gorm:"type:text;not null" json:"content"`
+	Type           string          `gorm:"size:20;not null" json:"type"` // 内容类型（MessageType），发送目标由TargetType、ReceiverID、RoomID表示
+	TargetType     MessageTarget   `gorm:"size:20;not null;index:idx_target_id,priority:1" json:"target_type"`
+	TargetID       uint            `gorm:"not null;index:idx_target_id,priority:2" json:"target_id"`                            // 目标ID（用户ID或房间ID）
+	SenderID       uint            `gorm:"uniqueIndex:idx_sender_client_msg,priority:1" json:"sender_id"`                       // 发送者ID，0表示系统
+	ClientMsgID    *string         `gorm:"size:64;uniqueIndex:idx_sender_client_msg,priority:2" json:"client_msg_id,omitempty"` // 客户端生成的消息ID，同一发送者内唯一，重试发送时用于去重
+	SenderName     string          `gorm:"size:50" json:"sender_name"`                                                          // 发送者名称
+	ReceiverID     uint            `json:"receiver_id"`                                                                         // 接收者ID，私聊时使用
+	RoomID         uint            `json:"room_id"`                                                                             // 房间ID，房间消息时使用
+	InstanceID     string          `gorm:"size:50" json:"instance_id"`                                                          // 消息所属实例ID
+	ConversationID string          `gorm:"size:64;index:idx_conversation_seq,priority:1" json:"conversation_id"`                // 会话ID：dm:<小ID>:<大ID> 或 room:<房间ID>
+	Seq            uint64          `gorm:"index:idx_conversation_seq,priority:2" json:"seq"`                                    // 会话内单调递增的序号，持久化时分配
+	IsRead         bool            `gorm:"default:false" json:"is_read"`                                                        // 私聊消息是否已被接收者读过，房间消息的已读状态见ReadCursor
+	EditedAt       *time.Time      `json:"edited_at,omitempty"`                                                                 // 最后编辑时间，未编辑过时为空
+	Attachments    []Attachment    `gorm:"foreignKey:MessageID" json:"attachments,omitempty"`                                   // 附件
+	ReplyToID      uint            `gorm:"index" json:"reply_to_id,omitempty"`                                                  // 回复（引用）的消息ID
+	ThreadRootID   uint            `gorm:"index" json:"thread_root_id,omitempty"`                                               // 所属话题的根消息ID，回复时根据被回复的消息确定
+	ReplyTo        *MessageQuote   `gorm:"-" json:"reply_to,omitempty"`                                                         // 被回复消息的引用预览，已撤回时为空
+	ReplyCount     int64           `gorm:"-" json:"reply_count,omitempty"`                                                      // 话题根消息的回复数
+	Reactions      []ReactionCount `gorm:"-" json:"reactions,omitempty"`                                                        // 表情回应统计，按首次回应的先后排序
+	ExpiresAt      *time.Time      `gorm:"index" json:"expires_at,omitempty"`                                                   // 过期时间，过期后消息被删除，为空时不过期
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt  `gorm:"index" json:"-"`
 }
 
 // TableName 指定表名
 func (Message) TableName() string {
 	return "messages"
 }
+
+// ExpireAfter 设置消息在ttl后过期，ttl不大于0或已有更早的过期时间时不修改
+func (m *Message) ExpireAfter(ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	if m.ExpiresAt == nil || expiresAt.Before(*m.ExpiresAt) {
+		m.ExpiresAt = &expiresAt
+	}
+}
+
+// quoteMaxRunes 引用预览保留的最大字符数
+const quoteMaxRunes = 100
+
+// MessageQuote 被回复消息的引用预览
+type MessageQuote struct {
+	ID         uint      `json:"id"`
+	SenderID   uint      `json:"sender_id"`
+	SenderName string    `json:"sender_name"`
+	Content    string    `json:"content"` // 内容预览，过长时截断
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Quote 生成消息的引用预览
+func (m *Message) Quote() *MessageQuote {
+	content := m.Content
+	if utf8.RuneCountInString(content) > quoteMaxRunes {
+		content = string([]rune(content)[:quoteMaxRunes]) + "…"
+	}
+	return &MessageQuote{
+		ID:         m.ID,
+		SenderID:   m.SenderID,
+		SenderName: m.SenderName,
+		Content:    content,
+		CreatedAt:  m.CreatedAt,
+	}
+}
+
+// Normalize 补全消息的目标字段，使 TargetType/TargetID 与 ReceiverID/RoomID 保持一致
+func (m *Message) Normalize() {
+	switch {
+	case m.TargetType == MessageTargetRoom || m.RoomID != 0:
+		m.TargetType = MessageTargetRoom
+		if m.TargetID == 0 {
+			m.TargetID = m.RoomID
+		}
+		if m.RoomID == 0 {
+			m.RoomID = m.TargetID
+		}
+	case m.TargetType == MessageTargetUser || m.ReceiverID != 0:
+		m.TargetType = MessageTargetUser
+		if m.TargetID == 0 {
+			m.TargetID = m.ReceiverID
+		}
+		if m.ReceiverID == 0 {
+			m.ReceiverID = m.TargetID
+		}
+	case m.TargetType == "":
+		m.TargetType = MessageTargetAll
+	}
+	if m.ConversationID == "" {
+		m.ConversationID = m.conversationID()
+	}
+}
+
+// conversationID 根据消息目标计算会话ID
+func (m *Message) conversationID() string {
+	switch m.TargetType {
+	case MessageTargetRoom:
+		return RoomConversationID(m.TargetID)
+	case MessageTargetUser:
+		return DirectConversationID(m.SenderID, m.TargetID)
+	default:
+		return BroadcastConversationID
+	}
+}
+
+// BroadcastConversationID 全员消息的会话ID
+const BroadcastConversationID = "all"
+
+// DirectConversationID 私聊会话ID，与双方顺序无关
+func DirectConversationID(a, b uint) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("dm:%d:%d", a, b)
+}
+
+// RoomConversationID 房间会话ID
+func RoomConversationID(roomID uint) string {
+	return fmt.Sprintf("room:%d", roomID)
+}
+
+// ParseConversationID 解析会话ID，返回会话类型及相关的用户ID或房间ID
+func ParseConversationID(id string) (MessageTarget, []uint, error) {
+	if id == BroadcastConversationID {
+		return MessageTargetAll, nil, nil
+	}
+
+	kind, rest, ok := strings.Cut(id, ":")
+	if !ok {
+		return "", nil, fmt.Errorf("invalid conversation id %q", id)
+	}
+
+	var ids []uint
+	for _, part := range strings.Split(rest, ":") {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid conversation id %q", id)
+		}
+		ids = append(ids, uint(n))
+	}
+
+	switch {
+	case kind == "dm" && len(ids) == 2:
+		return MessageTargetUser, ids, nil
+	case kind == "room" && len(ids) == 1:
+		return MessageTargetRoom, ids, nil
+	default:
+		return "", nil, fmt.Errorf("invalid conversation id %q", id)
+	}
+}
+
+// ConversationSeq 会话序号计数器
+type ConversationSeq struct {
+	ConversationID string    `gorm:"primarykey;size:64" json:"conversation_id"`
+	Seq            uint64    `gorm:"not null;default:0" json:"seq"` // 会话内已分配的最大序号
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (ConversationSeq) TableName() string {
+	return "conversation_seqs"
+}
+
+// DeliveryCursor 设备在会话中已确认接收的最大序号
+type DeliveryCursor struct {
+	UserID         uint      `gorm:"primarykey;autoIncrement:false" json:"user_id"`
+	DeviceID       string    `gorm:"primarykey;size:64" json:"device_id"`
+	ConversationID string    `gorm:"primarykey;size:64" json:"conversation_id"`
+	Seq            uint64    `gorm:"not null;default:0" json:"seq"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (DeliveryCursor) TableName() string {
+	return "delivery_cursors"
+}
+
+// ReadCursor 用户在会话中已读到的最大消息ID
+type ReadCursor struct {
+	UserID         uint      `gorm:"primarykey;autoIncrement:false" json:"user_id"`
+	ConversationID string    `gorm:"primarykey;size:64" json:"conversation_id"`
+	LastReadID     uint      `gorm:"not null;default:0" json:"last_read_id"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (ReadCursor) TableName() string {
+	return "read_cursors"
+}
+
+// UnreadCount 用户在会话中的未读消息统计
+type UnreadCount struct {
+	ConversationID string `json:"conversation_id"`
+	Unread         int64  `json:"unread"`          // 他人发送的、ID大于已读位置的消息数
+	LastReadID     uint   `json:"last_read_id"`    // 已读到的消息ID
+	LastMessageID  uint   `json:"last_message_id"` // 最新一条未读消息的ID，没有未读时为0
+}
+
+// MessageEdit 消息编辑历史，每次编辑保存编辑前的内容
+type MessageEdit struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	MessageID  uint      `gorm:"not null;index" json:"message_id"`
+	EditorID   uint      `gorm:"not null" json:"editor_id"`             // 编辑者ID，房间管理员可编辑他人消息
+	OldContent string    `gorm:"type:text;not null" json:"old_content"` // 编辑前的内容
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName 指定表名
+func (MessageEdit) TableName() string {
+	return "message_edits"
+}
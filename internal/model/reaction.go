@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Reaction 消息的表情回应，同一用户对同一消息的同一表情只记录一次
+type Reaction struct {
+	ID        uint `gorm:"primarykey" json:"id"`
+	MessageID uint `gorm:"not null;uniqueIndex:idx_reaction,priority:1" json:"message_id"`
+	UserID    uint `gorm:"not null;uniqueIndex:idx_reaction,priority:2" json:"user_id"`
+	// 使用二进制排序规则，否则MySQL的默认排序规则会把不同的表情视为相等
+	Emoji     string    `gorm:"type:varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;not null;uniqueIndex:idx_reaction,priority:3" json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName 指定表名
+func (Reaction) TableName() string {
+	return "reactions"
+}
+
+// ReactionCount 消息上某个表情的回应统计
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int64  `json:"count"`
+}
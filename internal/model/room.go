@@ -6,17 +6,25 @@ import (
 	"gorm.io/gorm"
 )
 
+// 房间成员角色
+const (
+	RoomRoleMember  = 0 // 普通成员
+	RoomRoleAdmin   = 1 // 管理员
+	RoomRoleCreator = 2 // 创建者
+)
+
 // Room 房间模型
 type Room struct {
-	ID          uint           `gorm:"primarykey" json:"id"`
-	Name        string         `gorm:"size:50;not null" json:"name"`
-	Description string         `gorm:"size:255" json:"description"`
-	CreatorID   uint           `gorm:"not null" json:"creator_id"`          // 创建者ID
-	InstanceID  string         `gorm:"size:50;not null" json:"instance_id"` // 房间所属实例ID
-	IsPrivate   bool           `gorm:"default:false" json:"is_private"`     // 是否为私有房间
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                uint           `gorm:"primarykey" json:"id"`
+	Name              string         `gorm:"size:50;not null" json:"name"`
+	Description       string         `gorm:"size:255" json:"description"`
+	CreatorID         uint           `gorm:"not null" json:"creator_id"`                    // 创建者ID
+	InstanceID        string         `gorm:"size:50;not null" json:"instance_id"`           // 房间所属实例ID
+	IsPrivate         bool           `gorm:"default:false" json:"is_private"`               // 是否为私有房间
+	MessageTTLSeconds int            `gorm:"not null;default:0" json:"message_ttl_seconds"` // 消息有效期（秒），0表示不过期，修改只对之后发送的消息生效
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // RoomMember 房间成员关系
@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+// ScheduledStatus 定时消息状态
+type ScheduledStatus string
+
+const (
+	ScheduledPending   ScheduledStatus = "pending"   // 等待发送
+	ScheduledSending   ScheduledStatus = "sending"   // 已被实例领取，正在发送
+	ScheduledSent      ScheduledStatus = "sent"      // 已发送
+	ScheduledCancelled ScheduledStatus = "cancelled" // 已取消
+	ScheduledFailed    ScheduledStatus = "failed"    // 发送失败
+)
+
+// ScheduledMessage 定时消息，到达发送时间后由领取到它的实例按普通消息发送
+type ScheduledMessage struct {
+	ID            uint            `gorm:"primarykey" json:"id"`
+	SenderID      uint            `gorm:"not null;index" json:"sender_id"`
+	SenderName    string          `gorm:"size:50" json:"sender_name"`
+	TargetType    MessageTarget   `gorm:"size:20;not null" json:"target_type"` // user | room
+	TargetID      uint            `gorm:"not null" json:"target_id"`
+	Content       string          `gorm:"type:text;not null" json:"content"`
+	AttachmentIDs []uint          `gorm:"serializer:json;type:text" json:"attachment_ids,omitempty"` // 发送时关联的附件
+	ReplyToID     uint            `json:"reply_to_id,omitempty"`
+	TTLSeconds    int             `gorm:"not null;default:0" json:"ttl_seconds,omitempty"` // 消息发送后的有效期（秒），0表示不过期
+	SendAt        time.Time       `gorm:"not null;index:idx_scheduled_due,priority:2" json:"send_at"`
+	Status        ScheduledStatus `gorm:"size:20;not null;index:idx_scheduled_due,priority:1" json:"status"`
+	LockedBy      string          `gorm:"size:50" json:"-"`                // 领取消息的实例ID
+	LockedUntil   *time.Time      `json:"-"`                               // 租约到期时间
+	MessageID     uint            `json:"message_id,omitempty"`            // 发送后生成的消息ID
+	Error         string          `gorm:"size:255" json:"error,omitempty"` // 发送失败的原因
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (ScheduledMessage) TableName() string {
+	return "scheduled_messages"
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// ErrAttachmentUnavailable 附件不存在、不属于发送者或已随其他消息发送
+var ErrAttachmentUnavailable = errors.New("attachment unavailable")
+
+// IAttachmentRepository 附件仓库接口
+type IAttachmentRepository interface {
+	Create(ctx context.Context, attachment *model.Attachment) error
+	GetByID(ctx context.Context, id uint) (*model.Attachment, error)
+	GetSendable(ctx context.Context, uploaderID uint, clientMsgID *string, ids []uint) ([]model.Attachment, error)
+}
+
+// AttachmentRepository 附件仓库实现
+type AttachmentRepository struct {
+	db *gorm.DB
+}
+
+// NewAttachmentRepository 创建附件仓库
+func NewAttachmentRepository(db *gorm.DB) IAttachmentRepository {
+	return &AttachmentRepository{
+		db: db,
+	}
+}
+
+// Create 创建附件
+func (r *AttachmentRepository) Create(ctx context.Context, attachment *model.Attachment) error {
+	return r.db.WithContext(ctx).Create(attachment).Error
+}
+
+// GetByID 根据ID获取附件
+func (r *AttachmentRepository) GetByID(ctx context.Context, id uint) (*model.Attachment, error) {
+	var attachment model.Attachment
+	if err := r.db.WithContext(ctx).First(&attachment, id).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// GetSendable 获取上传者尚未随消息发送的附件，按ID升序
+// 带客户端消息ID时也返回已关联到该ID对应消息的附件，重试发送时由消息仓库识别为重复消息
+func (r *AttachmentRepository) GetSendable(ctx context.Context, uploaderID uint, clientMsgID *string, ids []uint) ([]model.Attachment, error) {
+	db := r.db.WithContext(ctx)
+	query := db.Where("id IN ? AND uploader_id = ?", ids, uploaderID)
+	if clientMsgID != nil {
+		original := db.Unscoped().Model(&model.Message{}).Select("id").Where("sender_id = ? AND client_msg_id = ?", uploaderID, *clientMsgID)
+		query = query.Where("message_id = 0 OR message_id IN (?)", original)
+	} else {
+		query = query.Where("message_id = 0")
+	}
+
+	var attachments []model.Attachment
+	err := query.Order("id ASC").Find(&attachments).Error
+	return attachments, err
+}
+
+// AttachmentRepositorySet 附件仓库依赖注入
+var AttachmentRepositorySet = wire.NewSet(NewAttachmentRepository)
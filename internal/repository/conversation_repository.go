@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// IConversationRepository 会话仓库接口
+type IConversationRepository interface {
+	ListByUser(ctx context.Context, userID uint, roomIDs []uint, beforeAt time.Time, beforeID string, limit int) ([]*model.Conversation, error)
+}
+
+// ConversationRepository 会话仓库实现
+type ConversationRepository struct {
+	db *gorm.DB
+}
+
+// NewConversationRepository 创建会话仓库
+func NewConversationRepository(db *gorm.DB) IConversationRepository {
+	return &ConversationRepository{
+		db: db,
+	}
+}
+
+// ListByUser 获取用户的私聊会话和所在房间的会话，按最后活跃时间倒序
+// beforeAt不为零值时只返回排在(beforeAt, beforeID)之后的会话，用于游标分页
+func (r *ConversationRepository) ListByUser(ctx context.Context, userID uint, roomIDs []uint, beforeAt time.Time, beforeID string, limit int) ([]*model.Conversation, error) {
+	var conversations []*model.Conversation
+
+	member := r.db.Where("type = ? AND (user_a = ? OR user_b = ?)", model.MessageTargetUser, userID, userID)
+	if len(roomIDs) > 0 {
+		member = member.Or("type = ? AND room_id IN ?", model.MessageTargetRoom, roomIDs)
+	}
+
+	query := r.db.WithContext(ctx).Where(member)
+	if !beforeAt.IsZero() {
+		query = query.Where("last_activity_at < ? OR (last_activity_at = ? AND id < ?)", beforeAt, beforeAt, beforeID)
+	}
+	err := query.Order("last_activity_at DESC, id DESC").Limit(limit).Find(&conversations).Error
+	return conversations, err
+}
+
+// touchConversation 在事务中记录会话的最新消息，会话不存在时创建
+func touchConversation(tx *gorm.DB, message *model.Message) error {
+	conversation, err := model.NewConversation(message.ConversationID)
+	if err != nil || conversation == nil {
+		return err
+	}
+	conversation.LastMessageID = message.ID
+	conversation.LastActivityAt = message.CreatedAt
+
+	// 会话内的消息由序号计数器的行锁串行写入，后写入的消息总是更新的
+	return tx.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"last_message_id", "last_activity_at", "updated_at"}),
+	}).Create(conversation).Error
+}
+
+// ensureConversation 在事务中创建会话，已存在时不做修改
+func ensureConversation(tx *gorm.DB, conversationID string, createdAt time.Time) error {
+	conversation, err := model.NewConversation(conversationID)
+	if err != nil || conversation == nil {
+		return err
+	}
+	conversation.LastActivityAt = createdAt
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(conversation).Error
+}
+
+// ConversationRepositorySet 会话仓库依赖注入
+var ConversationRepositorySet = wire.NewSet(NewConversationRepository)
@@ -2,20 +2,67 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/google/wire"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/Gopher0727/RTMP/internal/model"
 )
 
+// ErrInvalidReply 被回复的消息不存在、已撤回或不属于同一会话
+var ErrInvalidReply = errors.New("invalid reply target")
+
+// ErrDuplicateMessage 发送者已使用相同的客户端消息ID发送过消息
+var ErrDuplicateMessage = errors.New("duplicate client message id")
+
+// MessageCursor 消息历史的键集分页参数，BeforeID、AfterID、AroundID至多设置一个，都为0时获取最新的消息
+type MessageCursor struct {
+	BeforeID uint // 获取ID小于BeforeID的消息
+	AfterID  uint // 获取ID大于AfterID的消息
+	AroundID uint // 获取AroundID及其前后的消息，前后各约一半
+	Limit    int
+}
+
+// MessagePage 键集分页的一页消息
+type MessagePage struct {
+	Messages  []*model.Message // 按ID降序
+	HasBefore bool             // 是否还有更早的消息
+	HasAfter  bool             // 是否还有更新的消息
+}
+
 // IMessageRepository 消息仓库接口
 type IMessageRepository interface {
 	Create(ctx context.Context, message *model.Message) error
 	GetByID(ctx context.Context, id uint) (*model.Message, error)
+	GetByIDs(ctx context.Context, ids []uint) ([]*model.Message, error)
+	Edit(ctx context.Context, message *model.Message, content string, editorID uint) error
+	Recall(ctx context.Context, id uint) error
+	GetEdits(ctx context.Context, messageID uint) ([]*model.MessageEdit, error)
 	GetUserMessages(ctx context.Context, userID uint, page, size int) ([]*model.Message, int64, error)
 	GetRoomMessages(ctx context.Context, roomID uint, page, size int) ([]*model.Message, int64, error)
-	MarkAsRead(ctx context.Context, messageIDs []uint) error
+	GetMessagesByCursor(ctx context.Context, target model.MessageTarget, targetID uint, cursor MessageCursor) (*MessagePage, error)
+	CountMessages(ctx context.Context, target model.MessageTarget, targetID uint) (int64, error)
+	GetThread(ctx context.Context, rootID uint, page, size int) ([]*model.Message, int64, error)
+	CountReplies(ctx context.Context, rootIDs []uint) (map[uint]int64, error)
+	GetThreadParticipants(ctx context.Context, rootID uint) ([]uint, error)
+	GetUserFeedInRange(ctx context.Context, userID uint, roomIDs []uint, fromID, toID uint) ([]*model.Message, error)
+	MarkAsRead(ctx context.Context, receiverID uint, messageIDs []uint) error
+	MarkRead(ctx context.Context, userID uint, conversationID string, messageID uint) (*model.ReadCursor, error)
+	GetLatestID(ctx context.Context, conversationID string) (uint, error)
+	CountUnread(ctx context.Context, userID uint, roomIDs []uint) ([]*model.UnreadCount, error)
+	CountUnreadIn(ctx context.Context, userID uint, conversationIDs []string) (map[string]*model.UnreadCount, error)
+	GetUserFeedAfter(ctx context.Context, userID uint, roomIDs []uint, afterID uint, limit int) ([]*model.Message, error)
+	GetConversationMessagesAfter(ctx context.Context, conversationID string, afterSeq uint64, limit int) ([]*model.Message, error)
+	GetBroadcastsAfter(ctx context.Context, afterSeq uint64, since time.Time, limit int) ([]*model.Message, error)
+	AckDelivery(ctx context.Context, userID uint, deviceID, conversationID string, seq uint64) error
+	GetDeliveryCursors(ctx context.Context, userID uint, deviceID string) ([]*model.DeliveryCursor, error)
+	DeleteExpired(ctx context.Context, now time.Time, limit int) ([]*model.Message, error)
+	GetByClientMsgID(ctx context.Context, senderID uint, clientMsgID string) (*model.Message, error)
 }
 
 // MessageRepository 消息仓库实现
@@ -30,20 +77,205 @@ func NewMessageRepository(db *gorm.DB) IMessageRepository {
 	}
 }
 
-// Create 创建消息
+// Create 创建消息，在同一事务中为消息分配会话内的序号
+// 消息带客户端消息ID且发送者已用它发送过消息时，不再创建，将原消息写入message并返回 ErrDuplicateMessage
 func (r *MessageRepository) Create(ctx context.Context, message *model.Message) error {
-	return r.db.WithContext(ctx).Create(message).Error
+	message.Normalize()
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 递增会话计数器，计数器行在事务提交前保持锁定，保证序号单调且不重复
+		counter := model.ConversationSeq{ConversationID: message.ConversationID, Seq: 1}
+		if err := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]any{"seq": gorm.Expr("seq + 1")}),
+		}).Create(&counter).Error; err != nil {
+			return err
+		}
+
+		var current model.ConversationSeq
+		if err := tx.Where("conversation_id = ?", message.ConversationID).Take(&current).Error; err != nil {
+			return err
+		}
+		message.Seq = current.Seq
+
+		// 同一会话的重试在计数器行上排队，前一次发送提交后这里能查到
+		if message.ClientMsgID != nil {
+			if original, err := findByClientMsgID(tx, message.SenderID, *message.ClientMsgID); err == nil {
+				*message = *original
+				return ErrDuplicateMessage
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		if err := applyRoomTTL(tx, message); err != nil {
+			return err
+		}
+		if err := resolveReply(tx, message); err != nil {
+			return err
+		}
+		if err := tx.Omit("Attachments").Create(message).Error; err != nil {
+			return err
+		}
+		if err := linkAttachments(tx, message); err != nil {
+			return err
+		}
+		return touchConversation(tx, message)
+	})
+	if err == nil || errors.Is(err, ErrDuplicateMessage) || message.ClientMsgID == nil {
+		return err
+	}
+
+	// 相同的客户端消息ID用于其他会话时违反唯一索引，同样返回原消息
+	if original, findErr := r.GetByClientMsgID(ctx, message.SenderID, *message.ClientMsgID); findErr == nil {
+		*message = *original
+		return ErrDuplicateMessage
+	}
+	return err
+}
+
+// GetByClientMsgID 根据客户端消息ID获取发送者的消息，包括已撤回的消息
+func (r *MessageRepository) GetByClientMsgID(ctx context.Context, senderID uint, clientMsgID string) (*model.Message, error) {
+	return findByClientMsgID(r.db.WithContext(ctx), senderID, clientMsgID)
+}
+
+// findByClientMsgID 根据客户端消息ID查询消息
+func findByClientMsgID(tx *gorm.DB, senderID uint, clientMsgID string) (*model.Message, error) {
+	var message model.Message
+	if err := tx.Unscoped().Preload("Attachments").
+		Where("sender_id = ? AND client_msg_id = ?", senderID, clientMsgID).
+		Take(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// applyRoomTTL 房间设置了消息有效期时设置消息的过期时间，消息自身的过期时间更早时保留
+func applyRoomTTL(tx *gorm.DB, message *model.Message) error {
+	if message.TargetType != model.MessageTargetRoom {
+		return nil
+	}
+
+	var ttl int
+	if err := tx.Model(&model.Room{}).Where("id = ?", message.RoomID).
+		Select("message_ttl_seconds").Scan(&ttl).Error; err != nil {
+		return err
+	}
+	message.ExpireAfter(time.Duration(ttl) * time.Second)
+	return nil
+}
+
+// resolveReply 校验被回复的消息属于同一会话，确定话题根消息并生成引用预览
+func resolveReply(tx *gorm.DB, message *model.Message) error {
+	message.ThreadRootID = 0
+	if message.ReplyToID == 0 {
+		return nil
+	}
+
+	var parent model.Message
+	err := tx.Take(&parent, message.ReplyToID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidReply
+	}
+	if err != nil {
+		return err
+	}
+	parent.Normalize()
+	if parent.ConversationID != message.ConversationID {
+		return ErrInvalidReply
+	}
+
+	// 回复话题中的消息时归入同一话题，话题只有一层
+	message.ThreadRootID = parent.ThreadRootID
+	if message.ThreadRootID == 0 {
+		message.ThreadRootID = parent.ID
+	}
+	message.ReplyTo = parent.Quote()
+	return nil
+}
+
+// linkAttachments 将上传者尚未发送的附件关联到消息
+func linkAttachments(tx *gorm.DB, message *model.Message) error {
+	if len(message.Attachments) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(message.Attachments))
+	for i, attachment := range message.Attachments {
+		ids[i] = attachment.ID
+	}
+	result := tx.Model(&model.Attachment{}).
+		Where("id IN ? AND uploader_id = ? AND message_id = 0", ids, message.SenderID).
+		Update("message_id", message.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	// 附件已被并发发送的其他消息关联
+	if result.RowsAffected != int64(len(ids)) {
+		return ErrAttachmentUnavailable
+	}
+
+	for i := range message.Attachments {
+		message.Attachments[i].MessageID = message.ID
+	}
+	return nil
 }
 
 // GetByID 根据ID获取消息
 func (r *MessageRepository) GetByID(ctx context.Context, id uint) (*model.Message, error) {
 	var message model.Message
-	if err := r.db.WithContext(ctx).First(&message, id).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("Attachments").First(&message, id).Error; err != nil {
 		return nil, err
 	}
 	return &message, nil
 }
 
+// GetByIDs 批量获取消息，已撤回的消息不会返回
+func (r *MessageRepository) GetByIDs(ctx context.Context, ids []uint) ([]*model.Message, error) {
+	var messages []*model.Message
+	if len(ids) == 0 {
+		return messages, nil
+	}
+	if err := r.db.WithContext(ctx).Preload("Attachments").Where("id IN ?", ids).Order("id ASC").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Edit 修改消息内容，在同一事务中保存编辑前的内容
+func (r *MessageRepository) Edit(ctx context.Context, message *model.Message, content string, editorID uint) error {
+	now := time.Now()
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		edit := &model.MessageEdit{
+			MessageID:  message.ID,
+			EditorID:   editorID,
+			OldContent: message.Content,
+		}
+		if err := tx.Create(edit).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(message).Updates(map[string]any{"content": content, "edited_at": now}).Error; err != nil {
+			return err
+		}
+		message.Content = content
+		message.EditedAt = &now
+		return nil
+	})
+}
+
+// Recall 撤回消息（软删除）
+func (r *MessageRepository) Recall(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&model.Message{}, id).Error
+}
+
+// GetEdits 获取消息的编辑历史，按编辑时间升序
+func (r *MessageRepository) GetEdits(ctx context.Context, messageID uint) ([]*model.MessageEdit, error) {
+	var edits []*model.MessageEdit
+	if err := r.db.WithContext(ctx).Where("message_id = ?", messageID).Order("id ASC").Find(&edits).Error; err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
+
 // GetUserMessages 获取用户消息
 func (r *MessageRepository) GetUserMessages(ctx context.Context, userID uint, page, size int) ([]*model.Message, int64, error) {
 	var messages []*model.Message
@@ -57,7 +289,7 @@ func (r *MessageRepository) GetUserMessages(ctx context.Context, userID uint, pa
 		return nil, 0, err
 	}
 
-	if err := query.Order("created_at DESC").Offset(offset).Limit(size).Find(&messages).Error; err != nil {
+	if err := query.Preload("Attachments").Order("created_at DESC").Offset(offset).Limit(size).Find(&messages).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -77,19 +309,423 @@ func (r *MessageRepository) GetRoomMessages(ctx context.Context, roomID uint, pa
 		return nil, 0, err
 	}
 
-	if err := query.Order("created_at DESC").Offset(offset).Limit(size).Find(&messages).Error; err != nil {
+	if err := query.Preload("Attachments").Order("created_at DESC").Offset(offset).Limit(size).Find(&messages).Error; err != nil {
 		return nil, 0, err
 	}
 
 	return messages, total, nil
 }
 
-// MarkAsRead 标记消息为已读
-func (r *MessageRepository) MarkAsRead(ctx context.Context, messageIDs []uint) error {
+// GetMessagesByCursor 按键集分页获取发给用户或房间的消息，使用(target_type, target_id, id)索引，不受新消息写入的影响
+func (r *MessageRepository) GetMessagesByCursor(ctx context.Context, target model.MessageTarget, targetID uint, cursor MessageCursor) (*MessagePage, error) {
+	page := &MessagePage{}
+	switch {
+	case cursor.AfterID > 0:
+		newer, err := r.targetMessages(ctx, target, targetID, "id > ?", cursor.AfterID, "id ASC", cursor.Limit+1)
+		if err != nil {
+			return nil, err
+		}
+		if page.HasAfter = len(newer) > cursor.Limit; page.HasAfter {
+			newer = newer[:cursor.Limit]
+		}
+		slices.Reverse(newer)
+		page.Messages = newer
+		page.HasBefore, err = r.targetMessageExists(ctx, target, targetID, "id <= ?", cursor.AfterID)
+		if err != nil {
+			return nil, err
+		}
+
+	case cursor.AroundID > 0:
+		// 包含AroundID本身的较早一半和较新的一半
+		afterLimit := cursor.Limit / 2
+		beforeLimit := cursor.Limit - afterLimit
+		older, err := r.targetMessages(ctx, target, targetID, "id <= ?", cursor.AroundID, "id DESC", beforeLimit+1)
+		if err != nil {
+			return nil, err
+		}
+		newer, err := r.targetMessages(ctx, target, targetID, "id > ?", cursor.AroundID, "id ASC", afterLimit+1)
+		if err != nil {
+			return nil, err
+		}
+		if page.HasBefore = len(older) > beforeLimit; page.HasBefore {
+			older = older[:beforeLimit]
+		}
+		if page.HasAfter = len(newer) > afterLimit; page.HasAfter {
+			newer = newer[:afterLimit]
+		}
+		slices.Reverse(newer)
+		page.Messages = append(newer, older...)
+
+	default:
+		where, anchor := "id > ?", uint(0)
+		if cursor.BeforeID > 0 {
+			where, anchor = "id < ?", cursor.BeforeID
+		}
+		older, err := r.targetMessages(ctx, target, targetID, where, anchor, "id DESC", cursor.Limit+1)
+		if err != nil {
+			return nil, err
+		}
+		if page.HasBefore = len(older) > cursor.Limit; page.HasBefore {
+			older = older[:cursor.Limit]
+		}
+		page.Messages = older
+		if cursor.BeforeID > 0 {
+			page.HasAfter, err = r.targetMessageExists(ctx, target, targetID, "id >= ?", cursor.BeforeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return page, nil
+}
+
+// targetMessages 获取发给用户或房间的、满足ID条件的消息
+func (r *MessageRepository) targetMessages(ctx context.Context, target model.MessageTarget, targetID uint, where string, id uint, order string, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.WithContext(ctx).Preload("Attachments").
+		Where("target_type = ? AND target_id = ?", target, targetID).
+		Where(where, id).
+		Order(order).
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// targetMessageExists 检查是否存在发给用户或房间的、满足ID条件的消息
+func (r *MessageRepository) targetMessageExists(ctx context.Context, target model.MessageTarget, targetID uint, where string, id uint) (bool, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("target_type = ? AND target_id = ?", target, targetID).
+		Where(where, id).
+		Limit(1).
+		Pluck("id", &ids).Error
+	return len(ids) > 0, err
+}
+
+// CountMessages 统计发给用户或房间的消息数
+func (r *MessageRepository) CountMessages(ctx context.Context, target model.MessageTarget, targetID uint) (int64, error) {
+	var total int64
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("target_type = ? AND target_id = ?", target, targetID).
+		Count(&total).Error
+	return total, err
+}
+
+// GetThread 获取话题中的回复，按ID升序
+func (r *MessageRepository) GetThread(ctx context.Context, rootID uint, page, size int) ([]*model.Message, int64, error) {
+	var messages []*model.Message
+	var total int64
+
+	offset := (page - 1) * size
+	query := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("thread_root_id = ?", rootID)
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if err := query.Preload("Attachments").Order("id ASC").Offset(offset).Limit(size).Find(&messages).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return messages, total, nil
+}
+
+// CountReplies 统计各话题根消息的回复数，没有回复的消息不在结果中
+func (r *MessageRepository) CountReplies(ctx context.Context, rootIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64)
+	if len(rootIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		ThreadRootID uint
+		Count        int64
+	}
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Select("thread_root_id, COUNT(*) AS count").
+		Where("thread_root_id IN ?", rootIDs).
+		Group("thread_root_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.ThreadRootID] = row.Count
+	}
+	return counts, nil
+}
+
+// GetThreadParticipants 获取话题的参与者：根消息的发送者及所有回复者
+func (r *MessageRepository) GetThreadParticipants(ctx context.Context, rootID uint) ([]uint, error) {
+	var userIDs []uint
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("id = ? OR thread_root_id = ?", rootID, rootID).
+		Distinct().
+		Pluck("sender_id", &userIDs).Error
+	return userIDs, err
+}
+
+// GetUserFeedInRange 获取推送给用户的ID在[fromID, toID)区间内的消息（发给用户的私聊、所在房间的消息及全员广播），
+// toID为0表示不限上界，按ID升序
+func (r *MessageRepository) GetUserFeedInRange(ctx context.Context, userID uint, roomIDs []uint, fromID, toID uint) ([]*model.Message, error) {
+	var messages []*model.Message
+
+	query := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where(r.userFeed(userID, roomIDs)).
+		Where("id >= ?", fromID)
+	if toID > 0 {
+		query = query.Where("id < ?", toID)
+	}
+
+	if err := query.Preload("Attachments").Order("id ASC").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkAsRead 将接收者收到的私聊消息标记为已读
+func (r *MessageRepository) MarkAsRead(ctx context.Context, receiverID uint, messageIDs []uint) error {
 	return r.db.WithContext(ctx).Model(&model.Message{}).
-		Where("id IN ?", messageIDs).
+		Where("id IN ? AND receiver_id = ?", messageIDs, receiverID).
 		Update("is_read", true).Error
 }
 
+// MarkRead 将用户在会话中的已读位置推进到messageID，已读位置只增不减，返回更新后的位置
+func (r *MessageRepository) MarkRead(ctx context.Context, userID uint, conversationID string, messageID uint) (*model.ReadCursor, error) {
+	cursor := model.ReadCursor{
+		UserID:         userID,
+		ConversationID: conversationID,
+		LastReadID:     messageID,
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 首次标记时创建已读位置，已存在时只在新位置更大时更新，条件更新在各数据库中都是原子的
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&cursor).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.ReadCursor{}).
+			Where("user_id = ? AND conversation_id = ? AND last_read_id < ?", userID, conversationID, messageID).
+			Update("last_read_id", messageID).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND conversation_id = ?", userID, conversationID).Take(&cursor).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// GetLatestID 获取会话中最新一条消息的ID，会话没有消息时返回0
+func (r *MessageRepository) GetLatestID(ctx context.Context, conversationID string) (uint, error) {
+	var id uint
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("conversation_id = ?", conversationID).
+		Select("COALESCE(MAX(id), 0)").
+		Scan(&id).Error
+	return id, err
+}
+
+// CountUnread 统计用户在所在房间、私聊会话和全员广播中的未读消息数
+// 所在房间和有已读位置的会话都会返回，其余会话只在有未读消息时返回
+func (r *MessageRepository) CountUnread(ctx context.Context, userID uint, roomIDs []uint) ([]*model.UnreadCount, error) {
+	var cursors []*model.ReadCursor
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&cursors).Error; err != nil {
+		return nil, err
+	}
+
+	// 收到的私聊、所在房间中的消息和全员广播
+	target := r.db.Where("messages.receiver_id = ?", userID).
+		Or("messages.target_type = ?", model.MessageTargetAll)
+	if len(roomIDs) > 0 {
+		target = target.Or("messages.room_id IN ?", roomIDs)
+	}
+	rows, err := r.countUnread(ctx, userID, target)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]*model.UnreadCount, len(rows)+len(roomIDs))
+	for _, row := range rows {
+		counts[row.ConversationID] = row
+	}
+	for _, cursor := range cursors {
+		// 已退出的房间不再返回
+		if kind, ids, err := model.ParseConversationID(cursor.ConversationID); err != nil ||
+			kind == model.MessageTargetRoom && !slices.Contains(roomIDs, ids[0]) {
+			continue
+		}
+		if _, ok := counts[cursor.ConversationID]; !ok {
+			counts[cursor.ConversationID] = &model.UnreadCount{ConversationID: cursor.ConversationID, LastReadID: cursor.LastReadID}
+		}
+	}
+	for _, roomID := range roomIDs {
+		conversationID := model.RoomConversationID(roomID)
+		if _, ok := counts[conversationID]; !ok {
+			counts[conversationID] = &model.UnreadCount{ConversationID: conversationID}
+		}
+	}
+
+	result := make([]*model.UnreadCount, 0, len(counts))
+	for _, count := range counts {
+		result = append(result, count)
+	}
+	slices.SortFunc(result, func(a, b *model.UnreadCount) int {
+		return strings.Compare(a.ConversationID, b.ConversationID)
+	})
+	return result, nil
+}
+
+// CountUnreadIn 统计用户在指定会话中的未读消息数，没有未读消息的会话不在结果中
+func (r *MessageRepository) CountUnreadIn(ctx context.Context, userID uint, conversationIDs []string) (map[string]*model.UnreadCount, error) {
+	counts := make(map[string]*model.UnreadCount, len(conversationIDs))
+	if len(conversationIDs) == 0 {
+		return counts, nil
+	}
+
+	rows, err := r.countUnread(ctx, userID, r.db.Where("messages.conversation_id IN ?", conversationIDs))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.ConversationID] = row
+	}
+	return counts, nil
+}
+
+// countUnread 按会话统计target范围内他人发送的、在用户已读位置之后的消息
+func (r *MessageRepository) countUnread(ctx context.Context, userID uint, target *gorm.DB) ([]*model.UnreadCount, error) {
+	var rows []*model.UnreadCount
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Select("messages.conversation_id, COUNT(*) AS unread, COALESCE(MAX(read_cursors.last_read_id), 0) AS last_read_id, MAX(messages.id) AS last_message_id").
+		Joins("LEFT JOIN read_cursors ON read_cursors.user_id = ? AND read_cursors.conversation_id = messages.conversation_id", userID).
+		Where(target).
+		Where("messages.sender_id <> ? AND messages.conversation_id <> ''", userID).
+		Where("messages.id > COALESCE(read_cursors.last_read_id, 0)").
+		Group("messages.conversation_id").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// GetUserFeedAfter 获取推送给用户的ID大于afterID的消息（发给用户的私聊、所在房间的消息及全员广播），按ID升序
+func (r *MessageRepository) GetUserFeedAfter(ctx context.Context, userID uint, roomIDs []uint, afterID uint, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.WithContext(ctx).Preload("Attachments").
+		Where("id > ?", afterID).
+		Where(r.userFeed(userID, roomIDs)).
+		Order("id ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// userFeed 推送给用户的消息的查询条件：发给用户的私聊、所在房间的消息及全员广播
+func (r *MessageRepository) userFeed(userID uint, roomIDs []uint) *gorm.DB {
+	target := r.db.Where("receiver_id = ?", userID).
+		Or("target_type = ? AND target_id = ?", model.MessageTargetUser, userID).
+		Or("target_type = ?", model.MessageTargetAll)
+	if len(roomIDs) > 0 {
+		target = target.Or("room_id IN ?", roomIDs)
+	}
+	return target
+}
+
+// GetConversationMessagesAfter 获取会话中序号大于afterSeq的消息，按序号升序
+func (r *MessageRepository) GetConversationMessagesAfter(ctx context.Context, conversationID string, afterSeq uint64, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.WithContext(ctx).Preload("Attachments").
+		Where("conversation_id = ? AND seq > ?", conversationID, afterSeq).
+		Order("seq ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// GetBroadcastsAfter 获取序号大于afterSeq且在since之后发出的全员广播，按序号升序
+func (r *MessageRepository) GetBroadcastsAfter(ctx context.Context, afterSeq uint64, since time.Time, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.WithContext(ctx).Preload("Attachments").
+		Where("conversation_id = ? AND seq > ? AND created_at >= ?", model.BroadcastConversationID, afterSeq, since).
+		Order("seq ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// AckDelivery 更新设备在会话中已确认的最大序号，序号只增不减
+func (r *MessageRepository) AckDelivery(ctx context.Context, userID uint, deviceID, conversationID string, seq uint64) error {
+	cursor := model.DeliveryCursor{
+		UserID:         userID,
+		DeviceID:       deviceID,
+		ConversationID: conversationID,
+		Seq:            seq,
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 与 MarkRead 相同：首次确认时创建，之后只在序号更大时更新
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&cursor).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.DeliveryCursor{}).
+			Where("user_id = ? AND device_id = ? AND conversation_id = ? AND seq < ?", userID, deviceID, conversationID, seq).
+			Update("seq", seq).Error
+	})
+}
+
+// GetDeliveryCursors 获取设备在各会话中的确认位置
+func (r *MessageRepository) GetDeliveryCursors(ctx context.Context, userID uint, deviceID string) ([]*model.DeliveryCursor, error) {
+	var cursors []*model.DeliveryCursor
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND device_id = ?", userID, deviceID).
+		Find(&cursors).Error
+	return cursors, err
+}
+
+// DeleteExpired 删除已过期的消息及其附件记录、表情回应和编辑历史，返回删除的消息（带附件）
+// 使用 FOR UPDATE SKIP LOCKED 锁定待删除的行，多个实例同时清理时各自删除不同的消息
+func (r *MessageRepository) DeleteExpired(ctx context.Context, now time.Time, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 已撤回的消息同样需要删除
+		if err := tx.Unscoped().
+			Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("expires_at <= ?", now).
+			Order("expires_at ASC").
+			Limit(limit).
+			Find(&messages).Error; err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(messages))
+		byID := make(map[uint]*model.Message, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+			byID[message.ID] = message
+		}
+
+		var attachments []model.Attachment
+		if err := tx.Where("message_id IN ?", ids).Find(&attachments).Error; err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			message := byID[attachment.MessageID]
+			message.Attachments = append(message.Attachments, attachment)
+		}
+
+		for _, table := range []any{&model.Attachment{}, &model.Reaction{}, &model.MessageEdit{}} {
+			if err := tx.Unscoped().Where("message_id IN ?", ids).Delete(table).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&model.Message{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
 // MessageRepositorySet 消息仓库依赖注入
 var MessageRepositorySet = wire.NewSet(NewMessageRepository)
@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// newTestDB 创建测试用的SQLite数据库
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(
+		&model.User{},
+		&model.Message{},
+		&model.Room{},
+		&model.RoomMember{},
+		&model.ConversationSeq{},
+		&model.DeliveryCursor{},
+		&model.ReadCursor{},
+		&model.Conversation{},
+		&model.MessageEdit{},
+		&model.Attachment{},
+		&model.ScheduledMessage{},
+	); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// reactions表的emoji列使用MySQL的字符集语法，手动建表
+	if err := db.Exec(`CREATE TABLE reactions (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		message_id integer NOT NULL,
+		user_id integer NOT NULL,
+		emoji varchar(32) NOT NULL,
+		created_at datetime,
+		UNIQUE (message_id, user_id, emoji)
+	)`).Error; err != nil {
+		t.Fatalf("create reactions: %v", err)
+	}
+	return db
+}
+
+// createMessage 保存一条文本消息
+func createMessage(t *testing.T, repo IMessageRepository, sender, receiver, room uint, content string) *model.Message {
+	t.Helper()
+	message := &model.Message{
+		SenderID:   sender,
+		ReceiverID: receiver,
+		RoomID:     room,
+		Content:    content,
+		Type:       string(model.MessageTypeText),
+	}
+	if err := repo.Create(context.Background(), message); err != nil {
+		t.Fatalf("Create(%s): %v", content, err)
+	}
+	return message
+}
+
+func TestCreateAssignsSeq(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewMessageRepository(db)
+
+	steps := []struct {
+		sender, receiver, room uint
+		wantConversation       string
+		wantSeq                uint64
+	}{
+		{1, 2, 0, "dm:1:2", 1},
+		{2, 1, 0, "dm:1:2", 2}, // 私聊会话与双方顺序无关
+		{1, 0, 1, "room:1", 1},
+		{1, 3, 0, "dm:1:3", 1},
+		{2, 0, 1, "room:1", 2},
+		{1, 2, 0, "dm:1:2", 3},
+		{0, 0, 0, model.BroadcastConversationID, 1},
+	}
+	var lastRoom *model.Message
+	for i, step := range steps {
+		message := createMessage(t, repo, step.sender, step.receiver, step.room, "m")
+		if message.ConversationID != step.wantConversation || message.Seq != step.wantSeq {
+			t.Errorf("message %d: conversation %q seq %d, want %q seq %d", i, message.ConversationID, message.Seq, step.wantConversation, step.wantSeq)
+		}
+		if message.RoomID == 1 {
+			lastRoom = message
+		}
+	}
+
+	var counter model.ConversationSeq
+	if err := db.Where("conversation_id = ?", "dm:1:2").Take(&counter).Error; err != nil || counter.Seq != 3 {
+		t.Errorf("dm:1:2 counter = %d, %v, want 3", counter.Seq, err)
+	}
+
+	var conversation model.Conversation
+	if err := db.Where("id = ?", "room:1").Take(&conversation).Error; err != nil || conversation.LastMessageID != lastRoom.ID {
+		t.Errorf("room:1 last message = %d, %v, want %d", conversation.LastMessageID, err, lastRoom.ID)
+	}
+}
+
+func TestGetConversationMessagesAfter(t *testing.T) {
+	repo := NewMessageRepository(newTestDB(t))
+	ctx := context.Background()
+	for _, content := range []string{"a", "b", "c", "d"} {
+		createMessage(t, repo, 1, 2, 0, content)
+	}
+	createMessage(t, repo, 1, 0, 1, "room")
+
+	messages, err := repo.GetConversationMessagesAfter(ctx, "dm:1:2", 1, 2)
+	if err != nil {
+		t.Fatalf("GetConversationMessagesAfter: %v", err)
+	}
+	var got []string
+	for _, message := range messages {
+		got = append(got, message.Content)
+	}
+	if !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("GetConversationMessagesAfter() = %v, want [b c]", got)
+	}
+}
+
+func TestAckDelivery(t *testing.T) {
+	repo := NewMessageRepository(newTestDB(t))
+	ctx := context.Background()
+
+	for _, ack := range []struct {
+		deviceID, conversationID string
+		seq                      uint64
+	}{
+		{"phone", "dm:1:2", 5},
+		{"phone", "dm:1:2", 3}, // 确认位置不回退
+		{"phone", "room:1", 2},
+		{"laptop", "dm:1:2", 1},
+		{"phone", "room:1", 4},
+	} {
+		if err := repo.AckDelivery(ctx, 1, ack.deviceID, ack.conversationID, ack.seq); err != nil {
+			t.Fatalf("AckDelivery(%s, %s, %d): %v", ack.deviceID, ack.conversationID, ack.seq, err)
+		}
+	}
+
+	for deviceID, want := range map[string]map[string]uint64{
+		"phone":  {"dm:1:2": 5, "room:1": 4},
+		"laptop": {"dm:1:2": 1},
+		"tablet": {},
+	} {
+		cursors, err := repo.GetDeliveryCursors(ctx, 1, deviceID)
+		if err != nil {
+			t.Fatalf("GetDeliveryCursors(%s): %v", deviceID, err)
+		}
+		got := make(map[string]uint64, len(cursors))
+		for _, cursor := range cursors {
+			got[cursor.ConversationID] = cursor.Seq
+		}
+		if len(got) != len(want) {
+			t.Errorf("cursors of %s = %v, want %v", deviceID, got, want)
+			continue
+		}
+		for conversationID, seq := range want {
+			if got[conversationID] != seq {
+				t.Errorf("cursor of %s in %s = %d, want %d", deviceID, conversationID, got[conversationID], seq)
+			}
+		}
+	}
+}
+
+func TestGetMessagesByCursor(t *testing.T) {
+	repo := NewMessageRepository(newTestDB(t))
+	ctx := context.Background()
+
+	// 房间1的消息r1..r7与房间2的消息交错写入
+	ids := make(map[string]uint)
+	for i := 1; i <= 7; i++ {
+		name := fmt.Sprintf("r%d", i)
+		ids[name] = createMessage(t, repo, 1, 0, 1, name).ID
+		createMessage(t, repo, 1, 0, 2, "other")
+	}
+
+	tests := []struct {
+		name       string
+		cursor     MessageCursor
+		want       []string
+		wantBefore bool
+		wantAfter  bool
+	}{
+		{"latest", MessageCursor{Limit: 3}, []string{"r7", "r6", "r5"}, true, false},
+		{"before", MessageCursor{BeforeID: ids["r5"], Limit: 3}, []string{"r4", "r3", "r2"}, true, true},
+		{"before first page", MessageCursor{BeforeID: ids["r3"], Limit: 3}, []string{"r2", "r1"}, false, true},
+		{"after", MessageCursor{AfterID: ids["r1"], Limit: 3}, []string{"r4", "r3", "r2"}, true, true},
+		{"after last page", MessageCursor{AfterID: ids["r5"], Limit: 3}, []string{"r7", "r6"}, true, false},
+		{"around", MessageCursor{AroundID: ids["r4"], Limit: 4}, []string{"r6", "r5", "r4", "r3"}, true, true},
+		{"around first", MessageCursor{AroundID: ids["r1"], Limit: 4}, []string{"r3", "r2", "r1"}, false, true},
+		{"all", MessageCursor{Limit: 10}, []string{"r7", "r6", "r5", "r4", "r3", "r2", "r1"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.GetMessagesByCursor(ctx, model.MessageTargetRoom, 1, tt.cursor)
+			if err != nil {
+				t.Fatalf("GetMessagesByCursor: %v", err)
+			}
+			var got []string
+			for _, message := range page.Messages {
+				got = append(got, message.Content)
+			}
+			if !slices.Equal(got, tt.want) || page.HasBefore != tt.wantBefore || page.HasAfter != tt.wantAfter {
+				t.Errorf("GetMessagesByCursor() = %v before=%v after=%v, want %v before=%v after=%v",
+					got, page.HasBefore, page.HasAfter, tt.want, tt.wantBefore, tt.wantAfter)
+			}
+		})
+	}
+
+	// 翻页期间写入的新消息不影响更早的页
+	createMessage(t, repo, 1, 0, 1, "r8")
+	page, err := repo.GetMessagesByCursor(ctx, model.MessageTargetRoom, 1, MessageCursor{BeforeID: ids["r5"], Limit: 3})
+	if err != nil {
+		t.Fatalf("GetMessagesByCursor: %v", err)
+	}
+	if len(page.Messages) != 3 || page.Messages[0].Content != "r4" {
+		t.Errorf("page after new message starts with %v, want r4", page.Messages)
+	}
+
+	if total, err := repo.CountMessages(ctx, model.MessageTargetRoom, 1); err != nil || total != 8 {
+		t.Errorf("CountMessages() = %d, %v, want 8", total, err)
+	}
+}
+
+// TestDeleteExpired SQLite不支持 FOR UPDATE SKIP LOCKED（驱动会忽略锁定子句），这里覆盖选取顺序、分批和关联数据的删除
+func TestDeleteExpired(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewMessageRepository(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	create := func(content string, expiresIn time.Duration) *model.Message {
+		message := createMessage(t, repo, 1, 2, 0, content)
+		if expiresIn != 0 {
+			if err := db.Model(message).UpdateColumn("expires_at", now.Add(expiresIn)).Error; err != nil {
+				t.Fatalf("set expires_at: %v", err)
+			}
+		}
+		return message
+	}
+	expired1 := create("expired1", -time.Hour)
+	expired2 := create("expired2", -time.Minute)
+	recalled := create("recalled", -time.Second)
+	create("later", time.Hour)
+	create("forever", 0)
+
+	// 关联的附件、表情回应和编辑历史一起删除，已撤回的消息同样删除
+	if err := db.Create(&model.Attachment{UploaderID: 1, MessageID: expired2.ID, FileName: "a.txt", StorageKey: "k"}).Error; err != nil {
+		t.Fatalf("create attachment: %v", err)
+	}
+	if err := db.Create(&model.Reaction{MessageID: expired2.ID, UserID: 2, Emoji: "👍"}).Error; err != nil {
+		t.Fatalf("create reaction: %v", err)
+	}
+	if err := db.Create(&model.MessageEdit{MessageID: expired1.ID, EditorID: 1, OldContent: "old"}).Error; err != nil {
+		t.Fatalf("create edit: %v", err)
+	}
+	if err := repo.Recall(ctx, recalled.ID); err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+
+	// 按过期时间分批删除
+	var got []string
+	for _, want := range []int{2, 1, 0} {
+		deleted, err := repo.DeleteExpired(ctx, now, 2)
+		if err != nil {
+			t.Fatalf("DeleteExpired: %v", err)
+		}
+		if len(deleted) != want {
+			t.Fatalf("DeleteExpired() deleted %d messages, want %d", len(deleted), want)
+		}
+		for _, message := range deleted {
+			got = append(got, message.Content)
+			if message.ID == expired2.ID && (len(message.Attachments) != 1 || message.Attachments[0].StorageKey != "k") {
+				t.Errorf("expired2 attachments = %+v, want one with key k", message.Attachments)
+			}
+		}
+	}
+	if !slices.Equal(got, []string{"expired1", "expired2", "recalled"}) {
+		t.Errorf("deleted %v, want [expired1 expired2 recalled]", got)
+	}
+
+	var remaining []string
+	if err := db.Unscoped().Model(&model.Message{}).Order("id").Pluck("content", &remaining).Error; err != nil {
+		t.Fatalf("load messages: %v", err)
+	}
+	if !slices.Equal(remaining, []string{"later", "forever"}) {
+		t.Errorf("remaining messages = %v, want [later forever]", remaining)
+	}
+	for _, table := range []any{&model.Attachment{}, &model.Reaction{}, &model.MessageEdit{}} {
+		var count int64
+		if err := db.Unscoped().Model(table).Count(&count).Error; err != nil || count != 0 {
+			t.Errorf("%T rows = %d, %v, want 0", table, count, err)
+		}
+	}
+}
+
+func TestCreateDedupesClientMsgID(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewMessageRepository(db)
+	ctx := context.Background()
+	clientMsgID := func(id string) *string { return &id }
+
+	send := func(sender, receiver, room uint, content, id string) (*model.Message, error) {
+		message := &model.Message{
+			SenderID:    sender,
+			ReceiverID:  receiver,
+			RoomID:      room,
+			Content:     content,
+			Type:        string(model.MessageTypeText),
+			ClientMsgID: clientMsgID(id),
+		}
+		return message, repo.Create(ctx, message)
+	}
+
+	original, err := send(1, 2, 0, "first", "c1")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	tests := []struct {
+		name                   string
+		sender, receiver, room uint
+	}{
+		{"retry", 1, 2, 0},
+		{"other conversation", 1, 0, 1}, // 违反唯一索引时同样返回原消息
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, err := send(tt.sender, tt.receiver, tt.room, "retry", "c1")
+			if !errors.Is(err, ErrDuplicateMessage) {
+				t.Fatalf("err = %v, want %v", err, ErrDuplicateMessage)
+			}
+			if replay.ID != original.ID || replay.Content != "first" || replay.Seq != original.Seq {
+				t.Errorf("replay = id %d %q seq %d, want id %d %q seq %d", replay.ID, replay.Content, replay.Seq, original.ID, "first", original.Seq)
+			}
+		})
+	}
+
+	// 其他发送者可使用相同的客户端消息ID；重复发送不占用会话序号
+	other, err := send(2, 1, 0, "reply", "c1")
+	if err != nil {
+		t.Fatalf("Create(other sender): %v", err)
+	}
+	if other.ID == original.ID || other.Seq != original.Seq+1 {
+		t.Errorf("other sender message = id %d seq %d, want new id and seq %d", other.ID, other.Seq, original.Seq+1)
+	}
+
+	// 原消息已撤回时返回已撤回的原消息，不再创建
+	if err := repo.Recall(ctx, original.ID); err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	if replay, err := send(1, 2, 0, "retry", "c1"); !errors.Is(err, ErrDuplicateMessage) || replay.ID != original.ID || !replay.DeletedAt.Valid {
+		t.Errorf("replay of recalled = id %d deleted %v, %v, want id %d deleted", replay.ID, replay.DeletedAt.Valid, err, original.ID)
+	}
+
+	var count int64
+	if err := db.Unscoped().Model(&model.Message{}).Count(&count).Error; err != nil || count != 2 {
+		t.Errorf("messages = %d, %v, want 2", count, err)
+	}
+}
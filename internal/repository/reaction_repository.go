@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// IReactionRepository 表情回应仓库接口
+type IReactionRepository interface {
+	Add(ctx context.Context, reaction *model.Reaction) (bool, error)
+	Remove(ctx context.Context, messageID, userID uint, emoji string) (bool, error)
+	CountByMessages(ctx context.Context, messageIDs []uint) (map[uint][]model.ReactionCount, error)
+}
+
+// ReactionRepository 表情回应仓库实现
+type ReactionRepository struct {
+	db *gorm.DB
+}
+
+// NewReactionRepository 创建表情回应仓库
+func NewReactionRepository(db *gorm.DB) IReactionRepository {
+	return &ReactionRepository{
+		db: db,
+	}
+}
+
+// Add 添加表情回应，已存在时不做修改，返回是否新增
+func (r *ReactionRepository) Add(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Remove 移除表情回应，返回是否存在
+func (r *ReactionRepository) Remove(ctx context.Context, messageID, userID uint, emoji string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Delete(&model.Reaction{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CountByMessages 按消息统计各表情的回应数，按首次回应的先后排序
+func (r *ReactionRepository) CountByMessages(ctx context.Context, messageIDs []uint) (map[uint][]model.ReactionCount, error) {
+	counts := make(map[uint][]model.ReactionCount)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		MessageID uint
+		Emoji     string
+		Count     int64
+	}
+	err := r.db.WithContext(ctx).Model(&model.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count").
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("MIN(id) ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.MessageID] = append(counts[row.MessageID], model.ReactionCount{Emoji: row.Emoji, Count: row.Count})
+	}
+	return counts, nil
+}
+
+// ReactionRepositorySet 表情回应仓库依赖注入
+var ReactionRepositorySet = wire.NewSet(NewReactionRepository)
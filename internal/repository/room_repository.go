@@ -18,7 +18,10 @@ type IRoomRepository interface {
 	RemoveMember(ctx context.Context, roomID, userID uint) error
 	GetMembers(ctx context.Context, roomID uint) ([]*model.RoomMember, error)
 	IsMember(ctx context.Context, roomID, userID uint) (bool, error)
+	GetMemberRole(ctx context.Context, roomID, userID uint) (int, error)
 	GetRoomUsers(ctx context.Context, roomID uint) ([]*model.User, error)
+	GetUserRoomIDs(ctx context.Context, userID uint) ([]uint, error)
+	UpdateMessageTTL(ctx context.Context, roomID uint, ttlSeconds int) error
 }
 
 // RoomRepository 房间仓库实现
@@ -33,9 +36,14 @@ func NewRoomRepository(db *gorm.DB) IRoomRepository {
 	}
 }
 
-// Create 创建房间
+// Create 创建房间，同时创建房间会话，使房间在没有消息时也出现在会话列表中
 func (r *RoomRepository) Create(ctx context.Context, room *model.Room) error {
-	return r.db.WithContext(ctx).Create(room).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(room).Error; err != nil {
+			return err
+		}
+		return ensureConversation(tx, model.RoomConversationID(room.ID), room.CreatedAt)
+	})
 }
 
 // GetByID 根据ID获取房间
@@ -100,6 +108,15 @@ func (r *RoomRepository) IsMember(ctx context.Context, roomID, userID uint) (boo
 	return count > 0, err
 }
 
+// GetMemberRole 获取用户在房间中的角色，不是成员时返回gorm.ErrRecordNotFound
+func (r *RoomRepository) GetMemberRole(ctx context.Context, roomID, userID uint) (int, error) {
+	var member model.RoomMember
+	err := r.db.WithContext(ctx).
+		Where("room_id = ? AND user_id = ?", roomID, userID).
+		Take(&member).Error
+	return member.Role, err
+}
+
 // GetRoomUsers 获取房间内的所有用户
 func (r *RoomRepository) GetRoomUsers(ctx context.Context, roomID uint) ([]*model.User, error) {
 	var users []*model.User
@@ -112,5 +129,21 @@ func (r *RoomRepository) GetRoomUsers(ctx context.Context, roomID uint) ([]*mode
 	return users, nil
 }
 
+// GetUserRoomIDs 获取用户所在的所有房间ID
+func (r *RoomRepository) GetUserRoomIDs(ctx context.Context, userID uint) ([]uint, error) {
+	var roomIDs []uint
+	err := r.db.WithContext(ctx).
+		Model(&model.RoomMember{}).
+		Where("user_id = ?", userID).
+		Pluck("room_id", &roomIDs).Error
+	return roomIDs, err
+}
+
+// UpdateMessageTTL 更新房间的消息有效期
+func (r *RoomRepository) UpdateMessageTTL(ctx context.Context, roomID uint, ttlSeconds int) error {
+	return r.db.WithContext(ctx).Model(&model.Room{}).Where("id = ?", roomID).
+		Update("message_ttl_seconds", ttlSeconds).Error
+}
+
 // RoomRepositorySet 房间仓库依赖注入
 var RoomRepositorySet = wire.NewSet(NewRoomRepository)
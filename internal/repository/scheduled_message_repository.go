@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// maxScheduledErrorLength 失败原因的最大长度，与error列的长度一致
+const maxScheduledErrorLength = 255
+
+// IScheduledMessageRepository 定时消息仓库接口
+type IScheduledMessageRepository interface {
+	Create(ctx context.Context, scheduled *model.ScheduledMessage) error
+	GetByID(ctx context.Context, id uint) (*model.ScheduledMessage, error)
+	ListPending(ctx context.Context, senderID uint, page, size int) ([]*model.ScheduledMessage, int64, error)
+	Cancel(ctx context.Context, id, senderID uint) (bool, error)
+	Claim(ctx context.Context, instanceID string, now time.Time, lease time.Duration, limit int) ([]*model.ScheduledMessage, error)
+	MarkSent(ctx context.Context, id uint, instanceID string, messageID uint) error
+	MarkFailed(ctx context.Context, id uint, instanceID string, reason string) error
+	FailExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// ScheduledMessageRepository 定时消息仓库实现
+type ScheduledMessageRepository struct {
+	db *gorm.DB
+}
+
+// NewScheduledMessageRepository 创建定时消息仓库
+func NewScheduledMessageRepository(db *gorm.DB) IScheduledMessageRepository {
+	return &ScheduledMessageRepository{
+		db: db,
+	}
+}
+
+// Create 创建定时消息
+func (r *ScheduledMessageRepository) Create(ctx context.Context, scheduled *model.ScheduledMessage) error {
+	scheduled.Status = model.ScheduledPending
+	return r.db.WithContext(ctx).Create(scheduled).Error
+}
+
+// GetByID 根据ID获取定时消息
+func (r *ScheduledMessageRepository) GetByID(ctx context.Context, id uint) (*model.ScheduledMessage, error) {
+	var scheduled model.ScheduledMessage
+	if err := r.db.WithContext(ctx).First(&scheduled, id).Error; err != nil {
+		return nil, err
+	}
+	return &scheduled, nil
+}
+
+// ListPending 获取用户等待发送的定时消息，按发送时间升序
+func (r *ScheduledMessageRepository) ListPending(ctx context.Context, senderID uint, page, size int) ([]*model.ScheduledMessage, int64, error) {
+	var scheduled []*model.ScheduledMessage
+	var total int64
+
+	tx := r.db.WithContext(ctx).Model(&model.ScheduledMessage{}).
+		Where("sender_id = ? AND status = ?", senderID, model.ScheduledPending)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * size
+	err := tx.Order("send_at ASC, id ASC").Offset(offset).Limit(size).Find(&scheduled).Error
+	return scheduled, total, err
+}
+
+// Cancel 取消等待发送的定时消息，已被实例领取的消息不能取消，返回是否取消成功
+func (r *ScheduledMessageRepository) Cancel(ctx context.Context, id, senderID uint) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&model.ScheduledMessage{}).
+		Where("id = ? AND sender_id = ? AND status = ?", id, senderID, model.ScheduledPending).
+		Update("status", model.ScheduledCancelled)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Claim 领取已到发送时间的定时消息并设置租约，
+// 使用 FOR UPDATE SKIP LOCKED 锁定待领取的行，多个实例同时领取时每条消息只会被一个实例领取
+func (r *ScheduledMessageRepository) Claim(ctx context.Context, instanceID string, now time.Time, lease time.Duration, limit int) ([]*model.ScheduledMessage, error) {
+	var claimed []*model.ScheduledMessage
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("status = ? AND send_at <= ?", model.ScheduledPending, now).
+			Order("send_at ASC, id ASC").
+			Limit(limit).
+			Find(&claimed).Error; err != nil {
+			return err
+		}
+		if len(claimed) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(claimed))
+		until := now.Add(lease)
+		for i, scheduled := range claimed {
+			ids[i] = scheduled.ID
+			scheduled.Status = model.ScheduledSending
+			scheduled.LockedBy = instanceID
+			scheduled.LockedUntil = &until
+		}
+		return tx.Model(&model.ScheduledMessage{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":       model.ScheduledSending,
+			"locked_by":    instanceID,
+			"locked_until": until,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// MarkSent 标记定时消息已发送，仅当消息仍由该实例持有时更新
+func (r *ScheduledMessageRepository) MarkSent(ctx context.Context, id uint, instanceID string, messageID uint) error {
+	return r.finish(ctx, id, instanceID, map[string]any{
+		"status":     model.ScheduledSent,
+		"message_id": messageID,
+	})
+}
+
+// MarkFailed 标记定时消息发送失败，仅当消息仍由该实例持有时更新
+func (r *ScheduledMessageRepository) MarkFailed(ctx context.Context, id uint, instanceID string, reason string) error {
+	return r.finish(ctx, id, instanceID, map[string]any{
+		"status": model.ScheduledFailed,
+		"error":  truncateError(reason),
+	})
+}
+
+// finish 结束实例持有的定时消息
+func (r *ScheduledMessageRepository) finish(ctx context.Context, id uint, instanceID string, updates map[string]any) error {
+	updates["locked_until"] = nil
+	return r.db.WithContext(ctx).Model(&model.ScheduledMessage{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, model.ScheduledSending, instanceID).
+		Updates(updates).Error
+}
+
+// FailExpired 将租约已过期仍未发送完成的定时消息标记为失败，返回更新的条数
+// 持有的实例可能已在宕机前发出消息，为避免重复发送不再重试
+func (r *ScheduledMessageRepository) FailExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&model.ScheduledMessage{}).
+		Where("status = ? AND locked_until < ?", model.ScheduledSending, now).
+		Updates(map[string]any{
+			"status":       model.ScheduledFailed,
+			"error":        "lease expired before the message was sent",
+			"locked_until": nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// truncateError 截断失败原因，避免超出列长度
+func truncateError(reason string) string {
+	runes := []rune(reason)
+	if len(runes) > maxScheduledErrorLength {
+		return string(runes[:maxScheduledErrorLength])
+	}
+	return reason
+}
+
+// ScheduledMessageRepositorySet 定时消息仓库依赖注入
+var ScheduledMessageRepositorySet = wire.NewSet(NewScheduledMessageRepository)
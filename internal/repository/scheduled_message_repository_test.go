@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// scheduledFixture 按名称写入的定时消息，发送时间相对now
+type scheduledFixture struct {
+	repo IScheduledMessageRepository
+	now  time.Time
+	ids  map[string]uint
+}
+
+func newScheduledFixture(t *testing.T) *scheduledFixture {
+	t.Helper()
+	f := &scheduledFixture{
+		repo: NewScheduledMessageRepository(newTestDB(t)),
+		now:  time.Now().Truncate(time.Second),
+		ids:  make(map[string]uint),
+	}
+	for _, item := range []struct {
+		name   string
+		sendAt time.Duration
+	}{
+		{"due2", -time.Minute},
+		{"due1", -time.Hour},
+		{"due3", 0},
+		{"future", time.Minute},
+	} {
+		scheduled := &model.ScheduledMessage{
+			SenderID:   1,
+			TargetType: model.MessageTargetUser,
+			TargetID:   2,
+			Content:    item.name,
+			SendAt:     f.now.Add(item.sendAt),
+		}
+		if err := f.repo.Create(context.Background(), scheduled); err != nil {
+			t.Fatalf("Create(%s): %v", item.name, err)
+		}
+		f.ids[item.name] = scheduled.ID
+	}
+	return f
+}
+
+func (f *scheduledFixture) claim(t *testing.T, instanceID string, limit int) []string {
+	t.Helper()
+	claimed, err := f.repo.Claim(context.Background(), instanceID, f.now, time.Minute, limit)
+	if err != nil {
+		t.Fatalf("Claim(%s): %v", instanceID, err)
+	}
+	names := make([]string, len(claimed))
+	for i, scheduled := range claimed {
+		names[i] = scheduled.Content
+		if scheduled.Status != model.ScheduledSending || scheduled.LockedBy != instanceID ||
+			scheduled.LockedUntil == nil || !scheduled.LockedUntil.Equal(f.now.Add(time.Minute)) {
+			t.Errorf("claimed %s = status %s locked by %q until %v", scheduled.Content, scheduled.Status, scheduled.LockedBy, scheduled.LockedUntil)
+		}
+	}
+	return names
+}
+
+func (f *scheduledFixture) get(t *testing.T, name string) *model.ScheduledMessage {
+	t.Helper()
+	scheduled, err := f.repo.GetByID(context.Background(), f.ids[name])
+	if err != nil {
+		t.Fatalf("GetByID(%s): %v", name, err)
+	}
+	return scheduled
+}
+
+func TestClaimScheduled(t *testing.T) {
+	f := newScheduledFixture(t)
+
+	// 按发送时间领取，已领取的消息不会再被其他实例领取
+	if got := f.claim(t, "node-a", 2); !slices.Equal(got, []string{"due1", "due2"}) {
+		t.Errorf("node-a claimed %v, want [due1 due2]", got)
+	}
+	if got := f.claim(t, "node-b", 10); !slices.Equal(got, []string{"due3"}) {
+		t.Errorf("node-b claimed %v, want [due3]", got)
+	}
+	if got := f.claim(t, "node-a", 10); len(got) != 0 {
+		t.Errorf("claimed again %v, want none", got)
+	}
+	if scheduled := f.get(t, "future"); scheduled.Status != model.ScheduledPending {
+		t.Errorf("future status = %s, want pending", scheduled.Status)
+	}
+}
+
+func TestFinishScheduledRequiresLease(t *testing.T) {
+	ctx := context.Background()
+	f := newScheduledFixture(t)
+	f.claim(t, "node-a", 2)
+
+	// 只有持有租约的实例能结束定时消息
+	if err := f.repo.MarkSent(ctx, f.ids["due1"], "node-b", 42); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	if scheduled := f.get(t, "due1"); scheduled.Status != model.ScheduledSending {
+		t.Errorf("status after MarkSent by other instance = %s, want sending", scheduled.Status)
+	}
+
+	if err := f.repo.MarkSent(ctx, f.ids["due1"], "node-a", 42); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	if scheduled := f.get(t, "due1"); scheduled.Status != model.ScheduledSent || scheduled.MessageID != 42 || scheduled.LockedUntil != nil {
+		t.Errorf("due1 = status %s message %d until %v, want sent 42 nil", scheduled.Status, scheduled.MessageID, scheduled.LockedUntil)
+	}
+
+	if err := f.repo.MarkFailed(ctx, f.ids["due2"], "node-a", strings.Repeat("错", 300)); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	if scheduled := f.get(t, "due2"); scheduled.Status != model.ScheduledFailed || len([]rune(scheduled.Error)) != maxScheduledErrorLength {
+		t.Errorf("due2 = status %s error length %d, want failed %d", scheduled.Status, len([]rune(scheduled.Error)), maxScheduledErrorLength)
+	}
+
+	// 已结束的消息不能再次结束
+	if err := f.repo.MarkFailed(ctx, f.ids["due1"], "node-a", "late"); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	if scheduled := f.get(t, "due1"); scheduled.Status != model.ScheduledSent {
+		t.Errorf("due1 status after late MarkFailed = %s, want sent", scheduled.Status)
+	}
+}
+
+func TestFailExpiredScheduled(t *testing.T) {
+	ctx := context.Background()
+	f := newScheduledFixture(t)
+	f.claim(t, "node-a", 2)
+
+	if n, err := f.repo.FailExpired(ctx, f.now.Add(time.Minute)); err != nil || n != 0 {
+		t.Errorf("FailExpired() before lease expiry = %d, %v, want 0", n, err)
+	}
+	if n, err := f.repo.FailExpired(ctx, f.now.Add(2*time.Minute)); err != nil || n != 2 {
+		t.Errorf("FailExpired() after lease expiry = %d, %v, want 2", n, err)
+	}
+	for _, name := range []string{"due1", "due2"} {
+		if scheduled := f.get(t, name); scheduled.Status != model.ScheduledFailed || scheduled.LockedUntil != nil {
+			t.Errorf("%s = status %s until %v, want failed nil", name, scheduled.Status, scheduled.LockedUntil)
+		}
+	}
+
+	// 租约过期的实例不能再标记发送成功
+	if err := f.repo.MarkSent(ctx, f.ids["due1"], "node-a", 42); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	if scheduled := f.get(t, "due1"); scheduled.Status != model.ScheduledFailed {
+		t.Errorf("due1 status after MarkSent = %s, want failed", scheduled.Status)
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	ctx := context.Background()
+	f := newScheduledFixture(t)
+	f.claim(t, "node-a", 1)
+
+	for _, tt := range []struct {
+		name     string
+		senderID uint
+		want     bool
+	}{
+		{"due1", 1, false}, // 已被领取
+		{"due2", 2, false}, // 不是发送者
+		{"due2", 1, true},
+		{"due2", 1, false}, // 已取消
+	} {
+		if cancelled, err := f.repo.Cancel(ctx, f.ids[tt.name], tt.senderID); err != nil || cancelled != tt.want {
+			t.Errorf("Cancel(%s, %d) = %v, %v, want %v", tt.name, tt.senderID, cancelled, err, tt.want)
+		}
+	}
+
+	pending, total, err := f.repo.ListPending(ctx, 1, 1, 10)
+	if err != nil {
+		t.Fatalf("ListPending: %v", err)
+	}
+	var got []string
+	for _, scheduled := range pending {
+		got = append(got, scheduled.Content)
+	}
+	if !slices.Equal(got, []string{"due3", "future"}) || total != 2 {
+		t.Errorf("ListPending() = %v (total %d), want [due3 future] (total 2)", got, total)
+	}
+}
@@ -16,6 +16,8 @@ type IUserRepository interface {
 	GetByUsername(ctx context.Context, username string) (*model.User, error)
 	GetByEmail(ctx context.Context, email string) (*model.User, error)
 	UpdateStatus(ctx context.Context, id uint, status int, instanceID string) error
+	AdvanceBroadcastSeq(ctx context.Context, ids []uint, seq uint64) error
+	SetRole(ctx context.Context, ids []uint, role int) (int64, error)
 	List(ctx context.Context, page, size int) ([]*model.User, int64, error)
 	IsOnline(ctx context.Context, id uint) (bool, string, error)
 	GetOnlineUsers(ctx context.Context) ([]*model.User, error)
@@ -65,6 +67,33 @@ func (r *UserRepository) UpdateStatus(ctx context.Context, id uint, status int,
 		}).Error
 }
 
+// AdvanceBroadcastSeq 将用户已推送的全员广播序号推进到seq，序号只增不减
+func (r *UserRepository) AdvanceBroadcastSeq(ctx context.Context, ids []uint, seq uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(&model.User{}).
+		Where("id IN ? AND broadcast_seq < ?", ids, seq).
+		UpdateColumn("broadcast_seq", seq).Error
+}
+
+// SetRole 设置用户角色，返回匹配的用户数
+func (r *UserRepository) SetRole(ctx context.Context, ids []uint, role int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var count int64
+	if err := r.db.WithContext(ctx).Model(&model.User{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	if err := r.db.WithContext(ctx).Model(&model.User{}).
+		Where("id IN ? AND role <> ?", ids, role).
+		Update("role", role).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 // GetByEmail 根据邮箱获取用户
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*model.User, error) {
 	var user model.User
@@ -12,10 +12,12 @@ import (
 
 // SetupRouter 设置路由
 func SetupRouter(r *gin.Engine, authHandler *api.AuthHandler, userHandler *api.UserHandler,
-	messageHandler *api.MessageHandler, roomHandler *api.RoomHandler, hubHandler *api.HubHandler) {
+	messageHandler *api.MessageHandler, roomHandler *api.RoomHandler, hubHandler *api.HubHandler,
+	attachmentHandler *api.AttachmentHandler, conversationHandler *api.ConversationHandler, adminHandler *api.AdminHandler,
+	instanceID string) {
 	// 全局中间件
 	r.Use(middleware.RequestID())
-	r.Use(middleware.Logger())
+	r.Use(middleware.Logger(instanceID))
 	r.Use(middleware.CORS())
 	r.Use(middleware.RateLimit())
 
@@ -52,6 +54,25 @@ func SetupRouter(r *gin.Engine, authHandler *api.AuthHandler, userHandler *api.U
 			auth.GET("/messages/user/:user_id", messageHandler.GetUserMessages)
 			auth.GET("/messages/room/:room_id", messageHandler.GetRoomMessages)
 			auth.PUT("/messages/read", messageHandler.MarkAsRead)
+			auth.GET("/messages/search", messageHandler.SearchMessages)
+			auth.GET("/messages/scheduled", messageHandler.ListScheduledMessages)
+			auth.DELETE("/messages/scheduled/:id", messageHandler.CancelScheduledMessage)
+			auth.PATCH("/messages/:id", messageHandler.EditMessage)
+			auth.DELETE("/messages/:id", messageHandler.RecallMessage)
+			auth.GET("/messages/:id/edits", messageHandler.GetMessageEdits)
+			auth.GET("/messages/:id/thread", messageHandler.GetThread)
+			auth.POST("/messages/:id/reactions", messageHandler.AddReaction)
+			auth.DELETE("/messages/:id/reactions/:emoji", messageHandler.RemoveReaction)
+
+			// 会话相关
+			auth.GET("/conversations", conversationHandler.ListConversations)
+			auth.GET("/conversations/unread", messageHandler.GetUnreadCounts)
+			auth.PUT("/conversations/:conversation_id/read", messageHandler.MarkConversationRead)
+
+			// 附件相关
+			auth.POST("/attachments", attachmentHandler.UploadAttachment)
+			auth.GET("/attachments/:id", attachmentHandler.DownloadAttachment)
+			auth.GET("/attachments/:id/thumbnail", attachmentHandler.GetThumbnail)
 
 			// 房间相关
 			auth.POST("/rooms", roomHandler.CreateRoom)
@@ -60,15 +81,26 @@ func SetupRouter(r *gin.Engine, authHandler *api.AuthHandler, userHandler *api.U
 			auth.POST("/rooms/:id/members", roomHandler.AddMember)
 			auth.DELETE("/rooms/:id/members/:user_id", roomHandler.RemoveMember)
 			auth.GET("/rooms/:id/members", roomHandler.GetMembers)
+			auth.PUT("/rooms/:id/settings", roomHandler.UpdateSettings)
 
 			// WebSocket连接
 			auth.GET("/ws", hubHandler.WebSocketHandler)
 
 			// HTTP长轮询
 			auth.GET("/poll", hubHandler.LongPollingHandler)
+			auth.DELETE("/poll", hubHandler.ClosePollHandler)
+
+			// Server-Sent Events
+			auth.GET("/events", hubHandler.EventsHandler)
 
 			// 在线用户
 			auth.GET("/online", hubHandler.GetOnlineUsers)
+
+			// 客户端发送队列统计（仅管理员）
+			auth.GET("/hub/stats", hubHandler.GetClientStats)
+
+			// 管理员
+			auth.POST("/admin/broadcast", adminHandler.Broadcast)
 		}
 	}
 }
@@ -0,0 +1,124 @@
+package search
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		terms   []string
+		want    string
+	}{
+		{"ascii", "Hello World", []string{"world"}, "Hello <mark>World</mark>"},
+		{"every occurrence", "go go gopher", []string{"go"}, "<mark>go</mark> <mark>go</mark> <mark>go</mark>pher"},
+		{"several terms", "red green blue", []string{"blue", "red"}, "<mark>red</mark> green <mark>blue</mark>"},
+		{"overlapping terms merge", "abcabc", []string{"abc", "ca"}, "<mark>abcabc</mark>"},
+		{"html escaped", `<b>hi</b> & "hi"`, []string{"hi"}, "&lt;b&gt;<mark>hi</mark>&lt;/b&gt; &amp; &#34;<mark>hi</mark>&#34;"},
+		{"term with markup", "a<b>c", []string{"<b>"}, "a<mark>&lt;b&gt;</mark>c"},
+		{"cjk", "我们今天去北京吃烤鸭", []string{"北京"}, "我们今天去<mark>北京</mark>吃烤鸭"},
+		{"cjk mixed with latin", "用Go写的服务go", []string{"GO"}, "用<mark>Go</mark>写的服务<mark>go</mark>"},
+		{"emoji around term", "👍🏽好的👍", []string{"好的"}, "👍🏽<mark>好的</mark>👍"},
+		{"emoji term", "ok 👍 ok", []string{"👍"}, "ok <mark>👍</mark> ok"},
+		// İ小写后字节数变化，按字符下标标记不能错位
+		{"case folding changes byte length", "İSTANBUL and istanbul", []string{"istanbul"}, "<mark>İSTANBUL</mark> and <mark>istanbul</mark>"},
+		{"accented", "Crème brûlée", []string{"BRÛLÉE"}, "Crème <mark>brûlée</mark>"},
+		{"no match", "nothing here", []string{"absent"}, "nothing here"},
+		{"empty term ignored", "abc", []string{"", "b"}, "a<mark>b</mark>c"},
+		{"empty content", "", []string{"a"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Snippet(tt.content, tt.terms); got != tt.want {
+				t.Errorf("Snippet(%q, %q) = %q, want %q", tt.content, tt.terms, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippetWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		terms   []string
+		want    string
+	}{
+		{
+			// 关键词之前保留snippetContext个字符，片段共snippetRunes个字符
+			name:    "cjk in the middle",
+			content: strings.Repeat("字", 100) + "关键词" + strings.Repeat("文", 100),
+			terms:   []string{"关键词"},
+			want:    "…" + strings.Repeat("字", 20) + "<mark>关键词</mark>" + strings.Repeat("文", 57) + "…",
+		},
+		{
+			name:    "near the end",
+			content: strings.Repeat("a", 197) + "end",
+			terms:   []string{"END"},
+			want:    "…" + strings.Repeat("a", 77) + "<mark>end</mark>",
+		},
+		{
+			name:    "near the start",
+			content: "start" + strings.Repeat("é", 195),
+			terms:   []string{"start"},
+			want:    "<mark>start</mark>" + strings.Repeat("é", 75) + "…",
+		},
+		{
+			name:    "no match keeps the beginning",
+			content: strings.Repeat("🙂", 100),
+			terms:   []string{"x"},
+			want:    strings.Repeat("🙂", 80) + "…",
+		},
+		{
+			// 窗口以首个关键词定位，之后超出窗口的关键词被截断
+			name:    "later match cut at the window edge",
+			content: strings.Repeat("a", 30) + "key" + strings.Repeat("b", 54) + "key" + strings.Repeat("c", 30),
+			terms:   []string{"key"},
+			want:    "…" + strings.Repeat("a", 20) + "<mark>key</mark>" + strings.Repeat("b", 54) + "<mark>key</mark>" + "…",
+		},
+		{
+			name:    "exactly the snippet length",
+			content: strings.Repeat("中", 79) + "x",
+			terms:   []string{"x"},
+			want:    strings.Repeat("中", 79) + "<mark>x</mark>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Snippet(tt.content, tt.terms)
+			if got != tt.want {
+				t.Errorf("Snippet = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Snippet split a multi-byte rune: %q", got)
+			}
+			text := strings.NewReplacer("<mark>", "", "</mark>", "", "…", "").Replace(got)
+			if n := utf8.RuneCountInString(text); n > snippetRunes {
+				t.Errorf("Snippet has %d runes, want at most %d", n, snippetRunes)
+			}
+		})
+	}
+}
+
+func TestParseTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"hello", []string{"hello"}},
+		{"  hello   world ", []string{"hello", "world"}},
+		{"Hello hello HELLO", []string{"Hello"}},
+		{"北京　烤鸭\t北京", []string{"北京", "烤鸭"}}, // 全角空格也是分隔符
+		{"a\nb", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		got := ParseTerms(tt.text)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("ParseTerms(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
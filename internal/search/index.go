@@ -0,0 +1,70 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// Query 消息搜索条件，搜索范围为RoomIDs中的房间和DirectUserID参与的私聊
+type Query struct {
+	Terms        []string  // 关键词，消息须包含全部关键词（不区分大小写）
+	RoomIDs      []uint    // 可搜索的房间
+	DirectUserID uint      // 搜索该用户参与的私聊，为0时不搜索私聊
+	SenderID     uint      // 发送者，为0时不限
+	Since        time.Time // 发送时间下限（含），为零值时不限
+	Until        time.Time // 发送时间上限（不含），为零值时不限
+	Offset       int
+	Limit        int
+}
+
+// Hit 搜索命中的消息
+type Hit struct {
+	MessageID uint   `json:"message_id"`
+	Snippet   string `json:"snippet"` // 高亮片段，已做HTML转义，关键词用<mark>标出
+}
+
+// SearchIndex 消息搜索索引接口，结果按消息ID降序（新消息在前）
+type SearchIndex interface {
+	Index(ctx context.Context, message *model.Message) error
+	Remove(ctx context.Context, messageIDs ...uint) error
+	Search(ctx context.Context, query Query) ([]Hit, int64, error)
+}
+
+// NewSearchIndex 根据配置创建搜索索引
+func NewSearchIndex(cfg *config.Config, db *gorm.DB) (SearchIndex, error) {
+	switch cfg.Search.Driver {
+	case "", "mysql":
+		return NewMySQLIndex(db)
+	case "memory":
+		return NewMemoryIndex(), nil
+	default:
+		return nil, fmt.Errorf("unknown search driver %q", cfg.Search.Driver)
+	}
+}
+
+// ParseTerms 将搜索文本按空白拆分为关键词，去掉重复的关键词
+func ParseTerms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range strings.FieldsFunc(text, unicode.IsSpace) {
+		key := strings.ToLower(term)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// SearchIndexSet 搜索索引依赖注入
+var SearchIndexSet = wire.NewSet(NewSearchIndex)
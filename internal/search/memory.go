@@ -0,0 +1,118 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// MemoryIndex 内存搜索索引，逐条匹配全部消息，用于测试
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	messages map[uint]model.Message
+}
+
+// NewMemoryIndex 创建内存搜索索引
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{messages: make(map[uint]model.Message)}
+}
+
+// Index 添加或更新消息
+func (m *MemoryIndex) Index(ctx context.Context, message *model.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := *message
+	msg.Normalize()
+	m.messages[msg.ID] = msg
+	return nil
+}
+
+// Remove 删除消息
+func (m *MemoryIndex) Remove(ctx context.Context, messageIDs ...uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range messageIDs {
+		delete(m.messages, id)
+	}
+	return nil
+}
+
+// Search 搜索消息
+func (m *MemoryIndex) Search(ctx context.Context, query Query) ([]Hit, int64, error) {
+	if len(query.Terms) == 0 {
+		return nil, 0, nil
+	}
+	needles := make([]string, len(query.Terms))
+	for i, term := range query.Terms {
+		needles[i] = string(lowerRunes(term))
+	}
+
+	m.mu.RLock()
+	var matched []*model.Message
+	for _, msg := range m.messages {
+		if query.matches(&msg) && containsAll(string(lowerRunes(msg.Content)), needles) {
+			matched = append(matched, &msg)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(matched, func(a, b *model.Message) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	total := int64(len(matched))
+	start := min(max(query.Offset, 0), len(matched))
+	end := len(matched)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, end)
+	}
+
+	hits := make([]Hit, 0, end-start)
+	for _, msg := range matched[start:end] {
+		hits = append(hits, Hit{MessageID: msg.ID, Snippet: Snippet(msg.Content, query.Terms)})
+	}
+	return hits, total, nil
+}
+
+// matches 检查消息是否在搜索范围内且满足过滤条件
+func (q *Query) matches(msg *model.Message) bool {
+	switch msg.TargetType {
+	case model.MessageTargetRoom:
+		if !slices.Contains(q.RoomIDs, msg.RoomID) {
+			return false
+		}
+	case model.MessageTargetUser:
+		if q.DirectUserID == 0 || (msg.SenderID != q.DirectUserID && msg.ReceiverID != q.DirectUserID) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if q.SenderID != 0 && msg.SenderID != q.SenderID {
+		return false
+	}
+	if !q.Since.IsZero() && msg.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.CreatedAt.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// containsAll 检查内容是否包含全部关键词
+func containsAll(content string, needles []string) bool {
+	for _, needle := range needles {
+		if !strings.Contains(content, needle) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package search
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+var baseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testMessages 用户1、2在房间1，用户2在房间2，另有1与2、3与4之间的私聊和一条全员广播
+func testMessages() []model.Message {
+	at := func(hours int) time.Time {
+		return baseTime.Add(time.Duration(hours) * time.Hour)
+	}
+	messages := []model.Message{
+		{CreatedAt: at(0), SenderID: 1, RoomID: 1, Content: "Hello World"},
+		{CreatedAt: at(1), SenderID: 2, RoomID: 2, Content: "hello there"},
+		{CreatedAt: at(2), SenderID: 1, ReceiverID: 2, Content: "HELLO 你好世界"},
+		{CreatedAt: at(3), SenderID: 3, ReceiverID: 4, Content: "hello secret"},
+		{CreatedAt: at(4), SenderID: 2, RoomID: 1, Content: "goodbye world"},
+		{CreatedAt: at(5), SenderID: 2, ReceiverID: 1, Content: "世界很大 👋 hello"},
+		{CreatedAt: at(6), SenderID: 1, TargetType: model.MessageTargetAll, Content: "hello everyone"},
+	}
+	for i := range messages {
+		messages[i].ID = uint(i + 1)
+	}
+	return messages
+}
+
+func newTestIndex(t *testing.T) *MemoryIndex {
+	t.Helper()
+	index := NewMemoryIndex()
+	for _, msg := range testMessages() {
+		if err := index.Index(context.Background(), &msg); err != nil {
+			t.Fatalf("Index(%d): %v", msg.ID, err)
+		}
+	}
+	return index
+}
+
+func hitIDs(hits []Hit) []uint {
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.MessageID
+	}
+	return ids
+}
+
+func TestMemoryIndexSearch(t *testing.T) {
+	index := newTestIndex(t)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []uint
+		total int64
+	}{
+		{"no terms", Query{RoomIDs: []uint{1, 2}, DirectUserID: 1}, nil, 0},
+		{"rooms and direct messages of user 1", Query{Terms: []string{"hello"}, RoomIDs: []uint{1}, DirectUserID: 1}, []uint{6, 3, 1}, 3},
+		{"rooms and direct messages of user 2", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2}, []uint{6, 3, 2, 1}, 4},
+		{"rooms only", Query{Terms: []string{"hello"}, RoomIDs: []uint{2}}, []uint{2}, 1},
+		{"direct messages only", Query{Terms: []string{"hello"}, DirectUserID: 4}, []uint{4}, 1},
+		{"no scope", Query{Terms: []string{"hello"}}, nil, 0},
+		{"case insensitive", Query{Terms: []string{"WORLD"}, RoomIDs: []uint{1}}, []uint{5, 1}, 2},
+		{"all terms required", Query{Terms: []string{"hello", "world"}, RoomIDs: []uint{1}, DirectUserID: 1}, []uint{1}, 1},
+		{"cjk", Query{Terms: []string{"世界"}, RoomIDs: []uint{1}, DirectUserID: 1}, []uint{6, 3}, 2},
+		{"cjk partial", Query{Terms: []string{"你好"}, DirectUserID: 2}, []uint{3}, 1},
+		{"emoji", Query{Terms: []string{"👋"}, DirectUserID: 2}, []uint{6}, 1},
+		{"sender", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2, SenderID: 2}, []uint{6, 2}, 2},
+		{"since is inclusive", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2, Since: baseTime.Add(time.Hour)}, []uint{6, 3, 2}, 3},
+		{"until is exclusive", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2, Until: baseTime.Add(2 * time.Hour)}, []uint{2, 1}, 2},
+		{"time range", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2, Since: baseTime.Add(time.Hour), Until: baseTime.Add(3 * time.Hour)}, []uint{3, 2}, 2},
+		{"limit", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2, Limit: 2}, []uint{6, 3}, 4},
+		{"offset", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2, Offset: 2, Limit: 2}, []uint{2, 1}, 4},
+		{"offset past the end", Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2, Offset: 10, Limit: 2}, []uint{}, 4},
+		{"negative offset", Query{Terms: []string{"hello"}, RoomIDs: []uint{1}, Offset: -1}, []uint{1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, total, err := index.Search(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if got := hitIDs(hits); !slices.Equal(got, tt.want) {
+				t.Errorf("hits = %v, want %v", got, tt.want)
+			}
+			if total != tt.total {
+				t.Errorf("total = %d, want %d", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestMemoryIndexSnippet(t *testing.T) {
+	index := newTestIndex(t)
+
+	hits, _, err := index.Search(context.Background(), Query{Terms: []string{"世界", "HELLO"}, DirectUserID: 1})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	want := []Hit{
+		{MessageID: 6, Snippet: "<mark>世界</mark>很大 👋 <mark>hello</mark>"},
+		{MessageID: 3, Snippet: "<mark>HELLO</mark> 你好<mark>世界</mark>"},
+	}
+	if !slices.Equal(hits, want) {
+		t.Errorf("hits = %q, want %q", hits, want)
+	}
+}
+
+func TestMemoryIndexUpdate(t *testing.T) {
+	index := newTestIndex(t)
+	ctx := context.Background()
+	query := Query{Terms: []string{"hello"}, RoomIDs: []uint{1}}
+
+	// 编辑后的内容覆盖原来的内容
+	edited := testMessages()[0]
+	edited.Content = "edited"
+	if err := index.Index(ctx, &edited); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if hits, _, _ := index.Search(ctx, query); len(hits) != 0 {
+		t.Errorf("old content still matches: %v", hitIDs(hits))
+	}
+	hits, _, _ := index.Search(ctx, Query{Terms: []string{"edited"}, RoomIDs: []uint{1}})
+	if got := hitIDs(hits); !slices.Equal(got, []uint{1}) {
+		t.Errorf("new content hits = %v, want [1]", got)
+	}
+
+	// 修改调用方的消息不影响已索引的内容
+	edited.Content = "hello again"
+	if hits, _, _ := index.Search(ctx, query); len(hits) != 0 {
+		t.Errorf("index shares the caller's message: %v", hitIDs(hits))
+	}
+}
+
+func TestMemoryIndexRemove(t *testing.T) {
+	query := Query{Terms: []string{"hello"}, RoomIDs: []uint{1, 2}, DirectUserID: 2}
+
+	tests := []struct {
+		name   string
+		remove []uint
+		want   []uint
+	}{
+		{"nothing", nil, []uint{6, 3, 2, 1}},
+		{"one", []uint{3}, []uint{6, 2, 1}},
+		{"several", []uint{1, 6}, []uint{3, 2}},
+		{"unknown ids", []uint{42, 2, 42}, []uint{6, 3, 1}},
+		{"everything", []uint{1, 2, 3, 4, 5, 6, 7}, []uint{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := newTestIndex(t)
+			if err := index.Remove(context.Background(), tt.remove...); err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+			hits, total, err := index.Search(context.Background(), query)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if got := hitIDs(hits); !slices.Equal(got, tt.want) || total != int64(len(tt.want)) {
+				t.Errorf("hits = %v (total %d), want %v", got, total, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+const (
+	fulltextIndexName = "idx_messages_content_ngram"
+	ngramTokenSize    = 2 // 与MySQL默认的ngram_token_size一致，更短的关键词无法通过全文索引匹配
+)
+
+// likeEscaper 转义LIKE模式中的通配符
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// MySQLIndex 基于MySQL FULLTEXT索引的搜索，使用ngram分词以支持中文；索引由MySQL在写入消息时维护
+type MySQLIndex struct {
+	db *gorm.DB
+}
+
+// NewMySQLIndex 创建MySQL搜索索引，消息表缺少全文索引时创建
+func NewMySQLIndex(db *gorm.DB) (*MySQLIndex, error) {
+	if !db.Migrator().HasIndex(&model.Message{}, fulltextIndexName) {
+		sql := fmt.Sprintf("CREATE FULLTEXT INDEX %s ON %s (content) WITH PARSER ngram", fulltextIndexName, model.Message{}.TableName())
+		if err := db.Exec(sql).Error; err != nil {
+			return nil, fmt.Errorf("failed to create fulltext index: %w", err)
+		}
+	}
+	return &MySQLIndex{db: db}, nil
+}
+
+// Index 全文索引随消息写入更新，无需额外处理
+func (m *MySQLIndex) Index(ctx context.Context, message *model.Message) error {
+	return nil
+}
+
+// Remove 已撤回（软删除）和已删除的消息在查询时排除，无需额外处理
+func (m *MySQLIndex) Remove(ctx context.Context, messageIDs ...uint) error {
+	return nil
+}
+
+// Search 搜索消息，长度不足ngram分词长度的关键词退化为LIKE匹配
+func (m *MySQLIndex) Search(ctx context.Context, query Query) ([]Hit, int64, error) {
+	tx, ok := m.scope(ctx, &query)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	var phrases []string
+	for _, term := range query.Terms {
+		if utf8.RuneCountInString(term) < ngramTokenSize {
+			tx = tx.Where("content LIKE ?", "%"+likeEscaper.Replace(term)+"%")
+			continue
+		}
+		// 关键词作为短语匹配，短语中只有双引号需要去掉
+		if phrase := strings.ReplaceAll(term, `"`, ""); phrase != "" {
+			phrases = append(phrases, `+"`+phrase+`"`)
+		}
+	}
+	if len(phrases) > 0 {
+		tx = tx.Where("MATCH(content) AGAINST(? IN BOOLEAN MODE)", strings.Join(phrases, " "))
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var messages []*model.Message
+	tx = tx.Select("id", "content").Order("id DESC").Offset(query.Offset)
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit)
+	}
+	if err := tx.Find(&messages).Error; err != nil {
+		return nil, 0, err
+	}
+
+	hits := make([]Hit, len(messages))
+	for i, msg := range messages {
+		hits[i] = Hit{MessageID: msg.ID, Snippet: Snippet(msg.Content, query.Terms)}
+	}
+	return hits, total, nil
+}
+
+// scope 构造限定搜索范围和过滤条件的查询，搜索范围为空或没有关键词时返回false
+func (m *MySQLIndex) scope(ctx context.Context, query *Query) (*gorm.DB, bool) {
+	if len(query.Terms) == 0 {
+		return nil, false
+	}
+
+	var conversations *gorm.DB
+	if len(query.RoomIDs) > 0 {
+		conversations = m.db.Where("target_type = ? AND target_id IN ?", model.MessageTargetRoom, query.RoomIDs)
+	}
+	if query.DirectUserID != 0 {
+		direct := m.db.Where("target_type = ? AND (sender_id = ? OR receiver_id = ?)", model.MessageTargetUser, query.DirectUserID, query.DirectUserID)
+		if conversations == nil {
+			conversations = direct
+		} else {
+			conversations = conversations.Or(direct)
+		}
+	}
+	if conversations == nil {
+		return nil, false
+	}
+
+	tx := m.db.WithContext(ctx).Model(&model.Message{}).Where(conversations)
+	if query.SenderID != 0 {
+		tx = tx.Where("sender_id = ?", query.SenderID)
+	}
+	if !query.Since.IsZero() {
+		tx = tx.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		tx = tx.Where("created_at < ?", query.Until)
+	}
+	return tx, true
+}
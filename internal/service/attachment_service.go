@@ -0,0 +1,269 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/google/wire"
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/blob"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
+
+const (
+	defaultMaxAttachmentSize = 20 << 20 // 默认单个附件最大20MB
+	defaultThumbnailSize     = 256      // 默认缩略图最大边长
+	maxFileNameLength        = 255
+)
+
+// defaultAllowedTypes 默认允许上传的MIME类型
+var defaultAllowedTypes = []string{"image/", "video/", "audio/", "application/pdf"}
+
+// IAttachmentService 附件服务接口
+type IAttachmentService interface {
+	Upload(ctx context.Context, uploaderID uint, fileName string, r io.Reader) (*model.Attachment, error)
+	Resolve(ctx context.Context, uploaderID uint, clientMsgID *string, ids []uint) ([]model.Attachment, error)
+	Open(ctx context.Context, userID, id uint, thumbnail bool) (*model.Attachment, io.ReadCloser, error)
+}
+
+// AttachmentService 附件服务实现
+type AttachmentService struct {
+	attachmentRepo repository.IAttachmentRepository
+	messageRepo    repository.IMessageRepository
+	roomRepo       repository.IRoomRepository
+	store          blob.BlobStore
+	maxSize        int64
+	allowedTypes   []string
+	thumbnailSize  int
+}
+
+// NewAttachmentService 创建附件服务
+func NewAttachmentService(
+	cfg *config.Config,
+	attachmentRepo repository.IAttachmentRepository,
+	messageRepo repository.IMessageRepository,
+	roomRepo repository.IRoomRepository,
+	store blob.BlobStore,
+) IAttachmentService {
+	sc := cfg.Storage
+	s := &AttachmentService{
+		attachmentRepo: attachmentRepo,
+		messageRepo:    messageRepo,
+		roomRepo:       roomRepo,
+		store:          store,
+		maxSize:        int64(sc.MaxSizeMB) << 20,
+		allowedTypes:   sc.AllowedTypes,
+		thumbnailSize:  sc.ThumbnailSize,
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxAttachmentSize
+	}
+	if len(s.allowedTypes) == 0 {
+		s.allowedTypes = defaultAllowedTypes
+	}
+	if s.thumbnailSize <= 0 {
+		s.thumbnailSize = defaultThumbnailSize
+	}
+	return s
+}
+
+// Upload 上传附件，MIME类型根据文件内容检测，图片会生成缩略图
+func (s *AttachmentService) Upload(ctx context.Context, uploaderID uint, fileName string, r io.Reader) (*model.Attachment, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+	if len(data) == 0 {
+		return nil, ErrInvalidOperation
+	}
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || !s.allowed(contentType) {
+		return nil, ErrAttachmentTypeNotAllowed
+	}
+
+	attachment := &model.Attachment{
+		UploaderID:  uploaderID,
+		FileName:    cleanFileName(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  fmt.Sprintf("attachments/%s/%s", time.Now().Format("2006/01/02"), uuid.New().String()),
+	}
+	if err := s.store.Put(ctx, attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		return nil, err
+	}
+
+	// 生成缩略图失败不影响上传，客户端使用原图
+	if strings.HasPrefix(contentType, "image/") {
+		if err := s.putThumbnail(ctx, attachment, data); err != nil {
+			log.Printf("Failed to generate thumbnail for %s: %v", attachment.StorageKey, err)
+		}
+	}
+
+	if err := s.attachmentRepo.Create(ctx, attachment); err != nil {
+		s.deleteBlobs(attachment)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// putThumbnail 生成并保存图片缩略图，记录原图尺寸
+func (s *AttachmentService) putThumbnail(ctx context.Context, attachment *model.Attachment, data []byte) error {
+	thumb, width, height, err := makeThumbnail(data, s.thumbnailSize)
+	if err != nil {
+		return err
+	}
+	attachment.Width = width
+	attachment.Height = height
+
+	key := attachment.StorageKey + ".thumb.jpg"
+	if err := s.store.Put(ctx, key, bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg"); err != nil {
+		return err
+	}
+	attachment.ThumbnailKey = key
+	attachment.HasThumbnail = true
+	return nil
+}
+
+// deleteBlobs 删除附件的对象，用于写入数据库失败时的清理
+func (s *AttachmentService) deleteBlobs(attachment *model.Attachment) {
+	for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := s.store.Delete(context.Background(), key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// allowed 检查MIME类型是否允许上传，以/结尾的配置项按前缀匹配
+func (s *AttachmentService) allowed(contentType string) bool {
+	for _, t := range s.allowedTypes {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t) || contentType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanFileName 去掉文件名中的路径并限制长度
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "file"
+	}
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// Resolve 获取发送者尚未随消息发送的附件，用于发送消息时关联
+// 带客户端消息ID重试发送时，已关联到首次发送的消息的附件同样有效
+func (s *AttachmentService) Resolve(ctx context.Context, uploaderID uint, clientMsgID *string, ids []uint) ([]model.Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	attachments, err := s.attachmentRepo.GetSendable(ctx, uploaderID, clientMsgID, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) != len(unique) {
+		return nil, ErrAttachmentUnavailable
+	}
+	return attachments, nil
+}
+
+// Open 打开附件或其缩略图，仅上传者和所属消息的会话参与者可访问
+func (s *AttachmentService) Open(ctx context.Context, userID, id uint, thumbnail bool) (*model.Attachment, io.ReadCloser, error) {
+	attachment, err := s.attachmentRepo.GetByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkAccess(ctx, userID, attachment); err != nil {
+		return nil, nil, err
+	}
+
+	key := attachment.StorageKey
+	if thumbnail {
+		if attachment.ThumbnailKey == "" {
+			return nil, nil, ErrAttachmentNotFound
+		}
+		key = attachment.ThumbnailKey
+	}
+
+	reader, err := s.store.Get(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, reader, nil
+}
+
+// checkAccess 检查用户能否访问附件
+func (s *AttachmentService) checkAccess(ctx context.Context, userID uint, attachment *model.Attachment) error {
+	if attachment.UploaderID == userID {
+		return nil
+	}
+	if attachment.MessageID == 0 {
+		return ErrAttachmentNotFound
+	}
+
+	// 所属消息已撤回时不再允许访问
+	message, err := s.messageRepo.GetByID(ctx, attachment.MessageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAttachmentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	message.Normalize()
+
+	switch message.TargetType {
+	case model.MessageTargetRoom:
+		isMember, err := s.roomRepo.IsMember(ctx, message.RoomID, userID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotRoomMember
+		}
+	case model.MessageTargetUser:
+		if message.SenderID != userID && message.ReceiverID != userID {
+			return ErrAttachmentNotFound
+		}
+	}
+	return nil
+}
+
+// AttachmentServiceSet 附件服务依赖注入
+var AttachmentServiceSet = wire.NewSet(NewAttachmentService)
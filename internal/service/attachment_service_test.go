@@ -0,0 +1,410 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/blob"
+	"github.com/Gopher0727/RTMP/internal/blob/blobtest"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
+
+// newTestDB 创建测试用的SQLite数据库
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(
+		&model.User{},
+		&model.Message{},
+		&model.Room{},
+		&model.RoomMember{},
+		&model.ConversationSeq{},
+		&model.ReadCursor{},
+		&model.Conversation{},
+		&model.Attachment{},
+	); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// testBlobStores 返回本地目录和S3兼容测试服务两种对象存储
+func testBlobStores(t *testing.T) map[string]blob.BlobStore {
+	t.Helper()
+
+	local, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	server := blobtest.NewS3Server()
+	t.Cleanup(server.Close)
+	s3, err := blob.NewS3Store(server.Config())
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	return map[string]blob.BlobStore{"local": local, "s3": s3}
+}
+
+// attachmentFixture 附件服务及其依赖
+type attachmentFixture struct {
+	db          *gorm.DB
+	service     IAttachmentService
+	messageRepo repository.IMessageRepository
+	store       blob.BlobStore
+}
+
+func newAttachmentFixture(t *testing.T, store blob.BlobStore) *attachmentFixture {
+	t.Helper()
+
+	db := newTestDB(t)
+	cfg := &config.Config{Storage: config.StorageConfig{MaxSizeMB: 1, ThumbnailSize: 32}}
+	messageRepo := repository.NewMessageRepository(db)
+	return &attachmentFixture{
+		db:          db,
+		service:     NewAttachmentService(cfg, repository.NewAttachmentRepository(db), messageRepo, repository.NewRoomRepository(db), store),
+		messageRepo: messageRepo,
+		store:       store,
+	}
+}
+
+// upload 以uploaderID上传文件，失败时终止测试
+func (f *attachmentFixture) upload(t *testing.T, uploaderID uint, name string, data []byte) *model.Attachment {
+	t.Helper()
+	attachment, err := f.service.Upload(context.Background(), uploaderID, name, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Upload(%q): %v", name, err)
+	}
+	return attachment
+}
+
+// send 发送带附件的私聊消息
+func (f *attachmentFixture) send(t *testing.T, senderID, receiverID uint, clientMsgID *string, attachments []model.Attachment) *model.Message {
+	t.Helper()
+	message := &model.Message{
+		SenderID:    senderID,
+		ReceiverID:  receiverID,
+		Content:     "with attachments",
+		Type:        string(model.MessageTypeText),
+		Attachments: attachments,
+		ClientMsgID: clientMsgID,
+	}
+	if err := f.messageRepo.Create(context.Background(), message); err != nil {
+		t.Fatalf("Create message: %v", err)
+	}
+	return message
+}
+
+// testPNG 生成指定尺寸的PNG图片
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, r io.ReadCloser) []byte {
+	t.Helper()
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return data
+}
+
+func TestAttachmentUpload(t *testing.T) {
+	pngData := testPNG(t, 100, 50)
+	pdfData := []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n")
+
+	tests := []struct {
+		name          string
+		fileName      string
+		data          []byte
+		wantErr       error
+		wantType      string
+		wantFileName  string
+		wantThumbnail bool
+		wantWidth     int
+		wantHeight    int
+	}{
+		{name: "image", fileName: "photo.png", data: pngData, wantType: "image/png", wantFileName: "photo.png",
+			wantThumbnail: true, wantWidth: 100, wantHeight: 50},
+		{name: "pdf", fileName: `C:\docs\..\report.pdf`, data: pdfData, wantType: "application/pdf", wantFileName: "report.pdf"},
+		{name: "path in name", fileName: "../../etc/photo.png", data: pngData, wantType: "image/png", wantFileName: "photo.png",
+			wantThumbnail: true, wantWidth: 100, wantHeight: 50},
+		{name: "not allowed", fileName: "a.txt", data: []byte("plain text"), wantErr: ErrAttachmentTypeNotAllowed},
+		{name: "empty", fileName: "a.png", data: nil, wantErr: ErrInvalidOperation},
+		{name: "too large", fileName: "a.png", data: append(bytes.Clone(pngData), make([]byte, 1<<20)...), wantErr: ErrAttachmentTooLarge},
+	}
+
+	for storeName, store := range testBlobStores(t) {
+		t.Run(storeName, func(t *testing.T) {
+			f := newAttachmentFixture(t, store)
+			ctx := context.Background()
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					attachment, err := f.service.Upload(ctx, 1, tt.fileName, bytes.NewReader(tt.data))
+					if tt.wantErr != nil {
+						if !errors.Is(err, tt.wantErr) {
+							t.Fatalf("Upload err = %v, want %v", err, tt.wantErr)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatalf("Upload: %v", err)
+					}
+
+					if attachment.ID == 0 || attachment.UploaderID != 1 || attachment.MessageID != 0 {
+						t.Errorf("attachment = %+v, want saved, uploaded by 1 and unlinked", attachment)
+					}
+					if attachment.ContentType != tt.wantType || attachment.FileName != tt.wantFileName || attachment.Size != int64(len(tt.data)) {
+						t.Errorf("attachment type/name/size = %q/%q/%d, want %q/%q/%d",
+							attachment.ContentType, attachment.FileName, attachment.Size, tt.wantType, tt.wantFileName, len(tt.data))
+					}
+					if attachment.HasThumbnail != tt.wantThumbnail || attachment.Width != tt.wantWidth || attachment.Height != tt.wantHeight {
+						t.Errorf("thumbnail = %v %dx%d, want %v %dx%d",
+							attachment.HasThumbnail, attachment.Width, attachment.Height, tt.wantThumbnail, tt.wantWidth, tt.wantHeight)
+					}
+
+					r, err := store.Get(ctx, attachment.StorageKey)
+					if err != nil {
+						t.Fatalf("Get original: %v", err)
+					}
+					if got := readAll(t, r); !bytes.Equal(got, tt.data) {
+						t.Errorf("stored original differs: %d bytes, want %d", len(got), len(tt.data))
+					}
+					if !tt.wantThumbnail {
+						if attachment.ThumbnailKey != "" {
+							t.Errorf("ThumbnailKey = %q, want empty", attachment.ThumbnailKey)
+						}
+						return
+					}
+
+					r, err = store.Get(ctx, attachment.ThumbnailKey)
+					if err != nil {
+						t.Fatalf("Get thumbnail: %v", err)
+					}
+					thumb, err := jpeg.Decode(bytes.NewReader(readAll(t, r)))
+					if err != nil {
+						t.Fatalf("decode thumbnail: %v", err)
+					}
+					// 按比例缩放到最大边长32
+					if size := thumb.Bounds().Size(); size.X != 32 || size.Y != 16 {
+						t.Errorf("thumbnail size = %v, want 32x16", size)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestAttachmentResolve(t *testing.T) {
+	f := newAttachmentFixture(t, testBlobStores(t)["local"])
+	ctx := context.Background()
+	pdf := []byte("%PDF-1.4\n")
+
+	a := f.upload(t, 1, "a.pdf", pdf)
+	b := f.upload(t, 1, "b.pdf", pdf)
+	other := f.upload(t, 2, "other.pdf", pdf)
+	sent := f.upload(t, 1, "sent.pdf", pdf)
+	clientMsgID := "c-1"
+	f.send(t, 1, 2, &clientMsgID, []model.Attachment{*sent})
+
+	otherClientMsgID := "c-2"
+	tests := []struct {
+		name        string
+		uploaderID  uint
+		clientMsgID *string
+		ids         []uint
+		wantIDs     []uint
+		wantErr     error
+	}{
+		{name: "none", uploaderID: 1, ids: nil, wantIDs: nil},
+		{name: "unlinked", uploaderID: 1, ids: []uint{b.ID, a.ID}, wantIDs: []uint{a.ID, b.ID}},
+		{name: "duplicate ids", uploaderID: 1, ids: []uint{a.ID, a.ID}, wantIDs: []uint{a.ID}},
+		{name: "other uploader", uploaderID: 1, ids: []uint{a.ID, other.ID}, wantErr: ErrAttachmentUnavailable},
+		{name: "missing", uploaderID: 1, ids: []uint{a.ID, 9999}, wantErr: ErrAttachmentUnavailable},
+		{name: "already sent", uploaderID: 1, ids: []uint{sent.ID}, wantErr: ErrAttachmentUnavailable},
+		{name: "retry of the same message", uploaderID: 1, clientMsgID: &clientMsgID, ids: []uint{sent.ID, a.ID}, wantIDs: []uint{a.ID, sent.ID}},
+		{name: "other client message", uploaderID: 1, clientMsgID: &otherClientMsgID, ids: []uint{sent.ID}, wantErr: ErrAttachmentUnavailable},
+		{name: "same client id of another sender", uploaderID: 2, clientMsgID: &clientMsgID, ids: []uint{sent.ID}, wantErr: ErrAttachmentUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachments, err := f.service.Resolve(ctx, tt.uploaderID, tt.clientMsgID, tt.ids)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Resolve err = %v, want %v", err, tt.wantErr)
+			}
+			var gotIDs []uint
+			for _, attachment := range attachments {
+				gotIDs = append(gotIDs, attachment.ID)
+			}
+			if len(gotIDs) != len(tt.wantIDs) {
+				t.Fatalf("Resolve ids = %v, want %v", gotIDs, tt.wantIDs)
+			}
+			for i := range gotIDs {
+				if gotIDs[i] != tt.wantIDs[i] {
+					t.Fatalf("Resolve ids = %v, want %v", gotIDs, tt.wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestAttachmentRetrySendReturnsOriginal(t *testing.T) {
+	f := newAttachmentFixture(t, testBlobStores(t)["local"])
+	ctx := context.Background()
+
+	attachment := f.upload(t, 1, "a.pdf", []byte("%PDF-1.4\n"))
+	clientMsgID := "retry-1"
+	first := f.send(t, 1, 2, &clientMsgID, []model.Attachment{*attachment})
+
+	// 重试时附件已关联到首次发送的消息，仍能解析，消息仓库返回原消息
+	attachments, err := f.service.Resolve(ctx, 1, &clientMsgID, []uint{attachment.ID})
+	if err != nil {
+		t.Fatalf("Resolve on retry: %v", err)
+	}
+	retry := &model.Message{SenderID: 1, ReceiverID: 2, Content: "with attachments", Attachments: attachments, ClientMsgID: &clientMsgID}
+	if err := f.messageRepo.Create(ctx, retry); !errors.Is(err, repository.ErrDuplicateMessage) {
+		t.Fatalf("Create retry err = %v, want ErrDuplicateMessage", err)
+	}
+	if retry.ID != first.ID || retry.Seq != first.Seq {
+		t.Fatalf("retry = message %d seq %d, want message %d seq %d", retry.ID, retry.Seq, first.ID, first.Seq)
+	}
+
+	var count int64
+	f.db.Model(&model.Message{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("messages = %d, want 1", count)
+	}
+}
+
+func TestAttachmentOpen(t *testing.T) {
+	for storeName, store := range testBlobStores(t) {
+		t.Run(storeName, func(t *testing.T) {
+			f := newAttachmentFixture(t, store)
+			ctx := context.Background()
+
+			room := model.Room{Name: "room", CreatorID: 1, InstanceID: "test"}
+			if err := f.db.Create(&room).Error; err != nil {
+				t.Fatalf("create room: %v", err)
+			}
+			for _, userID := range []uint{1, 3} {
+				if err := f.db.Create(&model.RoomMember{RoomID: room.ID, UserID: userID}).Error; err != nil {
+					t.Fatalf("add member: %v", err)
+				}
+			}
+
+			pngData := testPNG(t, 64, 64)
+			direct := f.upload(t, 1, "direct.png", pngData)
+			inRoom := f.upload(t, 1, "room.pdf", []byte("%PDF-1.4\n"))
+			unsent := f.upload(t, 1, "unsent.png", pngData)
+			f.send(t, 1, 2, nil, []model.Attachment{*direct})
+			roomMessage := &model.Message{SenderID: 1, RoomID: room.ID, Content: "file", Attachments: []model.Attachment{*inRoom}}
+			if err := f.messageRepo.Create(ctx, roomMessage); err != nil {
+				t.Fatalf("Create room message: %v", err)
+			}
+
+			tests := []struct {
+				name      string
+				userID    uint
+				id        uint
+				thumbnail bool
+				want      []byte // 为nil时只检查能否打开
+				wantErr   error
+			}{
+				{name: "uploader original", userID: 1, id: direct.ID, want: pngData},
+				{name: "uploader thumbnail", userID: 1, id: direct.ID, thumbnail: true},
+				{name: "uploader unsent", userID: 1, id: unsent.ID, want: pngData},
+				{name: "receiver original", userID: 2, id: direct.ID, want: pngData},
+				{name: "receiver thumbnail", userID: 2, id: direct.ID, thumbnail: true},
+				{name: "outsider", userID: 3, id: direct.ID, wantErr: ErrAttachmentNotFound},
+				{name: "unsent by others", userID: 2, id: unsent.ID, wantErr: ErrAttachmentNotFound},
+				{name: "room member", userID: 3, id: inRoom.ID, want: []byte("%PDF-1.4\n")},
+				{name: "room non-member", userID: 2, id: inRoom.ID, wantErr: ErrNotRoomMember},
+				{name: "no thumbnail", userID: 1, id: inRoom.ID, thumbnail: true, wantErr: ErrAttachmentNotFound},
+				{name: "missing", userID: 1, id: 9999, wantErr: ErrAttachmentNotFound},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					attachment, r, err := f.service.Open(ctx, tt.userID, tt.id, tt.thumbnail)
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("Open err = %v, want %v", err, tt.wantErr)
+					}
+					if err != nil {
+						return
+					}
+					data := readAll(t, r)
+					if attachment.ID != tt.id {
+						t.Errorf("attachment id = %d, want %d", attachment.ID, tt.id)
+					}
+					if tt.want != nil && !bytes.Equal(data, tt.want) {
+						t.Errorf("content = %d bytes, want %d", len(data), len(tt.want))
+					}
+					if tt.thumbnail {
+						if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+							t.Errorf("thumbnail is not a jpeg: %v", err)
+						}
+					}
+				})
+			}
+
+			// 对象存储中的文件丢失时返回附件不存在
+			if err := store.Delete(ctx, direct.ThumbnailKey); err != nil {
+				t.Fatalf("Delete thumbnail: %v", err)
+			}
+			if _, _, err := f.service.Open(ctx, 1, direct.ID, true); !errors.Is(err, ErrAttachmentNotFound) {
+				t.Fatalf("Open deleted thumbnail err = %v, want ErrAttachmentNotFound", err)
+			}
+		})
+	}
+}
+
+func TestCleanFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"photo.png", "photo.png"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\file.pdf`, "file.pdf"},
+		{"/", "file"},
+		{"", "file"},
+		{strings.Repeat("文", 100), strings.Repeat("文", 85)}, // 截断到255字节以内且不截断多字节字符
+	}
+	for _, tt := range tests {
+		if got := cleanFileName(tt.name); got != tt.want {
+			t.Errorf("cleanFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
+
+// systemSenderName 系统广播的发送者名称
+const systemSenderName = "system"
+
+// BroadcastParams 系统广播参数
+type BroadcastParams struct {
+	Target  model.MessageTarget // all：全体用户，room：房间全体成员
+	RoomID  uint                // 目标房间，Target为room时使用
+	Type    model.MessageType   // system | notify | warning，为空时为system
+	Content string
+}
+
+// IBroadcastService 系统广播服务接口
+type IBroadcastService interface {
+	Broadcast(ctx context.Context, operatorID uint, params BroadcastParams) (*model.Message, error)
+}
+
+// BroadcastService 系统广播服务实现
+type BroadcastService struct {
+	userRepo   repository.IUserRepository
+	roomRepo   repository.IRoomRepository
+	hubService IHubService
+}
+
+// NewBroadcastService 创建系统广播服务
+func NewBroadcastService(userRepo repository.IUserRepository, roomRepo repository.IRoomRepository, hubService IHubService) IBroadcastService {
+	return &BroadcastService{
+		userRepo:   userRepo,
+		roomRepo:   roomRepo,
+		hubService: hubService,
+	}
+}
+
+// Broadcast 以系统身份发送广播，仅管理员可用；广播只持久化一次，推送给所有实例上的在线连接，离线用户重连时补发
+func (s *BroadcastService) Broadcast(ctx context.Context, operatorID uint, params BroadcastParams) (*model.Message, error) {
+	operator, err := s.userRepo.GetByID(ctx, operatorID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if operator.Role != model.UserRoleAdmin {
+		return nil, ErrPermissionDenied
+	}
+
+	if params.Type == "" {
+		params.Type = model.MessageTypeSystem
+	}
+	switch params.Type {
+	case model.MessageTypeSystem, model.MessageTypeNotify, model.MessageTypeWarning:
+	default:
+		return nil, ErrInvalidOperation
+	}
+	if params.Content == "" {
+		return nil, ErrInvalidOperation
+	}
+
+	message := &model.Message{
+		Content:    params.Content,
+		Type:       string(params.Type),
+		SenderName: systemSenderName,
+	}
+	switch params.Target {
+	case model.MessageTargetAll:
+		message.TargetType = model.MessageTargetAll
+	case model.MessageTargetRoom:
+		if _, err := s.roomRepo.GetByID(ctx, params.RoomID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrRoomNotFound
+			}
+			return nil, err
+		}
+		message.TargetType = model.MessageTargetRoom
+		message.TargetID = params.RoomID
+		message.RoomID = params.RoomID
+	default:
+		return nil, ErrInvalidOperation
+	}
+
+	if err := s.hubService.BroadcastSystemMessage(ctx, message); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Broadcast %s message %d to %s by admin %d", message.Type, message.ID, message.ConversationID, operatorID)
+	return message, nil
+}
+
+// BroadcastServiceSet 系统广播服务依赖注入
+var BroadcastServiceSet = wire.NewSet(NewBroadcastService)
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/Gopher0727/RTMP/config"
+)
+
+// SlowConsumerPolicy 慢消费者处理策略（发送队列已满时的行为）
+type SlowConsumerPolicy string
+
+const (
+	PolicyDropOldest SlowConsumerPolicy = "drop_oldest" // 丢弃队列中最旧的消息
+	PolicyDropNewest SlowConsumerPolicy = "drop_newest" // 丢弃当前要发送的消息
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"  // 断开客户端连接
+)
+
+// defaultSendQueueSize 默认发送队列长度
+const defaultSendQueueSize = 256
+
+// ClientOptions 客户端发送队列选项
+type ClientOptions struct {
+	QueueSize int
+	Policy    SlowConsumerPolicy
+}
+
+// NewClientOptions 根据Hub配置创建客户端选项，未配置的项使用默认值
+func NewClientOptions(cfg config.HubConfig) ClientOptions {
+	opts := ClientOptions{
+		QueueSize: cfg.SendQueueSize,
+		Policy:    SlowConsumerPolicy(cfg.SlowConsumerPolicy),
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultSendQueueSize
+	}
+	switch opts.Policy {
+	case PolicyDropOldest, PolicyDropNewest, PolicyDisconnect:
+	default:
+		opts.Policy = PolicyDropOldest
+	}
+	return opts
+}
+
+// ClientKind 客户端连接类型
+type ClientKind string
+
+const (
+	KindWS   ClientKind = "ws"   // WebSocket
+	KindPoll ClientKind = "poll" // HTTP长轮询
+	KindSSE  ClientKind = "sse"  // Server-Sent Events
+)
+
+// ProtocolSSE SSE客户端的推送编码
+const ProtocolSSE = "sse"
+
+// Client 客户端连接
+type Client struct {
+	UserID      uint
+	DeviceID    string // 设备ID，同一用户的多个连接以此区分
+	Kind        ClientKind
+	IsWS        bool
+	Protocol    string          // 推送编码：握手协商的子协议或ProtocolSSE，为空时使用旧的JSON格式
+	Conn        *websocket.Conn // WebSocket 连接，可为空
+	SendQueue   chan []byte     // 发送队列：WebSocket 由 writePump 独占消费，长轮询和SSE由处理器消费
+	ResumeAfter uint            // 断线续传：补发ID大于此值的消息（SSE的Last-Event-ID），为0时不补发
+	LastActive  time.Time       // 上次活跃时间，用于心跳或超时清理
+	ConnectedAt time.Time       // 连接建立时间
+	Ctx         context.Context
+	Cancel      context.CancelFunc
+
+	policy   SlowConsumerPolicy
+	mu       sync.Mutex      // 保护入队操作及统计信息
+	enqueued uint64          // 成功入队的消息数
+	dropped  uint64          // 因队列已满被丢弃的消息数
+	holding  bool            // 是否暂存实时消息（离线消息回放期间）
+	held     [][]byte        // 回放期间暂存的实时消息
+	subs     map[string]bool // 订阅的房间会话，为空时接收所有所在房间的消息
+}
+
+// ClientStats 客户端发送队列统计
+type ClientStats struct {
+	UserID     uint               `json:"user_id"`
+	DeviceID   string             `json:"device_id"`
+	Kind       ClientKind         `json:"kind"`
+	IsWS       bool               `json:"is_ws"`
+	QueueLen   int                `json:"queue_len"`
+	QueueCap   int                `json:"queue_cap"`
+	Enqueued   uint64             `json:"enqueued"`
+	Dropped    uint64             `json:"dropped"`
+	Policy     SlowConsumerPolicy `json:"policy"`
+	LastActive time.Time          `json:"last_active"`
+}
+
+// newClient 创建客户端，未指定设备ID时为连接生成一个
+func newClient(userID uint, deviceID string, kind ClientKind, conn *websocket.Conn, opts ClientOptions) *Client {
+	if deviceID == "" {
+		deviceID = uuid.New().String()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	return &Client{
+		UserID:      userID,
+		DeviceID:    deviceID,
+		Kind:        kind,
+		IsWS:        kind == KindWS,
+		Conn:        conn,
+		SendQueue:   make(chan []byte, opts.QueueSize),
+		LastActive:  now,
+		ConnectedAt: now,
+		Ctx:         ctx,
+		Cancel:      cancel,
+		policy:      opts.Policy,
+	}
+}
+
+// NewWSClient 创建WebSocket客户端
+func NewWSClient(userID uint, deviceID string, conn *websocket.Conn, opts ClientOptions) *Client {
+	return newClient(userID, deviceID, KindWS, conn, opts)
+}
+
+// NewHTTPClient 创建HTTP长轮询客户端
+func NewHTTPClient(userID uint, deviceID string, opts ClientOptions) *Client {
+	return newClient(userID, deviceID, KindPoll, nil, opts)
+}
+
+// NewSSEClient 创建SSE客户端，推送以SSE事件格式编码
+func NewSSEClient(userID uint, deviceID string, opts ClientOptions) *Client {
+	client := newClient(userID, deviceID, KindSSE, nil, opts)
+	client.Protocol = ProtocolSSE
+	return client
+}
+
+// Enqueue 将消息放入发送队列，队列已满时按慢消费者策略处理，返回消息是否入队
+func (c *Client) Enqueue(msg []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 客户端已关闭
+	if c.Ctx.Err() != nil {
+		return false
+	}
+	c.LastActive = time.Now()
+
+	// 离线消息回放期间先暂存，回放结束后再按顺序入队
+	if c.holding {
+		return c.holdLocked(msg)
+	}
+
+	return c.enqueueLocked(msg)
+}
+
+// holdLocked 暂存回放期间的实时消息，暂存数达到发送队列长度时按慢消费者策略处理，调用方需持有c.mu
+func (c *Client) holdLocked(msg []byte) bool {
+	if len(c.held) < cap(c.SendQueue) {
+		c.held = append(c.held, msg)
+		return true
+	}
+
+	switch c.policy {
+	case PolicyDropNewest:
+		c.dropped++
+		return false
+	case PolicyDisconnect:
+		c.dropped++
+		c.Cancel()
+		return false
+	default:
+		c.held = append(c.held[1:], msg)
+		c.dropped++
+		return true
+	}
+}
+
+// enqueueLocked 入队，调用方需持有c.mu
+func (c *Client) enqueueLocked(msg []byte) bool {
+	select {
+	case c.SendQueue <- msg:
+		c.enqueued++
+		return true
+	default:
+	}
+
+	// 队列已满
+	switch c.policy {
+	case PolicyDropNewest:
+		c.dropped++
+		return false
+	case PolicyDisconnect:
+		c.dropped++
+		c.Cancel()
+		return false
+	default:
+		// 丢弃最旧的一条消息后重新入队
+		select {
+		case <-c.SendQueue:
+			c.dropped++
+		default:
+		}
+		select {
+		case c.SendQueue <- msg:
+			c.enqueued++
+			return true
+		default:
+			c.dropped++
+			return false
+		}
+	}
+}
+
+// Reply 将请求的回复放入发送队列，不受离线消息回放暂存的影响
+func (c *Client) Reply(msg []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Ctx.Err() != nil {
+		return false
+	}
+	return c.enqueueLocked(msg)
+}
+
+// Subscribe 订阅房间会话
+func (c *Client) Subscribe(conversationIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subs == nil {
+		c.subs = make(map[string]bool)
+	}
+	for _, id := range conversationIDs {
+		c.subs[id] = true
+	}
+}
+
+// Unsubscribe 取消订阅房间会话
+func (c *Client) Unsubscribe(conversationIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range conversationIDs {
+		delete(c.subs, id)
+	}
+}
+
+// Subscribed 判断连接是否接收指定房间会话的消息
+func (c *Client) Subscribed(conversationID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.subs) == 0 || c.subs[conversationID]
+}
+
+// Hold 开始暂存实时消息，保证离线消息先于实时消息送达
+func (c *Client) Hold() {
+	c.mu.Lock()
+	c.holding = true
+	c.mu.Unlock()
+}
+
+// Replay 将回放的消息放入发送队列，队列已满时阻塞等待，客户端关闭时返回false
+func (c *Client) Replay(msg []byte) bool {
+	select {
+	case c.SendQueue <- msg:
+		c.mu.Lock()
+		c.enqueued++
+		c.mu.Unlock()
+		return true
+	case <-c.Ctx.Done():
+		return false
+	}
+}
+
+// Release 结束暂存，将回放期间收到的实时消息按顺序入队，skip返回true的消息会被丢弃（已在回放中推送）
+func (c *Client) Release(skip func(msg []byte) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	held := c.held
+	c.holding = false
+	c.held = nil
+	if c.Ctx.Err() != nil {
+		return
+	}
+	for _, msg := range held {
+		if skip != nil && skip(msg) {
+			continue
+		}
+		c.enqueueLocked(msg)
+	}
+}
+
+// Touch 更新最后活跃时间
+func (c *Client) Touch() {
+	c.mu.Lock()
+	c.LastActive = time.Now()
+	c.mu.Unlock()
+}
+
+// Stats 获取客户端发送队列统计
+func (c *Client) Stats() ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ClientStats{
+		UserID:     c.UserID,
+		DeviceID:   c.DeviceID,
+		Kind:       c.Kind,
+		IsWS:       c.IsWS,
+		QueueLen:   len(c.SendQueue),
+		QueueCap:   cap(c.SendQueue),
+		Enqueued:   c.enqueued,
+		Dropped:    c.dropped,
+		Policy:     c.policy,
+		LastActive: c.LastActive,
+	}
+}
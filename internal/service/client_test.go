@@ -0,0 +1,173 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Gopher0727/RTMP/config"
+)
+
+// drainQueue 取出发送队列中的全部消息
+func drainQueue(c *Client) []string {
+	var got []string
+	for {
+		select {
+		case msg := <-c.SendQueue:
+			got = append(got, string(msg))
+		default:
+			return got
+		}
+	}
+}
+
+func TestNewClientOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.HubConfig
+		want ClientOptions
+	}{
+		{"defaults", config.HubConfig{}, ClientOptions{QueueSize: defaultSendQueueSize, Policy: PolicyDropOldest}},
+		{"configured", config.HubConfig{SendQueueSize: 8, SlowConsumerPolicy: "disconnect"}, ClientOptions{QueueSize: 8, Policy: PolicyDisconnect}},
+		{"unknown policy", config.HubConfig{SendQueueSize: -1, SlowConsumerPolicy: "block"}, ClientOptions{QueueSize: defaultSendQueueSize, Policy: PolicyDropOldest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewClientOptions(tt.cfg); got != tt.want {
+				t.Errorf("NewClientOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientEnqueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy     SlowConsumerPolicy
+		wantQueue  []string
+		wantOK     bool
+		wantClosed bool
+	}{
+		{PolicyDropOldest, []string{"2", "3"}, true, false},
+		{PolicyDropNewest, []string{"1", "2"}, false, false},
+		{PolicyDisconnect, []string{"1", "2"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			c := NewHTTPClient(1, "d1", ClientOptions{QueueSize: 2, Policy: tt.policy})
+			for _, msg := range []string{"1", "2"} {
+				if !c.Enqueue([]byte(msg)) {
+					t.Fatalf("Enqueue(%s) = false below capacity", msg)
+				}
+			}
+			if ok := c.Enqueue([]byte("3")); ok != tt.wantOK {
+				t.Errorf("Enqueue on full queue = %v, want %v", ok, tt.wantOK)
+			}
+			if closed := c.Ctx.Err() != nil; closed != tt.wantClosed {
+				t.Errorf("client closed = %v, want %v", closed, tt.wantClosed)
+			}
+
+			stats := c.Stats()
+			if stats.Dropped != 1 {
+				t.Errorf("Dropped = %d, want 1", stats.Dropped)
+			}
+			if got := drainQueue(c); !slices.Equal(got, tt.wantQueue) {
+				t.Errorf("queue = %v, want %v", got, tt.wantQueue)
+			}
+		})
+	}
+}
+
+func TestClientEnqueueClosed(t *testing.T) {
+	c := NewHTTPClient(1, "d1", ClientOptions{QueueSize: 2, Policy: PolicyDropOldest})
+	c.Cancel()
+	if c.Enqueue([]byte("1")) {
+		t.Error("Enqueue on closed client = true, want false")
+	}
+	if c.Reply([]byte("1")) {
+		t.Error("Reply on closed client = true, want false")
+	}
+}
+
+func TestClientHoldRelease(t *testing.T) {
+	c := NewHTTPClient(1, "d1", ClientOptions{QueueSize: 4, Policy: PolicyDropOldest})
+	c.Hold()
+	c.Enqueue([]byte("live1"))
+	c.Enqueue([]byte("live2"))
+	if !c.Reply([]byte("reply")) {
+		t.Fatal("Reply during hold = false")
+	}
+	if !c.Replay([]byte("offline")) {
+		t.Fatal("Replay = false")
+	}
+	c.Release(func(msg []byte) bool { return string(msg) == "live1" })
+
+	want := []string{"reply", "offline", "live2"}
+	if got := drainQueue(c); !slices.Equal(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+
+	// 结束暂存后直接入队
+	c.Enqueue([]byte("live3"))
+	if got := drainQueue(c); !slices.Equal(got, []string{"live3"}) {
+		t.Errorf("queue after release = %v, want [live3]", got)
+	}
+}
+
+func TestClientHoldPolicies(t *testing.T) {
+	tests := []struct {
+		policy     SlowConsumerPolicy
+		wantQueue  []string
+		wantOK     bool
+		wantClosed bool
+	}{
+		{PolicyDropOldest, []string{"2", "3"}, true, false},
+		{PolicyDropNewest, []string{"1", "2"}, false, false},
+		{PolicyDisconnect, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			c := NewHTTPClient(1, "d1", ClientOptions{QueueSize: 2, Policy: tt.policy})
+			c.Hold()
+			c.Enqueue([]byte("1"))
+			c.Enqueue([]byte("2"))
+			if ok := c.Enqueue([]byte("3")); ok != tt.wantOK {
+				t.Errorf("Enqueue over held limit = %v, want %v", ok, tt.wantOK)
+			}
+			if closed := c.Ctx.Err() != nil; closed != tt.wantClosed {
+				t.Errorf("client closed = %v, want %v", closed, tt.wantClosed)
+			}
+			if dropped := c.Stats().Dropped; dropped != 1 {
+				t.Errorf("Dropped = %d, want 1", dropped)
+			}
+
+			// 已关闭的客户端不再释放暂存的消息
+			c.Release(nil)
+			if got := drainQueue(c); !slices.Equal(got, tt.wantQueue) {
+				t.Errorf("queue = %v, want %v", got, tt.wantQueue)
+			}
+		})
+	}
+}
+
+func TestClientReplayClosed(t *testing.T) {
+	c := NewHTTPClient(1, "d1", ClientOptions{QueueSize: 1, Policy: PolicyDropOldest})
+	if !c.Replay([]byte("1")) {
+		t.Fatal("Replay below capacity = false")
+	}
+	c.Cancel()
+	if c.Replay([]byte("2")) {
+		t.Error("Replay on full queue of closed client = true, want false")
+	}
+}
+
+func TestClientSubscriptions(t *testing.T) {
+	c := NewHTTPClient(1, "d1", ClientOptions{QueueSize: 1})
+	if !c.Subscribed("room:1") {
+		t.Error("client without subscriptions should receive all rooms")
+	}
+	c.Subscribe([]string{"room:1", "room:2"})
+	c.Unsubscribe([]string{"room:2"})
+	if !c.Subscribed("room:1") || c.Subscribed("room:2") || c.Subscribed("room:3") {
+		t.Errorf("Subscribed = room:1 %v, room:2 %v, room:3 %v, want true false false",
+			c.Subscribed("room:1"), c.Subscribed("room:2"), c.Subscribed("room:3"))
+	}
+}
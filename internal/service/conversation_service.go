@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
+
+const (
+	defaultConversationLimit = 20  // 默认每页会话数
+	maxConversationLimit     = 100 // 每页最多会话数
+)
+
+// ConversationSummary 会话列表项
+type ConversationSummary struct {
+	*model.Conversation
+	PeerID      uint           `json:"peer_id,omitempty"`      // 私聊对方的用户ID
+	LastMessage *model.Message `json:"last_message,omitempty"` // 最新消息，已撤回时为空
+	Unread      int64          `json:"unread"`                 // 未读消息数
+}
+
+// IConversationService 会话服务接口
+type IConversationService interface {
+	ListConversations(ctx context.Context, userID uint, cursor string, limit int) ([]*ConversationSummary, string, error)
+}
+
+// ConversationService 会话服务实现
+type ConversationService struct {
+	conversationRepo repository.IConversationRepository
+	messageRepo      repository.IMessageRepository
+	roomRepo         repository.IRoomRepository
+}
+
+// NewConversationService 创建会话服务
+func NewConversationService(
+	conversationRepo repository.IConversationRepository,
+	messageRepo repository.IMessageRepository,
+	roomRepo repository.IRoomRepository,
+) IConversationService {
+	return &ConversationService{
+		conversationRepo: conversationRepo,
+		messageRepo:      messageRepo,
+		roomRepo:         roomRepo,
+	}
+}
+
+// ListConversations 按最后活跃时间倒序获取用户的私聊和房间会话，返回下一页的游标，没有更多时为空
+func (s *ConversationService) ListConversations(ctx context.Context, userID uint, cursor string, limit int) ([]*ConversationSummary, string, error) {
+	if limit <= 0 {
+		limit = defaultConversationLimit
+	}
+	limit = min(limit, maxConversationLimit)
+
+	var beforeAt time.Time
+	var beforeID string
+	if cursor != "" {
+		var err error
+		if beforeAt, beforeID, err = decodeConversationCursor(cursor); err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+
+	roomIDs, err := s.roomRepo.GetUserRoomIDs(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	// 多取一条用于判断是否还有下一页
+	conversations, err := s.conversationRepo.ListByUser(ctx, userID, roomIDs, beforeAt, beforeID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(conversations) > limit {
+		conversations = conversations[:limit]
+		last := conversations[limit-1]
+		next = encodeConversationCursor(last.LastActivityAt, last.ID)
+	}
+
+	ids := make([]string, len(conversations))
+	var messageIDs []uint
+	for i, conversation := range conversations {
+		ids[i] = conversation.ID
+		if conversation.LastMessageID != 0 {
+			messageIDs = append(messageIDs, conversation.LastMessageID)
+		}
+	}
+	unread, err := s.messageRepo.CountUnreadIn(ctx, userID, ids)
+	if err != nil {
+		return nil, "", err
+	}
+	messages, err := s.messageRepo.GetByIDs(ctx, messageIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	lastMessages := make(map[uint]*model.Message, len(messages))
+	for _, message := range messages {
+		lastMessages[message.ID] = message
+	}
+
+	summaries := make([]*ConversationSummary, len(conversations))
+	for i, conversation := range conversations {
+		summaries[i] = &ConversationSummary{
+			Conversation: conversation,
+			PeerID:       conversation.PeerID(userID),
+			LastMessage:  lastMessages[conversation.LastMessageID],
+		}
+		if count, ok := unread[conversation.ID]; ok {
+			summaries[i].Unread = count.Unread
+		}
+	}
+	return summaries, next, nil
+}
+
+// encodeConversationCursor 将分页位置编码为不透明的游标
+func encodeConversationCursor(at time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(at.UnixNano(), 10) + "|" + id))
+}
+
+// decodeConversationCursor 解析游标中的分页位置
+func decodeConversationCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	nanos, id, _ := strings.Cut(string(raw), "|")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return time.Unix(0, n), id, nil
+}
+
+// ConversationServiceSet 会话服务依赖注入
+var ConversationServiceSet = wire.NewSet(NewConversationService)
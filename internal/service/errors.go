@@ -1,15 +1,38 @@
 package service
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
 
 // 定义服务层错误
 var (
-	ErrUserNotFound       = errors.New("user not found")
-	ErrUserAlreadyExists  = errors.New("user already exists")
-	ErrEmailAlreadyExists = errors.New("email already exists")
-	ErrInvalidPassword    = errors.New("invalid password")
-	ErrRoomNotFound       = errors.New("room not found")
-	ErrNotRoomMember      = errors.New("not a room member")
-	ErrMessageNotFound    = errors.New("message not found")
-	ErrInvalidOperation   = errors.New("invalid operation")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrUserAlreadyExists   = errors.New("user already exists")
+	ErrEmailAlreadyExists  = errors.New("email already exists")
+	ErrInvalidPassword     = errors.New("invalid password")
+	ErrRoomNotFound        = errors.New("room not found")
+	ErrNotRoomMember       = errors.New("not a room member")
+	ErrMessageNotFound     = errors.New("message not found")
+	ErrInvalidOperation    = errors.New("invalid operation")
+	ErrInvalidConversation = errors.New("invalid conversation")
+	ErrPollSessionNotFound = errors.New("poll session not found")
+	ErrNotMessageSender    = errors.New("not the message sender")
+	ErrEditWindowExpired   = errors.New("edit window expired")
+	ErrInvalidReply        = repository.ErrInvalidReply
+	ErrInvalidReaction     = errors.New("invalid reaction")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidSignal       = errors.New("invalid signal")
+	ErrRateLimited         = errors.New("rate limited")
+	ErrPermissionDenied    = errors.New("permission denied")
+	ErrInvalidSendTime     = errors.New("invalid send time")
+	ErrScheduledNotFound   = errors.New("scheduled message not found")
+	ErrScheduledNotPending = errors.New("scheduled message is not pending")
+	ErrInvalidTTL          = errors.New("invalid ttl")
+
+	ErrAttachmentNotFound       = errors.New("attachment not found")
+	ErrAttachmentTooLarge       = errors.New("attachment too large")
+	ErrAttachmentTypeNotAllowed = errors.New("attachment type not allowed")
+	ErrAttachmentUnavailable    = repository.ErrAttachmentUnavailable
 )
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+// 推送给客户端的事件类型
+const (
+	EventStatusUpdate    = "status_update"    // 用户在线状态变化
+	EventTyping          = "typing"           // 用户正在输入
+	EventSignal          = "signal"           // 自定义的临时信号
+	EventMessageEdited   = "message_edited"   // 消息被编辑
+	EventMessageRecalled = "message_recalled" // 消息被撤回
+	EventMessagesExpired = "messages_expired" // 会话中的一批消息已过期被删除
+	EventThreadReply     = "thread_reply"     // 参与的话题有新回复
+	EventReactionAdded   = "reaction_added"   // 消息新增表情回应
+	EventReactionRemoved = "reaction_removed" // 消息移除表情回应
+	EventReadReceipt     = "read_receipt"     // 会话参与者的已读位置变化
+)
+
+// Event 推送给客户端的事件
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// StatusEvent 用户在线状态变化事件
+type StatusEvent struct {
+	UserID     uint   `json:"user_id"`
+	Status     int    `json:"status"`
+	InstanceID string `json:"instance_id"`
+}
+
+// TypingEvent 正在输入事件
+type TypingEvent struct {
+	ConversationID string `json:"conversation_id"`
+	UserID         uint   `json:"user_id"`
+	Typing         bool   `json:"typing"`
+	ExpiresIn      int    `json:"expires_in,omitempty"` // 有效期（秒），超时未收到刷新时视为停止输入
+}
+
+// SignalEvent 自定义的临时信号事件
+type SignalEvent struct {
+	ConversationID string          `json:"conversation_id"`
+	UserID         uint            `json:"user_id"`
+	Signal         string          `json:"signal"`
+	Active         bool            `json:"active"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	ExpiresIn      int             `json:"expires_in,omitempty"` // 有效期（秒），超时未收到刷新时视为结束
+}
+
+// MessageEvent 消息编辑、撤回事件
+type MessageEvent struct {
+	MessageID      uint       `json:"message_id"`
+	ConversationID string     `json:"conversation_id"`
+	Seq            uint64     `json:"seq"`
+	Content        string     `json:"content,omitempty"` // 编辑后的内容，撤回时为空
+	OperatorID     uint       `json:"operator_id"`       // 操作者ID，房间管理员可操作他人消息
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+}
+
+// ExpiredEvent 消息过期事件，同一会话中一次清理的消息合并为一个事件
+type ExpiredEvent struct {
+	ConversationID string `json:"conversation_id"`
+	MessageIDs     []uint `json:"message_ids"`
+}
+
+// ThreadEvent 话题新回复事件，推送给话题参与者
+type ThreadEvent struct {
+	ThreadRootID   uint   `json:"thread_root_id"`
+	MessageID      uint   `json:"message_id"`
+	ConversationID string `json:"conversation_id"`
+	SenderID       uint   `json:"sender_id"`
+	ReplyCount     int64  `json:"reply_count"`
+}
+
+// ReactionEvent 表情回应事件，附带消息最新的回应统计
+type ReactionEvent struct {
+	MessageID      uint                  `json:"message_id"`
+	ConversationID string                `json:"conversation_id"`
+	UserID         uint                  `json:"user_id"`
+	Emoji          string                `json:"emoji"`
+	Reactions      []model.ReactionCount `json:"reactions"`
+}
+
+// ReadReceiptEvent 已读回执事件
+type ReadReceiptEvent struct {
+	ConversationID string `json:"conversation_id"`
+	UserID         uint   `json:"user_id"`
+	LastReadID     uint   `json:"last_read_id"`
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/blob"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
+)
+
+const (
+	defaultExpirySweepInterval = 5 * time.Second
+	defaultExpiryBatchSize     = 500
+)
+
+// IExpiryService 过期消息清理服务接口
+type IExpiryService interface {
+	Start(ctx context.Context)
+}
+
+// ExpiryService 过期消息清理服务
+// 每个实例定期删除已过期的消息，删除时锁定数据行，多个实例同时清理时不会重复处理；
+// 每批删除的消息一起从搜索索引、附件存储和接收者的离线队列中移除，并按会话向参与者推送一个过期事件
+type ExpiryService struct {
+	messageRepo    repository.IMessageRepository
+	roomRepo       repository.IRoomRepository
+	offlineService IOfflineService
+	hubService     IHubService
+	searchIndex    search.SearchIndex
+	blobStore      blob.BlobStore
+	interval       time.Duration
+	batchSize      int
+}
+
+// NewExpiryService 创建过期消息清理服务
+func NewExpiryService(
+	cfg *config.Config,
+	messageRepo repository.IMessageRepository,
+	roomRepo repository.IRoomRepository,
+	offlineService IOfflineService,
+	hubService IHubService,
+	searchIndex search.SearchIndex,
+	blobStore blob.BlobStore,
+) IExpiryService {
+	batchSize := cfg.Message.ExpiryBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultExpiryBatchSize
+	}
+	return &ExpiryService{
+		messageRepo:    messageRepo,
+		roomRepo:       roomRepo,
+		offlineService: offlineService,
+		hubService:     hubService,
+		searchIndex:    searchIndex,
+		blobStore:      blobStore,
+		interval:       secondsOr(cfg.Message.ExpirySweepIntervalSeconds, defaultExpirySweepInterval),
+		batchSize:      batchSize,
+	}
+}
+
+// Start 启动过期消息清理任务
+func (s *ExpiryService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// sweep 删除已过期的消息，一批删满时继续清理下一批
+func (s *ExpiryService) sweep(ctx context.Context) {
+	for {
+		messages, err := s.messageRepo.DeleteExpired(ctx, time.Now(), s.batchSize)
+		if err != nil {
+			log.Printf("Failed to delete expired messages: %v", err)
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+
+		s.cleanup(ctx, messages)
+		log.Printf("Deleted %d expired messages", len(messages))
+
+		if len(messages) < s.batchSize {
+			return
+		}
+	}
+}
+
+// cleanup 批量清理一批已删除消息的索引、附件和离线队列，并按会话合并推送过期事件
+func (s *ExpiryService) cleanup(ctx context.Context, messages []*model.Message) {
+	ids := make([]uint, 0, len(messages))
+	var keys []string
+	expired := make(map[string][]uint) // 会话ID -> 过期的消息ID
+	queued := make(map[uint][]uint)    // userID -> 离线队列中可能存在的消息ID
+	roomUsers := make(map[uint][]*model.User)
+
+	for _, message := range messages {
+		message.Normalize()
+		ids = append(ids, message.ID)
+		expired[message.ConversationID] = append(expired[message.ConversationID], message.ID)
+
+		for _, attachment := range message.Attachments {
+			for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+				if key != "" {
+					keys = append(keys, key)
+				}
+			}
+		}
+
+		switch message.TargetType {
+		case model.MessageTargetUser:
+			queued[message.ReceiverID] = append(queued[message.ReceiverID], message.ID)
+		case model.MessageTargetRoom:
+			users, ok := roomUsers[message.RoomID]
+			if !ok {
+				var err error
+				if users, err = s.roomRepo.GetRoomUsers(ctx, message.RoomID); err != nil {
+					log.Printf("Failed to load members of room %d: %v", message.RoomID, err)
+				}
+				roomUsers[message.RoomID] = users
+			}
+			for _, user := range users {
+				queued[user.ID] = append(queued[user.ID], message.ID)
+			}
+		}
+	}
+
+	if err := s.searchIndex.Remove(ctx, ids...); err != nil {
+		log.Printf("Failed to remove %d expired messages from search index: %v", len(ids), err)
+	}
+	if len(keys) > 0 {
+		if err := s.blobStore.DeleteMany(ctx, keys); err != nil {
+			log.Printf("Failed to delete %d blobs of expired messages: %v", len(keys), err)
+		}
+	}
+
+	// 离线队列在回放时会按数据库中的最新状态过滤，这里删除是为了不在Redis中保留已过期的内容
+	for userID, ids := range queued {
+		if err := s.offlineService.Remove(ctx, userID, ids); err != nil {
+			log.Printf("Failed to remove expired messages from offline queue of user %d: %v", userID, err)
+		}
+	}
+
+	for conversationID, ids := range expired {
+		if err := s.hubService.PublishExpiredEvent(ctx, conversationID, ids); err != nil {
+			log.Printf("Failed to publish expired event of conversation %s: %v", conversationID, err)
+		}
+	}
+}
+
+// ExpiryServiceSet 过期消息清理服务依赖注入
+var ExpiryServiceSet = wire.NewSet(NewExpiryService)
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/blob"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
+	"github.com/Gopher0727/RTMP/pkg/protocol"
+)
+
+// receiveEvent 从发送队列读取指定类型的事件，忽略其他帧
+func receiveEvent(t *testing.T, c *Client, eventType string) json.RawMessage {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-c.SendQueue:
+			var frame protocol.Frame
+			if err := json.Unmarshal(data, &frame); err != nil {
+				t.Fatalf("decode frame %s: %v", data, err)
+			}
+			if frame.Type != protocol.TypeEvent {
+				continue
+			}
+			var event protocol.Event
+			if err := json.Unmarshal(frame.Data, &event); err != nil {
+				t.Fatalf("decode event %s: %v", frame.Data, err)
+			}
+			if event.Type == eventType {
+				return event.Data
+			}
+		case <-timeout:
+			t.Fatalf("device %s did not receive %s event", c.DeviceID, eventType)
+		}
+	}
+}
+
+func TestExpirySweep(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	if err := f.db.AutoMigrate(&model.MessageEdit{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	createReactionsTable(t, f.db)
+	sender := f.connect(t, 2, "phone")
+
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	index := search.NewMemoryIndex()
+	service := NewExpiryService(
+		&config.Config{Message: config.MessageConfig{ExpiryBatchSize: 1}},
+		repository.NewMessageRepository(f.db),
+		repository.NewRoomRepository(f.db),
+		f.offline,
+		f.hub,
+		index,
+		store,
+	).(*ExpiryService)
+
+	// 用户1离线，私聊消息进入离线队列
+	var expired []uint
+	for _, content := range []string{"secret one", "secret two", "secret kept"} {
+		message := newTextMessage(2, 1, 0, content)
+		if content != "secret kept" {
+			message.ExpireAfter(time.Hour)
+		}
+		if err := f.hub.SendMessage(ctx, message); err != nil {
+			t.Fatalf("SendMessage(%s): %v", content, err)
+		}
+		indexMessage(ctx, index, message)
+		if message.ExpiresAt != nil {
+			expired = append(expired, message.ID)
+		}
+	}
+	if err := store.Put(ctx, "files/secret", strings.NewReader("data"), 4, "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := f.db.Create(&model.Attachment{UploaderID: 2, MessageID: expired[0], FileName: "secret.txt", StorageKey: "files/secret"}).Error; err != nil {
+		t.Fatalf("create attachment: %v", err)
+	}
+	if err := f.db.Model(&model.Message{}).Where("id IN ?", expired).
+		UpdateColumn("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatalf("expire messages: %v", err)
+	}
+
+	// 每批一条，一次清理删除全部过期消息
+	service.sweep(ctx)
+
+	var remaining []string
+	if err := f.db.Unscoped().Model(&model.Message{}).Pluck("content", &remaining).Error; err != nil {
+		t.Fatalf("load messages: %v", err)
+	}
+	if !slices.Equal(remaining, []string{"secret kept"}) {
+		t.Errorf("remaining messages = %v, want [secret kept]", remaining)
+	}
+
+	queued, _, err := f.offline.Drain(ctx, 1)
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if len(queued) != 1 || queued[0].Content != "secret kept" {
+		t.Errorf("offline queue = %d messages, want only secret kept", len(queued))
+	}
+
+	hits, _, err := index.Search(ctx, search.Query{Terms: []string{"secret"}, DirectUserID: 1, Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 1 {
+		t.Errorf("search hits = %d, want 1", len(hits))
+	}
+
+	if _, err := store.Get(ctx, "files/secret"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Get(expired attachment) error = %v, want %v", err, blob.ErrNotFound)
+	}
+
+	// 发送者收到按批推送的过期事件
+	var got []uint
+	for len(got) < len(expired) {
+		var event ExpiredEvent
+		if err := json.Unmarshal(receiveEvent(t, sender, EventMessagesExpired), &event); err != nil {
+			t.Fatalf("decode expired event: %v", err)
+		}
+		if event.ConversationID != "dm:1:2" {
+			t.Errorf("expired event conversation = %q, want dm:1:2", event.ConversationID)
+		}
+		got = append(got, event.MessageIDs...)
+	}
+	if !slices.Equal(got, expired) {
+		t.Errorf("expired events = %v, want %v", got, expired)
+	}
+}
@@ -1,74 +1,99 @@
 package service
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/wire"
-	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/instance"
 	"github.com/Gopher0727/RTMP/internal/model"
 	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
+	"github.com/Gopher0727/RTMP/pkg/protocol"
 )
 
+// resendLimit 重连时每个会话最多补发的未确认消息数
+const resendLimit = 500
+
 // MessageNotifier 消息通知接口，用于解耦Kafka依赖
 type MessageNotifier interface {
 	SendUserMessage(userID uint, message *model.Message) error
+	SendUserMessageToInstance(instanceID string, userID uint, message *model.Message) error
 	SendRoomMessage(roomID uint, message *model.Message) error
+	SendSystemMessage(message *model.Message) error
 	SendStatusUpdate(userID uint, status int) error
+	SendMessageEvent(eventType string, message *model.Message, operatorID uint) error
+	SendExpiredEvent(conversationID string, messageIDs []uint) error
+	SendReactionEvent(eventType string, message *model.Message, userID uint, emoji string) error
+	SendReadReceipt(cursor *model.ReadCursor) error
+	SendSignal(signal *Signal) error
 	GetInstanceID() string
 }
 
-// Client 客户端连接
-type Client struct {
-	UserID     uint
-	IsWS       bool
-	Conn       *websocket.Conn // WebSocket 连接，可为空
-	SendQueue  chan []byte     // HTTP 长轮询客户端用于暂存消息
-	LastActive time.Time       // 上次活跃时间，用于心跳或超时清理
-	Ctx        context.Context
-	Cancel     context.CancelFunc
+// DeviceInfo 设备连接信息
+type DeviceInfo struct {
+	DeviceID    string     `json:"device_id"`
+	Kind        ClientKind `json:"kind"`
+	IsWS        bool       `json:"is_ws"`
+	InstanceID  string     `json:"instance_id"`
+	ConnectedAt time.Time  `json:"connected_at"`
 }
 
-// NewWSClient 创建WebSocket客户端
-func NewWSClient(userID uint, conn *websocket.Conn) *Client {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &Client{
-		UserID:     userID,
-		IsWS:       true,
-		Conn:       conn,
-		LastActive: time.Now(),
-		Ctx:        ctx,
-		Cancel:     cancel,
-	}
+// Presence 用户在线状态
+type Presence struct {
+	UserID     uint         `json:"user_id"`
+	Online     bool         `json:"online"`
+	InstanceID string       `json:"instance_id"` // 用户最近连接的实例ID
+	Instances  []string     `json:"instances"`   // 用户有连接的所有实例ID
+	Devices    []DeviceInfo `json:"devices"`     // 本实例已知的设备连接
 }
 
-// NewHTTPClient 创建HTTP长轮询客户端
-func NewHTTPClient(userID uint) *Client {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &Client{
-		UserID:     userID,
-		IsWS:       false,
-		SendQueue:  make(chan []byte, 100),
-		LastActive: time.Now(),
-		Ctx:        ctx,
-		Cancel:     cancel,
-	}
+// OnlineUser 在线用户及其在本实例的设备连接
+type OnlineUser struct {
+	*model.User
+	Devices []DeviceInfo `json:"devices"`
 }
 
 // IHubService Hub服务接口
 type IHubService interface {
 	Register(ctx context.Context, client *Client) error
-	Unregister(ctx context.Context, userID uint) error
-	IsOnline(ctx context.Context, userID uint) (bool, string, error)
-	GetOnlineUsers(ctx context.Context) ([]*model.User, error)
+	Unregister(ctx context.Context, client *Client) error
+	Heartbeat(ctx context.Context, client *Client)
+	Ack(ctx context.Context, client *Client, conversationID string, seq uint64) error
+	SendSignal(ctx context.Context, client *Client, conversationID, name string, active bool, data json.RawMessage) error
+	Subscribe(ctx context.Context, client *Client, conversationIDs []string) error
+	StartPresence(ctx context.Context)
+	IsOnline(ctx context.Context, userID uint) (*Presence, error)
+	GetOnlineUsers(ctx context.Context) ([]*OnlineUser, error)
 	SendMessage(ctx context.Context, message *model.Message) error
 	BroadcastToRoom(ctx context.Context, roomID uint, message *model.Message) error
+	BroadcastSystemMessage(ctx context.Context, message *model.Message) error
+	DeliverToUser(ctx context.Context, userID uint, message *model.Message) error
+	DeliverToRoom(ctx context.Context, roomID uint, message *model.Message) error
+	DeliverToAll(ctx context.Context, message *model.Message) error
+	PublishMessageEvent(ctx context.Context, eventType string, message *model.Message, operatorID uint) error
+	DeliverMessageEvent(ctx context.Context, eventType string, message *model.Message, operatorID uint) error
+	PublishExpiredEvent(ctx context.Context, conversationID string, messageIDs []uint) error
+	DeliverExpiredEvent(ctx context.Context, conversationID string, messageIDs []uint) error
+	PublishReactionEvent(ctx context.Context, eventType string, message *model.Message, userID uint, emoji string) error
+	DeliverReactionEvent(ctx context.Context, eventType string, message *model.Message, userID uint, emoji string) error
+	PublishReadReceipt(ctx context.Context, cursor *model.ReadCursor) error
+	DeliverReadReceipt(ctx context.Context, cursor *model.ReadCursor) error
+	PublishSignal(ctx context.Context, signal *Signal) error
+	DeliverSignal(ctx context.Context, signal *Signal) error
+	NotifyStatus(ctx context.Context, userID uint, status int, instanceID string) error
 	SetMessageNotifier(notifier MessageNotifier)
+	GetClientStats(ctx context.Context) []ClientStats
 }
 
 // HubService Hub服务实现
@@ -78,165 +103,635 @@ type HubService struct {
 	roomRepo        repository.IRoomRepository
 	db              *gorm.DB
 	instanceID      string
-	messageNotifier MessageNotifier
+	offlineService  IOfflineService
+	presenceService IPresenceService
+	searchIndex     search.SearchIndex
+	messageNotifier atomic.Pointer[MessageNotifier] // Kafka生产者在Hub创建之后才设置，读写需原子操作
+	signals         *signalTracker
 
 	// 本地内存中的客户端连接
 	mu      sync.RWMutex
-	clients map[uint]*Client // userID -> Client
+	clients map[uint]map[string]*Client // userID -> deviceID -> Client
 }
 
 // NewHubService 创建Hub服务
 func NewHubService(
+	cfg *config.Config,
 	userRepo repository.IUserRepository,
 	messageRepo repository.IMessageRepository,
 	roomRepo repository.IRoomRepository,
 	db *gorm.DB,
+	identity *instance.Identity,
+	offlineService IOfflineService,
+	presenceService IPresenceService,
+	searchIndex search.SearchIndex,
 ) IHubService {
-	return &HubService{
-		userRepo:    userRepo,
-		messageRepo: messageRepo,
-		roomRepo:    roomRepo,
-		db:          db,
-		instanceID:  "unknown", // 初始为unknown，后续通过SetMessageNotifier更新
-		clients:     make(map[uint]*Client),
+	h := &HubService{
+		userRepo:        userRepo,
+		messageRepo:     messageRepo,
+		roomRepo:        roomRepo,
+		db:              db,
+		instanceID:      identity.ID(),
+		offlineService:  offlineService,
+		presenceService: presenceService,
+		searchIndex:     searchIndex,
+		clients:         make(map[uint]map[string]*Client),
 	}
+	h.signals = newSignalTracker(cfg.Hub, func(signal *Signal) {
+		if err := h.PublishSignal(context.Background(), signal); err != nil {
+			log.Printf("Failed to publish expired %s signal of user %d: %v", signal.Name, signal.UserID, err)
+		}
+	})
+	return h
 }
 
 // SetMessageNotifier 设置消息通知器
 func (h *HubService) SetMessageNotifier(notifier MessageNotifier) {
-	h.mu.Lock()
-	h.messageNotifier = notifier
-	if notifier != nil {
-		h.instanceID = notifier.GetInstanceID()
+	h.messageNotifier.Store(&notifier)
+}
+
+// notifier 获取消息通知器，未设置时返回nil
+func (h *HubService) notifier() MessageNotifier {
+	if notifier := h.messageNotifier.Load(); notifier != nil {
+		return *notifier
 	}
-	h.mu.Unlock()
+	return nil
 }
 
-// Register 注册客户端
+// Register 注册客户端，同一用户可以有多个设备同时在线
 func (h *HubService) Register(ctx context.Context, client *Client) error {
 	// 更新用户状态为在线
 	if err := h.userRepo.UpdateStatus(ctx, client.UserID, model.UserStatusOnline, h.instanceID); err != nil {
 		return err
 	}
 
+	// 回放离线消息期间暂存实时消息，保证消息顺序
+	client.Hold()
+
 	// 保存客户端连接到本地内存
 	h.mu.Lock()
-	h.clients[client.UserID] = client
+	devices, ok := h.clients[client.UserID]
+	if !ok {
+		devices = make(map[string]*Client)
+		h.clients[client.UserID] = devices
+	}
+	first := len(devices) == 0
+	old, replaced := devices[client.DeviceID]
+	devices[client.DeviceID] = client
 	h.mu.Unlock()
 
-	// 发送用户上线状态到消息通知器
-	if h.messageNotifier != nil {
+	// 同一设备重复连接，关闭旧连接
+	if replaced && old != client {
+		old.Cancel()
+	}
+
+	// 登记到在线状态注册表，用户在其他实例上已有设备在线时不再推送上线状态
+	if elsewhere, err := h.presenceService.Connect(ctx, client.UserID, client.DeviceID); err != nil {
+		log.Printf("Failed to register presence of user %d: %v", client.UserID, err)
+	} else {
+		first = !elsewhere
+	}
+
+	// 用户的第一个设备上线时推送上线状态给本实例客户端，并发送到消息通知器
+	if first {
+		if err := h.NotifyStatus(ctx, client.UserID, model.UserStatusOnline, h.instanceID); err != nil {
+			log.Printf("Failed to notify online status: %v", err)
+		}
+	}
+	if notifier := h.notifier(); notifier != nil && first {
 		go func() {
-			if err := h.messageNotifier.SendStatusUpdate(client.UserID, model.UserStatusOnline); err != nil {
+			if err := notifier.SendStatusUpdate(client.UserID, model.UserStatusOnline); err != nil {
 				log.Printf("Failed to send online status: %v", err)
 			}
 		}()
 	}
 
-	log.Printf("Client registered: UserID=%d, IsWS=%v, InstanceID=%s", client.UserID, client.IsWS, h.instanceID)
+	log.Printf("Client registered: UserID=%d, DeviceID=%s, Kind=%s, InstanceID=%s", client.UserID, client.DeviceID, client.Kind, h.instanceID)
+
+	// 连接加入本地内存后再取离线队列，之后发来的消息都会走实时推送
+	go h.replayOffline(client)
 
 	return nil
 }
 
-// Unregister 注销客户端
-func (h *HubService) Unregister(ctx context.Context, userID uint) error {
-	h.mu.Lock()
-	client, exists := h.clients[userID]
-	if exists {
-		client.Cancel() // 取消客户端上下文
-		if client.IsWS && client.Conn != nil {
-			client.Conn.Close()
+// replayOffline 推送用户的离线消息及设备未确认的消息，完成后开始推送实时消息
+func (h *HubService) replayOffline(client *Client) {
+	replayed := make(map[uint]bool)
+	defer client.Release(func(msg []byte) bool {
+		// 回放期间实时收到的消息可能已在未确认消息中补发过
+		if len(replayed) == 0 {
+			return false
+		}
+		id, ok := pushedMessageID(msg)
+		return ok && replayed[id]
+	})
+
+	// 离线队列取出后即被清空，使用独立的上下文避免连接断开导致消息丢失
+	ctx := context.Background()
+	offline := h.drainOffline(ctx, client.UserID)
+	unacked := h.unackedMessages(ctx, client)
+	resumed := h.resumedMessages(ctx, client)
+	broadcasts := h.broadcastMessages(ctx, client.UserID)
+
+	// 合并去重，同一会话内消息ID与序号同序
+	queued := make(map[uint]bool, len(offline))
+	seen := make(map[uint]bool, len(offline)+len(unacked)+len(resumed)+len(broadcasts))
+	messages := make([]*model.Message, 0, len(offline)+len(unacked)+len(resumed)+len(broadcasts))
+	for _, message := range offline {
+		queued[message.ID] = true
+		seen[message.ID] = true
+		messages = append(messages, message)
+	}
+	for _, message := range slices.Concat(unacked, resumed, broadcasts) {
+		if !seen[message.ID] {
+			seen[message.ID] = true
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+	slices.SortFunc(messages, func(a, b *model.Message) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	for i, message := range messages {
+		out, err := newOutbound(protocol.TypeMessage, message)
+		if err != nil {
+			log.Printf("Failed to marshal offline message %d: %v", message.ID, err)
+			continue
+		}
+		replayed[message.ID] = true
+		if !client.Replay(out.Encode(client.Protocol)) {
+			// 连接已断开，未推送的离线消息放回离线队列，未确认的消息下次重连时会再次补发
+			var rest []*model.Message
+			for _, m := range messages[i:] {
+				if queued[m.ID] {
+					rest = append(rest, m)
+				}
+			}
+			if err := h.offlineService.Requeue(ctx, client.UserID, rest); err != nil {
+				log.Printf("Failed to requeue offline messages for user %d: %v", client.UserID, err)
+			}
+			return
+		}
+	}
+
+	// 补发的全员广播已推送给该用户，用户的其他设备和会话注册时不再补发
+	var broadcastSeq uint64
+	for _, message := range messages {
+		if message.ConversationID == model.BroadcastConversationID {
+			broadcastSeq = max(broadcastSeq, message.Seq)
+		}
+	}
+	if broadcastSeq > 0 {
+		if err := h.userRepo.AdvanceBroadcastSeq(ctx, []uint{client.UserID}, broadcastSeq); err != nil {
+			log.Printf("Failed to advance broadcast seq of user %d: %v", client.UserID, err)
+		}
+	}
+
+	log.Printf("Replayed %d messages (%d offline, %d unacked, %d resumed, %d broadcasts): UserID=%d, DeviceID=%s",
+		len(messages), len(offline), len(unacked), len(resumed), len(broadcasts), client.UserID, client.DeviceID)
+}
+
+// drainOffline 取出用户的离线队列，队列被截断时从数据库补齐
+func (h *HubService) drainOffline(ctx context.Context, userID uint) []*model.Message {
+	messages, sinceID, err := h.offlineService.Drain(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to drain offline messages for user %d: %v", userID, err)
+		return nil
+	}
+
+	// 队列超出长度被截断时，从数据库补齐离线期间缺失的消息
+	if sinceID > 0 && (len(messages) == 0 || messages[0].ID > sinceID) {
+		var toID uint
+		if len(messages) > 0 {
+			toID = messages[0].ID
+		}
+		var missing []*model.Message
+		roomIDs, err := h.roomRepo.GetUserRoomIDs(ctx, userID)
+		if err == nil {
+			missing, err = h.messageRepo.GetUserFeedInRange(ctx, userID, roomIDs, sinceID, toID)
+		}
+		if err != nil {
+			log.Printf("Failed to load missing offline messages for user %d: %v", userID, err)
+		} else {
+			messages = append(missing, messages...)
+		}
+	}
+	return h.refreshMessages(ctx, messages)
+}
+
+// refreshMessages 用数据库中的最新状态替换离线队列中的消息快照，去掉已撤回的消息、更新已编辑的内容
+func (h *HubService) refreshMessages(ctx context.Context, messages []*model.Message) []*model.Message {
+	if len(messages) == 0 {
+		return messages
+	}
+	ids := make([]uint, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	current, err := h.messageRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		log.Printf("Failed to refresh offline messages: %v", err)
+		return messages
+	}
+	return current
+}
+
+// unackedMessages 获取设备在已确认过的会话中尚未确认的消息
+func (h *HubService) unackedMessages(ctx context.Context, client *Client) []*model.Message {
+	cursors, err := h.messageRepo.GetDeliveryCursors(ctx, client.UserID, client.DeviceID)
+	if err != nil {
+		log.Printf("Failed to load delivery cursors for user %d: %v", client.UserID, err)
+		return nil
+	}
+
+	var messages []*model.Message
+	for _, cursor := range cursors {
+		kind, ids, err := model.ParseConversationID(cursor.ConversationID)
+		if err != nil {
+			continue
+		}
+		// 已退出的房间不再补发
+		if kind == model.MessageTargetRoom {
+			if isMember, err := h.roomRepo.IsMember(ctx, ids[0], client.UserID); err != nil || !isMember {
+				continue
+			}
+		}
+
+		pending, err := h.messageRepo.GetConversationMessagesAfter(ctx, cursor.ConversationID, cursor.Seq, resendLimit)
+		if err != nil {
+			log.Printf("Failed to load unacked messages of %s: %v", cursor.ConversationID, err)
+			continue
+		}
+		for _, message := range pending {
+			// 私聊中自己发出的消息不会推送给自己
+			if kind == model.MessageTargetUser && message.SenderID == client.UserID {
+				continue
+			}
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// resumedMessages 获取断线续传需要补发的消息（ID大于客户端最后收到的消息ID）
+func (h *HubService) resumedMessages(ctx context.Context, client *Client) []*model.Message {
+	if client.ResumeAfter == 0 {
+		return nil
+	}
+
+	roomIDs, err := h.roomRepo.GetUserRoomIDs(ctx, client.UserID)
+	if err != nil {
+		log.Printf("Failed to load rooms of user %d: %v", client.UserID, err)
+		return nil
+	}
+	messages, err := h.messageRepo.GetUserFeedAfter(ctx, client.UserID, roomIDs, client.ResumeAfter, resendLimit)
+	if err != nil {
+		log.Printf("Failed to load messages after %d for user %d: %v", client.ResumeAfter, client.UserID, err)
+		return nil
+	}
+	return messages
+}
+
+// broadcastMessages 获取尚未推送给用户任何设备的全员广播，注册之前的广播不补发
+// 确认过 all 会话的设备另按自己的确认位置补发，见 unackedMessages
+func (h *HubService) broadcastMessages(ctx context.Context, userID uint) []*model.Message {
+	user, err := h.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %d: %v", userID, err)
+		return nil
+	}
+	messages, err := h.messageRepo.GetBroadcastsAfter(ctx, user.BroadcastSeq, user.CreatedAt, resendLimit)
+	if err != nil {
+		log.Printf("Failed to load broadcasts after %d for user %d: %v", user.BroadcastSeq, userID, err)
+		return nil
+	}
+	return messages
+}
+
+// Ack 记录设备对会话消息的确认，seq为设备在该会话中已收到的最大序号
+func (h *HubService) Ack(ctx context.Context, client *Client, conversationID string, seq uint64) error {
+	// 所有用户都是全员广播会话的参与者
+	if conversationID != model.BroadcastConversationID {
+		if _, _, err := checkConversation(ctx, h.roomRepo, client.UserID, conversationID); err != nil {
+			return err
+		}
+	}
+	return h.messageRepo.AckDelivery(ctx, client.UserID, client.DeviceID, conversationID, seq)
+}
+
+// SendSignal 发送不持久化的会话信号（正在输入等）：限制发送频率，合并重复的信号，超时未刷新时自动结束
+func (h *HubService) SendSignal(ctx context.Context, client *Client, conversationID, name string, active bool, data json.RawMessage) error {
+	if !validSignal(name, data) {
+		return ErrInvalidSignal
+	}
+	if !h.signals.allow(client.UserID) {
+		return ErrRateLimited
+	}
+	if _, _, err := checkConversation(ctx, h.roomRepo, client.UserID, conversationID); err != nil {
+		return err
+	}
+
+	signal := &Signal{
+		ConversationID: conversationID,
+		UserID:         client.UserID,
+		Name:           name,
+		Active:         active,
+		Data:           data,
+	}
+	if !h.signals.track(signal) {
+		return nil
+	}
+	return h.PublishSignal(ctx, signal)
+}
+
+// Subscribe 订阅房间会话，订阅后连接只接收已订阅房间的消息
+func (h *HubService) Subscribe(ctx context.Context, client *Client, conversationIDs []string) error {
+	for _, conversationID := range conversationIDs {
+		kind, _, err := checkConversation(ctx, h.roomRepo, client.UserID, conversationID)
+		if err != nil {
+			return err
 		}
-		delete(h.clients, userID)
+		if kind != model.MessageTargetRoom {
+			return ErrInvalidConversation
+		}
+	}
+	client.Subscribe(conversationIDs)
+	return nil
+}
+
+// checkConversation 校验用户是否是会话的参与者
+func checkConversation(ctx context.Context, roomRepo repository.IRoomRepository, userID uint, conversationID string) (model.MessageTarget, []uint, error) {
+	kind, ids, err := model.ParseConversationID(conversationID)
+	if err != nil {
+		return "", nil, ErrInvalidConversation
+	}
+
+	switch kind {
+	case model.MessageTargetUser:
+		if ids[0] != userID && ids[1] != userID {
+			return "", nil, ErrInvalidConversation
+		}
+	case model.MessageTargetRoom:
+		isMember, err := roomRepo.IsMember(ctx, ids[0], userID)
+		if err != nil {
+			return "", nil, err
+		}
+		if !isMember {
+			return "", nil, ErrNotRoomMember
+		}
+	default:
+		return "", nil, ErrInvalidConversation
+	}
+	return kind, ids, nil
+}
+
+// Unregister 注销客户端，仅当用户最后一个设备断开时才标记为离线
+func (h *HubService) Unregister(ctx context.Context, client *Client) error {
+	h.mu.Lock()
+	devices := h.clients[client.UserID]
+	// 只移除当前这一个连接，避免误删同设备的新连接
+	removed := devices != nil && devices[client.DeviceID] == client
+	if removed {
+		delete(devices, client.DeviceID)
+	}
+	last := removed && len(devices) == 0
+	if last {
+		delete(h.clients, client.UserID)
 	}
 	h.mu.Unlock()
 
+	// 用户在本实例的设备全部下线，结束其发送的临时信号
+	if last {
+		for _, signal := range h.signals.clear(client.UserID) {
+			if err := h.PublishSignal(ctx, signal); err != nil {
+				log.Printf("Failed to publish %s signal end of user %d: %v", signal.Name, signal.UserID, err)
+			}
+		}
+	}
+
+	client.Cancel() // 取消客户端上下文
+	if client.IsWS && client.Conn != nil {
+		client.Conn.Close()
+	}
+
+	// 从在线状态注册表移除，用户在其他实例上仍有设备在线时不标记离线
+	if removed {
+		if online, err := h.presenceService.Disconnect(ctx, client.UserID, client.DeviceID); err != nil {
+			log.Printf("Failed to unregister presence of user %d: %v", client.UserID, err)
+		} else {
+			last = !online
+		}
+	}
+
+	if !last {
+		log.Printf("Client unregistered: UserID=%d, DeviceID=%s, InstanceID=%s", client.UserID, client.DeviceID, h.instanceID)
+		return nil
+	}
+
 	// 更新用户状态为离线
-	if err := h.userRepo.UpdateStatus(ctx, userID, model.UserStatusOffline, ""); err != nil {
+	if err := h.userRepo.UpdateStatus(ctx, client.UserID, model.UserStatusOffline, ""); err != nil {
 		log.Printf("Failed to update user status to offline: %v", err)
 	}
 
-	// 发送用户下线状态到消息通知器
-	if h.messageNotifier != nil && exists {
+	// 推送下线状态给本实例客户端，并发送到消息通知器
+	if err := h.NotifyStatus(ctx, client.UserID, model.UserStatusOffline, h.instanceID); err != nil {
+		log.Printf("Failed to notify offline status: %v", err)
+	}
+	if notifier := h.notifier(); notifier != nil {
 		go func() {
-			if err := h.messageNotifier.SendStatusUpdate(userID, model.UserStatusOffline); err != nil {
+			if err := notifier.SendStatusUpdate(client.UserID, model.UserStatusOffline); err != nil {
 				log.Printf("Failed to send offline status: %v", err)
 			}
 		}()
 	}
 
-	log.Printf("Client unregistered: UserID=%d, InstanceID=%s", userID, h.instanceID)
+	log.Printf("Client unregistered: UserID=%d, DeviceID=%s, InstanceID=%s, last device offline", client.UserID, client.DeviceID, h.instanceID)
 	return nil
 }
 
-// IsOnline 检查用户是否在线
-func (h *HubService) IsOnline(ctx context.Context, userID uint) (bool, string, error) {
-	// 首先检查本地实例是否有此用户连接
-	h.mu.RLock()
-	_, exists := h.clients[userID]
-	h.mu.RUnlock()
+// Heartbeat 客户端心跳，刷新活跃时间和在线状态注册表中的连接TTL
+func (h *HubService) Heartbeat(ctx context.Context, client *Client) {
+	client.Touch()
+	if err := h.presenceService.Refresh(ctx, client.UserID, client.DeviceID); err != nil {
+		log.Printf("Failed to refresh presence of user %d: %v", client.UserID, err)
+	}
+}
+
+// StartPresence 启动实例心跳和宕机实例清理
+func (h *HubService) StartPresence(ctx context.Context) {
+	h.presenceService.Start(ctx, h.markOffline)
+}
+
+// markOffline 将宕机实例上的用户标记为离线并推送下线状态
+func (h *HubService) markOffline(ctx context.Context, userIDs []uint) {
+	for _, userID := range userIDs {
+		if err := h.userRepo.UpdateStatus(ctx, userID, model.UserStatusOffline, ""); err != nil {
+			log.Printf("Failed to update user status to offline: %v", err)
+		}
+		if err := h.NotifyStatus(ctx, userID, model.UserStatusOffline, h.instanceID); err != nil {
+			log.Printf("Failed to notify offline status: %v", err)
+		}
+		if notifier := h.notifier(); notifier != nil {
+			if err := notifier.SendStatusUpdate(userID, model.UserStatusOffline); err != nil {
+				log.Printf("Failed to send offline status: %v", err)
+			}
+		}
+	}
+	log.Printf("Marked %d users offline after instance failure", len(userIDs))
+}
+
+// IsOnline 检查用户是否在线，返回用户有连接的所有实例
+func (h *HubService) IsOnline(ctx context.Context, userID uint) (*Presence, error) {
+	presence := &Presence{
+		UserID:  userID,
+		Devices: h.localDevices(userID),
+	}
 
-	if exists {
-		return true, h.instanceID, nil
+	// 本地实例有此用户连接
+	if len(presence.Devices) > 0 {
+		presence.Online = true
+		presence.InstanceID = h.instanceID
+		presence.Instances = append(presence.Instances, h.instanceID)
+	}
+
+	// 从在线状态注册表查询用户在其他实例上的连接
+	devices, err := h.presenceService.Lookup(ctx, userID)
+	if err == nil {
+		var others []string
+		for _, instanceID := range devices {
+			if instanceID != h.instanceID && !slices.Contains(others, instanceID) {
+				others = append(others, instanceID)
+			}
+		}
+		slices.Sort(others)
+		if len(others) > 0 {
+			if !presence.Online {
+				presence.InstanceID = others[0]
+			}
+			presence.Online = true
+			presence.Instances = append(presence.Instances, others...)
+		}
+		return presence, nil
 	}
+	log.Printf("Failed to look up presence of user %d, falling back to database: %v", userID, err)
 
-	// 从数据库查询用户状态（可能在其他实例上在线）
-	return h.userRepo.IsOnline(ctx, userID)
+	// 注册表不可用时退回到数据库中的用户状态
+	online, instanceID, err := h.userRepo.IsOnline(ctx, userID)
+	if err != nil {
+		if presence.Online {
+			return presence, nil
+		}
+		return nil, err
+	}
+	if online && instanceID != "" && instanceID != h.instanceID {
+		presence.Online = true
+		presence.InstanceID = instanceID
+		presence.Instances = append(presence.Instances, instanceID)
+	}
+	return presence, nil
 }
 
-// GetOnlineUsers 获取在线用户列表
-func (h *HubService) GetOnlineUsers(ctx context.Context) ([]*model.User, error) {
-	return h.userRepo.GetOnlineUsers(ctx)
+// GetOnlineUsers 获取在线用户列表，附带本实例上的设备连接
+func (h *HubService) GetOnlineUsers(ctx context.Context) ([]*OnlineUser, error) {
+	users, err := h.userRepo.GetOnlineUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	onlineUsers := make([]*OnlineUser, len(users))
+	for i, user := range users {
+		onlineUsers[i] = &OnlineUser{
+			User:    user,
+			Devices: h.localDevices(user.ID),
+		}
+	}
+	return onlineUsers, nil
 }
 
-// SendMessage 发送消息给指定用户
+// SendMessage 发送消息给指定用户，发送者已用相同的客户端消息ID发送过消息时不再推送，message被替换为原消息
 func (h *HubService) SendMessage(ctx context.Context, message *model.Message) error {
-	// 保存消息到数据库
+	// 保存消息到数据库，重试发送的消息已推送过，直接返回原消息
 	if err := h.messageRepo.Create(ctx, message); err != nil {
+		if errors.Is(err, repository.ErrDuplicateMessage) {
+			return nil
+		}
 		return err
 	}
+	indexMessage(ctx, h.searchIndex, message)
 
-	// 检查消息接收者是否在当前实例
-	h.mu.RLock()
-	client, exists := h.clients[message.ReceiverID]
-	h.mu.RUnlock()
+	// 推送给接收者在当前实例的设备
+	if err := h.DeliverToUser(ctx, message.ReceiverID, message); err != nil {
+		return err
+	}
 
-	// 如果用户在当前实例，则直接发送消息
-	if exists {
-		// 序列化消息
-		msgBytes, err := json.Marshal(message)
-		if err != nil {
-			return err
+	// 查询接收者所在实例
+	presence, err := h.IsOnline(ctx, message.ReceiverID)
+	if err != nil {
+		log.Printf("Failed to look up instance of user %d, falling back to broadcast: %v", message.ReceiverID, err)
+		if notifier := h.notifier(); notifier != nil {
+			go func() {
+				if err := notifier.SendUserMessage(message.ReceiverID, message); err != nil {
+					log.Printf("Failed to send message to notifier: %v", err)
+				}
+			}()
 		}
+		return nil
+	}
 
-		// 根据客户端类型发送消息
-		if client.IsWS {
-			// WebSocket客户端直接发送
-			if err := client.Conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-				// 如果发送失败，可能是连接已断开，需要注销客户端
-				log.Printf("Failed to send message via WebSocket, unregistering client: %v", err)
-				go h.Unregister(ctx, client.UserID)
-			}
-		} else {
-			// HTTP长轮询客户端放入发送队列
-			select {
-			case client.SendQueue <- msgBytes:
-			default:
-				// 队列已满，可能需要处理
-				// todo
-				log.Printf("Send queue full for user %d", client.UserID)
+	// 接收者不在线，写入离线队列，重连时回放
+	if !presence.Online {
+		if err := h.offlineService.Push(ctx, message.ReceiverID, message); err != nil {
+			log.Printf("Failed to push offline message for user %d: %v", message.ReceiverID, err)
+		}
+		return nil
+	}
+
+	// 根据接收者所在实例定向转发
+	if notifier := h.notifier(); notifier != nil {
+		h.routeUserMessage(notifier, presence, message)
+	}
+
+	return nil
+}
+
+// routeUserMessage 将私聊消息投递到接收者所在的其他实例的收件箱
+func (h *HubService) routeUserMessage(notifier MessageNotifier, presence *Presence, message *model.Message) {
+	for _, instanceID := range presence.Instances {
+		if instanceID == h.instanceID {
+			continue
+		}
+		go func(instanceID string) {
+			if err := notifier.SendUserMessageToInstance(instanceID, message.ReceiverID, message); err != nil {
+				log.Printf("Failed to send message to instance %s: %v", instanceID, err)
 			}
+		}(instanceID)
+	}
+}
+
+// BroadcastToRoom 向房间内所有用户广播消息，客户端消息ID重复时同 SendMessage
+func (h *HubService) BroadcastToRoom(ctx context.Context, roomID uint, message *model.Message) error {
+	// 保存消息到数据库，重试发送的消息已推送过，直接返回原消息
+	if err := h.messageRepo.Create(ctx, message); err != nil {
+		if errors.Is(err, repository.ErrDuplicateMessage) {
+			return nil
 		}
+		return err
+	}
+	indexMessage(ctx, h.searchIndex, message)
 
-		// 更新最后活跃时间
-		client.LastActive = time.Now()
+	// 推送给房间成员在当前实例的设备
+	if err := h.DeliverToRoom(ctx, roomID, message); err != nil {
+		return err
 	}
 
 	// 发送消息到消息通知器
-	if h.messageNotifier != nil {
+	if notifier := h.notifier(); notifier != nil {
 		go func() {
-			if err := h.messageNotifier.SendUserMessage(message.ReceiverID, message); err != nil {
-				log.Printf("Failed to send message to notifier: %v", err)
+			if err := notifier.SendRoomMessage(roomID, message); err != nil {
+				log.Printf("Failed to send room message to notifier: %v", err)
 			}
 		}()
 	}
@@ -244,13 +739,86 @@ func (h *HubService) SendMessage(ctx context.Context, message *model.Message) er
 	return nil
 }
 
-// BroadcastToRoom 向房间内所有用户广播消息
-func (h *HubService) BroadcastToRoom(ctx context.Context, roomID uint, message *model.Message) error {
-	// 保存消息到数据库
+// BroadcastSystemMessage 持久化并推送系统广播：全员广播推送给所有实例的全部连接，房间广播推送给房间成员
+// 房间广播时离线的成员写入离线队列，全员广播由离线用户重连时按序号补发
+func (h *HubService) BroadcastSystemMessage(ctx context.Context, message *model.Message) error {
+	message.Normalize()
+	if message.TargetType == model.MessageTargetRoom {
+		if err := h.BroadcastToRoom(ctx, message.RoomID, message); err != nil {
+			return err
+		}
+		h.queueOfflineMembers(ctx, message)
+		return nil
+	}
+
+	// 保存消息到数据库，全员广播只持久化一次
 	if err := h.messageRepo.Create(ctx, message); err != nil {
 		return err
 	}
 
+	// 推送给本实例的所有连接
+	if err := h.DeliverToAll(ctx, message); err != nil {
+		return err
+	}
+
+	// 发送消息到消息通知器，由其他实例推送给各自的连接
+	if notifier := h.notifier(); notifier != nil {
+		go func() {
+			if err := notifier.SendSystemMessage(message); err != nil {
+				log.Printf("Failed to send system message to notifier: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// queueOfflineMembers 将房间消息写入离线成员的离线队列
+func (h *HubService) queueOfflineMembers(ctx context.Context, message *model.Message) {
+	roomUsers, err := h.roomRepo.GetRoomUsers(ctx, message.RoomID)
+	if err != nil {
+		log.Printf("Failed to load members of room %d: %v", message.RoomID, err)
+		return
+	}
+	for _, user := range roomUsers {
+		presence, err := h.IsOnline(ctx, user.ID)
+		if err != nil || presence.Online {
+			continue
+		}
+		if err := h.offlineService.Push(ctx, user.ID, message); err != nil {
+			log.Printf("Failed to push offline message for user %d: %v", user.ID, err)
+		}
+	}
+}
+
+// DeliverToUser 将消息推送给用户在本实例的所有设备，不持久化也不转发到其他实例
+func (h *HubService) DeliverToUser(ctx context.Context, userID uint, message *model.Message) error {
+	clients := h.userClients(userID)
+	if len(clients) == 0 {
+		return nil
+	}
+
+	// 序列化消息
+	out, err := newOutbound(protocol.TypeMessage, message)
+	if err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		h.deliver(client, out)
+	}
+	return nil
+}
+
+// DeliverToRoom 将消息推送给房间成员在本实例的设备，不持久化也不转发到其他实例
+func (h *HubService) DeliverToRoom(ctx context.Context, roomID uint, message *model.Message) error {
+	// 本实例没有任何连接时无需查询房间成员
+	h.mu.RLock()
+	empty := len(h.clients) == 0
+	h.mu.RUnlock()
+	if empty {
+		return nil
+	}
+
 	// 获取房间内的所有用户
 	roomUsers, err := h.roomRepo.GetRoomUsers(ctx, roomID)
 	if err != nil {
@@ -258,55 +826,445 @@ func (h *HubService) BroadcastToRoom(ctx context.Context, roomID uint, message *
 	}
 
 	// 序列化消息
-	msgBytes, err := json.Marshal(message)
+	out, err := newOutbound(protocol.TypeMessage, message)
 	if err != nil {
 		return err
 	}
 
-	// 向当前实例中在房间内的用户发送消息
+	// 话题回复额外通知参与者，未订阅该房间的连接也能收到
+	if message.ThreadRootID != 0 {
+		if err := h.notifyThreadReply(ctx, roomUsers, message); err != nil {
+			log.Printf("Failed to notify participants of thread %d: %v", message.ThreadRootID, err)
+		}
+	}
+
+	conversationID := model.RoomConversationID(roomID)
 	h.mu.RLock()
+	defer h.mu.RUnlock()
 	for _, user := range roomUsers {
-		if client, exists := h.clients[user.ID]; exists {
-			if client.IsWS {
-				// WebSocket客户端直接发送
-				if err := client.Conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-					// 如果发送失败，可能是连接已断开，需要注销客户端
-					log.Printf("Failed to send room message via WebSocket, unregistering client: %v", err)
-					// 复制user.ID以避免在RLock内修改map
-					uID := user.ID
-					h.mu.RUnlock() // 先释放读锁
-					go h.Unregister(ctx, uID)
-					h.mu.RLock() // 重新获取读锁继续循环
-				}
-			} else {
-				// HTTP长轮询客户端放入发送队列
-				select {
-				case client.SendQueue <- msgBytes:
-				default:
-					// 队列已满，可能需要处理
-					// todo
-					log.Printf("Send queue full for user %d", client.UserID)
-				}
+		for _, client := range h.clients[user.ID] {
+			// 连接订阅了部分房间时只推送订阅的房间
+			if client.Subscribed(conversationID) {
+				h.deliver(client, out)
 			}
+		}
+	}
+	return nil
+}
+
+// DeliverToAll 将全员消息推送给本实例的所有连接，不持久化也不转发到其他实例
+func (h *HubService) DeliverToAll(ctx context.Context, message *model.Message) error {
+	out, err := newOutbound(protocol.TypeMessage, message)
+	if err != nil {
+		return err
+	}
+	userIDs := h.localUserIDs()
+	if err := h.deliverToConversation(ctx, model.BroadcastConversationID, out); err != nil {
+		return err
+	}
 
-			// 更新最后活跃时间
-			client.LastActive = time.Now()
+	// 记录本实例已推送广播的用户，这些用户的其他设备和会话注册时不再补发
+	if err := h.userRepo.AdvanceBroadcastSeq(ctx, userIDs, message.Seq); err != nil {
+		log.Printf("Failed to advance broadcast seq of %d users: %v", len(userIDs), err)
+	}
+	return nil
+}
+
+// notifyThreadReply 向仍在房间中的话题参与者在本实例的设备推送新回复事件，不包括回复者本人
+func (h *HubService) notifyThreadReply(ctx context.Context, roomUsers []*model.User, message *model.Message) error {
+	participants, err := h.messageRepo.GetThreadParticipants(ctx, message.ThreadRootID)
+	if err != nil {
+		return err
+	}
+
+	members := make(map[uint]bool, len(roomUsers))
+	for _, user := range roomUsers {
+		members[user.ID] = true
+	}
+	var clients []*Client
+	for _, userID := range participants {
+		if userID != message.SenderID && members[userID] {
+			clients = append(clients, h.userClients(userID)...)
 		}
 	}
-	h.mu.RUnlock()
+	if len(clients) == 0 {
+		return nil
+	}
 
-	// 发送消息到消息通知器
-	if h.messageNotifier != nil {
+	counts, err := h.messageRepo.CountReplies(ctx, []uint{message.ThreadRootID})
+	if err != nil {
+		return err
+	}
+	out, err := newOutbound(protocol.TypeEvent, Event{
+		Type: EventThreadReply,
+		Data: ThreadEvent{
+			ThreadRootID:   message.ThreadRootID,
+			MessageID:      message.ID,
+			ConversationID: message.ConversationID,
+			SenderID:       message.SenderID,
+			ReplyCount:     counts[message.ThreadRootID],
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		h.deliver(client, out)
+	}
+	return nil
+}
+
+// PublishMessageEvent 推送消息编辑、撤回事件给本实例的会话参与者，并发送到消息通知器
+func (h *HubService) PublishMessageEvent(ctx context.Context, eventType string, message *model.Message, operatorID uint) error {
+	if err := h.DeliverMessageEvent(ctx, eventType, message, operatorID); err != nil {
+		return err
+	}
+
+	if notifier := h.notifier(); notifier != nil {
 		go func() {
-			if err := h.messageNotifier.SendRoomMessage(roomID, message); err != nil {
-				log.Printf("Failed to send room message to notifier: %v", err)
+			if err := notifier.SendMessageEvent(eventType, message, operatorID); err != nil {
+				log.Printf("Failed to send %s event to notifier: %v", eventType, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// DeliverMessageEvent 将消息编辑、撤回事件推送给会话参与者在本实例的设备，不转发到其他实例
+func (h *HubService) DeliverMessageEvent(ctx context.Context, eventType string, message *model.Message, operatorID uint) error {
+	message.Normalize()
+	event := MessageEvent{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		Seq:            message.Seq,
+		OperatorID:     operatorID,
+	}
+	if eventType == EventMessageEdited {
+		event.Content = message.Content
+		event.EditedAt = message.EditedAt
+	}
+	out, err := newOutbound(protocol.TypeEvent, Event{Type: eventType, Data: event})
+	if err != nil {
+		return err
+	}
+	return h.deliverToConversation(ctx, message.ConversationID, out)
+}
+
+// PublishExpiredEvent 推送会话中一批消息的过期事件给本实例的会话参与者，并发送到消息通知器
+func (h *HubService) PublishExpiredEvent(ctx context.Context, conversationID string, messageIDs []uint) error {
+	if err := h.DeliverExpiredEvent(ctx, conversationID, messageIDs); err != nil {
+		return err
+	}
+
+	if notifier := h.notifier(); notifier != nil {
+		go func() {
+			if err := notifier.SendExpiredEvent(conversationID, messageIDs); err != nil {
+				log.Printf("Failed to send expired event to notifier: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// DeliverExpiredEvent 将消息过期事件推送给会话参与者在本实例的设备，不转发到其他实例
+func (h *HubService) DeliverExpiredEvent(ctx context.Context, conversationID string, messageIDs []uint) error {
+	out, err := newOutbound(protocol.TypeEvent, Event{
+		Type: EventMessagesExpired,
+		Data: ExpiredEvent{ConversationID: conversationID, MessageIDs: messageIDs},
+	})
+	if err != nil {
+		return err
+	}
+	return h.deliverToConversation(ctx, conversationID, out)
+}
+
+// PublishReactionEvent 推送表情回应事件给本实例的会话参与者，并发送到消息通知器
+func (h *HubService) PublishReactionEvent(ctx context.Context, eventType string, message *model.Message, userID uint, emoji string) error {
+	if err := h.DeliverReactionEvent(ctx, eventType, message, userID, emoji); err != nil {
+		return err
+	}
+
+	if notifier := h.notifier(); notifier != nil {
+		go func() {
+			if err := notifier.SendReactionEvent(eventType, message, userID, emoji); err != nil {
+				log.Printf("Failed to send %s event to notifier: %v", eventType, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// DeliverReactionEvent 将表情回应事件推送给会话参与者在本实例的设备，不转发到其他实例
+func (h *HubService) DeliverReactionEvent(ctx context.Context, eventType string, message *model.Message, userID uint, emoji string) error {
+	message.Normalize()
+	out, err := newOutbound(protocol.TypeEvent, Event{
+		Type: eventType,
+		Data: ReactionEvent{
+			MessageID:      message.ID,
+			ConversationID: message.ConversationID,
+			UserID:         userID,
+			Emoji:          emoji,
+			Reactions:      message.Reactions,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return h.deliverToConversation(ctx, message.ConversationID, out)
+}
+
+// PublishReadReceipt 推送已读回执给本实例的会话参与者，并发送到消息通知器
+func (h *HubService) PublishReadReceipt(ctx context.Context, cursor *model.ReadCursor) error {
+	if err := h.DeliverReadReceipt(ctx, cursor); err != nil {
+		return err
+	}
+
+	if notifier := h.notifier(); notifier != nil {
+		go func() {
+			if err := notifier.SendReadReceipt(cursor); err != nil {
+				log.Printf("Failed to send read receipt to notifier: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// DeliverReadReceipt 将已读回执推送给会话参与者在本实例的设备，用户自己的其他设备据此同步已读状态
+func (h *HubService) DeliverReadReceipt(ctx context.Context, cursor *model.ReadCursor) error {
+	out, err := newOutbound(protocol.TypeEvent, Event{
+		Type: EventReadReceipt,
+		Data: ReadReceiptEvent{
+			ConversationID: cursor.ConversationID,
+			UserID:         cursor.UserID,
+			LastReadID:     cursor.LastReadID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 全员广播的已读位置只同步到用户自己的其他设备
+	if cursor.ConversationID == model.BroadcastConversationID {
+		for _, client := range h.userClients(cursor.UserID) {
+			h.deliver(client, out)
+		}
+		return nil
+	}
+	return h.deliverToConversation(ctx, cursor.ConversationID, out)
+}
+
+// PublishSignal 推送临时信号给本实例的会话参与者，并发送到消息通知器，不写入数据库
+func (h *HubService) PublishSignal(ctx context.Context, signal *Signal) error {
+	if err := h.DeliverSignal(ctx, signal); err != nil {
+		return err
+	}
+
+	if notifier := h.notifier(); notifier != nil {
+		go func() {
+			if err := notifier.SendSignal(signal); err != nil {
+				log.Printf("Failed to send %s signal to notifier: %v", signal.Name, err)
 			}
 		}()
 	}
+	return nil
+}
+
+// DeliverSignal 将临时信号推送给会话中其他参与者在本实例的设备，已过期的信号直接丢弃
+func (h *HubService) DeliverSignal(ctx context.Context, signal *Signal) error {
+	now := time.Now()
+	if !now.Before(signal.ExpiresAt) {
+		return nil
+	}
+
+	event := Event{
+		Type: EventSignal,
+		Data: SignalEvent{
+			ConversationID: signal.ConversationID,
+			UserID:         signal.UserID,
+			Signal:         signal.Name,
+			Active:         signal.Active,
+			Data:           signal.Data,
+			ExpiresIn:      signal.expiresIn(now),
+		},
+	}
+	if signal.Name == SignalTyping {
+		event = Event{
+			Type: EventTyping,
+			Data: TypingEvent{
+				ConversationID: signal.ConversationID,
+				UserID:         signal.UserID,
+				Typing:         signal.Active,
+				ExpiresIn:      signal.expiresIn(now),
+			},
+		}
+	}
+	out, err := newOutbound(protocol.TypeEvent, event)
+	if err != nil {
+		return err
+	}
+
+	kind, ids, err := model.ParseConversationID(signal.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	// 会话中除发送者以外的参与者
+	var userIDs []uint
+	switch kind {
+	case model.MessageTargetUser:
+		userIDs = ids
+	case model.MessageTargetRoom:
+		users, err := h.roomRepo.GetRoomUsers(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			userIDs = append(userIDs, user.ID)
+		}
+	default:
+		return ErrInvalidConversation
+	}
+
+	for _, userID := range userIDs {
+		if userID == signal.UserID {
+			continue
+		}
+		for _, c := range h.userClients(userID) {
+			if kind == model.MessageTargetRoom && !c.Subscribed(signal.ConversationID) {
+				continue
+			}
+			h.deliver(c, out)
+		}
+	}
+	return nil
+}
+
+// deliverToConversation 将事件推送给会话参与者在本实例的设备
+func (h *HubService) deliverToConversation(ctx context.Context, conversationID string, out *Outbound) error {
+	kind, ids, err := model.ParseConversationID(conversationID)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case model.MessageTargetRoom:
+		roomUsers, err := h.roomRepo.GetRoomUsers(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		for _, user := range roomUsers {
+			for _, client := range h.userClients(user.ID) {
+				if client.Subscribed(conversationID) {
+					h.deliver(client, out)
+				}
+			}
+		}
+	case model.MessageTargetUser:
+		// 操作者的其他设备也需要同步，自己发给自己的会话只推送一次
+		for _, userID := range slices.Compact(ids) {
+			for _, client := range h.userClients(userID) {
+				h.deliver(client, out)
+			}
+		}
+	default:
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		for _, devices := range h.clients {
+			for _, client := range devices {
+				h.deliver(client, out)
+			}
+		}
+	}
+	return nil
+}
+
+// NotifyStatus 将用户在线状态变化推送给本实例的所有客户端
+func (h *HubService) NotifyStatus(ctx context.Context, userID uint, status int, instanceID string) error {
+	out, err := newOutbound(protocol.TypeEvent, Event{
+		Type: EventStatusUpdate,
+		Data: StatusEvent{
+			UserID:     userID,
+			Status:     status,
+			InstanceID: instanceID,
+		},
+	})
+	if err != nil {
+		return err
+	}
 
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, devices := range h.clients {
+		for _, client := range devices {
+			h.deliver(client, out)
+		}
+	}
 	return nil
 }
 
+// GetClientStats 获取本实例所有客户端的发送队列统计
+func (h *HubService) GetClientStats(ctx context.Context) []ClientStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := make([]ClientStats, 0, len(h.clients))
+	for _, devices := range h.clients {
+		for _, client := range devices {
+			stats = append(stats, client.Stats())
+		}
+	}
+	return stats
+}
+
+// localUserIDs 获取在本实例有连接的用户
+func (h *HubService) localUserIDs() []uint {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	userIDs := make([]uint, 0, len(h.clients))
+	for userID := range h.clients {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// userClients 获取用户在本实例的所有设备连接
+func (h *HubService) userClients(userID uint) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	devices := h.clients[userID]
+	clients := make([]*Client, 0, len(devices))
+	for _, client := range devices {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// localDevices 获取用户在本实例的设备信息
+func (h *HubService) localDevices(userID uint) []DeviceInfo {
+	clients := h.userClients(userID)
+	devices := make([]DeviceInfo, len(clients))
+	for i, client := range clients {
+		devices[i] = DeviceInfo{
+			DeviceID:    client.DeviceID,
+			Kind:        client.Kind,
+			IsWS:        client.IsWS,
+			InstanceID:  h.instanceID,
+			ConnectedAt: client.ConnectedAt,
+		}
+	}
+	return devices
+}
+
+// deliver 将消息放入客户端发送队列，由写协程统一写出，避免并发写同一连接
+func (h *HubService) deliver(client *Client, out *Outbound) {
+	if !client.Enqueue(out.Encode(client.Protocol)) {
+		stats := client.Stats()
+		log.Printf("Send queue full for user %d, policy=%s, dropped=%d", client.UserID, stats.Policy, stats.Dropped)
+	}
+}
+
 // HubServiceSet Hub服务依赖注入
 var HubServiceSet = wire.NewSet(
 	NewHubService,
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/instance"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
+	"github.com/Gopher0727/RTMP/pkg/protocol"
+)
+
+// newTestRedis 创建测试用的miniredis及其客户端
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+// hubFixture 用户1、2、3，用户1、2在房间1，用户1在房间2，Hub的离线队列和在线状态注册表使用miniredis
+type hubFixture struct {
+	db       *gorm.DB
+	hub      *HubService
+	presence *PresenceService
+	offline  *OfflineService
+	mr       *miniredis.Miniredis
+}
+
+func newHubFixture(t *testing.T) *hubFixture {
+	t.Helper()
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&model.DeliveryCursor{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := db.Create(&model.User{Username: name, Password: "x", Email: name + "@example.com"}).Error; err != nil {
+			t.Fatalf("create user %s: %v", name, err)
+		}
+	}
+	for _, member := range []model.RoomMember{{RoomID: 1, UserID: 1}, {RoomID: 1, UserID: 2}, {RoomID: 2, UserID: 1}} {
+		if err := db.Create(&member).Error; err != nil {
+			t.Fatalf("create room member: %v", err)
+		}
+	}
+
+	cfg := &config.Config{Instance: config.InstanceConfig{Name: "node-a"}}
+	identity, err := instance.NewIdentity(cfg)
+	if err != nil {
+		t.Fatalf("NewIdentity: %v", err)
+	}
+	mr, rdb := newTestRedis(t)
+	f := &hubFixture{
+		db:       db,
+		presence: &PresenceService{rdb: rdb, instanceID: identity.ID(), connectionTTL: defaultConnectionTTL},
+		offline:  &OfflineService{rdb: rdb, maxLength: defaultOfflineMaxLength, ttl: defaultOfflineTTL},
+		mr:       mr,
+	}
+	f.hub = NewHubService(
+		cfg,
+		repository.NewUserRepository(db),
+		repository.NewMessageRepository(db),
+		repository.NewRoomRepository(db),
+		db,
+		identity,
+		f.offline,
+		f.presence,
+		search.NewMemoryIndex(),
+	).(*HubService)
+	return f
+}
+
+// connect 注册一个使用帧协议的长轮询客户端
+func (f *hubFixture) connect(t *testing.T, userID uint, deviceID string) *Client {
+	t.Helper()
+	client := NewHTTPClient(userID, deviceID, ClientOptions{QueueSize: 16, Policy: PolicyDropOldest})
+	client.Protocol = protocol.Subprotocol
+	if err := f.hub.Register(context.Background(), client); err != nil {
+		t.Fatalf("Register(%d, %s): %v", userID, deviceID, err)
+	}
+	return client
+}
+
+// receiveMessages 从发送队列读取n条聊天消息，忽略事件帧
+func receiveMessages(t *testing.T, c *Client, n int) []string {
+	t.Helper()
+	var got []string
+	timeout := time.After(2 * time.Second)
+	for len(got) < n {
+		select {
+		case data := <-c.SendQueue:
+			if content, ok := frameContent(t, data); ok {
+				got = append(got, content)
+			}
+		case <-timeout:
+			t.Fatalf("device %s received %v, want %d messages", c.DeviceID, got, n)
+		}
+	}
+	return got
+}
+
+// expectNoMessage 确认发送队列中没有聊天消息
+func expectNoMessage(t *testing.T, c *Client) {
+	t.Helper()
+	timeout := time.After(50 * time.Millisecond)
+	for {
+		select {
+		case data := <-c.SendQueue:
+			if content, ok := frameContent(t, data); ok {
+				t.Fatalf("device %s received unexpected message %q", c.DeviceID, content)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// frameContent 解析帧，返回聊天消息的内容
+func frameContent(t *testing.T, data []byte) (string, bool) {
+	t.Helper()
+	var frame protocol.Frame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		t.Fatalf("decode frame %s: %v", data, err)
+	}
+	if frame.Type != protocol.TypeMessage {
+		return "", false
+	}
+	var message protocol.Message
+	if err := json.Unmarshal(frame.Data, &message); err != nil {
+		t.Fatalf("decode message %s: %v", frame.Data, err)
+	}
+	return message.Content, true
+}
+
+func newTextMessage(sender, receiver, room uint, content string) *model.Message {
+	message := &model.Message{
+		SenderID:   sender,
+		ReceiverID: receiver,
+		RoomID:     room,
+		Content:    content,
+		Type:       string(model.MessageTypeText),
+	}
+	message.Normalize()
+	return message
+}
+
+func TestHubFanOutToDevices(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	phone := f.connect(t, 1, "phone")
+	laptop := f.connect(t, 1, "laptop")
+	other := f.connect(t, 3, "phone")
+
+	if err := f.hub.SendMessage(ctx, newTextMessage(2, 1, 0, "dm")); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if err := f.hub.BroadcastToRoom(ctx, 1, newTextMessage(2, 0, 1, "room")); err != nil {
+		t.Fatalf("BroadcastToRoom: %v", err)
+	}
+
+	for _, client := range []*Client{phone, laptop} {
+		if got := receiveMessages(t, client, 2); !slices.Equal(got, []string{"dm", "room"}) {
+			t.Errorf("device %s received %v, want [dm room]", client.DeviceID, got)
+		}
+	}
+	expectNoMessage(t, other)
+}
+
+func TestHubRoomSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	phone := f.connect(t, 1, "phone")
+	laptop := f.connect(t, 1, "laptop")
+
+	if err := f.hub.Subscribe(ctx, laptop, []string{"room:3"}); !errors.Is(err, ErrNotRoomMember) {
+		t.Errorf("Subscribe(room:3) error = %v, want %v", err, ErrNotRoomMember)
+	}
+	if err := f.hub.Subscribe(ctx, laptop, []string{"room:2"}); err != nil {
+		t.Fatalf("Subscribe(room:2): %v", err)
+	}
+
+	if err := f.hub.BroadcastToRoom(ctx, 1, newTextMessage(2, 0, 1, "room")); err != nil {
+		t.Fatalf("BroadcastToRoom: %v", err)
+	}
+	receiveMessages(t, phone, 1)
+	expectNoMessage(t, laptop)
+}
+
+func TestHubRegisterReplacesDevice(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	old := f.connect(t, 1, "phone")
+	current := f.connect(t, 1, "phone")
+
+	if old.Ctx.Err() == nil {
+		t.Error("old connection of the same device should be closed")
+	}
+	if devices := f.hub.localDevices(1); len(devices) != 1 {
+		t.Errorf("local devices = %d, want 1", len(devices))
+	}
+
+	// 旧连接注销不影响同设备的新连接
+	if err := f.hub.Unregister(ctx, old); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	if err := f.hub.DeliverToUser(ctx, 1, newTextMessage(2, 1, 0, "hello")); err != nil {
+		t.Fatalf("DeliverToUser: %v", err)
+	}
+	receiveMessages(t, current, 1)
+}
+
+func TestHubUnregisterLastDevice(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	phone := f.connect(t, 1, "phone")
+	laptop := f.connect(t, 1, "laptop")
+
+	if err := f.hub.Unregister(ctx, phone); err != nil {
+		t.Fatalf("Unregister(phone): %v", err)
+	}
+	presence, err := f.hub.IsOnline(ctx, 1)
+	if err != nil {
+		t.Fatalf("IsOnline: %v", err)
+	}
+	if !presence.Online || len(presence.Devices) != 1 || presence.Devices[0].DeviceID != "laptop" {
+		t.Errorf("presence after first disconnect = %+v, want online with laptop", presence)
+	}
+
+	if err := f.hub.Unregister(ctx, laptop); err != nil {
+		t.Fatalf("Unregister(laptop): %v", err)
+	}
+	presence, err = f.hub.IsOnline(ctx, 1)
+	if err != nil {
+		t.Fatalf("IsOnline: %v", err)
+	}
+	if presence.Online {
+		t.Errorf("presence after last disconnect = %+v, want offline", presence)
+	}
+}
+
+func TestHubReplayUnacked(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	phone := f.connect(t, 1, "phone")
+	f.connect(t, 1, "laptop")
+
+	for _, content := range []string{"m1", "m2"} {
+		if err := f.hub.SendMessage(ctx, newTextMessage(2, 1, 0, content)); err != nil {
+			t.Fatalf("SendMessage(%s): %v", content, err)
+		}
+	}
+	receiveMessages(t, phone, 2)
+	if err := f.hub.Ack(ctx, phone, "dm:1:2", 1); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := f.hub.Ack(ctx, phone, "room:3", 1); !errors.Is(err, ErrNotRoomMember) {
+		t.Errorf("Ack(room:3) error = %v, want %v", err, ErrNotRoomMember)
+	}
+
+	// 手机断开期间用户在电脑上在线，消息不进入离线队列
+	if err := f.hub.Unregister(ctx, phone); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	if err := f.hub.SendMessage(ctx, newTextMessage(2, 1, 0, "m3")); err != nil {
+		t.Fatalf("SendMessage(m3): %v", err)
+	}
+
+	// 重连时补发确认位置之后的消息
+	phone = f.connect(t, 1, "phone")
+	if got := receiveMessages(t, phone, 2); !slices.Equal(got, []string{"m2", "m3"}) {
+		t.Errorf("replayed %v, want [m2 m3]", got)
+	}
+	expectNoMessage(t, phone)
+}
+
+func TestHubRetriedSendIsPushedOnce(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	phone := f.connect(t, 1, "phone")
+	clientMsgID := func(id string) *string { return &id }
+
+	first := newTextMessage(2, 1, 0, "dm")
+	first.ClientMsgID = clientMsgID("c1")
+	if err := f.hub.SendMessage(ctx, first); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	room := newTextMessage(2, 0, 1, "room")
+	room.ClientMsgID = clientMsgID("c2")
+	if err := f.hub.BroadcastToRoom(ctx, 1, room); err != nil {
+		t.Fatalf("BroadcastToRoom: %v", err)
+	}
+	receiveMessages(t, phone, 2)
+
+	// 重试返回原消息，不再推送
+	retry := newTextMessage(2, 1, 0, "dm again")
+	retry.ClientMsgID = clientMsgID("c1")
+	if err := f.hub.SendMessage(ctx, retry); err != nil {
+		t.Fatalf("SendMessage(retry): %v", err)
+	}
+	if retry.ID != first.ID || retry.Content != "dm" {
+		t.Errorf("retry = id %d %q, want id %d %q", retry.ID, retry.Content, first.ID, "dm")
+	}
+	roomRetry := newTextMessage(2, 0, 1, "room again")
+	roomRetry.ClientMsgID = clientMsgID("c2")
+	if err := f.hub.BroadcastToRoom(ctx, 1, roomRetry); err != nil {
+		t.Fatalf("BroadcastToRoom(retry): %v", err)
+	}
+	if roomRetry.ID != room.ID {
+		t.Errorf("room retry id = %d, want %d", roomRetry.ID, room.ID)
+	}
+	expectNoMessage(t, phone)
+}
@@ -2,36 +2,116 @@ package service
 
 import (
 	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/wire"
+	"gorm.io/gorm"
 
+	"github.com/Gopher0727/RTMP/config"
 	"github.com/Gopher0727/RTMP/internal/model"
 	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
 )
 
+// 消息历史的键集分页参数和结果
+type (
+	MessageCursor = repository.MessageCursor
+	MessagePage   = repository.MessagePage
+)
+
+// SearchParams 消息搜索参数
+type SearchParams struct {
+	Text     string    // 搜索文本，按空白拆分为关键词，消息须包含全部关键词
+	SenderID uint      // 发送者，为0时不限
+	RoomID   uint      // 房间，为0时搜索用户所在的全部房间和私聊
+	Since    time.Time // 发送时间下限（含），为零值时不限
+	Until    time.Time // 发送时间上限（不含），为零值时不限
+	Page     int
+	Size     int
+}
+
+// SearchResult 消息搜索结果
+type SearchResult struct {
+	Message *model.Message `json:"message"`
+	Snippet string         `json:"snippet"` // 高亮片段，已做HTML转义，关键词用<mark>标出
+}
+
 // IMessageService 消息服务接口
 type IMessageService interface {
 	SendMessage(ctx context.Context, message *model.Message) error
 	GetUserMessages(ctx context.Context, userID uint, page, size int) ([]*model.Message, int64, error)
 	GetRoomMessages(ctx context.Context, roomID uint, page, size int) ([]*model.Message, int64, error)
-	MarkAsRead(ctx context.Context, messageIDs []uint) error
+	GetMessagesByCursor(ctx context.Context, userID uint, target model.MessageTarget, targetID uint, cursor MessageCursor, withTotal bool) (*MessagePage, int64, error)
+	MarkAsRead(ctx context.Context, userID uint, messageIDs []uint) ([]*model.ReadCursor, error)
+	MarkConversationRead(ctx context.Context, userID uint, conversationID string, messageID uint) (*model.ReadCursor, error)
+	GetUnreadCounts(ctx context.Context, userID uint) ([]*model.UnreadCount, error)
+	EditMessage(ctx context.Context, operatorID, messageID uint, content string) (*model.Message, error)
+	RecallMessage(ctx context.Context, operatorID, messageID uint) (*model.Message, error)
+	GetMessageEdits(ctx context.Context, userID, messageID uint) ([]*model.MessageEdit, error)
+	GetThread(ctx context.Context, userID, messageID uint, page, size int) (*model.Message, []*model.Message, int64, error)
+	AddReaction(ctx context.Context, userID, messageID uint, emoji string) (*model.Message, bool, error)
+	RemoveReaction(ctx context.Context, userID, messageID uint, emoji string) (*model.Message, bool, error)
+	SearchMessages(ctx context.Context, userID uint, params SearchParams) ([]*SearchResult, int64, error)
+	ExpireAfter(message *model.Message, ttlSeconds int) error
 }
 
+const (
+	defaultEditWindow = 2 * time.Minute     // 默认的编辑、撤回时间窗口
+	defaultMaxTTL     = 30 * 24 * time.Hour // 默认的消息最大有效期
+	maxEmojiLength    = 32                  // 表情的最大字节数，与数据库字段长度一致
+)
+
 // MessageService 消息服务实现
 type MessageService struct {
-	messageRepo repository.IMessageRepository
-	roomRepo    repository.IRoomRepository
+	messageRepo  repository.IMessageRepository
+	roomRepo     repository.IRoomRepository
+	reactionRepo repository.IReactionRepository
+	searchIndex  search.SearchIndex
+	editWindow   time.Duration
+	maxTTL       time.Duration
 }
 
 // NewMessageService 创建消息服务
-func NewMessageService(messageRepo repository.IMessageRepository, roomRepo repository.IRoomRepository) IMessageService {
+func NewMessageService(
+	cfg *config.Config,
+	messageRepo repository.IMessageRepository,
+	roomRepo repository.IRoomRepository,
+	reactionRepo repository.IReactionRepository,
+	searchIndex search.SearchIndex,
+) IMessageService {
 	return &MessageService{
-		messageRepo: messageRepo,
-		roomRepo:    roomRepo,
+		messageRepo:  messageRepo,
+		roomRepo:     roomRepo,
+		reactionRepo: reactionRepo,
+		searchIndex:  searchIndex,
+		editWindow:   secondsOr(cfg.Message.EditWindowSeconds, defaultEditWindow),
+		maxTTL:       secondsOr(cfg.Message.MaxTTLSeconds, defaultMaxTTL),
+	}
+}
+
+// ExpireAfter 设置消息发送后的有效期（秒），0表示不过期；房间设置了更短的有效期时以房间为准
+func (s *MessageService) ExpireAfter(message *model.Message, ttlSeconds int) error {
+	if err := checkTTL(ttlSeconds, s.maxTTL); err != nil {
+		return err
 	}
+	message.ExpireAfter(time.Duration(ttlSeconds) * time.Second)
+	return nil
 }
 
-// SendMessage 发送消息
+// checkTTL 校验有效期不为负数且不超过最大值
+func checkTTL(ttlSeconds int, maxTTL time.Duration) error {
+	if ttlSeconds < 0 || time.Duration(ttlSeconds)*time.Second > maxTTL {
+		return ErrInvalidTTL
+	}
+	return nil
+}
+
+// SendMessage 发送消息，发送者已用相同的客户端消息ID发送过消息时不再创建，message被替换为原消息
 func (s *MessageService) SendMessage(ctx context.Context, message *model.Message) error {
 	// 如果是房间消息，验证发送者是否是房间成员
 	if message.TargetType == model.MessageTargetRoom {
@@ -44,22 +124,441 @@ func (s *MessageService) SendMessage(ctx context.Context, message *model.Message
 		}
 	}
 
-	return s.messageRepo.Create(ctx, message)
+	if err := s.messageRepo.Create(ctx, message); err != nil {
+		if errors.Is(err, repository.ErrDuplicateMessage) {
+			return nil
+		}
+		return err
+	}
+	indexMessage(ctx, s.searchIndex, message)
+	return nil
 }
 
 // GetUserMessages 获取用户消息
 func (s *MessageService) GetUserMessages(ctx context.Context, userID uint, page, size int) ([]*model.Message, int64, error) {
-	return s.messageRepo.GetUserMessages(ctx, userID, page, size)
+	messages, total, err := s.messageRepo.GetUserMessages(ctx, userID, page, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.fillDetails(ctx, messages); err != nil {
+		return nil, 0, err
+	}
+	return messages, total, nil
 }
 
-// GetRoomMessages 获取房间消息
+// GetRoomMessages 获取房间消息，附带话题回复数
 func (s *MessageService) GetRoomMessages(ctx context.Context, roomID uint, page, size int) ([]*model.Message, int64, error) {
-	return s.messageRepo.GetRoomMessages(ctx, roomID, page, size)
+	messages, total, err := s.messageRepo.GetRoomMessages(ctx, roomID, page, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.fillDetails(ctx, messages); err != nil {
+		return nil, 0, err
+	}
+	return messages, total, nil
+}
+
+// GetMessagesByCursor 按键集分页获取发给用户或房间的消息，withTotal为true时同时统计消息总数
+// 房间消息仅房间成员可查看，发给用户的消息仅该用户本人可查看
+func (s *MessageService) GetMessagesByCursor(ctx context.Context, userID uint, target model.MessageTarget, targetID uint, cursor MessageCursor, withTotal bool) (*MessagePage, int64, error) {
+	switch target {
+	case model.MessageTargetRoom:
+		isMember, err := s.roomRepo.IsMember(ctx, targetID, userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !isMember {
+			return nil, 0, ErrNotRoomMember
+		}
+	case model.MessageTargetUser:
+		if targetID != userID {
+			return nil, 0, ErrPermissionDenied
+		}
+	default:
+		return nil, 0, ErrInvalidOperation
+	}
+
+	page, err := s.messageRepo.GetMessagesByCursor(ctx, target, targetID, cursor)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.fillDetails(ctx, page.Messages); err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if withTotal {
+		if total, err = s.messageRepo.CountMessages(ctx, target, targetID); err != nil {
+			return nil, 0, err
+		}
+	}
+	return page, total, nil
+}
+
+// GetThread 获取话题的根消息和分页的回复，messageID为话题中的回复时返回其所在的话题
+func (s *MessageService) GetThread(ctx context.Context, userID, messageID uint, page, size int) (*model.Message, []*model.Message, int64, error) {
+	root, err := s.getMessage(ctx, messageID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if root.ThreadRootID != 0 {
+		if root, err = s.getMessage(ctx, root.ThreadRootID); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if err := s.checkParticipant(ctx, userID, root); err != nil {
+		return nil, nil, 0, err
+	}
+
+	replies, total, err := s.messageRepo.GetThread(ctx, root.ID, page, size)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if err := s.fillDetails(ctx, replies); err != nil {
+		return nil, nil, 0, err
+	}
+	root.ReplyCount = total
+	return root, replies, total, nil
 }
 
-// MarkAsRead 标记消息为已读
-func (s *MessageService) MarkAsRead(ctx context.Context, messageIDs []uint) error {
-	return s.messageRepo.MarkAsRead(ctx, messageIDs)
+// fillDetails 补全消息的话题信息和表情回应统计：根消息的回复数、回复消息的引用预览
+func (s *MessageService) fillDetails(ctx context.Context, messages []*model.Message) error {
+	var ids, rootIDs, quoteIDs []uint
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+		if message.ThreadRootID == 0 {
+			rootIDs = append(rootIDs, message.ID)
+		}
+		if message.ReplyToID != 0 {
+			quoteIDs = append(quoteIDs, message.ReplyToID)
+		}
+	}
+
+	counts, err := s.messageRepo.CountReplies(ctx, rootIDs)
+	if err != nil {
+		return err
+	}
+	// 已撤回的消息不会返回，对应的引用预览为空
+	quoted, err := s.messageRepo.GetByIDs(ctx, quoteIDs)
+	if err != nil {
+		return err
+	}
+	quotes := make(map[uint]*model.MessageQuote, len(quoted))
+	for _, message := range quoted {
+		quotes[message.ID] = message.Quote()
+	}
+	reactions, err := s.reactionRepo.CountByMessages(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		message.ReplyCount = counts[message.ID]
+		message.Reactions = reactions[message.ID]
+		if message.ReplyToID != 0 {
+			message.ReplyTo = quotes[message.ReplyToID]
+		}
+	}
+	return nil
+}
+
+// MarkAsRead 将用户在消息所在会话中的已读位置推进到这些消息，返回推进后的已读位置
+// 用户只能标记自己参与的会话中的消息，收到的私聊消息同时标记为已读
+func (s *MessageService) MarkAsRead(ctx context.Context, userID uint, messageIDs []uint) ([]*model.ReadCursor, error) {
+	messages, err := s.messageRepo.GetByIDs(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]uint)
+	var conversationIDs []string
+	for _, message := range messages {
+		message.Normalize()
+		if err := s.checkParticipant(ctx, userID, message); err != nil {
+			return nil, err
+		}
+		if _, ok := latest[message.ConversationID]; !ok {
+			conversationIDs = append(conversationIDs, message.ConversationID)
+		}
+		latest[message.ConversationID] = max(latest[message.ConversationID], message.ID)
+	}
+
+	if err := s.messageRepo.MarkAsRead(ctx, userID, messageIDs); err != nil {
+		return nil, err
+	}
+	cursors := make([]*model.ReadCursor, 0, len(conversationIDs))
+	for _, conversationID := range conversationIDs {
+		cursor, err := s.messageRepo.MarkRead(ctx, userID, conversationID, latest[conversationID])
+		if err != nil {
+			return nil, err
+		}
+		cursors = append(cursors, cursor)
+	}
+	return cursors, nil
+}
+
+// MarkConversationRead 将用户在会话中的已读位置推进到messageID，messageID为0时标记会话中的全部消息为已读
+func (s *MessageService) MarkConversationRead(ctx context.Context, userID uint, conversationID string, messageID uint) (*model.ReadCursor, error) {
+	// 所有用户都是全员广播会话的参与者
+	if conversationID != model.BroadcastConversationID {
+		if _, _, err := checkConversation(ctx, s.roomRepo, userID, conversationID); err != nil {
+			return nil, err
+		}
+	}
+
+	if messageID == 0 {
+		latestID, err := s.messageRepo.GetLatestID(ctx, conversationID)
+		if err != nil {
+			return nil, err
+		}
+		messageID = latestID
+	} else {
+		message, err := s.getMessage(ctx, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if message.ConversationID != conversationID {
+			return nil, ErrMessageNotFound
+		}
+	}
+
+	return s.messageRepo.MarkRead(ctx, userID, conversationID, messageID)
+}
+
+// GetUnreadCounts 获取用户在所在房间、私聊会话和全员广播中的未读消息数
+func (s *MessageService) GetUnreadCounts(ctx context.Context, userID uint) ([]*model.UnreadCount, error) {
+	roomIDs, err := s.roomRepo.GetUserRoomIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.messageRepo.CountUnread(ctx, userID, roomIDs)
+}
+
+// EditMessage 编辑消息内容，保存编辑历史
+func (s *MessageService) EditMessage(ctx context.Context, operatorID, messageID uint, content string) (*model.Message, error) {
+	message, err := s.getMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkModify(ctx, operatorID, message); err != nil {
+		return nil, err
+	}
+	if message.Content == content {
+		return message, nil
+	}
+
+	if err := s.messageRepo.Edit(ctx, message, content, operatorID); err != nil {
+		return nil, err
+	}
+	indexMessage(ctx, s.searchIndex, message)
+	return message, nil
+}
+
+// RecallMessage 撤回消息，返回被撤回的消息
+func (s *MessageService) RecallMessage(ctx context.Context, operatorID, messageID uint) (*model.Message, error) {
+	message, err := s.getMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkModify(ctx, operatorID, message); err != nil {
+		return nil, err
+	}
+
+	if err := s.messageRepo.Recall(ctx, message.ID); err != nil {
+		return nil, err
+	}
+	if err := s.searchIndex.Remove(ctx, message.ID); err != nil {
+		log.Printf("Failed to remove message %d from search index: %v", message.ID, err)
+	}
+	return message, nil
+}
+
+// GetMessageEdits 获取消息的编辑历史，仅会话参与者可查看
+func (s *MessageService) GetMessageEdits(ctx context.Context, userID, messageID uint) ([]*model.MessageEdit, error) {
+	message, err := s.getMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkParticipant(ctx, userID, message); err != nil {
+		return nil, err
+	}
+
+	return s.messageRepo.GetEdits(ctx, message.ID)
+}
+
+// AddReaction 添加表情回应，返回更新了回应统计的消息及是否新增
+func (s *MessageService) AddReaction(ctx context.Context, userID, messageID uint, emoji string) (*model.Message, bool, error) {
+	return s.react(ctx, userID, messageID, emoji, func() (bool, error) {
+		return s.reactionRepo.Add(ctx, &model.Reaction{MessageID: messageID, UserID: userID, Emoji: emoji})
+	})
+}
+
+// RemoveReaction 移除表情回应，返回更新了回应统计的消息及是否移除
+func (s *MessageService) RemoveReaction(ctx context.Context, userID, messageID uint, emoji string) (*model.Message, bool, error) {
+	return s.react(ctx, userID, messageID, emoji, func() (bool, error) {
+		return s.reactionRepo.Remove(ctx, messageID, userID, emoji)
+	})
+}
+
+// react 校验表情和会话参与者后修改表情回应
+func (s *MessageService) react(ctx context.Context, userID, messageID uint, emoji string, modify func() (bool, error)) (*model.Message, bool, error) {
+	if !validEmoji(emoji) {
+		return nil, false, ErrInvalidReaction
+	}
+	message, err := s.getMessage(ctx, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.checkParticipant(ctx, userID, message); err != nil {
+		return nil, false, err
+	}
+
+	changed, err := modify()
+	if err != nil {
+		return nil, false, err
+	}
+	reactions, err := s.reactionRepo.CountByMessages(ctx, []uint{message.ID})
+	if err != nil {
+		return nil, false, err
+	}
+	message.Reactions = reactions[message.ID]
+	return message, changed, nil
+}
+
+// SearchMessages 在用户参与的会话中搜索消息，按发送时间倒序返回消息及高亮片段
+func (s *MessageService) SearchMessages(ctx context.Context, userID uint, params SearchParams) ([]*SearchResult, int64, error) {
+	terms := search.ParseTerms(params.Text)
+	if len(terms) == 0 {
+		return nil, 0, ErrInvalidOperation
+	}
+
+	query := search.Query{
+		Terms:    terms,
+		SenderID: params.SenderID,
+		Since:    params.Since,
+		Until:    params.Until,
+		Offset:   (params.Page - 1) * params.Size,
+		Limit:    params.Size,
+	}
+	if params.RoomID != 0 {
+		isMember, err := s.roomRepo.IsMember(ctx, params.RoomID, userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !isMember {
+			return nil, 0, ErrNotRoomMember
+		}
+		query.RoomIDs = []uint{params.RoomID}
+	} else {
+		roomIDs, err := s.roomRepo.GetUserRoomIDs(ctx, userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		query.RoomIDs = roomIDs
+		query.DirectUserID = userID
+	}
+
+	hits, total, err := s.searchIndex.Search(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.MessageID
+	}
+	messages, err := s.messageRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.fillDetails(ctx, messages); err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[uint]*model.Message, len(messages))
+	for _, message := range messages {
+		message.Normalize()
+		byID[message.ID] = message
+	}
+
+	// 按命中顺序返回，跳过搜索期间被撤回的消息
+	results := make([]*SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		if message, ok := byID[hit.MessageID]; ok {
+			results = append(results, &SearchResult{Message: message, Snippet: hit.Snippet})
+		}
+	}
+	return results, total, nil
+}
+
+// indexMessage 将消息写入搜索索引，失败时只记录日志，不影响消息发送
+func indexMessage(ctx context.Context, index search.SearchIndex, message *model.Message) {
+	if err := index.Index(ctx, message); err != nil {
+		log.Printf("Failed to index message %d: %v", message.ID, err)
+	}
+}
+
+// validEmoji 检查表情：非空、不超过长度限制且不含空白和控制字符
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	return strings.IndexFunc(emoji, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) < 0
+}
+
+// checkParticipant 检查用户是否是消息所在会话的参与者
+func (s *MessageService) checkParticipant(ctx context.Context, userID uint, message *model.Message) error {
+	switch message.TargetType {
+	case model.MessageTargetRoom:
+		isMember, err := s.roomRepo.IsMember(ctx, message.RoomID, userID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotRoomMember
+		}
+	case model.MessageTargetUser:
+		if message.SenderID != userID && message.ReceiverID != userID {
+			return ErrMessageNotFound
+		}
+	case model.MessageTargetAll:
+		// 所有用户都是全员广播会话的参与者
+	}
+	return nil
+}
+
+// getMessage 获取消息，不存在或已撤回时返回ErrMessageNotFound
+func (s *MessageService) getMessage(ctx context.Context, messageID uint) (*model.Message, error) {
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	message.Normalize()
+	return message, nil
+}
+
+// checkModify 检查用户能否编辑或撤回消息：房间管理员不受限制，发送者只能在时间窗口内操作
+func (s *MessageService) checkModify(ctx context.Context, operatorID uint, message *model.Message) error {
+	if message.TargetType == model.MessageTargetRoom {
+		role, err := s.roomRepo.GetMemberRole(ctx, message.RoomID, operatorID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && role >= model.RoomRoleAdmin {
+			return nil
+		}
+	}
+
+	if message.SenderID != operatorID {
+		return ErrNotMessageSender
+	}
+	if time.Since(message.CreatedAt) > s.editWindow {
+		return ErrEditWindowExpired
+	}
+	return nil
 }
 
 // MessageServiceSet 消息服务依赖注入
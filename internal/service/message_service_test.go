@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
+)
+
+// searchFixture 用户1、2在房间1，用户2、3在房间2，消息写入SQLite并索引到内存搜索索引
+type searchFixture struct {
+	db       *gorm.DB
+	service  IMessageService
+	messages map[string]*model.Message
+	start    time.Time
+}
+
+func newSearchFixture(t *testing.T) *searchFixture {
+	t.Helper()
+	ctx := context.Background()
+	db := newTestDB(t)
+	createReactionsTable(t, db)
+
+	for _, member := range []model.RoomMember{{RoomID: 1, UserID: 1}, {RoomID: 1, UserID: 2}, {RoomID: 2, UserID: 2}, {RoomID: 2, UserID: 3}} {
+		if err := db.Create(&member).Error; err != nil {
+			t.Fatalf("create room member: %v", err)
+		}
+	}
+
+	f := &searchFixture{
+		db: db,
+		service: NewMessageService(
+			&config.Config{},
+			repository.NewMessageRepository(db),
+			repository.NewRoomRepository(db),
+			repository.NewReactionRepository(db),
+			search.NewMemoryIndex(),
+		),
+		messages: make(map[string]*model.Message),
+		start:    time.Now().Add(-time.Hour),
+	}
+	for _, msg := range []struct {
+		name     string
+		sender   uint
+		receiver uint
+		room     uint
+		content  string
+	}{
+		{"room1", 1, 0, 1, "项目周会 meeting at 10"},
+		{"room2", 2, 0, 2, "Meeting notes for room 2"},
+		{"dm12", 2, 1, 0, "明天的meeting改到下午"},
+		{"dm23", 3, 2, 0, "private meeting 🤫"},
+		{"room1-emoji", 2, 0, 1, "👍 会议纪要已上传"},
+	} {
+		message := &model.Message{
+			SenderID:   msg.sender,
+			ReceiverID: msg.receiver,
+			RoomID:     msg.room,
+			Content:    msg.content,
+			Type:       string(model.MessageTypeText),
+		}
+		message.Normalize()
+		if err := f.service.SendMessage(ctx, message); err != nil {
+			t.Fatalf("SendMessage(%s): %v", msg.name, err)
+		}
+		f.messages[msg.name] = message
+	}
+	return f
+}
+
+// createReactionsTable reactions表的emoji列使用MySQL的字符集语法，在SQLite中手动建表
+func createReactionsTable(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	if err := db.Exec(`CREATE TABLE reactions (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		message_id integer NOT NULL,
+		user_id integer NOT NULL,
+		emoji varchar(32) NOT NULL,
+		created_at datetime,
+		UNIQUE (message_id, user_id, emoji)
+	)`).Error; err != nil {
+		t.Fatalf("create reactions: %v", err)
+	}
+}
+
+// ids 将消息名转换为消息ID
+func (f *searchFixture) ids(names ...string) []uint {
+	ids := make([]uint, len(names))
+	for i, name := range names {
+		ids[i] = f.messages[name].ID
+	}
+	return ids
+}
+
+func TestSearchMessages(t *testing.T) {
+	f := newSearchFixture(t)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		userID uint
+		params SearchParams
+		want   []string
+		total  int64
+	}{
+		{"rooms and direct messages", 1, SearchParams{Text: "meeting"}, []string{"dm12", "room1"}, 2},
+		{"member of both rooms", 2, SearchParams{Text: "MEETING"}, []string{"dm23", "dm12", "room2", "room1"}, 4},
+		{"other users' direct messages hidden", 3, SearchParams{Text: "meeting"}, []string{"dm23", "room2"}, 2},
+		{"single room", 2, SearchParams{Text: "meeting", RoomID: 2}, []string{"room2"}, 1},
+		{"sender", 2, SearchParams{Text: "meeting", SenderID: 2}, []string{"dm12", "room2"}, 2},
+		{"cjk", 1, SearchParams{Text: "会议"}, []string{"room1-emoji"}, 1},
+		{"cjk and latin terms", 2, SearchParams{Text: "明天　meeting"}, []string{"dm12"}, 1},
+		{"emoji", 3, SearchParams{Text: "🤫"}, []string{"dm23"}, 1},
+		{"since", 1, SearchParams{Text: "meeting", Since: future}, []string{}, 0},
+		{"until", 1, SearchParams{Text: "meeting", Since: f.start, Until: future}, []string{"dm12", "room1"}, 2},
+		{"first page", 2, SearchParams{Text: "meeting", Page: 1, Size: 3}, []string{"dm23", "dm12", "room2"}, 4},
+		{"second page", 2, SearchParams{Text: "meeting", Page: 2, Size: 3}, []string{"room1"}, 4},
+		{"no match", 1, SearchParams{Text: "absent"}, []string{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.params.Page == 0 {
+				tt.params.Page, tt.params.Size = 1, 20
+			}
+			results, total, err := f.service.SearchMessages(context.Background(), tt.userID, tt.params)
+			if err != nil {
+				t.Fatalf("SearchMessages: %v", err)
+			}
+			got := make([]uint, len(results))
+			for i, result := range results {
+				got[i] = result.Message.ID
+			}
+			if want := f.ids(tt.want...); !slices.Equal(got, want) {
+				t.Errorf("results = %v, want %v", got, want)
+			}
+			if total != tt.total {
+				t.Errorf("total = %d, want %d", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestSearchMessagesSnippet(t *testing.T) {
+	f := newSearchFixture(t)
+
+	results, _, err := f.service.SearchMessages(context.Background(), 1, SearchParams{Text: "meeting 明天", Page: 1, Size: 20})
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if want := "<mark>明天</mark>的<mark>meeting</mark>改到下午"; results[0].Snippet != want {
+		t.Errorf("snippet = %q, want %q", results[0].Snippet, want)
+	}
+	if message := results[0].Message; message.ConversationID != model.DirectConversationID(1, 2) || message.Content != "明天的meeting改到下午" {
+		t.Errorf("message = %+v", message)
+	}
+}
+
+func TestSearchMessagesErrors(t *testing.T) {
+	f := newSearchFixture(t)
+
+	tests := []struct {
+		name   string
+		userID uint
+		params SearchParams
+		want   error
+	}{
+		{"empty text", 1, SearchParams{Text: ""}, ErrInvalidOperation},
+		{"only whitespace", 1, SearchParams{Text: " \t　"}, ErrInvalidOperation},
+		{"not a member", 1, SearchParams{Text: "meeting", RoomID: 2}, ErrNotRoomMember},
+		{"unknown room", 1, SearchParams{Text: "meeting", RoomID: 42}, ErrNotRoomMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params.Page, tt.params.Size = 1, 20
+			if _, _, err := f.service.SearchMessages(context.Background(), tt.userID, tt.params); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessagesRecalled(t *testing.T) {
+	f := newSearchFixture(t)
+	ctx := context.Background()
+	params := SearchParams{Text: "meeting", Page: 1, Size: 20}
+
+	if _, err := f.service.RecallMessage(ctx, 1, f.messages["room1"].ID); err != nil {
+		t.Fatalf("RecallMessage: %v", err)
+	}
+	// 模拟搜索期间被撤回：数据库中已删除，索引中仍存在，命中计入总数但不返回
+	if err := f.db.Delete(&model.Message{}, f.messages["dm12"].ID).Error; err != nil {
+		t.Fatalf("delete message: %v", err)
+	}
+
+	results, total, err := f.service.SearchMessages(ctx, 2, params)
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	got := make([]uint, len(results))
+	for i, result := range results {
+		got[i] = result.Message.ID
+	}
+	if want := f.ids("dm23", "room2"); !slices.Equal(got, want) || total != 3 {
+		t.Errorf("results = %v (total %d), want %v (total 3)", got, total, want)
+	}
+}
+
+func TestGetMessagesByCursor(t *testing.T) {
+	f := newSearchFixture(t)
+
+	tests := []struct {
+		name      string
+		target    model.MessageTarget
+		targetID  uint
+		want      []string
+		wantTotal int64
+		wantErr   error
+	}{
+		{"room member", model.MessageTargetRoom, 1, []string{"room1-emoji", "room1"}, 2, nil},
+		{"own messages", model.MessageTargetUser, 1, []string{"dm12"}, 1, nil},
+		{"not a member", model.MessageTargetRoom, 2, nil, 0, ErrNotRoomMember},
+		{"other user", model.MessageTargetUser, 2, nil, 0, ErrPermissionDenied},
+		{"broadcast", model.MessageTargetAll, 0, nil, 0, ErrInvalidOperation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, total, err := f.service.GetMessagesByCursor(context.Background(), 1, tt.target, tt.targetID, MessageCursor{Limit: 20}, true)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := make([]uint, len(page.Messages))
+			for i, message := range page.Messages {
+				got[i] = message.ID
+			}
+			if want := f.ids(tt.want...); !slices.Equal(got, want) || total != tt.wantTotal {
+				t.Errorf("messages = %v (total %d), want %v (total %d)", got, total, want, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestSendMessageRetryReturnsOriginal(t *testing.T) {
+	f := newSearchFixture(t)
+	ctx := context.Background()
+	clientMsgID := "c1"
+
+	send := func(content string) *model.Message {
+		message := &model.Message{
+			SenderID:    1,
+			RoomID:      1,
+			Content:     content,
+			Type:        string(model.MessageTypeText),
+			ClientMsgID: &clientMsgID,
+		}
+		message.Normalize()
+		if err := f.service.SendMessage(ctx, message); err != nil {
+			t.Fatalf("SendMessage(%s): %v", content, err)
+		}
+		return message
+	}
+	original := send("retrospective notes")
+	retry := send("retrospective notes v2")
+	if retry.ID != original.ID || retry.Content != original.Content {
+		t.Errorf("retry = id %d %q, want id %d %q", retry.ID, retry.Content, original.ID, original.Content)
+	}
+
+	// 重试的消息不再写入搜索索引
+	results, total, err := f.service.SearchMessages(ctx, 1, SearchParams{Text: "retrospective", Page: 1, Size: 20})
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if total != 1 || len(results) != 1 || results[0].Message.ID != original.ID {
+		t.Errorf("search results = %d (total %d), want only the original", len(results), total)
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/db"
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+const (
+	defaultOfflineMaxLength = 1000
+	defaultOfflineTTL       = 7 * 24 * time.Hour
+)
+
+// removeOfflineScript 从离线队列中删除指定ID的消息，返回删除的条数
+// KEYS[1] 离线队列键，ARGV 消息ID
+var removeOfflineScript = redis.NewScript(`
+local ids = {}
+for _, id in ipairs(ARGV) do
+	ids[id] = true
+end
+local removed = 0
+for _, item in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	local ok, message = pcall(cjson.decode, item)
+	if ok and type(message) == 'table' and message['id'] and ids[tostring(message['id'])] then
+		removed = removed + redis.call('LREM', KEYS[1], 0, item)
+	end
+end
+return removed
+`)
+
+// requeueOfflineScript 将未能推送的消息放回离线队列尾部，只放回队列剩余容量能容纳的较新的消息，不挤掉队列中已有的消息；
+// 同时将离线起点设为放回的最旧的消息，未放回的消息在下次取出时从数据库补齐，返回放回的条数
+// KEYS[1] 离线队列键，KEYS[2] 离线起点键，ARGV[1] 队列最大长度，ARGV[2] 过期秒数，ARGV[3] 离线起点消息ID，ARGV[4...] 从新到旧的消息
+var requeueOfflineScript = redis.NewScript(`
+local free = tonumber(ARGV[1]) - redis.call('LLEN', KEYS[1])
+local n = math.min(free, #ARGV - 3)
+for i = 4, 3 + n do
+	redis.call('RPUSH', KEYS[1], ARGV[i])
+end
+redis.call('SET', KEYS[2], ARGV[3], 'EX', ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return math.max(n, 0)
+`)
+
+// IOfflineService 离线消息服务接口
+type IOfflineService interface {
+	Push(ctx context.Context, userID uint, message *model.Message) error
+	Drain(ctx context.Context, userID uint) ([]*model.Message, uint, error)
+	Requeue(ctx context.Context, userID uint, messages []*model.Message) error
+	Remove(ctx context.Context, userID uint, messageIDs []uint) error
+}
+
+// OfflineService 基于Redis列表的离线消息服务
+// 新消息LPUSH到队首，LTRIM限制长度，队尾是最旧的消息；
+// 同时记录用户离线期间第一条消息的ID，队列被截断时据此从数据库补齐。
+type OfflineService struct {
+	rdb       *redis.Client
+	maxLength int64
+	ttl       time.Duration
+}
+
+// NewOfflineService 创建离线消息服务
+func NewOfflineService(cfg *config.Config) IOfflineService {
+	s := &OfflineService{
+		rdb:       db.GetRedisMessage(),
+		maxLength: int64(cfg.Offline.MaxLength),
+		ttl:       time.Duration(cfg.Offline.TTLHours) * time.Hour,
+	}
+	if s.maxLength <= 0 {
+		s.maxLength = defaultOfflineMaxLength
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultOfflineTTL
+	}
+	return s
+}
+
+// offlineQueueKey 离线消息队列键
+func offlineQueueKey(userID uint) string {
+	return fmt.Sprintf("offline:msg:%d", userID)
+}
+
+// offlineSinceKey 离线期间第一条消息ID的键
+func offlineSinceKey(userID uint) string {
+	return fmt.Sprintf("offline:since:%d", userID)
+}
+
+// Push 将消息写入用户的离线队列
+func (s *OfflineService) Push(ctx context.Context, userID uint, message *model.Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	key, sinceKey := offlineQueueKey(userID), offlineSinceKey(userID)
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetNX(ctx, sinceKey, message.ID, s.ttl)
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, s.maxLength-1)
+		pipe.Expire(ctx, key, s.ttl)
+		pipe.Expire(ctx, sinceKey, s.ttl)
+		return nil
+	})
+	return err
+}
+
+// Drain 取出并清空用户的离线队列，消息按从旧到新排列
+// 同时返回离线期间第一条消息的ID，没有记录时为0
+func (s *OfflineService) Drain(ctx context.Context, userID uint) ([]*model.Message, uint, error) {
+	key, sinceKey := offlineQueueKey(userID), offlineSinceKey(userID)
+
+	var items *redis.StringSliceCmd
+	var since *redis.StringCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		items = pipe.LRange(ctx, key, 0, -1)
+		since = pipe.Get(ctx, sinceKey)
+		pipe.Del(ctx, key, sinceKey)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+
+	var sinceID uint
+	if id, err := since.Uint64(); err == nil {
+		sinceID = uint(id)
+	}
+
+	// 队首是最新的消息，倒序遍历得到从旧到新的顺序
+	values := items.Val()
+	messages := make([]*model.Message, 0, len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		var message model.Message
+		if err := json.Unmarshal([]byte(values[i]), &message); err != nil {
+			log.Printf("Failed to unmarshal offline message for user %d: %v", userID, err)
+			continue
+		}
+		messages = append(messages, &message)
+	}
+	return messages, sinceID, nil
+}
+
+// Requeue 将未能推送的消息放回离线队列尾部，messages需按从旧到新排列
+// 队列容量不足时只放回较新的消息，较旧的消息在下次取出时按离线起点从数据库补齐
+func (s *OfflineService) Requeue(ctx context.Context, userID uint, messages []*model.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	// 放回的消息比队列中已有的消息都旧，从新到旧追加到队尾
+	args := make([]any, 0, len(messages)+3)
+	args = append(args, s.maxLength, int64(s.ttl/time.Second), messages[0].ID)
+	for i := len(messages) - 1; i >= 0; i-- {
+		data, err := json.Marshal(messages[i])
+		if err != nil {
+			return err
+		}
+		args = append(args, data)
+	}
+
+	keys := []string{offlineQueueKey(userID), offlineSinceKey(userID)}
+	requeued, err := requeueOfflineScript.Run(ctx, s.rdb, keys, args...).Int()
+	if err != nil {
+		return err
+	}
+	if requeued < len(messages) {
+		log.Printf("Offline queue of user %d is full, %d messages will be reloaded from database", userID, len(messages)-requeued)
+	}
+	return nil
+}
+
+// Remove 从用户的离线队列中删除消息，用于消息过期时
+func (s *OfflineService) Remove(ctx context.Context, userID uint, messageIDs []uint) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	return removeOfflineScript.Run(ctx, s.rdb, []string{offlineQueueKey(userID)}, args...).Err()
+}
+
+// OfflineServiceSet 离线消息服务依赖注入
+var OfflineServiceSet = wire.NewSet(NewOfflineService)
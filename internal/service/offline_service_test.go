@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+)
+
+func newTestOfflineService(t *testing.T, maxLength int64) (*OfflineService, *miniredis.Miniredis) {
+	t.Helper()
+	mr, rdb := newTestRedis(t)
+	return &OfflineService{rdb: rdb, maxLength: maxLength, ttl: defaultOfflineTTL}, mr
+}
+
+// offlineMessages 按ID创建离线消息
+func offlineMessages(ids ...uint) []*model.Message {
+	messages := make([]*model.Message, len(ids))
+	for i, id := range ids {
+		messages[i] = &model.Message{ID: id, SenderID: 2, ReceiverID: 1, Content: "hi"}
+	}
+	return messages
+}
+
+// messageIDs 返回消息ID列表
+func messageIDs(messages []*model.Message) []uint {
+	ids := make([]uint, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+func pushOffline(t *testing.T, s *OfflineService, ids ...uint) {
+	t.Helper()
+	for _, message := range offlineMessages(ids...) {
+		if err := s.Push(context.Background(), 1, message); err != nil {
+			t.Fatalf("Push(%d): %v", message.ID, err)
+		}
+	}
+}
+
+func drainOffline(t *testing.T, s *OfflineService) ([]uint, uint) {
+	t.Helper()
+	messages, sinceID, err := s.Drain(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	return messageIDs(messages), sinceID
+}
+
+func TestOfflinePushDrain(t *testing.T) {
+	s, _ := newTestOfflineService(t, 10)
+	pushOffline(t, s, 1, 2, 3)
+
+	ids, sinceID := drainOffline(t, s)
+	if !slices.Equal(ids, []uint{1, 2, 3}) || sinceID != 1 {
+		t.Errorf("Drain() = %v, since %d, want [1 2 3], since 1", ids, sinceID)
+	}
+
+	// 取出后队列和离线起点都被清空
+	ids, sinceID = drainOffline(t, s)
+	if len(ids) != 0 || sinceID != 0 {
+		t.Errorf("second Drain() = %v, since %d, want empty", ids, sinceID)
+	}
+}
+
+func TestOfflinePushTruncates(t *testing.T) {
+	s, _ := newTestOfflineService(t, 2)
+	pushOffline(t, s, 1, 2, 3)
+
+	// 最旧的消息被截断，离线起点仍是第一条消息
+	ids, sinceID := drainOffline(t, s)
+	if !slices.Equal(ids, []uint{2, 3}) || sinceID != 1 {
+		t.Errorf("Drain() = %v, since %d, want [2 3], since 1", ids, sinceID)
+	}
+}
+
+func TestOfflineExpires(t *testing.T) {
+	s, mr := newTestOfflineService(t, 10)
+	pushOffline(t, s, 1)
+	mr.FastForward(defaultOfflineTTL)
+
+	ids, sinceID := drainOffline(t, s)
+	if len(ids) != 0 || sinceID != 0 {
+		t.Errorf("Drain() after TTL = %v, since %d, want empty", ids, sinceID)
+	}
+}
+
+func TestOfflineRequeue(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxLength int64
+		want      []uint
+	}{
+		{"fits", 10, []uint{1, 2, 3, 4}},
+		{"keeps newer", 3, []uint{2, 3, 4}},
+		{"queue full", 2, []uint{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestOfflineService(t, tt.maxLength)
+			pushOffline(t, s, 3, 4)
+			if err := s.Requeue(context.Background(), 1, offlineMessages(1, 2)); err != nil {
+				t.Fatalf("Requeue: %v", err)
+			}
+
+			// 放回的消息排在已有消息之前，离线起点改为放回的最旧的消息
+			ids, sinceID := drainOffline(t, s)
+			if !slices.Equal(ids, tt.want) || sinceID != 1 {
+				t.Errorf("Drain() = %v, since %d, want %v, since 1", ids, sinceID, tt.want)
+			}
+		})
+	}
+}
+
+func TestOfflineRemove(t *testing.T) {
+	s, _ := newTestOfflineService(t, 10)
+	pushOffline(t, s, 1, 2, 3)
+	if err := s.Remove(context.Background(), 1, []uint{2, 4}); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	ids, _ := drainOffline(t, s)
+	if !slices.Equal(ids, []uint{1, 3}) {
+		t.Errorf("Drain() = %v, want [1 3]", ids)
+	}
+}
+
+func TestHubReplayOfflineBackfill(t *testing.T) {
+	ctx := context.Background()
+	f := newHubFixture(t)
+	f.offline.maxLength = 2
+
+	// 用户1离线期间收到的消息超出离线队列长度
+	for _, content := range []string{"m1", "m2", "m3", "m4"} {
+		if err := f.hub.SendMessage(ctx, newTextMessage(2, 1, 0, content)); err != nil {
+			t.Fatalf("SendMessage(%s): %v", content, err)
+		}
+	}
+
+	// 重连时从数据库补齐被截断的消息
+	phone := f.connect(t, 1, "phone")
+	want := []string{"m1", "m2", "m3", "m4"}
+	if got := receiveMessages(t, phone, len(want)); !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	expectNoMessage(t, phone)
+
+	// 之后的消息实时推送
+	if err := f.hub.SendMessage(ctx, newTextMessage(2, 1, 0, "live")); err != nil {
+		t.Fatalf("SendMessage(live): %v", err)
+	}
+	if got := receiveMessages(t, phone, 1); !slices.Equal(got, []string{"live"}) {
+		t.Errorf("received %v, want [live]", got)
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/pkg/protocol"
+)
+
+// Outbound 待推送给客户端的数据，按客户端协商的协议编码，同一份数据每种编码只序列化一次
+type Outbound struct {
+	frameType string
+	eventID   string // 聊天消息的ID，用作SSE的事件ID
+	payload   []byte // 旧格式直接推送的JSON
+
+	frameOnce sync.Once
+	framed    []byte // rtmp.v1协议的帧
+	sseOnce   sync.Once
+	sse       []byte // SSE事件
+}
+
+// newOutbound 创建待推送数据，frameType为rtmp.v1协议下的帧类型，同时用作SSE的事件名
+func newOutbound(frameType string, v any) (*Outbound, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	o := &Outbound{frameType: frameType, payload: payload}
+	if message, ok := v.(*model.Message); ok {
+		o.eventID = strconv.FormatUint(uint64(message.ID), 10)
+	}
+	return o, nil
+}
+
+// Encode 按客户端协议编码
+func (o *Outbound) Encode(proto string) []byte {
+	switch proto {
+	case protocol.Subprotocol:
+		o.frameOnce.Do(func() {
+			o.framed, _ = json.Marshal(protocol.Frame{
+				V:    protocol.Version,
+				Type: o.frameType,
+				Data: o.payload,
+			})
+		})
+		return o.framed
+	case ProtocolSSE:
+		o.sseOnce.Do(func() {
+			var buf bytes.Buffer
+			if o.eventID != "" {
+				buf.WriteString("id: " + o.eventID + "\n")
+			}
+			buf.WriteString("event: " + o.frameType + "\n")
+			buf.WriteString("data: ")
+			buf.Write(o.payload)
+			buf.WriteString("\n\n")
+			o.sse = buf.Bytes()
+		})
+		return o.sse
+	default:
+		return o.payload
+	}
+}
+
+// pushedMessageID 从已编码的推送数据中解析消息ID，非聊天消息返回false
+func pushedMessageID(data []byte) (uint, bool) {
+	// SSE事件：只有聊天消息带事件ID
+	if bytes.HasPrefix(data, []byte("id: ")) {
+		line, _, _ := bytes.Cut(data[len("id: "):], []byte("\n"))
+		id, err := strconv.ParseUint(string(line), 10, 64)
+		return uint(id), err == nil
+	}
+	if bytes.HasPrefix(data, []byte("event: ")) {
+		return 0, false
+	}
+
+	var frame struct {
+		V    int             `json:"v"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &frame); err == nil && frame.V == protocol.Version {
+		if frame.Type != protocol.TypeMessage {
+			return 0, false
+		}
+		data = frame.Data
+	}
+
+	var message struct {
+		ID             uint   `json:"id"`
+		ConversationID string `json:"conversation_id"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil || message.ConversationID == "" {
+		return 0, false
+	}
+	return message.ID, true
+}
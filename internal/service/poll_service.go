@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/wire"
+
+	"github.com/Gopher0727/RTMP/config"
+)
+
+const (
+	defaultPollTimeout    = 30 * time.Second
+	defaultPollGrace      = 60 * time.Second
+	defaultPollBufferSize = 1000
+	defaultPollMaxBatch   = 100
+)
+
+// PollResult 一次长轮询请求的响应
+type PollResult struct {
+	Session  string            `json:"session"`  // 会话令牌，下次请求时携带
+	Cursor   uint64            `json:"cursor"`   // 本批最后一条数据的游标，下次请求时携带以确认本批
+	Messages []json.RawMessage `json:"messages"` // 本批推送的数据
+	Dropped  uint64            `json:"dropped"`  // 缓冲区已满被丢弃的数据总数
+	Reset    bool              `json:"reset"`    // 原会话已过期，已新建会话，游标从0开始
+}
+
+// IPollService 长轮询会话服务接口
+type IPollService interface {
+	Open(ctx context.Context, userID uint, deviceID string) (*PollSession, error)
+	Session(userID uint, token string) (*PollSession, bool)
+	Poll(ctx context.Context, session *PollSession, cursor uint64) *PollResult
+	Close(ctx context.Context, userID uint, token string) error
+}
+
+// PollSession 长轮询会话，两次请求之间客户端保持注册，推送的数据缓存在服务端缓冲区
+type PollSession struct {
+	Token  string
+	Client *Client
+
+	mu         sync.Mutex
+	items      [][]byte           // 未确认的数据，items[i]的游标为base+i+1
+	base       uint64             // 已确认或被丢弃的最大游标
+	dropped    uint64             // 缓冲区已满被丢弃的数据数
+	notify     chan struct{}      // 有新数据时关闭并替换
+	active     int                // 进行中的请求数
+	cancelWait context.CancelFunc // 取消上一个仍在等待的请求
+	expiry     *time.Timer        // 宽限期计时器，没有进行中的请求时启动
+	closed     bool
+}
+
+// PollService 长轮询会话管理，会话保存在本实例内存中
+type PollService struct {
+	hubService IHubService
+	options    ClientOptions
+	timeout    time.Duration
+	grace      time.Duration
+	bufferSize int
+	maxBatch   int
+
+	mu       sync.Mutex
+	sessions map[string]*PollSession // token -> session
+}
+
+// NewPollService 创建长轮询会话服务
+func NewPollService(cfg *config.Config, hubService IHubService) IPollService {
+	hc := cfg.Hub
+	s := &PollService{
+		hubService: hubService,
+		options:    NewClientOptions(hc),
+		timeout:    secondsOr(hc.PollTimeoutSeconds, defaultPollTimeout),
+		grace:      secondsOr(hc.PollGraceSeconds, defaultPollGrace),
+		bufferSize: hc.PollBufferSize,
+		maxBatch:   hc.PollMaxBatch,
+		sessions:   make(map[string]*PollSession),
+	}
+	if s.bufferSize <= 0 {
+		s.bufferSize = defaultPollBufferSize
+	}
+	if s.maxBatch <= 0 {
+		s.maxBatch = defaultPollMaxBatch
+	}
+	return s
+}
+
+// newPollToken 生成会话令牌
+func newPollToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Open 新建会话并注册长轮询客户端，会话在宽限期内没有请求时注销客户端
+func (s *PollService) Open(ctx context.Context, userID uint, deviceID string) (*PollSession, error) {
+	token, err := newPollToken()
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewHTTPClient(userID, deviceID, s.options)
+	if err := s.hubService.Register(ctx, client); err != nil {
+		return nil, err
+	}
+
+	session := &PollSession{
+		Token:  token,
+		Client: client,
+		notify: make(chan struct{}),
+	}
+	session.expiry = time.AfterFunc(s.grace, func() { s.expire(session, false) })
+
+	s.mu.Lock()
+	s.sessions[token] = session
+	s.mu.Unlock()
+
+	go s.pump(session)
+
+	log.Printf("Poll session opened: UserID=%d, DeviceID=%s", userID, client.DeviceID)
+	return session, nil
+}
+
+// Session 获取用户的会话，会话不存在、已过期或不属于该用户时返回false
+func (s *PollService) Session(userID uint, token string) (*PollSession, bool) {
+	if token == "" {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok || session.Client.UserID != userID {
+		return nil, false
+	}
+	return session, true
+}
+
+// Poll 确认cursor及之前的数据，返回之后的一批数据；缓冲区为空时等待新数据直到超时
+func (s *PollService) Poll(ctx context.Context, session *PollSession, cursor uint64) *PollResult {
+	waitCtx := session.begin(ctx)
+	defer session.end(s.grace)
+
+	// 请求开始和结束时都刷新在线状态，宽限期内连接TTL不会过期
+	s.hubService.Heartbeat(ctx, session.Client)
+	defer s.hubService.Heartbeat(context.Background(), session.Client)
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	for {
+		result, notify := session.take(cursor, s.maxBatch)
+		if len(result.Messages) > 0 {
+			return result
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return result
+		case <-waitCtx.Done():
+			// 请求已断开或被同一会话的新请求取代
+			return result
+		case <-session.Client.Ctx.Done():
+			return result
+		}
+	}
+}
+
+// Close 关闭会话并注销客户端
+func (s *PollService) Close(ctx context.Context, userID uint, token string) error {
+	session, ok := s.Session(userID, token)
+	if !ok {
+		return ErrPollSessionNotFound
+	}
+	s.expire(session, true)
+	return nil
+}
+
+// pump 将客户端发送队列中的数据转存到会话缓冲区，客户端关闭（如同设备新连接取代）时结束会话
+func (s *PollService) pump(session *PollSession) {
+	for {
+		select {
+		case msg := <-session.Client.SendQueue:
+			session.push(msg, s.bufferSize)
+		case <-session.Client.Ctx.Done():
+			s.expire(session, true)
+			return
+		}
+	}
+}
+
+// expire 结束会话并注销客户端，force为false时仅在没有进行中的请求时结束
+func (s *PollService) expire(session *PollSession, force bool) {
+	session.mu.Lock()
+	if session.closed || (!force && session.active > 0) {
+		session.mu.Unlock()
+		return
+	}
+	session.closed = true
+	session.expiry.Stop()
+	session.mu.Unlock()
+
+	s.mu.Lock()
+	if s.sessions[session.Token] == session {
+		delete(s.sessions, session.Token)
+	}
+	s.mu.Unlock()
+
+	if err := s.hubService.Unregister(context.Background(), session.Client); err != nil {
+		log.Printf("Failed to unregister poll client of user %d: %v", session.Client.UserID, err)
+	}
+	log.Printf("Poll session closed: UserID=%d, DeviceID=%s", session.Client.UserID, session.Client.DeviceID)
+}
+
+// begin 开始一次请求：停止宽限期计时并取代上一个仍在等待的请求
+func (p *PollSession) begin(ctx context.Context) context.Context {
+	waitCtx, cancel := context.WithCancel(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancelWait != nil {
+		p.cancelWait()
+	}
+	p.cancelWait = cancel
+	p.active++
+	p.expiry.Stop()
+	return waitCtx
+}
+
+// end 结束一次请求，没有进行中的请求时重新开始宽限期计时
+func (p *PollSession) end(grace time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active--
+	if p.active == 0 && !p.closed {
+		p.cancelWait()
+		p.cancelWait = nil
+		p.expiry.Reset(grace)
+	}
+}
+
+// push 将数据追加到缓冲区，缓冲区已满时丢弃最旧的数据
+func (p *PollSession) push(msg []byte, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.items = append(p.items, msg)
+	if len(p.items) > size {
+		p.items = p.items[1:]
+		p.base++
+		p.dropped++
+	}
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// take 丢弃游标及之前已确认的数据，返回之后最多limit条数据及新数据到达的通知通道
+func (p *PollSession) take(cursor uint64, limit int) (*PollResult, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 游标超出已推送的范围时视为全部已确认
+	if last := p.base + uint64(len(p.items)); cursor > last {
+		cursor = last
+	}
+	if cursor > p.base {
+		p.items = p.items[cursor-p.base:]
+		p.base = cursor
+	}
+
+	n := min(len(p.items), limit)
+	messages := make([]json.RawMessage, n)
+	for i := range n {
+		messages[i] = p.items[i]
+	}
+	return &PollResult{
+		Session:  p.Token,
+		Cursor:   p.base + uint64(n),
+		Messages: messages,
+		Dropped:  p.dropped,
+	}, p.notify
+}
+
+// PollServiceSet 长轮询会话服务依赖注入
+var PollServiceSet = wire.NewSet(NewPollService)
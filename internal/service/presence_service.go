@@ -0,0 +1,373 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/db"
+	"github.com/Gopher0727/RTMP/internal/instance"
+)
+
+const (
+	defaultConnectionTTL     = 90 * time.Second
+	defaultHeartbeatInterval = 10 * time.Second
+	defaultInstanceTTL       = 30 * time.Second
+	defaultReapInterval      = 30 * time.Second
+
+	presenceInstancesKey  = "presence:instances"   // 所有注册过的实例ID集合
+	presenceReaperLockKey = "presence:reaper:lock" // 清理任务锁，同一周期只由一个实例执行
+)
+
+// removeConnScript 仅当连接仍属于指定实例时才删除，避免误删设备在其他实例上的新连接
+// KEYS[1] 用户设备哈希，KEYS[2] 连接键，KEYS[3] 实例用户集合
+// ARGV[1] 设备ID，ARGV[2] 实例ID，ARGV[3] 实例用户集合成员
+var removeConnScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+if redis.call('GET', KEYS[2]) == ARGV[2] then
+	redis.call('DEL', KEYS[2])
+end
+redis.call('SREM', KEYS[3], ARGV[3])
+return 1
+`)
+
+// connectScript 登记连接，返回登记前用户其他存活设备的数量；检查和写入在同一脚本中执行，
+// 同一用户的多个设备同时连接时只有一个设备看到没有其他设备
+// KEYS[1] 用户设备哈希，KEYS[2] 连接键，KEYS[3] 实例用户集合
+// ARGV[1] 设备ID，ARGV[2] 实例ID，ARGV[3] 连接TTL（毫秒），ARGV[4] 实例用户集合成员，ARGV[5] 用户连接键前缀
+var connectScript = redis.NewScript(`
+local others = 0
+for _, device in ipairs(redis.call('HKEYS', KEYS[1])) do
+	if device ~= ARGV[1] and redis.call('EXISTS', ARGV[5] .. device) == 1 then
+		others = others + 1
+	end
+end
+redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[3])
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('SADD', KEYS[3], ARGV[4])
+return others
+`)
+
+// disconnectScript 移除本实例上的连接，返回移除后用户剩余存活设备的数量；
+// 同一用户的多个设备同时断开时只有一个设备看到没有剩余设备
+// KEYS、ARGV[1..3] 同removeConnScript，ARGV[4] 用户连接键前缀
+var disconnectScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+if redis.call('GET', KEYS[2]) == ARGV[2] then
+	redis.call('DEL', KEYS[2])
+end
+redis.call('SREM', KEYS[3], ARGV[3])
+local remaining = 0
+for _, device in ipairs(redis.call('HKEYS', KEYS[1])) do
+	if redis.call('EXISTS', ARGV[4] .. device) == 1 then
+		remaining = remaining + 1
+	end
+end
+return remaining
+`)
+
+// IPresenceService 在线状态注册表接口
+type IPresenceService interface {
+	Connect(ctx context.Context, userID uint, deviceID string) (bool, error)
+	Refresh(ctx context.Context, userID uint, deviceID string) error
+	Disconnect(ctx context.Context, userID uint, deviceID string) (bool, error)
+	Lookup(ctx context.Context, userID uint) (map[string]string, error)
+	Start(ctx context.Context, onOffline func(ctx context.Context, userIDs []uint))
+}
+
+// PresenceService 基于Redis的在线状态注册表
+// 每个连接一个带TTL的键，由心跳刷新；每个实例定期写入心跳键，
+// 心跳键过期的实例视为已宕机，由清理任务将其上的连接全部移除。
+type PresenceService struct {
+	rdb               *redis.Client
+	instanceID        string
+	connectionTTL     time.Duration
+	heartbeatInterval time.Duration
+	instanceTTL       time.Duration
+	reapInterval      time.Duration
+}
+
+// NewPresenceService 创建在线状态注册表
+func NewPresenceService(cfg *config.Config, identity *instance.Identity) IPresenceService {
+	pc := cfg.Presence
+	return &PresenceService{
+		rdb:               db.GetRedisSession(),
+		instanceID:        identity.ID(),
+		connectionTTL:     secondsOr(pc.ConnectionTTLSeconds, defaultConnectionTTL),
+		heartbeatInterval: secondsOr(pc.HeartbeatIntervalSeconds, defaultHeartbeatInterval),
+		instanceTTL:       secondsOr(pc.InstanceTTLSeconds, defaultInstanceTTL),
+		reapInterval:      secondsOr(pc.ReapIntervalSeconds, defaultReapInterval),
+	}
+}
+
+// secondsOr 将秒数转换为时长，未配置时使用默认值
+func secondsOr(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// presenceUserKey 用户设备哈希键：deviceID -> instanceID
+func presenceUserKey(userID uint) string {
+	return fmt.Sprintf("presence:user:%d", userID)
+}
+
+// presenceConnKey 连接键，值为实例ID，带TTL
+func presenceConnKey(userID uint, deviceID string) string {
+	return presenceConnPrefix(userID) + deviceID
+}
+
+// presenceConnPrefix 用户连接键的前缀
+func presenceConnPrefix(userID uint) string {
+	return fmt.Sprintf("presence:conn:%d:", userID)
+}
+
+// presenceInstanceKey 实例心跳键
+func presenceInstanceKey(instanceID string) string {
+	return "presence:instance:" + instanceID
+}
+
+// presenceInstanceUsersKey 实例上的连接集合，成员为 userID:deviceID
+func presenceInstanceUsersKey(instanceID string) string {
+	return "presence:instance:" + instanceID + ":users"
+}
+
+// presenceMember 实例连接集合的成员
+func presenceMember(userID uint, deviceID string) string {
+	return fmt.Sprintf("%d:%s", userID, deviceID)
+}
+
+// parsePresenceMember 解析实例连接集合的成员
+func parsePresenceMember(member string) (uint, string, bool) {
+	uid, deviceID, ok := strings.Cut(member, ":")
+	if !ok {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(uid, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint(id), deviceID, true
+}
+
+// Connect 登记连接，返回用户在登记前是否已有其他在线设备
+func (s *PresenceService) Connect(ctx context.Context, userID uint, deviceID string) (bool, error) {
+	keys := []string{presenceUserKey(userID), presenceConnKey(userID, deviceID), presenceInstanceUsersKey(s.instanceID)}
+	others, err := connectScript.Run(ctx, s.rdb, keys, deviceID, s.instanceID, s.connectionTTL.Milliseconds(),
+		presenceMember(userID, deviceID), presenceConnPrefix(userID)).Int()
+	if err != nil {
+		return false, err
+	}
+	return others > 0, nil
+}
+
+// Refresh 刷新连接的TTL，连接键已过期时重新登记
+func (s *PresenceService) Refresh(ctx context.Context, userID uint, deviceID string) error {
+	return s.writeConn(ctx, userID, deviceID)
+}
+
+// writeConn 写入连接键、用户设备哈希和实例连接集合
+func (s *PresenceService) writeConn(ctx context.Context, userID uint, deviceID string) error {
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, presenceConnKey(userID, deviceID), s.instanceID, s.connectionTTL)
+		pipe.HSet(ctx, presenceUserKey(userID), deviceID, s.instanceID)
+		pipe.SAdd(ctx, presenceInstanceUsersKey(s.instanceID), presenceMember(userID, deviceID))
+		return nil
+	})
+	return err
+}
+
+// Disconnect 移除连接，返回用户是否仍有其他在线设备
+func (s *PresenceService) Disconnect(ctx context.Context, userID uint, deviceID string) (bool, error) {
+	keys := []string{presenceUserKey(userID), presenceConnKey(userID, deviceID), presenceInstanceUsersKey(s.instanceID)}
+	remaining, err := disconnectScript.Run(ctx, s.rdb, keys, deviceID, s.instanceID,
+		presenceMember(userID, deviceID), presenceConnPrefix(userID)).Int()
+	if err != nil {
+		return false, err
+	}
+	return remaining > 0, nil
+}
+
+// removeConn 移除指定实例上的连接
+func (s *PresenceService) removeConn(ctx context.Context, instanceID string, userID uint, deviceID string) error {
+	keys := []string{presenceUserKey(userID), presenceConnKey(userID, deviceID), presenceInstanceUsersKey(instanceID)}
+	return removeConnScript.Run(ctx, s.rdb, keys, deviceID, instanceID, presenceMember(userID, deviceID)).Err()
+}
+
+// Lookup 获取用户所有在线设备及其所在实例，连接键已过期的设备会被顺带清理
+func (s *PresenceService) Lookup(ctx context.Context, userID uint) (map[string]string, error) {
+	entries, err := s.rdb.HGetAll(ctx, presenceUserKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return map[string]string{}, nil
+	}
+
+	// 批量检查连接键是否存活
+	cmds := make(map[string]*redis.IntCmd, len(entries))
+	_, err = s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for deviceID := range entries {
+			cmds[deviceID] = pipe.Exists(ctx, presenceConnKey(userID, deviceID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]string, len(entries))
+	for deviceID, instanceID := range entries {
+		if cmds[deviceID].Val() > 0 {
+			devices[deviceID] = instanceID
+			continue
+		}
+		if err := s.removeConn(ctx, instanceID, userID, deviceID); err != nil {
+			log.Printf("Failed to remove expired connection %d/%s: %v", userID, deviceID, err)
+		}
+	}
+	return devices, nil
+}
+
+// Start 启动实例心跳和宕机实例清理，onOffline在用户因清理而离线时调用
+func (s *PresenceService) Start(ctx context.Context, onOffline func(ctx context.Context, userIDs []uint)) {
+	// 清理本实例上次运行遗留的连接（实例ID在重启后保持不变）
+	if userIDs, err := s.reapInstance(ctx, s.instanceID); err != nil {
+		log.Printf("Failed to clean up stale connections of instance %s: %v", s.instanceID, err)
+	} else if len(userIDs) > 0 {
+		onOffline(ctx, userIDs)
+	}
+
+	if err := s.heartbeat(ctx); err != nil {
+		log.Printf("Failed to send instance heartbeat: %v", err)
+	}
+
+	go func() {
+		heartbeat := time.NewTicker(s.heartbeatInterval)
+		reap := time.NewTicker(s.reapInterval)
+		defer heartbeat.Stop()
+		defer reap.Stop()
+
+		for {
+			select {
+			case <-heartbeat.C:
+				if err := s.heartbeat(ctx); err != nil {
+					log.Printf("Failed to send instance heartbeat: %v", err)
+				}
+			case <-reap.C:
+				userIDs, err := s.reapDead(ctx)
+				if err != nil {
+					log.Printf("Failed to reap dead instances: %v", err)
+				}
+				if len(userIDs) > 0 {
+					onOffline(ctx, userIDs)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// heartbeat 写入实例心跳键
+func (s *PresenceService) heartbeat(ctx context.Context) error {
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, presenceInstanceKey(s.instanceID), time.Now().Unix(), s.instanceTTL)
+		pipe.SAdd(ctx, presenceInstancesKey, s.instanceID)
+		return nil
+	})
+	return err
+}
+
+// reapDead 清理心跳已过期的实例，返回因此离线的用户
+func (s *PresenceService) reapDead(ctx context.Context) ([]uint, error) {
+	// 同一周期只由一个实例执行清理
+	ok, err := s.rdb.SetNX(ctx, presenceReaperLockKey, s.instanceID, s.reapInterval).Result()
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	instances, err := s.rdb.SMembers(ctx, presenceInstancesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var offline []uint
+	for _, instanceID := range instances {
+		if instanceID == s.instanceID {
+			continue
+		}
+		alive, err := s.rdb.Exists(ctx, presenceInstanceKey(instanceID)).Result()
+		if err != nil {
+			return offline, err
+		}
+		if alive > 0 {
+			continue
+		}
+
+		log.Printf("Instance %s missed heartbeats, reaping its connections", instanceID)
+		userIDs, err := s.reapInstance(ctx, instanceID)
+		if err != nil {
+			return offline, err
+		}
+		offline = append(offline, userIDs...)
+	}
+	return offline, nil
+}
+
+// reapInstance 移除实例上的所有连接，返回已没有任何在线设备的用户
+func (s *PresenceService) reapInstance(ctx context.Context, instanceID string) ([]uint, error) {
+	members, err := s.rdb.SMembers(ctx, presenceInstanceUsersKey(instanceID)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	affected := make(map[uint]struct{})
+	for _, member := range members {
+		userID, deviceID, ok := parsePresenceMember(member)
+		if !ok {
+			continue
+		}
+		if err := s.removeConn(ctx, instanceID, userID, deviceID); err != nil {
+			return nil, err
+		}
+		affected[userID] = struct{}{}
+	}
+
+	if err := s.rdb.Del(ctx, presenceInstanceUsersKey(instanceID)).Err(); err != nil {
+		return nil, err
+	}
+	if instanceID != s.instanceID {
+		if err := s.rdb.SRem(ctx, presenceInstancesKey, instanceID).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var offline []uint
+	for userID := range affected {
+		devices, err := s.Lookup(ctx, userID)
+		if err != nil {
+			return offline, err
+		}
+		if len(devices) == 0 {
+			offline = append(offline, userID)
+		}
+	}
+	return offline, nil
+}
+
+// PresenceServiceSet 在线状态注册表依赖注入
+var PresenceServiceSet = wire.NewSet(NewPresenceService)
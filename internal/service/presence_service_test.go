@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestPresence(rdb *redis.Client, instanceID string) *PresenceService {
+	return &PresenceService{
+		rdb:               rdb,
+		instanceID:        instanceID,
+		connectionTTL:     time.Hour,
+		heartbeatInterval: defaultHeartbeatInterval,
+		instanceTTL:       30 * time.Second,
+		reapInterval:      time.Hour,
+	}
+}
+
+func connectDevice(t *testing.T, s *PresenceService, userID uint, deviceID string) bool {
+	t.Helper()
+	others, err := s.Connect(context.Background(), userID, deviceID)
+	if err != nil {
+		t.Fatalf("Connect(%d, %s): %v", userID, deviceID, err)
+	}
+	return others
+}
+
+func lookupDevices(t *testing.T, s *PresenceService, userID uint) map[string]string {
+	t.Helper()
+	devices, err := s.Lookup(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("Lookup(%d): %v", userID, err)
+	}
+	return devices
+}
+
+func TestPresenceConnectDisconnect(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	a, b := newTestPresence(rdb, "node-a"), newTestPresence(rdb, "node-b")
+
+	if connectDevice(t, a, 1, "phone") {
+		t.Error("first device: others = true, want false")
+	}
+	if !connectDevice(t, a, 1, "laptop") {
+		t.Error("second device: others = false, want true")
+	}
+	if !connectDevice(t, b, 1, "tablet") {
+		t.Error("device on another instance: others = false, want true")
+	}
+	// 同一设备重连不算其他设备
+	connectDevice(t, a, 2, "phone")
+	if connectDevice(t, a, 2, "phone") {
+		t.Error("reconnecting device: others = true, want false")
+	}
+
+	devices := lookupDevices(t, a, 1)
+	want := map[string]string{"phone": "node-a", "laptop": "node-a", "tablet": "node-b"}
+	if len(devices) != len(want) {
+		t.Fatalf("Lookup() = %v, want %v", devices, want)
+	}
+	for deviceID, instanceID := range want {
+		if devices[deviceID] != instanceID {
+			t.Errorf("Lookup()[%s] = %q, want %q", deviceID, devices[deviceID], instanceID)
+		}
+	}
+
+	for _, step := range []struct {
+		s          *PresenceService
+		deviceID   string
+		wantOnline bool
+	}{
+		{a, "phone", true},
+		{b, "laptop", true}, // 其他实例上的连接不会被移除
+		{a, "laptop", true},
+		{b, "tablet", false},
+	} {
+		online, err := step.s.Disconnect(ctx, 1, step.deviceID)
+		if err != nil {
+			t.Fatalf("Disconnect(%s): %v", step.deviceID, err)
+		}
+		if online != step.wantOnline {
+			t.Errorf("Disconnect(%s) on %s = %v, want %v", step.deviceID, step.s.instanceID, online, step.wantOnline)
+		}
+	}
+}
+
+func TestPresenceConnectionTTL(t *testing.T) {
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+	s := newTestPresence(rdb, "node-a")
+
+	connectDevice(t, s, 1, "phone")
+	connectDevice(t, s, 1, "laptop")
+	mr.FastForward(s.connectionTTL / 2)
+	if err := s.Refresh(ctx, 1, "laptop"); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	mr.FastForward(s.connectionTTL / 2)
+
+	// 未刷新的连接过期，查询时从用户设备哈希中清理
+	if devices := lookupDevices(t, s, 1); len(devices) != 1 || devices["laptop"] != "node-a" {
+		t.Errorf("Lookup() = %v, want only laptop", devices)
+	}
+	if mr.HGet(presenceUserKey(1), "phone") != "" {
+		t.Error("expired device still in user hash")
+	}
+
+	mr.FastForward(s.connectionTTL)
+	if connectDevice(t, s, 1, "tablet") {
+		t.Error("Connect() after other devices expired: others = true, want false")
+	}
+}
+
+func TestPresenceConcurrentConnectDisconnect(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	instances := []*PresenceService{newTestPresence(rdb, "node-a"), newTestPresence(rdb, "node-b")}
+
+	const devices = 16
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first []string
+		last  []string
+	)
+	run := func(op func(s *PresenceService, deviceID string) (bool, error), record *[]string, want bool) {
+		for i := range devices {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				deviceID := fmt.Sprintf("device-%d", i)
+				got, err := op(instances[i%len(instances)], deviceID)
+				if err != nil {
+					t.Errorf("%s: %v", deviceID, err)
+					return
+				}
+				if got == want {
+					mu.Lock()
+					*record = append(*record, deviceID)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// 同时连接时只有一个设备看到没有其他设备，同时断开时只有一个设备看到没有剩余设备
+	run(func(s *PresenceService, deviceID string) (bool, error) { return s.Connect(ctx, 1, deviceID) }, &first, false)
+	if len(first) != 1 {
+		t.Errorf("devices that saw no others on connect = %v, want exactly one", first)
+	}
+	run(func(s *PresenceService, deviceID string) (bool, error) { return s.Disconnect(ctx, 1, deviceID) }, &last, false)
+	if len(last) != 1 {
+		t.Errorf("devices that saw no remaining on disconnect = %v, want exactly one", last)
+	}
+}
+
+func TestPresenceReapDead(t *testing.T) {
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+	a, b := newTestPresence(rdb, "node-a"), newTestPresence(rdb, "node-b")
+	for _, s := range []*PresenceService{a, b} {
+		if err := s.heartbeat(ctx); err != nil {
+			t.Fatalf("heartbeat(%s): %v", s.instanceID, err)
+		}
+	}
+	connectDevice(t, b, 1, "phone")
+	connectDevice(t, b, 2, "phone")
+	connectDevice(t, a, 2, "laptop")
+
+	// 心跳键未过期的实例不会被清理
+	if offline, err := a.reapDead(ctx); err != nil || len(offline) != 0 {
+		t.Fatalf("reapDead() with live instances = %v, %v, want none", offline, err)
+	}
+
+	// 实例B停止心跳
+	mr.FastForward(b.instanceTTL)
+	if err := a.heartbeat(ctx); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	mr.Del(presenceReaperLockKey)
+
+	offline, err := a.reapDead(ctx)
+	if err != nil {
+		t.Fatalf("reapDead: %v", err)
+	}
+	if !slices.Equal(offline, []uint{1}) {
+		t.Errorf("reapDead() = %v, want [1]", offline)
+	}
+	if devices := lookupDevices(t, a, 2); len(devices) != 1 || devices["laptop"] != "node-a" {
+		t.Errorf("Lookup(2) = %v, want only laptop", devices)
+	}
+	if members, _ := mr.Members(presenceInstancesKey); slices.Contains(members, "node-b") {
+		t.Errorf("instances = %v, node-b should be removed", members)
+	}
+
+	// 同一周期只清理一次
+	if err := b.heartbeat(ctx); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	connectDevice(t, b, 3, "phone")
+	mr.FastForward(b.instanceTTL)
+	if err := a.heartbeat(ctx); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if offline, err := a.reapDead(ctx); err != nil || len(offline) != 0 {
+		t.Errorf("reapDead() while locked = %v, %v, want none", offline, err)
+	}
+	mr.Del(presenceReaperLockKey)
+	if offline, err := a.reapDead(ctx); err != nil || !slices.Equal(offline, []uint{3}) {
+		t.Errorf("reapDead() after lock released = %v, %v, want [3]", offline, err)
+	}
+}
+
+func TestPresenceStartCleansOwnConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rdb := newTestRedis(t)
+	previous := newTestPresence(rdb, "node-a")
+	connectDevice(t, previous, 1, "phone")
+
+	// 重启后实例ID不变，上次运行遗留的连接在启动时移除
+	var offline []uint
+	restarted := newTestPresence(rdb, "node-a")
+	restarted.Start(ctx, func(_ context.Context, userIDs []uint) {
+		offline = append(offline, userIDs...)
+	})
+	if !slices.Equal(offline, []uint{1}) {
+		t.Errorf("offline users on start = %v, want [1]", offline)
+	}
+	if devices := lookupDevices(t, restarted, 1); len(devices) != 0 {
+		t.Errorf("Lookup() after start = %v, want empty", devices)
+	}
+}
@@ -2,9 +2,14 @@ package service
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/google/wire"
+	"gorm.io/gorm"
 
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/instance"
 	"github.com/Gopher0727/RTMP/internal/model"
 	"github.com/Gopher0727/RTMP/internal/repository"
 )
@@ -18,22 +23,34 @@ type IRoomService interface {
 	RemoveMember(ctx context.Context, roomID, userID uint) error
 	GetMembers(ctx context.Context, roomID uint) ([]*model.RoomMember, error)
 	IsMember(ctx context.Context, roomID, userID uint) (bool, error)
+	UpdateSettings(ctx context.Context, operatorID, roomID uint, messageTTLSeconds int) (*model.Room, error)
 }
 
 // RoomService 房间服务实现
 type RoomService struct {
-	roomRepo repository.IRoomRepository
+	roomRepo   repository.IRoomRepository
+	instanceID string
+	maxTTL     time.Duration
 }
 
 // NewRoomService 创建房间服务
-func NewRoomService(roomRepo repository.IRoomRepository) IRoomService {
+func NewRoomService(cfg *config.Config, roomRepo repository.IRoomRepository, identity *instance.Identity) IRoomService {
 	return &RoomService{
-		roomRepo: roomRepo,
+		roomRepo:   roomRepo,
+		instanceID: identity.ID(),
+		maxTTL:     secondsOr(cfg.Message.MaxTTLSeconds, defaultMaxTTL),
 	}
 }
 
 // CreateRoom 创建房间
 func (s *RoomService) CreateRoom(ctx context.Context, room *model.Room) error {
+	// 记录创建房间的实例
+	if room.InstanceID == "" {
+		room.InstanceID = s.instanceID
+	}
+	if err := checkTTL(room.MessageTTLSeconds, s.maxTTL); err != nil {
+		return err
+	}
 	return s.roomRepo.Create(ctx, room)
 }
 
@@ -79,5 +96,39 @@ func (s *RoomService) IsMember(ctx context.Context, roomID, userID uint) (bool,
 	return s.roomRepo.IsMember(ctx, roomID, userID)
 }
 
+// UpdateSettings 更新房间设置，只有房间管理员和创建者可以修改
+func (s *RoomService) UpdateSettings(ctx context.Context, operatorID, roomID uint, messageTTLSeconds int) (*model.Room, error) {
+	if err := checkTTL(messageTTLSeconds, s.maxTTL); err != nil {
+		return nil, err
+	}
+
+	room, err := s.roomRepo.GetByID(ctx, roomID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoomNotFound
+		}
+		return nil, err
+	}
+
+	if room.CreatorID != operatorID {
+		role, err := s.roomRepo.GetMemberRole(ctx, roomID, operatorID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrPermissionDenied
+			}
+			return nil, err
+		}
+		if role < model.RoomRoleAdmin {
+			return nil, ErrPermissionDenied
+		}
+	}
+
+	if err := s.roomRepo.UpdateMessageTTL(ctx, roomID, messageTTLSeconds); err != nil {
+		return nil, err
+	}
+	room.MessageTTLSeconds = messageTTLSeconds
+	return room, nil
+}
+
 // RoomServiceSet 房间服务依赖注入
 var RoomServiceSet = wire.NewSet(NewRoomService)
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/instance"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
+
+const (
+	defaultSchedulerPollInterval = time.Second
+	defaultSchedulerLease        = 30 * time.Second
+	defaultSchedulerBatchSize    = 100
+	defaultScheduleMaxDelayDays  = 30
+)
+
+// IScheduledMessageService 定时消息服务接口
+type IScheduledMessageService interface {
+	Schedule(ctx context.Context, scheduled *model.ScheduledMessage) error
+	ListPending(ctx context.Context, userID uint, page, size int) ([]*model.ScheduledMessage, int64, error)
+	Cancel(ctx context.Context, userID, id uint) error
+	Start(ctx context.Context)
+}
+
+// ScheduledMessageService 定时消息服务实现
+// 每个实例定期领取已到发送时间的定时消息，通过Hub按普通消息发送；
+// 领取时锁定数据行并设置租约，多实例部署时每条消息只由一个实例发送
+type ScheduledMessageService struct {
+	scheduledRepo     repository.IScheduledMessageRepository
+	roomRepo          repository.IRoomRepository
+	attachmentService IAttachmentService
+	hubService        IHubService
+	instanceID        string
+	pollInterval      time.Duration
+	lease             time.Duration
+	batchSize         int
+	maxDelay          time.Duration
+	maxTTL            time.Duration
+}
+
+// NewScheduledMessageService 创建定时消息服务
+func NewScheduledMessageService(
+	cfg *config.Config,
+	scheduledRepo repository.IScheduledMessageRepository,
+	roomRepo repository.IRoomRepository,
+	attachmentService IAttachmentService,
+	hubService IHubService,
+	identity *instance.Identity,
+) IScheduledMessageService {
+	batchSize := cfg.Scheduler.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultSchedulerBatchSize
+	}
+	maxDelayDays := cfg.Scheduler.MaxDelayDays
+	if maxDelayDays <= 0 {
+		maxDelayDays = defaultScheduleMaxDelayDays
+	}
+	return &ScheduledMessageService{
+		scheduledRepo:     scheduledRepo,
+		roomRepo:          roomRepo,
+		attachmentService: attachmentService,
+		hubService:        hubService,
+		instanceID:        identity.ID(),
+		pollInterval:      secondsOr(cfg.Scheduler.PollIntervalSeconds, defaultSchedulerPollInterval),
+		lease:             secondsOr(cfg.Scheduler.LeaseSeconds, defaultSchedulerLease),
+		batchSize:         batchSize,
+		maxDelay:          time.Duration(maxDelayDays) * 24 * time.Hour,
+		maxTTL:            secondsOr(cfg.Message.MaxTTLSeconds, defaultMaxTTL),
+	}
+}
+
+// Schedule 创建定时消息，发送时间须在当前时间之后且不超过最大天数；发送者和附件在创建和发送时都会校验
+func (s *ScheduledMessageService) Schedule(ctx context.Context, scheduled *model.ScheduledMessage) error {
+	if scheduled.TargetID == 0 || (scheduled.Content == "" && len(scheduled.AttachmentIDs) == 0) {
+		return ErrInvalidOperation
+	}
+	now := time.Now()
+	if !scheduled.SendAt.After(now) || scheduled.SendAt.After(now.Add(s.maxDelay)) {
+		return ErrInvalidSendTime
+	}
+	if err := checkTTL(scheduled.TTLSeconds, s.maxTTL); err != nil {
+		return err
+	}
+
+	switch scheduled.TargetType {
+	case model.MessageTargetUser:
+	case model.MessageTargetRoom:
+		isMember, err := s.roomRepo.IsMember(ctx, scheduled.TargetID, scheduled.SenderID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotRoomMember
+		}
+	default:
+		return ErrInvalidOperation
+	}
+
+	if _, err := s.attachmentService.Resolve(ctx, scheduled.SenderID, nil, scheduled.AttachmentIDs); err != nil {
+		return err
+	}
+	return s.scheduledRepo.Create(ctx, scheduled)
+}
+
+// ListPending 获取用户等待发送的定时消息
+func (s *ScheduledMessageService) ListPending(ctx context.Context, userID uint, page, size int) ([]*model.ScheduledMessage, int64, error) {
+	return s.scheduledRepo.ListPending(ctx, userID, page, size)
+}
+
+// Cancel 取消用户等待发送的定时消息
+func (s *ScheduledMessageService) Cancel(ctx context.Context, userID, id uint) error {
+	scheduled, err := s.scheduledRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrScheduledNotFound
+		}
+		return err
+	}
+	if scheduled.SenderID != userID {
+		return ErrScheduledNotFound
+	}
+
+	// 查询之后可能已被实例领取，以条件更新的结果为准
+	cancelled, err := s.scheduledRepo.Cancel(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return ErrScheduledNotPending
+	}
+	return nil
+}
+
+// Start 启动定时消息发送任务
+func (s *ScheduledMessageService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.dispatchDue(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// dispatchDue 领取并发送已到发送时间的定时消息
+func (s *ScheduledMessageService) dispatchDue(ctx context.Context) {
+	now := time.Now()
+	if n, err := s.scheduledRepo.FailExpired(ctx, now); err != nil {
+		log.Printf("Failed to expire scheduled message leases: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d scheduled messages failed after lease expiry", n)
+	}
+
+	claimed, err := s.scheduledRepo.Claim(ctx, s.instanceID, now, s.lease, s.batchSize)
+	if err != nil {
+		log.Printf("Failed to claim scheduled messages: %v", err)
+		return
+	}
+
+	for _, scheduled := range claimed {
+		message, err := s.fire(ctx, scheduled)
+		if err != nil {
+			log.Printf("Failed to send scheduled message %d: %v", scheduled.ID, err)
+			if err := s.scheduledRepo.MarkFailed(ctx, scheduled.ID, s.instanceID, err.Error()); err != nil {
+				log.Printf("Failed to mark scheduled message %d failed: %v", scheduled.ID, err)
+			}
+			continue
+		}
+		if err := s.scheduledRepo.MarkSent(ctx, scheduled.ID, s.instanceID, message.ID); err != nil {
+			log.Printf("Failed to mark scheduled message %d sent: %v", scheduled.ID, err)
+		}
+	}
+}
+
+// fire 通过Hub发送定时消息，与客户端实时发送的消息走同样的持久化和推送流程
+func (s *ScheduledMessageService) fire(ctx context.Context, scheduled *model.ScheduledMessage) (*model.Message, error) {
+	attachments, err := s.attachmentService.Resolve(ctx, scheduled.SenderID, nil, scheduled.AttachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &model.Message{
+		SenderID:    scheduled.SenderID,
+		SenderName:  scheduled.SenderName,
+		Content:     scheduled.Content,
+		Type:        string(model.MessageTypeText),
+		Attachments: attachments,
+		ReplyToID:   scheduled.ReplyToID,
+	}
+	message.ExpireAfter(time.Duration(scheduled.TTLSeconds) * time.Second)
+
+	switch scheduled.TargetType {
+	case model.MessageTargetUser:
+		message.ReceiverID = scheduled.TargetID
+		err = s.hubService.SendMessage(ctx, message)
+	case model.MessageTargetRoom:
+		// 创建后发送者可能已退出房间
+		var isMember bool
+		if isMember, err = s.roomRepo.IsMember(ctx, scheduled.TargetID, scheduled.SenderID); err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, ErrNotRoomMember
+		}
+		message.RoomID = scheduled.TargetID
+		err = s.hubService.BroadcastToRoom(ctx, scheduled.TargetID, message)
+	default:
+		err = ErrInvalidOperation
+	}
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ScheduledMessageServiceSet 定时消息服务依赖注入
+var ScheduledMessageServiceSet = wire.NewSet(NewScheduledMessageService)
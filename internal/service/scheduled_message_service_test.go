@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Gopher0727/RTMP/config"
+	"github.com/Gopher0727/RTMP/internal/blob"
+	"github.com/Gopher0727/RTMP/internal/instance"
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
+
+// schedulerFixture 在hubFixture上创建定时消息服务
+type schedulerFixture struct {
+	*hubFixture
+	repo    repository.IScheduledMessageRepository
+	service *ScheduledMessageService
+}
+
+func newSchedulerFixture(t *testing.T) *schedulerFixture {
+	t.Helper()
+	hub := newHubFixture(t)
+	if err := hub.db.AutoMigrate(&model.ScheduledMessage{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+
+	cfg := &config.Config{Instance: config.InstanceConfig{Name: "node-a"}}
+	identity, err := instance.NewIdentity(cfg)
+	if err != nil {
+		t.Fatalf("NewIdentity: %v", err)
+	}
+	messageRepo := repository.NewMessageRepository(hub.db)
+	roomRepo := repository.NewRoomRepository(hub.db)
+	f := &schedulerFixture{
+		hubFixture: hub,
+		repo:       repository.NewScheduledMessageRepository(hub.db),
+	}
+	f.service = NewScheduledMessageService(
+		cfg,
+		f.repo,
+		roomRepo,
+		NewAttachmentService(cfg, repository.NewAttachmentRepository(hub.db), messageRepo, roomRepo, store),
+		hub.hub,
+		identity,
+	).(*ScheduledMessageService)
+	return f
+}
+
+// due 直接写入已到发送时间的定时消息
+func (f *schedulerFixture) due(t *testing.T, senderID uint, target model.MessageTarget, targetID uint, content string) *model.ScheduledMessage {
+	t.Helper()
+	scheduled := &model.ScheduledMessage{
+		SenderID:   senderID,
+		TargetType: target,
+		TargetID:   targetID,
+		Content:    content,
+		SendAt:     time.Now().Add(-time.Second),
+	}
+	if err := f.repo.Create(context.Background(), scheduled); err != nil {
+		t.Fatalf("Create(%s): %v", content, err)
+	}
+	return scheduled
+}
+
+func (f *schedulerFixture) get(t *testing.T, id uint) *model.ScheduledMessage {
+	t.Helper()
+	scheduled, err := f.repo.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetByID(%d): %v", id, err)
+	}
+	return scheduled
+}
+
+func TestScheduleValidation(t *testing.T) {
+	f := newSchedulerFixture(t)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		scheduled model.ScheduledMessage
+		want      error
+	}{
+		{"dm", model.ScheduledMessage{TargetType: model.MessageTargetUser, TargetID: 1, Content: "hi", SendAt: now.Add(time.Hour)}, nil},
+		{"room", model.ScheduledMessage{TargetType: model.MessageTargetRoom, TargetID: 1, Content: "hi", SendAt: now.Add(time.Hour)}, nil},
+		{"past", model.ScheduledMessage{TargetType: model.MessageTargetUser, TargetID: 1, Content: "hi", SendAt: now.Add(-time.Minute)}, ErrInvalidSendTime},
+		{"too far", model.ScheduledMessage{TargetType: model.MessageTargetUser, TargetID: 1, Content: "hi", SendAt: now.AddDate(0, 0, 31)}, ErrInvalidSendTime},
+		{"empty", model.ScheduledMessage{TargetType: model.MessageTargetUser, TargetID: 1, SendAt: now.Add(time.Hour)}, ErrInvalidOperation},
+		{"not a member", model.ScheduledMessage{TargetType: model.MessageTargetRoom, TargetID: 2, Content: "hi", SendAt: now.Add(time.Hour)}, ErrNotRoomMember},
+		{"broadcast", model.ScheduledMessage{TargetType: model.MessageTargetAll, TargetID: 1, Content: "hi", SendAt: now.Add(time.Hour)}, ErrInvalidOperation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.scheduled.SenderID = 2
+			if err := f.service.Schedule(context.Background(), &tt.scheduled); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatchDue(t *testing.T) {
+	ctx := context.Background()
+	f := newSchedulerFixture(t)
+	phone := f.connect(t, 1, "phone")
+
+	dm := f.due(t, 2, model.MessageTargetUser, 1, "dm")
+	room := f.due(t, 2, model.MessageTargetRoom, 1, "room")
+	left := f.due(t, 2, model.MessageTargetRoom, 2, "left") // 创建后发送者已不在房间中
+
+	// 宕机实例持有的租约已过期
+	stale := f.due(t, 2, model.MessageTargetUser, 1, "stale")
+	if err := f.db.Model(stale).Updates(map[string]any{
+		"status":       model.ScheduledSending,
+		"locked_by":    "node-b",
+		"locked_until": time.Now().Add(-time.Second),
+	}).Error; err != nil {
+		t.Fatalf("lease stale message: %v", err)
+	}
+
+	f.service.dispatchDue(ctx)
+
+	if got := receiveMessages(t, phone, 2); !slices.Equal(got, []string{"dm", "room"}) {
+		t.Errorf("received %v, want [dm room]", got)
+	}
+	for _, scheduled := range []*model.ScheduledMessage{dm, room} {
+		if got := f.get(t, scheduled.ID); got.Status != model.ScheduledSent || got.MessageID == 0 {
+			t.Errorf("%s = status %s message %d, want sent", scheduled.Content, got.Status, got.MessageID)
+		}
+	}
+	if got := f.get(t, left.ID); got.Status != model.ScheduledFailed || got.Error != ErrNotRoomMember.Error() {
+		t.Errorf("left = status %s error %q, want failed %q", got.Status, got.Error, ErrNotRoomMember)
+	}
+	if got := f.get(t, stale.ID); got.Status != model.ScheduledFailed {
+		t.Errorf("stale = status %s, want failed", got.Status)
+	}
+
+	// 已发送的消息不会再次发送
+	f.service.dispatchDue(ctx)
+	expectNoMessage(t, phone)
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/Gopher0727/RTMP/config"
+)
+
+// SignalTyping 正在输入信号
+const SignalTyping = "typing"
+
+const (
+	defaultSignalTTL     = 6 * time.Second // 默认的信号有效期
+	defaultSignalRefresh = 2 * time.Second // 默认的重复信号合并间隔
+	defaultSignalRate    = 5               // 默认每个用户每秒可发送的信号数
+	signalStaleAfter     = 5 * time.Second // 结束信号的有效期，跨实例转发超过此时间后丢弃
+	maxSignalDataSize    = 1024            // 自定义信号数据的最大字节数
+)
+
+// signalNamePattern 信号名：小写字母开头，由小写字母、数字和下划线组成
+var signalNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// Signal 不持久化的会话信号（正在输入等），只推送给会话参与者当前在线的设备
+type Signal struct {
+	ConversationID string          `json:"conversation_id"`
+	UserID         uint            `json:"user_id"`
+	Name           string          `json:"name"`
+	Active         bool            `json:"active"`         // 开始或刷新为true，结束为false
+	Data           json.RawMessage `json:"data,omitempty"` // 自定义数据
+	ExpiresAt      time.Time       `json:"expires_at"`     // 过期时间，过期后不再推送
+}
+
+// expiresIn 剩余有效期（秒，向上取整），结束信号为0
+func (s *Signal) expiresIn(now time.Time) int {
+	if !s.Active {
+		return 0
+	}
+	return int((s.ExpiresAt.Sub(now) + time.Second - 1) / time.Second)
+}
+
+// signalKey 活跃信号的键
+type signalKey struct {
+	userID         uint
+	conversationID string
+	name           string
+}
+
+// signalState 活跃信号的状态
+type signalState struct {
+	sentAt time.Time   // 上次推送的时间
+	timer  *time.Timer // 超时未刷新时结束信号
+}
+
+// signalTracker 跟踪本实例用户的活跃信号：限制发送频率、合并重复的信号，超时未刷新时结束信号
+type signalTracker struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	refresh  time.Duration
+	limit    rate.Limit
+	burst    int
+	limiters map[uint]*rate.Limiter
+	active   map[signalKey]*signalState
+	expire   func(signal *Signal) // 信号超时未刷新时调用，参数为结束信号
+}
+
+// newSignalTracker 创建信号跟踪器
+func newSignalTracker(hc config.HubConfig, expire func(signal *Signal)) *signalTracker {
+	perSecond := hc.SignalRatePerSecond
+	if perSecond <= 0 {
+		perSecond = defaultSignalRate
+	}
+	return &signalTracker{
+		ttl:      secondsOr(hc.SignalTTLSeconds, defaultSignalTTL),
+		refresh:  secondsOr(hc.SignalRefreshSeconds, defaultSignalRefresh),
+		limit:    rate.Limit(perSecond),
+		burst:    perSecond * 2,
+		limiters: make(map[uint]*rate.Limiter),
+		active:   make(map[signalKey]*signalState),
+		expire:   expire,
+	}
+}
+
+// validSignal 检查信号名和自定义数据
+func validSignal(name string, data json.RawMessage) bool {
+	return signalNamePattern.MatchString(name) && len(data) <= maxSignalDataSize && (len(data) == 0 || json.Valid(data))
+}
+
+// allow 检查用户是否超出发送频率限制
+func (t *signalTracker) allow(userID uint) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limiter, ok := t.limiters[userID]
+	if !ok {
+		limiter = rate.NewLimiter(t.limit, t.burst)
+		t.limiters[userID] = limiter
+	}
+	return limiter.Allow()
+}
+
+// track 记录信号并设置过期时间，返回是否需要推送：
+// 重复的开始信号在合并间隔内只延长有效期，没有对应开始信号的结束信号直接忽略
+func (t *signalTracker) track(signal *Signal) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	key := signalKey{userID: signal.UserID, conversationID: signal.ConversationID, name: signal.Name}
+	state := t.active[key]
+
+	if !signal.Active {
+		signal.ExpiresAt = now.Add(signalStaleAfter)
+		if state == nil {
+			return false
+		}
+		state.timer.Stop()
+		delete(t.active, key)
+		return true
+	}
+
+	signal.ExpiresAt = now.Add(t.ttl)
+	if state != nil {
+		state.timer.Reset(t.ttl)
+		// 带自定义数据的信号内容可能变化，总是推送
+		if now.Sub(state.sentAt) < t.refresh && len(signal.Data) == 0 {
+			return false
+		}
+		state.sentAt = now
+		return true
+	}
+
+	state = &signalState{sentAt: now}
+	state.timer = time.AfterFunc(t.ttl, func() { t.timeout(key, state) })
+	t.active[key] = state
+	return true
+}
+
+// timeout 信号超时未刷新，移除并推送结束信号
+func (t *signalTracker) timeout(key signalKey, state *signalState) {
+	t.mu.Lock()
+	// 计时器触发时信号可能已被结束或重新开始
+	if t.active[key] != state {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.active, key)
+	t.mu.Unlock()
+
+	t.expire(endSignal(key))
+}
+
+// clear 移除用户的全部活跃信号和频率限制，返回需要推送的结束信号，用于用户在本实例的设备全部下线时
+func (t *signalTracker) clear(userID uint) []*Signal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.limiters, userID)
+	var ended []*Signal
+	for key, state := range t.active {
+		if key.userID != userID {
+			continue
+		}
+		state.timer.Stop()
+		delete(t.active, key)
+		ended = append(ended, endSignal(key))
+	}
+	return ended
+}
+
+// endSignal 创建结束信号
+func endSignal(key signalKey) *Signal {
+	return &Signal{
+		ConversationID: key.conversationID,
+		UserID:         key.userID,
+		Name:           key.name,
+		ExpiresAt:      time.Now().Add(signalStaleAfter),
+	}
+}
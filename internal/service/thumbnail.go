@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // 注册GIF解码器
+	"image/jpeg"
+	_ "image/png" // 注册PNG解码器
+)
+
+const (
+	thumbnailQuality   = 80
+	maxThumbnailPixels = 50_000_000 // 超过此像素数的图片不生成缩略图，防止解码占用过多内存
+)
+
+// makeThumbnail 生成JPEG缩略图，最长边不超过maxSize，透明区域填充为白色；返回缩略图及原图尺寸
+func makeThumbnail(data []byte, maxSize int) ([]byte, int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, cfg.Width, cfg.Height, errors.New("image too large for thumbnail")
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+
+	width, height := cfg.Width, cfg.Height
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			height = max(1, height*maxSize/width)
+			width = maxSize
+		} else {
+			width = max(1, width*maxSize/height)
+			height = maxSize
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, width, height), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+	return buf.Bytes(), cfg.Width, cfg.Height, nil
+}
+
+// scaleDown 按区域平均缩小图片，并合成到白色背景上
+func scaleDown(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*sh/height
+		y1 := max(b.Min.Y+(y+1)*sh/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*sw/width
+			x1 := max(b.Min.X+(x+1)*sw/width, x0+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// 颜色已预乘透明度，叠加白色背景只需补上透明部分
+			white := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r/n + white),
+				G: uint16(g/n + white),
+				B: uint16(bl/n + white),
+				A: 0xffff,
+			})
+		}
+	}
+	return dst
+}
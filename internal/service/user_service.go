@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"errors"
+	"log"
+	"slices"
 
 	"github.com/google/wire"
 	"gorm.io/gorm"
@@ -19,6 +21,7 @@ type IUserService interface {
 	GetUserByID(ctx context.Context, id uint) (*model.User, error)
 	UpdateUserStatus(ctx context.Context, id uint, status int, instanceID string) error
 	ListUsers(ctx context.Context, page, size int) ([]*model.User, int64, error)
+	GrantAdmin(ctx context.Context, userIDs []uint) error
 }
 
 // UserServiceImp 用户服务实现
@@ -109,5 +112,18 @@ func (s *UserServiceImp) ListUsers(ctx context.Context, page, size int) ([]*mode
 	return s.userRepo.List(ctx, page, size)
 }
 
+// GrantAdmin 授予用户管理员角色，不存在的用户只记录日志
+func (s *UserServiceImp) GrantAdmin(ctx context.Context, userIDs []uint) error {
+	userIDs = slices.Compact(slices.Sorted(slices.Values(userIDs)))
+	found, err := s.userRepo.SetRole(ctx, userIDs, model.UserRoleAdmin)
+	if err != nil {
+		return err
+	}
+	if found < int64(len(userIDs)) {
+		log.Printf("Granted admin role to %d of %d configured users, the rest do not exist", found, len(userIDs))
+	}
+	return nil
+}
+
 // UserServiceSet 用户服务依赖注入
 var UserServiceSet = wire.NewSet(NewUserService)
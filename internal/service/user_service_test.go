@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Gopher0727/RTMP/internal/model"
+	"github.com/Gopher0727/RTMP/internal/repository"
+)
+
+func TestGrantAdmin(t *testing.T) {
+	db := newTestDB(t)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if err := db.Create(&model.User{Username: username, Password: "x", Email: username + "@example.com"}).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	users := NewUserService(repository.NewUserRepository(db))
+	ctx := context.Background()
+
+	// 重复的ID和不存在的用户不影响其他用户
+	if err := users.GrantAdmin(ctx, []uint{3, 1, 3, 42}); err != nil {
+		t.Fatalf("GrantAdmin: %v", err)
+	}
+	// 再次授予时保持不变
+	if err := users.GrantAdmin(ctx, []uint{1}); err != nil {
+		t.Fatalf("GrantAdmin: %v", err)
+	}
+	if err := users.GrantAdmin(ctx, nil); err != nil {
+		t.Fatalf("GrantAdmin(nil): %v", err)
+	}
+
+	want := map[uint]int{1: model.UserRoleAdmin, 2: model.UserRoleMember, 3: model.UserRoleAdmin}
+	for id, role := range want {
+		user, err := users.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("GetUserByID(%d): %v", id, err)
+		}
+		if user.Role != role {
+			t.Errorf("user %d role = %d, want %d", id, user.Role, role)
+		}
+	}
+}
@@ -4,29 +4,57 @@
 package internal
 
 import (
+	"context"
+	"fmt"
+	"log"
+
 	"github.com/google/wire"
 	"gorm.io/gorm"
 
 	"github.com/Gopher0727/RTMP/config"
 	"github.com/Gopher0727/RTMP/internal/api"
+	"github.com/Gopher0727/RTMP/internal/blob"
+	"github.com/Gopher0727/RTMP/internal/instance"
 	"github.com/Gopher0727/RTMP/internal/kafka"
 	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
 	"github.com/Gopher0727/RTMP/internal/service"
 )
 
 // InitApp 初始化应用依赖
-func InitApp(db *gorm.DB) (*App, error) {
+func InitApp(db *gorm.DB, cfg *config.Config) (*App, error) {
 	wire.Build(
+		// 实例身份
+		instance.IdentitySet,
+
 		// 仓库层
 		repository.UserRepositorySet,
 		repository.MessageRepositorySet,
 		repository.RoomRepositorySet,
+		repository.AttachmentRepositorySet,
+		repository.ReactionRepositorySet,
+		repository.ConversationRepositorySet,
+		repository.ScheduledMessageRepositorySet,
+
+		// 附件对象存储
+		blob.BlobStoreSet,
+
+		// 消息搜索索引
+		search.SearchIndexSet,
 
 		// 服务层
 		service.UserServiceSet,
 		service.MessageServiceSet,
 		service.RoomServiceSet,
+		service.OfflineServiceSet,
+		service.PresenceServiceSet,
 		service.HubServiceSet,
+		service.PollServiceSet,
+		service.AttachmentServiceSet,
+		service.ConversationServiceSet,
+		service.BroadcastServiceSet,
+		service.ScheduledMessageServiceSet,
+		service.ExpiryServiceSet,
 
 		// API处理器层
 		api.AuthHandlerSet,
@@ -34,9 +62,9 @@ func InitApp(db *gorm.DB) (*App, error) {
 		api.MessageHandlerSet,
 		api.RoomHandlerSet,
 		api.HubHandlerSet,
-
-		// 配置
-		wire.Value(&config.Config{}),
+		api.AttachmentHandlerSet,
+		api.ConversationHandlerSet,
+		api.AdminHandlerSet,
 
 		// 应用
 		NewApp,
@@ -52,6 +80,9 @@ type App struct {
 	RoomService    service.IRoomService
 	HubService     service.IHubService
 
+	ScheduledMessageService service.IScheduledMessageService
+	ExpiryService           service.IExpiryService
+
 	// API处理器层
 	AuthHandler    *api.AuthHandler
 	UserHandler    *api.UserHandler
@@ -59,6 +90,13 @@ type App struct {
 	RoomHandler    *api.RoomHandler
 	HubHandler     *api.HubHandler
 
+	AttachmentHandler   *api.AttachmentHandler
+	ConversationHandler *api.ConversationHandler
+	AdminHandler        *api.AdminHandler
+
+	// 实例身份
+	Identity *instance.Identity
+
 	// 配置
 	Config *config.Config
 }
@@ -69,35 +107,67 @@ func NewApp(
 	messageService service.IMessageService,
 	roomService service.IRoomService,
 	hubService service.IHubService,
+	scheduledMessageService service.IScheduledMessageService,
+	expiryService service.IExpiryService,
 	authHandler *api.AuthHandler,
 	userHandler *api.UserHandler,
 	messageHandler *api.MessageHandler,
 	roomHandler *api.RoomHandler,
 	hubHandler *api.HubHandler,
+	attachmentHandler *api.AttachmentHandler,
+	conversationHandler *api.ConversationHandler,
+	adminHandler *api.AdminHandler,
+	identity *instance.Identity,
 	config *config.Config,
 ) *App {
+	// 日志统一带上实例ID
+	log.SetPrefix(fmt.Sprintf("[%s] ", identity.ID()))
+
 	// 初始化Kafka生产者
-	if err := kafka.InitKafka(config); err != nil {
+	if err := kafka.InitKafka(config, identity); err != nil {
 		// todo
 		panic("Failed to initialize Kafka producer: " + err.Error())
 	}
 
+	// Hub通过Kafka生产者将消息转发给其他实例
+	hubService.SetMessageNotifier(kafka.GetProducer())
+
+	// 授予配置中的用户管理员角色
+	if err := userService.GrantAdmin(context.Background(), config.Admin.UserIDs); err != nil {
+		panic("Failed to grant admin role: " + err.Error())
+	}
+
+	// 启动实例心跳和宕机实例清理
+	hubService.StartPresence(context.Background())
+
+	// 启动定时消息发送任务
+	scheduledMessageService.Start(context.Background())
+
+	// 启动过期消息清理任务
+	expiryService.Start(context.Background())
+
 	// 初始化Kafka消费者（在服务初始化后）
-	if err := kafka.InitConsumer(config, messageService, hubService); err != nil {
+	if err := kafka.InitConsumer(config, identity, messageService, hubService); err != nil {
 		// todo
 		panic("Failed to initialize Kafka consumer: " + err.Error())
 	}
 
 	return &App{
-		UserService:    userService,
-		MessageService: messageService,
-		RoomService:    roomService,
-		HubService:     hubService,
-		AuthHandler:    authHandler,
-		UserHandler:    userHandler,
-		MessageHandler: messageHandler,
-		RoomHandler:    roomHandler,
-		HubHandler:     hubHandler,
-		Config:         config,
+		UserService:             userService,
+		MessageService:          messageService,
+		RoomService:             roomService,
+		HubService:              hubService,
+		ScheduledMessageService: scheduledMessageService,
+		ExpiryService:           expiryService,
+		AuthHandler:             authHandler,
+		UserHandler:             userHandler,
+		MessageHandler:          messageHandler,
+		RoomHandler:             roomHandler,
+		HubHandler:              hubHandler,
+		AttachmentHandler:       attachmentHandler,
+		ConversationHandler:     conversationHandler,
+		AdminHandler:            adminHandler,
+		Identity:                identity,
+		Config:                  config,
 	}
 }
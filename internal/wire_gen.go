@@ -7,41 +7,70 @@
 package internal
 
 import (
+	"context"
+	"fmt"
 	"github.com/Gopher0727/RTMP/config"
 	"github.com/Gopher0727/RTMP/internal/api"
+	"github.com/Gopher0727/RTMP/internal/blob"
+	"github.com/Gopher0727/RTMP/internal/instance"
+	"github.com/Gopher0727/RTMP/internal/kafka"
 	"github.com/Gopher0727/RTMP/internal/repository"
+	"github.com/Gopher0727/RTMP/internal/search"
 	"github.com/Gopher0727/RTMP/internal/service"
 	"gorm.io/gorm"
+	"log"
 )
 
 // Injectors from wire.go:
 
 // InitApp 初始化应用依赖
-func InitApp(db *gorm.DB) (*App, error) {
+func InitApp(db *gorm.DB, cfg *config.Config) (*App, error) {
+	identity, err := instance.NewIdentity(cfg)
+	if err != nil {
+		return nil, err
+	}
 	iUserRepository := repository.NewUserRepository(db)
 	iMessageRepository := repository.NewMessageRepository(db)
 	iRoomRepository := repository.NewRoomRepository(db)
+	iAttachmentRepository := repository.NewAttachmentRepository(db)
+	iReactionRepository := repository.NewReactionRepository(db)
+	iConversationRepository := repository.NewConversationRepository(db)
+	iScheduledMessageRepository := repository.NewScheduledMessageRepository(db)
+	blobStore, err := blob.NewBlobStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	searchIndex, err := search.NewSearchIndex(cfg, db)
+	if err != nil {
+		return nil, err
+	}
 
 	iUserService := service.NewUserService(iUserRepository)
-	iMessageService := service.NewMessageService(iMessageRepository, iRoomRepository)
-	iRoomService := service.NewRoomService(iRoomRepository)
-	iHubService := service.NewHubService(iUserRepository, iMessageRepository, iRoomRepository, db)
+	iMessageService := service.NewMessageService(cfg, iMessageRepository, iRoomRepository, iReactionRepository, searchIndex)
+	iRoomService := service.NewRoomService(cfg, iRoomRepository, identity)
+	iOfflineService := service.NewOfflineService(cfg)
+	iPresenceService := service.NewPresenceService(cfg, identity)
+	iHubService := service.NewHubService(cfg, iUserRepository, iMessageRepository, iRoomRepository, db, identity, iOfflineService, iPresenceService, searchIndex)
+	iPollService := service.NewPollService(cfg, iHubService)
+	iAttachmentService := service.NewAttachmentService(cfg, iAttachmentRepository, iMessageRepository, iRoomRepository, blobStore)
+	iConversationService := service.NewConversationService(iConversationRepository, iMessageRepository, iRoomRepository)
+	iBroadcastService := service.NewBroadcastService(iUserRepository, iRoomRepository, iHubService)
+	iScheduledMessageService := service.NewScheduledMessageService(cfg, iScheduledMessageRepository, iRoomRepository, iAttachmentService, iHubService, identity)
+	iExpiryService := service.NewExpiryService(cfg, iMessageRepository, iRoomRepository, iOfflineService, iHubService, searchIndex, blobStore)
 
 	authHandler := api.NewAuthHandler(iUserService)
 	userHandler := api.NewUserHandler(iUserService)
-	messageHandler := api.NewMessageHandler(iMessageService)
+	messageHandler := api.NewMessageHandler(iMessageService, iHubService, iAttachmentService, iScheduledMessageService, iRoomService)
 	roomHandler := api.NewRoomHandler(iRoomService)
-	hubHandler := api.NewHubHandler(iHubService, iUserService, iMessageService, iRoomService)
+	hubHandler := api.NewHubHandler(iHubService, iUserService, iMessageService, iRoomService, iPollService, iAttachmentService)
+	attachmentHandler := api.NewAttachmentHandler(iAttachmentService)
+	conversationHandler := api.NewConversationHandler(iConversationService)
+	adminHandler := api.NewAdminHandler(iBroadcastService)
 
-	config := wireConfigValue
-	app := NewApp(iUserService, iMessageService, iRoomService, iHubService, authHandler, userHandler, messageHandler, roomHandler, hubHandler, config)
+	app := NewApp(iUserService, iMessageService, iRoomService, iHubService, iScheduledMessageService, iExpiryService, authHandler, userHandler, messageHandler, roomHandler, hubHandler, attachmentHandler, conversationHandler, adminHandler, identity, cfg)
 	return app, nil
 }
 
-var (
-	wireConfigValue = &config.Config{}
-)
-
 // wire.go:
 
 // App 应用结构体
@@ -52,12 +81,22 @@ type App struct {
 	RoomService    service.IRoomService
 	HubService     service.IHubService
 
+	ScheduledMessageService service.IScheduledMessageService
+	ExpiryService           service.IExpiryService
+
 	// API处理器层
 	AuthHandler    *api.AuthHandler
 	UserHandler    *api.UserHandler
 	MessageHandler *api.MessageHandler
 	RoomHandler    *api.RoomHandler
-	HubHandler     *api.HubHandler // 添加HubHandler
+	HubHandler     *api.HubHandler
+
+	AttachmentHandler   *api.AttachmentHandler
+	ConversationHandler *api.ConversationHandler
+	AdminHandler        *api.AdminHandler
+
+	// 实例身份
+	Identity *instance.Identity
 
 	// 配置
 	Config *config.Config
@@ -69,23 +108,67 @@ func NewApp(
 	messageService service.IMessageService,
 	roomService service.IRoomService,
 	hubService service.IHubService,
+	scheduledMessageService service.IScheduledMessageService,
+	expiryService service.IExpiryService,
 	authHandler *api.AuthHandler,
 	userHandler *api.UserHandler,
 	messageHandler *api.MessageHandler,
 	roomHandler *api.RoomHandler,
 	hubHandler *api.HubHandler,
+	attachmentHandler *api.AttachmentHandler,
+	conversationHandler *api.ConversationHandler,
+	adminHandler *api.AdminHandler,
+	identity *instance.Identity,
 	config *config.Config,
 ) *App {
+	// 日志统一带上实例ID
+	log.SetPrefix(fmt.Sprintf("[%s] ", identity.ID()))
+
+	// 初始化Kafka生产者
+	if err := kafka.InitKafka(config, identity); err != nil {
+		// todo
+		panic("Failed to initialize Kafka producer: " + err.Error())
+	}
+
+	// Hub通过Kafka生产者将消息转发给其他实例
+	hubService.SetMessageNotifier(kafka.GetProducer())
+
+	// 授予配置中的用户管理员角色
+	if err := userService.GrantAdmin(context.Background(), config.Admin.UserIDs); err != nil {
+		panic("Failed to grant admin role: " + err.Error())
+	}
+
+	// 启动实例心跳和宕机实例清理
+	hubService.StartPresence(context.Background())
+
+	// 启动定时消息发送任务
+	scheduledMessageService.Start(context.Background())
+
+	// 启动过期消息清理任务
+	expiryService.Start(context.Background())
+
+	// 初始化Kafka消费者（在服务初始化后）
+	if err := kafka.InitConsumer(config, identity, messageService, hubService); err != nil {
+		// todo
+		panic("Failed to initialize Kafka consumer: " + err.Error())
+	}
+
 	return &App{
-		UserService:    userService,
-		MessageService: messageService,
-		RoomService:    roomService,
-		HubService:     hubService,
-		AuthHandler:    authHandler,
-		UserHandler:    userHandler,
-		MessageHandler: messageHandler,
-		RoomHandler:    roomHandler,
-		HubHandler:     hubHandler,
-		Config:         config,
+		UserService:             userService,
+		MessageService:          messageService,
+		RoomService:             roomService,
+		HubService:              hubService,
+		ScheduledMessageService: scheduledMessageService,
+		ExpiryService:           expiryService,
+		AuthHandler:             authHandler,
+		UserHandler:             userHandler,
+		MessageHandler:          messageHandler,
+		RoomHandler:             roomHandler,
+		HubHandler:              hubHandler,
+		AttachmentHandler:       attachmentHandler,
+		ConversationHandler:     conversationHandler,
+		AdminHandler:            adminHandler,
+		Identity:                identity,
+		Config:                  config,
 	}
 }
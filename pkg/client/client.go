@@ -0,0 +1,319 @@
+// Package client 是推送服务 rtmp.v1 WebSocket 协议的 Go 客户端。
+//
+//	c, err := client.Dial(ctx, "ws://localhost:8080/api/v1/ws", client.Options{Token: token, DeviceID: "cli"})
+//	sent, err := c.Send(ctx, "user", 2, "hello")
+//	for frame := range c.Incoming() {
+//		msg, _ := client.DecodeMessage(frame)
+//		c.Ack(ctx, msg.ConversationID, msg.Seq)
+//	}
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Gopher0727/RTMP/pkg/protocol"
+)
+
+const (
+	writeWait       = 10 * time.Second // 单次写超时
+	incomingBufSize = 256              // 推送帧缓冲长度
+)
+
+var (
+	// ErrNotNegotiated 服务端未确认rtmp.v1子协议
+	ErrNotNegotiated = errors.New("server did not negotiate " + protocol.Subprotocol)
+	// ErrClosed 连接已关闭
+	ErrClosed = errors.New("client closed")
+)
+
+// Options 连接选项
+type Options struct {
+	Token    string            // JWT令牌，以 Authorization: Bearer 方式发送
+	DeviceID string            // 设备ID，固定的设备ID才能在重连时补发未确认的消息
+	Dialer   *websocket.Dialer // 为空时使用 websocket.DefaultDialer
+	Header   http.Header       // 额外的握手请求头
+}
+
+// Client rtmp.v1协议客户端，可并发使用
+type Client struct {
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	nextID   atomic.Uint64
+	incoming chan *protocol.Frame
+
+	mu      sync.Mutex
+	pending map[string]chan *protocol.Frame
+	err     error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial 连接推送服务并协商rtmp.v1协议
+func Dial(ctx context.Context, rawURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DeviceID != "" {
+		q := u.Query()
+		q.Set("device_id", opts.DeviceID)
+		u.RawQuery = q.Encode()
+	}
+
+	header := http.Header{}
+	for k, v := range opts.Header {
+		header[k] = v
+	}
+	if opts.Token != "" {
+		header.Set("Authorization", "Bearer "+opts.Token)
+	}
+
+	dialer := websocket.DefaultDialer
+	if opts.Dialer != nil {
+		dialer = opts.Dialer
+	}
+	d := *dialer
+	d.Subprotocols = []string{protocol.Subprotocol}
+
+	conn, _, err := d.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+	if conn.Subprotocol() != protocol.Subprotocol {
+		conn.Close()
+		return nil, ErrNotNegotiated
+	}
+
+	c := &Client{
+		conn:     conn,
+		incoming: make(chan *protocol.Frame, incomingBufSize),
+		pending:  make(map[string]chan *protocol.Frame),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Incoming 服务端推送的message和event帧，以及不对应任何请求的error帧；连接关闭后通道被关闭
+func (c *Client) Incoming() <-chan *protocol.Frame {
+	return c.incoming
+}
+
+// Send 发送私聊（targetType为user）或房间（room）消息，返回服务端分配的消息ID和序号
+func (c *Client) Send(ctx context.Context, targetType string, targetID uint, content string) (*protocol.SentData, error) {
+	var sent protocol.SentData
+	err := c.request(ctx, protocol.TypeSend, protocol.SendData{
+		TargetType: targetType,
+		TargetID:   targetID,
+		Content:    content,
+	}, &sent)
+	if err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+// Reply 回复同一会话中的消息
+func (c *Client) Reply(ctx context.Context, targetType string, targetID, replyToID uint, content string) (*protocol.SentData, error) {
+	var sent protocol.SentData
+	err := c.request(ctx, protocol.TypeSend, protocol.SendData{
+		TargetType: targetType,
+		TargetID:   targetID,
+		Content:    content,
+		ReplyToID:  replyToID,
+	}, &sent)
+	if err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+// Ack 确认已收到会话中序号不大于seq的消息
+func (c *Client) Ack(ctx context.Context, conversationID string, seq uint64) error {
+	return c.request(ctx, protocol.TypeAck, protocol.AckData{ConversationID: conversationID, Seq: seq}, nil)
+}
+
+// Typing 发送正在输入状态，持续输入时需在服务端的有效期内重复发送，否则视为停止输入
+func (c *Client) Typing(ctx context.Context, conversationID string, typing bool) error {
+	return c.request(ctx, protocol.TypeTyping, protocol.TypingData{ConversationID: conversationID, Typing: typing}, nil)
+}
+
+// Signal 发送自定义的临时信号，data为nil时不携带数据
+func (c *Client) Signal(ctx context.Context, conversationID, signal string, active bool, data any) error {
+	req := protocol.SignalData{ConversationID: conversationID, Signal: signal, Active: active}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		req.Data = raw
+	}
+	return c.request(ctx, protocol.TypeSignal, req, nil)
+}
+
+// Subscribe 订阅房间会话，订阅后连接只接收已订阅房间的消息
+func (c *Client) Subscribe(ctx context.Context, conversationIDs ...string) error {
+	return c.request(ctx, protocol.TypeSubscribe, protocol.SubscribeData{ConversationIDs: conversationIDs}, nil)
+}
+
+// Unsubscribe 取消订阅房间会话
+func (c *Client) Unsubscribe(ctx context.Context, conversationIDs ...string) error {
+	return c.request(ctx, protocol.TypeUnsubscribe, protocol.SubscribeData{ConversationIDs: conversationIDs}, nil)
+}
+
+// Ping 应用层心跳
+func (c *Client) Ping(ctx context.Context) error {
+	return c.request(ctx, protocol.TypePing, nil, nil)
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	c.writeMu.Lock()
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+	c.writeMu.Unlock()
+
+	c.shutdown(ErrClosed)
+	return c.conn.Close()
+}
+
+// Err 连接关闭的原因，连接未关闭时为nil
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// request 发送带请求ID的帧并等待对应的回复，error回复以*protocol.ErrorData返回
+func (c *Client) request(ctx context.Context, frameType string, data any, reply any) error {
+	id := strconv.FormatUint(c.nextID.Add(1), 10)
+	ch := make(chan *protocol.Frame, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(frameType, id, data); err != nil {
+		return err
+	}
+
+	select {
+	case frame := <-ch:
+		if frame.Type == protocol.TypeError {
+			var e protocol.ErrorData
+			if err := frame.DecodeData(&e); err != nil {
+				return err
+			}
+			return &e
+		}
+		if reply != nil {
+			return frame.DecodeData(reply)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return c.Err()
+	}
+}
+
+// write 写出一帧
+func (c *Client) write(frameType, id string, data any) error {
+	msg, err := protocol.Encode(frameType, id, data)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// readLoop 读取服务端帧，回复交给等待中的请求，推送放入Incoming
+func (c *Client) readLoop() {
+	defer close(c.incoming)
+
+	for {
+		_, raw, err := c.conn.ReadMessage()
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+
+		frame, err := protocol.Decode(raw)
+		if err != nil {
+			continue
+		}
+
+		switch frame.Type {
+		case protocol.TypeOK, protocol.TypeError, protocol.TypePong:
+			c.mu.Lock()
+			ch, ok := c.pending[frame.ID]
+			c.mu.Unlock()
+			if ok {
+				ch <- frame
+				continue
+			}
+			if frame.Type != protocol.TypeError {
+				continue
+			}
+		}
+
+		select {
+		case c.incoming <- frame:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// shutdown 记录关闭原因并唤醒所有等待中的请求
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+	})
+}
+
+// DecodeMessage 解析message帧
+func DecodeMessage(frame *protocol.Frame) (*protocol.Message, error) {
+	var msg protocol.Message
+	if err := frame.DecodeData(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// DecodeEvent 解析event帧
+func DecodeEvent(frame *protocol.Frame) (*protocol.Event, error) {
+	var event protocol.Event
+	if err := frame.DecodeData(&event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
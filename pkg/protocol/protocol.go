@@ -0,0 +1,184 @@
+// Package protocol 定义客户端与推送服务之间的 WebSocket 帧格式。
+//
+// 客户端在握手时通过 Sec-WebSocket-Protocol 请求 Subprotocol，服务端确认后
+// 双方的每条文本消息都是一个 Frame。带有 ID 的客户端帧会收到且只会收到一个
+// 同 ID 的 ok 或 error 回复。
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version 当前协议版本
+const Version = 1
+
+// Subprotocol 握手时协商的子协议名
+const Subprotocol = "rtmp.v1"
+
+// 客户端发送的帧类型
+const (
+	TypeSend        = "send"        // 发送消息
+	TypeAck         = "ack"         // 确认已收到的消息
+	TypeTyping      = "typing"      // 正在输入
+	TypeSignal      = "signal"      // 自定义的临时信号
+	TypeSubscribe   = "subscribe"   // 订阅房间会话
+	TypeUnsubscribe = "unsubscribe" // 取消订阅房间会话
+	TypePing        = "ping"        // 应用层心跳
+)
+
+// 服务端发送的帧类型
+const (
+	TypeMessage = "message" // 推送的聊天消息
+	TypeEvent   = "event"   // 推送的事件（在线状态、正在输入等）
+	TypeOK      = "ok"      // 请求成功的回复
+	TypeError   = "error"   // 请求失败的回复
+	TypePong    = "pong"    // 心跳回复
+)
+
+// 错误码
+const (
+	CodeBadRequest         = "bad_request"         // 帧或数据格式错误
+	CodeUnsupportedVersion = "unsupported_version" // 协议版本不支持
+	CodeUnknownType        = "unknown_type"        // 未知的帧类型
+	CodeForbidden          = "forbidden"           // 无权访问目标会话
+	CodeNotFound           = "not_found"           // 目标不存在
+	CodeRateLimited        = "rate_limited"        // 发送过于频繁
+	CodeInternal           = "internal_error"      // 服务端内部错误
+)
+
+// ErrVersion 帧的协议版本不受支持
+var ErrVersion = errors.New("unsupported protocol version")
+
+// Frame 协议信封
+type Frame struct {
+	V    int             `json:"v"`              // 协议版本
+	Type string          `json:"type"`           // 帧类型
+	ID   string          `json:"id,omitempty"`   // 请求ID，回复时原样带回
+	Data json.RawMessage `json:"data,omitempty"` // 帧数据，结构由Type决定
+}
+
+// Message 推送的聊天消息（message帧的数据）
+type Message struct {
+	ID             uint      `json:"id"`
+	Content        string    `json:"content"`
+	Type           string    `json:"type"`
+	TargetType     string    `json:"target_type"` // user | room
+	TargetID       uint      `json:"target_id"`
+	SenderID       uint      `json:"sender_id"`
+	SenderName     string    `json:"sender_name"`
+	ReceiverID     uint      `json:"receiver_id"`
+	RoomID         uint      `json:"room_id"`
+	ConversationID string    `json:"conversation_id"`
+	Seq            uint64    `json:"seq"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Event 推送的事件（event帧的数据），Data结构由Type决定
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// SendData 发送消息
+type SendData struct {
+	TargetType string `json:"target_type"` // user | room
+	TargetID   uint   `json:"target_id"`   // 用户ID或房间ID
+	Content    string `json:"content"`
+	// AttachmentIDs 通过 POST /api/v1/attachments 上传的附件，发送后关联到消息
+	AttachmentIDs []uint `json:"attachment_ids,omitempty"`
+	// ReplyToID 回复的消息ID，须属于同一会话，回复后消息归入被回复消息所在的话题
+	ReplyToID uint `json:"reply_to_id,omitempty"`
+	// TTLSeconds 有效期（秒），过期后消息被删除并推送 messages_expired 事件，0表示不过期
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+	// ClientMsgID 客户端生成的消息ID（不超过64字节），重发时使用相同的ID，服务端回复首次发送的消息而不会重复创建
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+}
+
+// SentData 发送成功的回复
+type SentData struct {
+	MessageID      uint   `json:"message_id"`
+	ConversationID string `json:"conversation_id"`
+	Seq            uint64 `json:"seq"`
+	ThreadRootID   uint   `json:"thread_root_id,omitempty"` // 回复所属话题的根消息ID
+}
+
+// AckData 确认设备在会话中已收到的最大序号
+type AckData struct {
+	ConversationID string `json:"conversation_id"`
+	Seq            uint64 `json:"seq"`
+}
+
+// TypingData 正在输入状态
+type TypingData struct {
+	ConversationID string `json:"conversation_id"`
+	Typing         bool   `json:"typing"`
+}
+
+// SignalData 自定义的临时信号，不持久化，只推送给会话中其他参与者当前在线的设备
+type SignalData struct {
+	ConversationID string          `json:"conversation_id"`
+	Signal         string          `json:"signal"`         // 信号名，小写字母开头，由小写字母、数字和下划线组成
+	Active         bool            `json:"active"`         // 开始或刷新为true，结束为false
+	Data           json.RawMessage `json:"data,omitempty"` // 自定义数据，不超过1KB
+}
+
+// SubscribeData 订阅或取消订阅的房间会话，连接上没有任何订阅时接收所有所在房间的消息
+type SubscribeData struct {
+	ConversationIDs []string `json:"conversation_ids"`
+}
+
+// ErrorData 错误回复
+type ErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error 实现error接口
+func (e *ErrorData) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewFrame 创建帧，data为nil时不携带数据
+func NewFrame(frameType, id string, data any) (*Frame, error) {
+	f := &Frame{V: Version, Type: frameType, ID: id}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		f.Data = raw
+	}
+	return f, nil
+}
+
+// Encode 创建并序列化帧
+func Encode(frameType, id string, data any) ([]byte, error) {
+	f, err := NewFrame(frameType, id, data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(f)
+}
+
+// Decode 解析帧并校验协议版本
+func Decode(raw []byte) (*Frame, error) {
+	var f Frame
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, err
+	}
+	if f.V != Version {
+		return &f, ErrVersion
+	}
+	return &f, nil
+}
+
+// DecodeData 解析帧数据
+func (f *Frame) DecodeData(v any) error {
+	if len(f.Data) == 0 {
+		return errors.New("missing frame data")
+	}
+	return json.Unmarshal(f.Data, v)
+}